@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/manifest"
+	"github.com/dukerupert/arnor/internal/plan"
+	"github.com/spf13/cobra"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Converge projects to the state declared in a manifest",
+	Long: `Compares arnor.yaml with the config database and the live servers and DNS,
+then runs project setup (or service deploy) for every environment that
+differs. Environments in the database but not in the manifest are listed and
+left alone.`,
+	RunE: runApply,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the current projects as an arnor.yaml manifest",
+	RunE:  runExport,
+}
+
+func init() {
+	applyCmd.Flags().StringP("file", "f", "arnor.yaml", "Manifest to apply")
+	applyCmd.Flags().Bool("dry-run", false, "Show the plan for each change without applying it")
+	applyCmd.Flags().BoolP("yes", "y", false, "Apply without asking for confirmation")
+	applyCmd.Flags().Bool("skip-live", false, "Only compare against the config database, not the servers and DNS")
+	rootCmd.AddCommand(applyCmd)
+
+	exportCmd.Flags().StringP("output", "o", "", "Write to a file instead of stdout")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("file")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+	skipLive, _ := cmd.Flags().GetBool("skip-live")
+
+	m, err := manifest.Load(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	var live manifest.LiveCheck
+	if !skipLive {
+		live = manifest.NewLiveCheck(cfg, store)
+	}
+	fmt.Println("Comparing manifest with current state...")
+	res := manifest.Diff(m, cfg, live)
+	pending, failed := res.Pending(), res.Failed()
+
+	for _, u := range res.Unmanaged {
+		fmt.Printf("  ? %s is not in the manifest (left unchanged)\n", u)
+	}
+	if len(res.Changes) == 0 {
+		fmt.Println("Everything is up to date.")
+		return nil
+	}
+
+	fmt.Println()
+	for _, c := range res.Changes {
+		fmt.Printf("  %s %s/%s\n", c.Action, c.Project, c.Env)
+		for _, r := range c.Reasons {
+			fmt.Printf("      %s\n", r)
+		}
+	}
+	fmt.Println()
+
+	if len(pending) == 0 {
+		return fmt.Errorf("%d environment(s) could not be checked", len(failed))
+	}
+	if !dryRun && !yes {
+		fmt.Printf("Apply %d change(s)? [y/N]: ", len(pending))
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	var plans []*plan.Plan
+	params := manifest.ApplyParams{
+		Manifest: m,
+		Changes:  pending,
+		Store:    store,
+		OnChange: func(c manifest.Change) {
+			fmt.Printf("\n--- %s %s/%s ---\n", c.Action, c.Project, c.Env)
+		},
+		OnProgress: func(step, total int, message string) {
+			fmt.Printf("Step %d/%d: %s\n", step, total, message)
+		},
+	}
+	if dryRun {
+		params.NewPlan = func(c manifest.Change) *plan.Plan {
+			p := plan.New(fmt.Sprintf("%s %s/%s", c.Action, c.Project, c.Env))
+			plans = append(plans, p)
+			return p
+		}
+	}
+
+	if err := manifest.Apply(params); err != nil {
+		return err
+	}
+
+	for _, p := range plans {
+		fmt.Println()
+		p.Render(os.Stdout)
+	}
+	if !dryRun {
+		fmt.Printf("\nApplied %d change(s).\n", len(pending))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d environment(s) could not be checked and were skipped", len(failed))
+	}
+	return nil
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	data, err := manifest.FromConfig(cfg).Marshal()
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", output)
+	return nil
+}
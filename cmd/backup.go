@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up and restore the arnor store",
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Write an encrypted archive of everything in the store",
+	Long: `Writes every server, project, credential, peon key and host key to a
+single archive encrypted with a passphrase. The archive does not depend on
+the store's own master key or age identity, so it can be restored on a new
+machine with nothing but the passphrase.
+
+Set ARNOR_BACKUP_PASSPHRASE to run without a prompt.`,
+	Args: cobra.NoArgs,
+	RunE: runBackupCreate,
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Replace the store with the contents of a backup",
+	Long: `Decrypts and validates the archive, shows what would be added, changed
+and removed compared with the current store, and then replaces the store's
+contents in one step. If anything fails, the store is left as it was.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackupRestore,
+}
+
+func init() {
+	backupCreateCmd.Flags().StringP("out", "o", "", "Archive path (default: arnor-backup-<timestamp>.age)")
+
+	backupRestoreCmd.Flags().Bool("dry-run", false, "Show the changes without restoring")
+	backupRestoreCmd.Flags().BoolP("yes", "y", false, "Restore without asking for confirmation")
+
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	rootCmd.AddCommand(backupCmd)
+}
+
+// backupPassphrase returns ARNOR_BACKUP_PASSPHRASE or asks for the backup
+// passphrase.
+func backupPassphrase(confirm bool) (string, error) {
+	if pass := os.Getenv("ARNOR_BACKUP_PASSPHRASE"); pass != "" {
+		return pass, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("set ARNOR_BACKUP_PASSPHRASE when not running interactively")
+	}
+	label := "Backup passphrase"
+	if confirm {
+		label = "New backup passphrase"
+	}
+	pass, err := readPassphrase(label, confirm)
+	if err == nil && pass == "" {
+		err = fmt.Errorf("passphrase is required")
+	}
+	return pass, err
+}
+
+func runBackupCreate(cmd *cobra.Command, args []string) error {
+	out, _ := cmd.Flags().GetString("out")
+	if out == "" {
+		out = fmt.Sprintf("arnor-backup-%s.age", time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	snap, err := config.TakeSnapshot(store)
+	if err != nil {
+		return err
+	}
+	pass, err := backupPassphrase(true)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	manifest, err := config.WriteBackup(f, snap, pass)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(out)
+		return fmt.Errorf("writing backup: %w", err)
+	}
+
+	c := manifest.Counts
+	fmt.Printf("Wrote %s: %d servers, %d projects, %d credentials, %d peon keys, %d host keys.\n",
+		out, c.Servers, c.Projects, c.Credentials, c.PeonKeys, c.HostKeys)
+	fmt.Println("Keep the passphrase somewhere other than this machine; the backup can't be restored without it.")
+	return nil
+}
+
+func runBackupRestore(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pass, err := backupPassphrase(false)
+	if err != nil {
+		return err
+	}
+	manifest, want, err := config.ReadBackup(f, pass)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	current, err := config.TakeSnapshot(store)
+	if err != nil {
+		return err
+	}
+	changes := config.DiffSnapshots(current, want)
+
+	fmt.Printf("Backup:  %s\n", path)
+	fmt.Printf("Created: %s on %s\n\n", manifest.CreatedAt.Local().Format("2006-01-02 15:04"), manifest.Hostname)
+	if len(changes) == 0 {
+		fmt.Println("The store already matches the backup.")
+		return nil
+	}
+	for _, c := range changes {
+		fmt.Printf("  %c %s %s\n", c.Op, c.Kind, c.Name)
+	}
+	fmt.Println()
+
+	if dryRun {
+		fmt.Printf("%d change(s) not applied (dry run).\n", len(changes))
+		return nil
+	}
+	if !yes {
+		fmt.Printf("Replace the store with this backup (%d change(s))? [y/N]: ", len(changes))
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	if err := config.RestoreSnapshot(store, want); err != nil {
+		return fmt.Errorf("restoring backup: %w", err)
+	}
+	fmt.Printf("Restored %s (%d change(s)).\n", path, len(changes))
+	return nil
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
 
+	"github.com/dukerupert/arnor/internal/config"
 	fhetzner "github.com/dukerupert/fornost/pkg/hetzner"
 	"github.com/spf13/cobra"
 )
@@ -34,10 +36,80 @@ var configAddCmd = &cobra.Command{
 	RunE:  runConfigAdd,
 }
 
+var configLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Encrypt stored credentials and peon keys with a master key",
+	Long: `Encrypts every credential and peon private key in the database with a
+master key. The key is derived from a passphrase (argon2id) or, with
+--keyring, generated and kept in the OS keyring. Commands that need a secret
+then ask for the passphrase once per run; set ARNOR_PASSPHRASE for scripts.
+
+Upgrading arnor never encrypts secrets on its own, since that would mean
+choosing a key source for you. Until the store is locked, every command
+warns how many credentials and peon keys are still stored in plaintext.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigLock,
+}
+
+var configUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Decrypt stored credentials and peon keys back to plaintext",
+	Args:  cobra.NoArgs,
+	RunE:  runConfigUnlock,
+}
+
+var configRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt stored secrets under a new passphrase or keyring key",
+	Long: `For the SQLite store, re-encrypts credentials and peon keys under a new
+passphrase (or, with --keyring, a new key in the OS keyring).
+
+For a file store, re-encrypts every file to the public keys currently listed
+in recipients.txt, e.g. after adding or removing a teammate.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigRekey,
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Show the database schema version and migrations",
+	Long: `The database schema is migrated automatically whenever arnor opens it, after
+writing a backup copy next to arnor.db. This command reports what was applied
+on this run; --status lists every migration and the current version.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigMigrate,
+}
+
+var configMigrateStoreCmd = &cobra.Command{
+	Use:   "migrate-store",
+	Short: "Copy all config, credentials and keys into another store",
+	Long: `Copies servers, projects, credentials, peon keys and host keys from the
+current store (see --store) into the one given by --to, e.g.
+
+  arnor config migrate-store --to file:~/src/arnor-config
+
+For a file store, an age identity is created at ~/.config/arnor/age.key
+(or $ARNOR_AGE_IDENTITY) if there is none. Teammates add their public key to
+recipients.txt in the store directory; then run 'arnor config rekey' so they
+can read the existing files.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigMigrateStore,
+}
+
 func init() {
+	configMigrateStoreCmd.Flags().String("to", "", "Destination store: sqlite:<path> or file:<dir>")
+	configMigrateCmd.Flags().Bool("status", false, "List every migration and whether it is applied")
+	configLockCmd.Flags().Bool("keyring", false, "Keep a generated master key in the OS keyring instead of using a passphrase")
+	configRekeyCmd.Flags().Bool("keyring", false, "Keep a generated master key in the OS keyring instead of using a passphrase")
+
 	configCmd.AddCommand(configInitCmd)
 	configCmd.AddCommand(configViewCmd)
 	configCmd.AddCommand(configAddCmd)
+	configCmd.AddCommand(configLockCmd)
+	configCmd.AddCommand(configUnlockCmd)
+	configCmd.AddCommand(configRekeyCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configMigrateStoreCmd)
 	rootCmd.AddCommand(configCmd)
 }
 
@@ -86,12 +158,7 @@ func runConfigInit(cmd *cobra.Command, args []string) error {
 				}
 			}
 			if !found {
-				cfg.Servers = append(cfg.Servers, struct {
-					Name           string
-					IP             string
-					HetznerProject string
-					HetznerID      int
-				}{
+				cfg.Servers = append(cfg.Servers, config.Server{
 					Name:           s.Name,
 					IP:             s.PublicNet.IPv4.IP,
 					HetznerProject: alias,
@@ -130,7 +197,11 @@ func runConfigView(cmd *cobra.Command, args []string) error {
 	if len(cfg.Servers) > 0 {
 		fmt.Println("Servers:")
 		for _, s := range cfg.Servers {
-			fmt.Printf("  - %s (%s) [%s]\n", s.Name, s.IP, s.HetznerProject)
+			orphaned := ""
+			if s.Orphaned {
+				orphaned = " (orphaned: not found in Hetzner)"
+			}
+			fmt.Printf("  - %s (%s) [%s]%s\n", s.Name, s.IP, s.HetznerProject, orphaned)
 		}
 		fmt.Println()
 	}
@@ -148,7 +219,7 @@ func runConfigView(cmd *cobra.Command, args []string) error {
 	}
 
 	// Credentials summary (names only, no values)
-	for _, svc := range []string{"porkbun", "cloudflare", "dockerhub"} {
+	for _, svc := range []string{"porkbun", "cloudflare", "rfc2136", "dockerhub", "s3"} {
 		creds, _ := store.ListCredentials(svc)
 		if len(creds) > 0 {
 			fmt.Printf("%s credentials:\n", svc)
@@ -166,6 +237,12 @@ func runConfigView(cmd *cobra.Command, args []string) error {
 
 	if len(projects) == 0 && len(cfg.Servers) == 0 && len(cfg.Projects) == 0 {
 		fmt.Println("No configuration found. Run 'arnor config init' to get started.")
+	} else if s, ok := store.(*config.SQLiteStore); ok {
+		if kind, err := s.Encryption(); err == nil && kind != "" {
+			fmt.Printf("Secrets are encrypted (%s).\n", kind)
+		} else if err == nil {
+			fmt.Println("Secrets are stored in plaintext. Run 'arnor config lock' to encrypt them.")
+		}
 	}
 
 	return nil
@@ -179,3 +256,156 @@ func runConfigAdd(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Stored %s/%s/%s\n", service, name, key)
 	return nil
 }
+
+// sqliteStore returns the store as a SQLiteStore, for the commands that
+// manage its encryption.
+func sqliteStore() (*config.SQLiteStore, error) {
+	s, ok := store.(*config.SQLiteStore)
+	if !ok {
+		return nil, fmt.Errorf("this command needs the SQLite store")
+	}
+	return s, nil
+}
+
+// newKeySource returns the key source chosen by the --keyring flag.
+func newKeySource(cmd *cobra.Command) (config.KeySource, error) {
+	useKeyring, _ := cmd.Flags().GetBool("keyring")
+	kind := "passphrase"
+	if useKeyring {
+		kind = "keyring"
+	}
+	return config.DefaultKeySources(promptPassphrase)(kind)
+}
+
+func runConfigLock(cmd *cobra.Command, args []string) error {
+	s, err := sqliteStore()
+	if err != nil {
+		return err
+	}
+	source, err := newKeySource(cmd)
+	if err != nil {
+		return err
+	}
+	if err := s.Lock(source); err != nil {
+		return err
+	}
+	fmt.Printf("Credentials and peon keys are now encrypted (%s).\n", source.Kind())
+	return nil
+}
+
+func runConfigUnlock(cmd *cobra.Command, args []string) error {
+	s, err := sqliteStore()
+	if err != nil {
+		return err
+	}
+	if err := s.Unlock(); err != nil {
+		return err
+	}
+	fmt.Println("Credentials and peon keys are now stored in plaintext.")
+	return nil
+}
+
+func runConfigRekey(cmd *cobra.Command, args []string) error {
+	if fs, ok := store.(*config.FileStore); ok {
+		if err := fs.Rekey(); err != nil {
+			return err
+		}
+		fmt.Printf("Re-encrypted %s to the keys in recipients.txt.\n", fs.Dir())
+		return nil
+	}
+
+	s, err := sqliteStore()
+	if err != nil {
+		return err
+	}
+	// Unseal with the current key before asking for the new one.
+	if err := s.Unseal(); err != nil {
+		return err
+	}
+	source, err := newKeySource(cmd)
+	if err != nil {
+		return err
+	}
+	if err := s.Rekey(source); err != nil {
+		return err
+	}
+	fmt.Printf("Secrets re-encrypted under a new key (%s).\n", source.Kind())
+	return nil
+}
+
+func runConfigMigrate(cmd *cobra.Command, args []string) error {
+	status, _ := cmd.Flags().GetBool("status")
+	s, err := sqliteStore()
+	if err != nil {
+		return err
+	}
+	res := s.Migrated()
+
+	if !status {
+		if len(res.Applied) == 0 {
+			fmt.Printf("Schema is up to date (v%d).\n", res.To)
+			return nil
+		}
+		fmt.Printf("Migrated schema from v%d to v%d.\n", res.From, res.To)
+		if res.Backup != "" {
+			fmt.Printf("Backup: %s\n", res.Backup)
+		}
+		return nil
+	}
+
+	fmt.Printf("Schema version: %d (latest %d)\n\n", res.To, config.LatestSchemaVersion())
+
+	appliedNow := make(map[int]bool)
+	for _, m := range res.Applied {
+		appliedNow[m.Version] = true
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tDESCRIPTION")
+	fmt.Fprintln(w, "───────\t──────\t───────────")
+	for _, m := range config.Migrations() {
+		state := "pending"
+		switch {
+		case appliedNow[m.Version]:
+			state = "applied now"
+		case m.Version <= res.To:
+			state = "applied"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, state, m.Description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if res.Backup != "" {
+		fmt.Printf("\nBackup taken before migrating: %s\n", res.Backup)
+	}
+	return nil
+}
+
+func runConfigMigrateStore(cmd *cobra.Command, args []string) error {
+	to, _ := cmd.Flags().GetString("to")
+	if to == "" {
+		return fmt.Errorf("--to is required, e.g. --to file:~/src/arnor-config")
+	}
+	if strings.HasPrefix(to, "file:") {
+		pub, err := config.GenerateIdentity(config.IdentityPath())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Age identity: %s (public key %s)\n", config.IdentityPath(), pub)
+	}
+
+	dst, err := openStore(to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	res, err := config.CopyStore(dst, store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Copied %d server(s), %d project(s), %d credential(s), %d peon key(s) and %d host key(s) to %s.\n",
+		res.Servers, res.Projects, res.Credentials, res.PeonKeys, res.HostKeys, to)
+	fmt.Printf("Use it with --store %s or ARNOR_STORE=%s.\n", to, to)
+	return nil
+}
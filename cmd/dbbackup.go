@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dbbackup"
+	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/remote"
+	"github.com/spf13/cobra"
+)
+
+var backupDBCmd = &cobra.Command{
+	Use:   "db <project>",
+	Short: "Dump an environment's database, or schedule dumps on its server",
+	Long: `Dumps the PostgreSQL database of the environment's compose stack with
+pg_dump, or copies a SQLite file from a container with --sqlite, over the
+peon SSH connection. The dump is gzipped on the server and written to a
+local file, or uploaded to S3 with --target s3://bucket/prefix using the
+s3/default credentials.
+
+With --schedule, a systemd timer is installed on the server instead. It
+dumps on that schedule to --target, a directory on the server or
+s3://bucket/prefix, and keeps the newest --keep dumps. One backup runs
+straight away to check the target works. --unschedule removes the timer
+and leaves the dumps.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackupDB,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore data of deployed apps",
+}
+
+var restoreDBCmd = &cobra.Command{
+	Use:   "db <project> <file|s3://bucket/key>",
+	Short: "Load a dump from backup db into an environment's database",
+	Long: `Replaces the environment's database with a dump taken by "backup db".
+PostgreSQL dumps are loaded in a single transaction, so a failed restore
+leaves the database as it was. SQLite files are swapped in and the service
+is restarted.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runRestoreDB,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{backupDBCmd, restoreDBCmd} {
+		c.Flags().String("env", "", "Environment (dev or prod)")
+		c.MarkFlagRequired("env")
+		c.Flags().String("service", "", "Compose service of the database (default: the postgres service, or web with --sqlite)")
+		c.Flags().String("sqlite", "", "Path of a SQLite file inside the service's container")
+	}
+	backupDBCmd.Flags().StringP("out", "o", "", "Dump file or directory (default: <project>-<env>-<timestamp>.sql.gz)")
+	backupDBCmd.Flags().String("target", "", "s3://bucket/prefix to upload to; with --schedule, also a directory on the server")
+	backupDBCmd.Flags().String("schedule", "", `Install a timer with this systemd OnCalendar, e.g. "daily" or "*-*-* 03:00"`)
+	backupDBCmd.Flags().Int("keep", 7, "Scheduled dumps to keep")
+	backupDBCmd.Flags().Bool("unschedule", false, "Remove the scheduled backup timer")
+	restoreDBCmd.Flags().BoolP("yes", "y", false, "Restore without asking for confirmation")
+
+	backupCmd.AddCommand(backupDBCmd)
+	restoreCmd.AddCommand(restoreDBCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
+
+// dialEnvironment connects to the server of a project environment as peon.
+func dialEnvironment(cmd *cobra.Command, name string) (*config.Project, string, *remote.Client, error) {
+	cfg, p, envName, err := envProject(cmd, name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	server, err := project.LookupServer(cfg, p.Server, store)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	peonKey, err := store.GetPeonKey(server.IP)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("peon key for %s: %w", server.IP, err)
+	}
+	client, err := remote.DialPeon(server.IP, peonKey, store)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return p, envName, client, nil
+}
+
+func runBackupDB(cmd *cobra.Command, args []string) error {
+	service, _ := cmd.Flags().GetString("service")
+	sqlitePath, _ := cmd.Flags().GetString("sqlite")
+	out, _ := cmd.Flags().GetString("out")
+	target, _ := cmd.Flags().GetString("target")
+	schedule, _ := cmd.Flags().GetString("schedule")
+	keep, _ := cmd.Flags().GetInt("keep")
+	unschedule, _ := cmd.Flags().GetBool("unschedule")
+
+	p, envName, client, err := dialEnvironment(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	env := p.Environments[envName]
+	name := path.Base(env.DeployPath)
+
+	if unschedule {
+		if err := dbbackup.Uninstall(client, name); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s.timer. Dumps already taken were left in place.\n", dbbackup.UnitName(name))
+		return nil
+	}
+
+	db, err := dbbackup.Resolve(client, env.DeployPath, service, sqlitePath)
+	if err != nil {
+		return err
+	}
+
+	if schedule != "" {
+		if target == "" {
+			return fmt.Errorf("--target is required with --schedule")
+		}
+		s := dbbackup.Schedule{Name: name, DeployPath: env.DeployPath, DB: db, Calendar: schedule, Keep: keep, Target: target}
+		if strings.HasPrefix(target, "s3://") {
+			if s.S3, err = dbbackup.LoadS3Client(store); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Installing %s.timer (%s) and taking a first backup...\n", dbbackup.UnitName(name), schedule)
+		if err := dbbackup.Install(client, s); err != nil {
+			return err
+		}
+		fmt.Printf("Backing up %s to %s on schedule %q, keeping %d.\n", db, target, schedule, keep)
+		fmt.Printf("Check it with: systemctl list-timers %s.timer\n", dbbackup.UnitName(name))
+		return nil
+	}
+
+	fileName := fmt.Sprintf("%s-%s-%s%s", p.Name, envName, time.Now().UTC().Format("20060102T150405Z"), db.Ext())
+
+	if target != "" {
+		bucket, prefix, err := dbbackup.ParseS3URL(target)
+		if err != nil {
+			return err
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		s3, err := dbbackup.LoadS3Client(store)
+		if err != nil {
+			return err
+		}
+		// S3 needs the length up front, so the dump is spooled first.
+		tmp, err := os.CreateTemp("", "arnor-db-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		fmt.Printf("Dumping %s...\n", db)
+		if err := dbbackup.Dump(client, env.DeployPath, db, tmp); err != nil {
+			return err
+		}
+		size, err := tmp.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return err
+		}
+		if err := s3.Put(bucket, prefix+fileName, tmp, size); err != nil {
+			return err
+		}
+		fmt.Printf("Uploaded s3://%s/%s%s (%s).\n", bucket, prefix, fileName, formatSize(size))
+		return nil
+	}
+
+	if out == "" {
+		out = fileName
+	} else if fi, err := os.Stat(out); err == nil && fi.IsDir() {
+		out = filepath.Join(out, fileName)
+	}
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dumping %s...\n", db)
+	err = dbbackup.Dump(client, env.DeployPath, db, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return err
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%s).\n", out, formatSize(fi.Size()))
+	return nil
+}
+
+func runRestoreDB(cmd *cobra.Command, args []string) error {
+	source := args[1]
+	service, _ := cmd.Flags().GetString("service")
+	sqlitePath, _ := cmd.Flags().GetString("sqlite")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	p, envName, client, err := dialEnvironment(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	env := p.Environments[envName]
+
+	db, err := dbbackup.Resolve(client, env.DeployPath, service, sqlitePath)
+	if err != nil {
+		return err
+	}
+	if kind := dbbackup.KindOfFile(source); kind != "" && kind != db.Kind {
+		return fmt.Errorf("%s is a %s dump but the database is %s", source, kind, db)
+	}
+
+	var r io.ReadCloser
+	if strings.HasPrefix(source, "s3://") {
+		bucket, key, err := dbbackup.ParseS3URL(source)
+		if err != nil {
+			return err
+		}
+		s3, err := dbbackup.LoadS3Client(store)
+		if err != nil {
+			return err
+		}
+		r, err = s3.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+	} else {
+		r, err = os.Open(source)
+		if err != nil {
+			return err
+		}
+	}
+	defer r.Close()
+
+	if !yes {
+		fmt.Printf("Replace the data of %s %s (%s) with %s? [y/N]: ", p.Name, envName, db, source)
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	fmt.Printf("Restoring %s...\n", source)
+	if err := dbbackup.Restore(client, env.DeployPath, db, r); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s into %s %s.\n", source, p.Name, envName)
+	return nil
+}
+
+// formatSize renders a byte count for humans.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -3,7 +3,9 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/registry"
 	"github.com/spf13/cobra"
 )
 
@@ -35,25 +37,31 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("project not found: %s", projectName)
 	}
 
-	env, ok := p.Environments[deployEnv]
+	return triggerDeploy(p, deployEnv)
+}
+
+// triggerDeploy dispatches the GitHub Actions deploy workflow of one
+// environment.
+func triggerDeploy(p *config.Project, envName string) error {
+	env, ok := p.Environments[envName]
 	if !ok {
-		return fmt.Errorf("environment %q not configured for project %s", deployEnv, projectName)
+		return fmt.Errorf("environment %q not configured for project %s", envName, p.Name)
 	}
 
-	dockerHubUsername, err := store.GetCredential("dockerhub", "default", "username")
+	reg, err := registry.New(p.Registry, store)
 	if err != nil {
-		return fmt.Errorf("dockerhub username: %w", err)
+		return err
 	}
 
 	fmt.Println("Ensuring workflow supports manual dispatch...")
-	if err := project.EnsureWorkflowDispatch(p.Repo, deployEnv, p.Name, dockerHubUsername); err != nil {
+	if err := project.EnsureWorkflowDispatch(p.Repo, envName, p.Name, reg, env.ComposeFile); err != nil {
 		return fmt.Errorf("ensuring workflow dispatch: %w", err)
 	}
 
-	workflowFile := project.WorkflowFile(deployEnv)
+	workflowFile := project.WorkflowFile(envName)
 	ref := project.DeployRef(env)
 
-	fmt.Printf("Triggering %s deploy for %s (ref: %s)...\n", deployEnv, p.Repo, ref)
+	fmt.Printf("Triggering %s deploy for %s (ref: %s)...\n", envName, p.Repo, ref)
 
 	if err := project.TriggerWorkflow(p.Repo, workflowFile, ref); err != nil {
 		return err
@@ -1,17 +1,21 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/plan"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var dnsCmd = &cobra.Command{
 	Use:   "dns",
-	Short: "Manage DNS records (auto-detects Porkbun or Cloudflare)",
+	Short: "Manage DNS records (auto-detects the provider)",
 }
 
 var dnsListCmd = &cobra.Command{
@@ -26,12 +30,60 @@ var dnsCreateCmd = &cobra.Command{
 	RunE:  runDNSCreate,
 }
 
+var dnsUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update a DNS record, or create it if missing",
+	Long: `Without --id, makes --name resolve to --content alone for --type, editing
+any existing records of that name and type or creating one if there are none.
+With --id, edits that record in place.`,
+	RunE: runDNSUpdate,
+}
+
 var dnsDeleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete a DNS record by ID",
 	RunE:  runDNSDelete,
 }
 
+var dnsPresetCmd = &cobra.Command{
+	Use:   "preset <preset> <domain>",
+	Short: "Create the records for a hosted service, e.g. google-workspace",
+	Long: `Creates the full record set for a mail provider: MX, SPF, DKIM and DMARC.
+Existing records that fill the same role (the MX records, the SPF, DKIM and
+DMARC TXT records, the DKIM CNAMEs) are replaced; other TXT records are left
+alone. New MX and TXT records are created before the old ones are removed;
+an old CNAME is removed first, as a name can only hold one.
+
+Available presets: google-workspace, fastmail.
+
+Google generates the DKIM key per domain; pass the TXT value from the Admin
+console (Apps > Google Workspace > Gmail > Authenticate email) with --dkim.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDNSPreset,
+}
+
+var dnsExportCmd = &cobra.Command{
+	Use:   "export <domain>",
+	Short: "Write a domain's records as a BIND zone file",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDNSExport,
+}
+
+var dnsImportCmd = &cobra.Command{
+	Use:   "import <domain> <zone-file>",
+	Short: "Make a domain's records match a BIND zone file",
+	Long: `Parses the zone file, compares it with the live zone and applies the
+differences: missing records are created, records whose TTL or proxied flag
+differ are updated, and records not in the file are deleted (unless
+--no-delete). SOA records and the NS records at the apex are left to the
+provider.
+
+Use --provider to import into a provider other than the one the domain's
+nameservers point at, e.g. to copy a zone to Cloudflare before switching.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDNSImport,
+}
+
 func init() {
 	dnsListCmd.Flags().String("domain", "", "Domain name (e.g. example.com)")
 
@@ -40,16 +92,63 @@ func init() {
 	dnsCreateCmd.Flags().String("type", "", "Record type (A, CNAME, TXT, etc.)")
 	dnsCreateCmd.Flags().String("content", "", "Record content (e.g. IP address)")
 	dnsCreateCmd.Flags().String("ttl", "600", "Time to live in seconds")
+	addRecordFlags(dnsCreateCmd.Flags())
+
+	dnsUpdateCmd.Flags().String("domain", "", "Domain name (e.g. example.com)")
+	dnsUpdateCmd.Flags().String("id", "", "Record ID to edit (default: match by name and type)")
+	dnsUpdateCmd.Flags().String("name", "", "Record name (e.g. www)")
+	dnsUpdateCmd.Flags().String("type", "", "Record type (A, CNAME, TXT, etc.)")
+	dnsUpdateCmd.Flags().String("content", "", "Record content (e.g. IP address)")
+	dnsUpdateCmd.Flags().String("ttl", "600", "Time to live in seconds")
+	addRecordFlags(dnsUpdateCmd.Flags())
 
 	dnsDeleteCmd.Flags().String("domain", "", "Domain name (e.g. example.com)")
 	dnsDeleteCmd.Flags().String("id", "", "Record ID to delete")
 
 	dnsCmd.AddCommand(dnsListCmd)
 	dnsCmd.AddCommand(dnsCreateCmd)
+	dnsCmd.AddCommand(dnsUpdateCmd)
+	dnsPresetCmd.Flags().String("dkim", "", "DKIM TXT value, for providers that generate one per domain")
+	dnsPresetCmd.Flags().String("dmarc-policy", "none", "DMARC policy: none, quarantine or reject")
+	dnsPresetCmd.Flags().String("dmarc-report", "", "Address for DMARC aggregate reports")
+	dnsPresetCmd.Flags().Bool("dry-run", false, "Show the record changes without applying them")
+
+	dnsExportCmd.Flags().StringP("output", "o", "", "Write to a file instead of stdout")
+
+	dnsImportCmd.Flags().String("provider", "", "DNS provider to import into (default: detect from nameservers)")
+	dnsImportCmd.Flags().Bool("no-delete", false, "Keep live records that are not in the zone file")
+	dnsImportCmd.Flags().Bool("dry-run", false, "Show the changes without applying them")
+	dnsImportCmd.Flags().BoolP("yes", "y", false, "Apply without asking for confirmation")
+
 	dnsCmd.AddCommand(dnsDeleteCmd)
+	dnsCmd.AddCommand(dnsPresetCmd)
+	dnsCmd.AddCommand(dnsExportCmd)
+	dnsCmd.AddCommand(dnsImportCmd)
 	rootCmd.AddCommand(dnsCmd)
 }
 
+// addRecordFlags adds the flags for record fields beyond name/type/content.
+func addRecordFlags(flags *pflag.FlagSet) {
+	flags.Int("priority", 0, "Priority (MX and SRV)")
+	flags.Int("weight", 0, "Weight (SRV)")
+	flags.Int("port", 0, "Target port (SRV)")
+	flags.Bool("proxied", false, "Proxy through Cloudflare (A, AAAA and CNAME)")
+}
+
+// recordFromFlags builds a record from the create/update flags.
+func recordFromFlags(cmd *cobra.Command) dns.DNSRecord {
+	var rec dns.DNSRecord
+	rec.Name, _ = cmd.Flags().GetString("name")
+	rec.Type, _ = cmd.Flags().GetString("type")
+	rec.Content, _ = cmd.Flags().GetString("content")
+	rec.TTL, _ = cmd.Flags().GetString("ttl")
+	rec.Priority, _ = cmd.Flags().GetInt("priority")
+	rec.Weight, _ = cmd.Flags().GetInt("weight")
+	rec.Port, _ = cmd.Flags().GetInt("port")
+	rec.Proxied, _ = cmd.Flags().GetBool("proxied")
+	return rec
+}
+
 func getProvider(domain string) (dns.Provider, error) {
 	cfg, err := store.LoadConfig()
 	if err != nil {
@@ -85,19 +184,20 @@ func runDNSList(cmd *cobra.Command, args []string) error {
 	fmt.Fprintln(w, "ID\tTYPE\tNAME\tCONTENT\tTTL")
 	fmt.Fprintln(w, "──\t────\t────\t───────\t───")
 	for _, r := range records {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.ID, r.Type, r.Name, r.Content, r.TTL)
+		content := r.Value()
+		if r.Proxied {
+			content += " (proxied)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.ID, r.Type, r.Name, content, r.TTL)
 	}
 	return w.Flush()
 }
 
 func runDNSCreate(cmd *cobra.Command, args []string) error {
 	domain, _ := cmd.Flags().GetString("domain")
-	name, _ := cmd.Flags().GetString("name")
-	recordType, _ := cmd.Flags().GetString("type")
-	content, _ := cmd.Flags().GetString("content")
-	ttl, _ := cmd.Flags().GetString("ttl")
+	rec := recordFromFlags(cmd)
 
-	if domain == "" || recordType == "" || content == "" {
+	if domain == "" || rec.Type == "" || rec.Content == "" {
 		return fmt.Errorf("--domain, --type, and --content are required")
 	}
 
@@ -106,12 +206,42 @@ func runDNSCreate(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	id, err := provider.CreateRecord(domain, name, recordType, content, ttl)
+	id, err := provider.CreateRecord(domain, rec)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Created %s record via %s (ID: %s)\n", recordType, provider.Name(), id)
+	fmt.Printf("Created %s record via %s (ID: %s)\n", rec.Type, provider.Name(), id)
+	return nil
+}
+
+func runDNSUpdate(cmd *cobra.Command, args []string) error {
+	domain, _ := cmd.Flags().GetString("domain")
+	id, _ := cmd.Flags().GetString("id")
+	rec := recordFromFlags(cmd)
+
+	if domain == "" || rec.Type == "" || rec.Content == "" {
+		return fmt.Errorf("--domain, --type, and --content are required")
+	}
+
+	provider, err := getProvider(domain)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if err := provider.UpdateRecord(domain, id, rec); err != nil {
+			return err
+		}
+		fmt.Printf("Updated %s record %s via %s\n", rec.Type, id, provider.Name())
+		return nil
+	}
+
+	id, err = provider.UpsertRecord(domain, rec)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Upserted %s record via %s (ID: %s)\n", rec.Type, provider.Name(), id)
 	return nil
 }
 
@@ -135,3 +265,180 @@ func runDNSDelete(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Deleted record %s from %s via %s\n", id, domain, provider.Name())
 	return nil
 }
+
+func runDNSPreset(cmd *cobra.Command, args []string) error {
+	presetName, domain := args[0], args[1]
+	dkim, _ := cmd.Flags().GetString("dkim")
+	dmarcPolicy, _ := cmd.Flags().GetString("dmarc-policy")
+	dmarcReport, _ := cmd.Flags().GetString("dmarc-report")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	preset, err := dns.FindPreset(presetName)
+	if err != nil {
+		return err
+	}
+	records, err := preset.Records(domain, dns.PresetOptions{
+		DKIM:        dkim,
+		DMARCPolicy: dmarcPolicy,
+		DMARCReport: dmarcReport,
+	})
+	if err != nil {
+		return err
+	}
+
+	provider, err := getProvider(domain)
+	if err != nil {
+		return err
+	}
+	var p *plan.Plan
+	if dryRun {
+		p = plan.New(fmt.Sprintf("dns preset %s %s", presetName, domain))
+		provider = dns.NewRecorder(provider, p)
+	}
+
+	changes, err := dns.SyncRecords(provider, domain, records)
+	if p != nil {
+		p.Render(os.Stdout)
+	} else {
+		for _, c := range changes {
+			sign := "="
+			switch c.Action {
+			case "create":
+				sign = "+"
+			case "delete":
+				sign = "-"
+			}
+			fmt.Printf("  %s %s %s %s\n", sign, c.Record.Type, c.Record.Name, c.Record.Value())
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if preset.NeedsDKIM && dkim == "" {
+		fmt.Printf("\nNote: no --dkim given, so the DKIM record was skipped. Re-run with --dkim once %s has generated the key.\n", presetName)
+	}
+	if p == nil {
+		fmt.Printf("\nApplied %s preset to %s via %s\n", presetName, domain, provider.Name())
+	}
+	return nil
+}
+
+func runDNSExport(cmd *cobra.Command, args []string) error {
+	domain := args[0]
+	output, _ := cmd.Flags().GetString("output")
+
+	provider, err := getProvider(domain)
+	if err != nil {
+		return err
+	}
+	records, err := provider.ListRecords(domain)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return dns.WriteZone(os.Stdout, domain, records)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := dns.WriteZone(f, domain, records); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d records for %s to %s\n", len(records), domain, output)
+	return nil
+}
+
+func runDNSImport(cmd *cobra.Command, args []string) error {
+	domain, path := args[0], args[1]
+	providerName, _ := cmd.Flags().GetString("provider")
+	noDelete, _ := cmd.Flags().GetBool("no-delete")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	want, err := dns.ReadZone(f, domain)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var provider dns.Provider
+	if providerName != "" {
+		provider, err = dns.NewProvider(providerName, store)
+	} else {
+		provider, err = getProvider(domain)
+	}
+	if err != nil {
+		return err
+	}
+	live, err := provider.ListRecords(domain)
+	if err != nil {
+		return err
+	}
+
+	proxied := dns.SupportsProxy(provider)
+	if !proxied {
+		for _, r := range want {
+			if r.Proxied {
+				fmt.Printf("Note: %s can't proxy records; the proxied flags in %s are ignored.\n\n", provider.Name(), path)
+				break
+			}
+		}
+	}
+	diff := dns.DiffZone(domain, live, want, proxied)
+	if noDelete {
+		diff.Delete = nil
+	}
+	if diff.Empty() {
+		fmt.Printf("%s on %s already matches %s.\n", domain, provider.Name(), path)
+		return nil
+	}
+
+	fmt.Printf("Provider: %s\n\n", provider.Name())
+	for _, r := range diff.Create {
+		fmt.Printf("  + %s %s %s\n", r.Type, r.Name, r.Value())
+	}
+	for _, r := range diff.Update {
+		if proxied {
+			fmt.Printf("  ~ %s %s %s (ttl %s, proxied %t)\n", r.Type, r.Name, r.Value(), r.TTL, r.Proxied)
+		} else {
+			fmt.Printf("  ~ %s %s %s (ttl %s)\n", r.Type, r.Name, r.Value(), r.TTL)
+		}
+	}
+	for _, r := range diff.Delete {
+		fmt.Printf("  - %s %s %s\n", r.Type, r.Name, r.Value())
+	}
+	fmt.Println()
+
+	total := len(diff.Create) + len(diff.Update) + len(diff.Delete)
+	if dryRun {
+		fmt.Printf("%d change(s) not applied (dry run).\n", total)
+		return nil
+	}
+	if !yes {
+		fmt.Printf("Apply %d change(s)? [y/N]: ", total)
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	if err := dns.ApplyZoneDiff(provider, domain, diff); err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d change(s) to %s via %s.\n", total, domain, provider.Name())
+	return nil
+}
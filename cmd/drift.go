@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/drift"
+	"github.com/spf13/cobra"
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift [project]",
+	Short: "Compare stored projects with the live servers, DNS and GitHub",
+	Long: `Checks every project environment (or just the named project) against reality:
+the Caddy config, the DNS A record, the container port and whether it is
+published beyond localhost, the deploy user and path, and the GitHub secrets
+and workflow. Nothing is changed. Exits non-zero
+if anything has drifted or could not be checked.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDrift,
+}
+
+func init() {
+	driftCmd.Flags().Bool("json", false, "Output results as JSON")
+	rootCmd.AddCommand(driftCmd)
+}
+
+var errDrift = errors.New("drift detected")
+
+func runDrift(cmd *cobra.Command, args []string) error {
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if len(args) == 1 {
+		p := cfg.FindProject(args[0])
+		if p == nil {
+			return fmt.Errorf("project %q not found", args[0])
+		}
+		cfg.Projects = []config.Project{*p}
+	}
+
+	report := drift.NewChecker(cfg, store).CheckAll()
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else if len(report.Results) == 0 {
+		fmt.Println("No projects configured.")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "PROJECT\tENV\tCHECK\tSTATUS\tDETAIL")
+		fmt.Fprintln(w, "───────\t───\t─────\t──────\t──────")
+		for _, r := range report.Results {
+			icon := "✓"
+			switch r.Status {
+			case drift.StatusDrift:
+				icon = "✗"
+			case drift.StatusError:
+				icon = "!"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s %s\t%s\n", r.Project, r.Env, r.Check, icon, r.Status, r.Detail)
+		}
+		w.Flush()
+	}
+
+	if report.Drifted() {
+		return errDrift
+	}
+	return nil
+}
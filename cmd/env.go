@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/project"
+	"github.com/spf13/cobra"
+)
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage environment variables of deployed apps",
+	Long: `Environment variables are stored encrypted per project environment and
+written to a .env file next to docker-compose.yml on the server, which the
+compose file loads with env_file. Containers only see changed values once
+they are recreated: pass --restart, or deploy.`,
+}
+
+var envSetCmd = &cobra.Command{
+	Use:   "set <project> KEY=VALUE...",
+	Short: "Set environment variables",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runEnvSet,
+}
+
+var envUnsetCmd = &cobra.Command{
+	Use:   "unset <project> KEY...",
+	Short: "Remove environment variables",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runEnvUnset,
+}
+
+var envListCmd = &cobra.Command{
+	Use:   "list <project>",
+	Short: "List environment variables",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runEnvList,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{envSetCmd, envUnsetCmd, envListCmd} {
+		c.Flags().String("env", "", "Environment (dev or prod)")
+		c.MarkFlagRequired("env")
+	}
+	for _, c := range []*cobra.Command{envSetCmd, envUnsetCmd} {
+		c.Flags().Bool("restart", false, "Recreate the containers so they pick up the change")
+		c.Flags().Bool("no-sync", false, "Only update the store; don't write .env on the server")
+	}
+	envListCmd.Flags().Bool("reveal", false, "Show values instead of masking them")
+
+	envCmd.AddCommand(envSetCmd)
+	envCmd.AddCommand(envUnsetCmd)
+	envCmd.AddCommand(envListCmd)
+	rootCmd.AddCommand(envCmd)
+}
+
+// envProject loads the config and finds a project environment.
+func envProject(cmd *cobra.Command, name string) (*config.Config, *config.Project, string, error) {
+	envName, _ := cmd.Flags().GetString("env")
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("loading config: %w", err)
+	}
+	p := cfg.FindProject(name)
+	if p == nil {
+		return nil, nil, "", fmt.Errorf("project not found: %s", name)
+	}
+	if _, ok := p.Environments[envName]; !ok {
+		return nil, nil, "", fmt.Errorf("environment %q not configured for project %s", envName, name)
+	}
+	return cfg, p, envName, nil
+}
+
+func runEnvSet(cmd *cobra.Command, args []string) error {
+	cfg, p, envName, err := envProject(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	var vars []project.EnvVar
+	for _, a := range args[1:] {
+		v, err := project.ParseEnvAssignment(a)
+		if err != nil {
+			return err
+		}
+		vars = append(vars, v)
+	}
+	for _, v := range vars {
+		if err := project.SetEnvVar(store, p.Name, envName, v); err != nil {
+			return err
+		}
+		fmt.Printf("Set %s for %s/%s.\n", v.Key, p.Name, envName)
+	}
+	return syncEnv(cmd, cfg, p, envName)
+}
+
+func runEnvUnset(cmd *cobra.Command, args []string) error {
+	cfg, p, envName, err := envProject(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	for _, key := range args[1:] {
+		if err := project.UnsetEnvVar(store, p.Name, envName, key); err != nil {
+			return err
+		}
+		fmt.Printf("Unset %s for %s/%s.\n", key, p.Name, envName)
+	}
+	return syncEnv(cmd, cfg, p, envName)
+}
+
+// syncEnv writes the stored variables to the server unless --no-sync.
+func syncEnv(cmd *cobra.Command, cfg *config.Config, p *config.Project, envName string) error {
+	if noSync, _ := cmd.Flags().GetBool("no-sync"); noSync {
+		return nil
+	}
+	restart, _ := cmd.Flags().GetBool("restart")
+
+	fmt.Println("Writing .env on the server...")
+	loads, err := project.SyncEnvFile(cfg, store, p, envName, restart)
+	if err != nil {
+		return err
+	}
+	if !loads {
+		fmt.Fprintf(os.Stderr, "Warning: docker-compose.yml in %s has no env_file entry, so the app won't see these variables. Re-run project setup to update it.\n", p.Environments[envName].DeployPath)
+	}
+	if restart {
+		fmt.Println("Containers recreated.")
+	} else {
+		fmt.Println("Run with --restart, or deploy, for the containers to pick up the change.")
+	}
+	return nil
+}
+
+func runEnvList(cmd *cobra.Command, args []string) error {
+	_, p, envName, err := envProject(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	reveal, _ := cmd.Flags().GetBool("reveal")
+
+	vars, err := project.ListEnvVars(store, p.Name, envName)
+	if err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		fmt.Printf("No variables set for %s/%s.\n", p.Name, envName)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE")
+	fmt.Fprintln(w, "───\t─────")
+	for _, v := range vars {
+		value := "***"
+		if reveal {
+			value = v.Value
+		}
+		fmt.Fprintf(w, "%s\t%s\n", v.Key, value)
+	}
+	return w.Flush()
+}
@@ -4,12 +4,16 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/dukerupert/arnor/internal/hetzner"
+	"github.com/dukerupert/arnor/internal/plan"
 	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/registry"
 	"github.com/spf13/cobra"
 )
 
@@ -37,6 +41,18 @@ var projectCreateCmd = &cobra.Command{
 	RunE:  runProjectCreate,
 }
 
+var projectDestroyCmd = &cobra.Command{
+	Use:   "destroy <name>",
+	Short: "Tear down a project's server, DNS, GitHub and config resources",
+	Long: `Reverses project create: stops and removes the compose stack, deletes the
+deploy user, removes the Caddy config, deletes the A and www CNAME records,
+removes the environment's GitHub secrets and workflow, and deletes the config
+rows. Without --env every environment is destroyed. Each step asks for
+confirmation unless --yes is given, and can be skipped with a --keep-* flag.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runProjectDestroy,
+}
+
 var projectInspectCmd = &cobra.Command{
 	Use:   "inspect [name]",
 	Short: "Show GitHub secrets and recent workflow runs for a project",
@@ -44,11 +60,39 @@ var projectInspectCmd = &cobra.Command{
 	RunE:  runProjectInspect,
 }
 
+var projectRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key <project>",
+	Short: "Replace an environment's deploy SSH key and its GitHub secret",
+	Long: `Generates a new keypair for the environment's deploy user, authorizes it
+next to the old one and checks that it logs in, updates the
+<ENV>_VPS_SSH_KEY GitHub secret, and then removes the old key from
+authorized_keys. Until the secret is updated the old key keeps working, so
+deploys in flight are not affected. Use --deploy to trigger a deploy
+afterwards and prove the new key works from GitHub Actions.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runProjectRotateKey,
+}
+
 func init() {
 	projectCmd.AddCommand(projectListCmd)
 	projectCmd.AddCommand(projectViewCmd)
+	projectCreateCmd.Flags().Bool("dry-run", false, "Show the commands, files, DNS, GitHub and config changes without applying them")
 	projectCmd.AddCommand(projectCreateCmd)
 	projectCmd.AddCommand(projectInspectCmd)
+	projectDestroyCmd.Flags().String("env", "", "Environment to destroy (dev or prod); all if omitted")
+	projectDestroyCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompts")
+	projectDestroyCmd.Flags().Bool("keep-stack", false, "Leave the compose stack and deploy path in place")
+	projectDestroyCmd.Flags().Bool("keep-user", false, "Leave the deploy user in place")
+	projectDestroyCmd.Flags().Bool("keep-caddy", false, "Leave the Caddy config in place")
+	projectDestroyCmd.Flags().Bool("keep-dns", false, "Leave the DNS records in place")
+	projectDestroyCmd.Flags().Bool("keep-secrets", false, "Leave the GitHub secrets in place")
+	projectDestroyCmd.Flags().Bool("keep-workflow", false, "Leave the GitHub workflow file in place")
+	projectDestroyCmd.Flags().Bool("keep-config", false, "Leave the project in the arnor config")
+	projectCmd.AddCommand(projectDestroyCmd)
+	projectRotateKeyCmd.Flags().String("env", "", "Environment whose key to rotate (dev or prod)")
+	projectRotateKeyCmd.MarkFlagRequired("env")
+	projectRotateKeyCmd.Flags().Bool("deploy", false, "Trigger a deploy after rotating")
+	projectCmd.AddCommand(projectRotateKeyCmd)
 	rootCmd.AddCommand(projectCmd)
 }
 
@@ -95,13 +139,20 @@ func runProjectView(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("project not found: %s", args[0])
 	}
 
-	fmt.Printf("Name:   %s\n", p.Name)
+	fmt.Printf("Name:     %s\n", p.Name)
 	repo := p.Repo
 	if repo == "" {
 		repo = "(service)"
 	}
-	fmt.Printf("Repo:   %s\n", repo)
-	fmt.Printf("Server: %s\n", p.Server)
+	fmt.Printf("Repo:     %s\n", repo)
+	fmt.Printf("Server:   %s\n", p.Server)
+	if p.Repo != "" {
+		registryName := p.Registry
+		if registryName == "" {
+			registryName = "dockerhub"
+		}
+		fmt.Printf("Registry: %s\n", registryName)
+	}
 
 	for envName, env := range p.Environments {
 		fmt.Printf("\n[%s]\n", envName)
@@ -111,10 +162,42 @@ func runProjectView(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Deploy Path: %s\n", env.DeployPath)
 		fmt.Printf("  Deploy User: %s\n", env.DeployUser)
 		fmt.Printf("  Port:        %d\n", env.Port)
+		if p.Repo != "" {
+			fmt.Printf("  Deploy Key:  %s\n", project.KeyAge(env.DeployKeyRotatedAt, time.Now()))
+		}
 	}
 	return nil
 }
 
+func runProjectRotateKey(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	envName, _ := cmd.Flags().GetString("env")
+	deploy, _ := cmd.Flags().GetBool("deploy")
+
+	fmt.Printf("Rotating the %s deploy key of %s...\n", envName, name)
+	if err := project.RotateKey(project.RotateKeyParams{
+		ProjectName: name,
+		EnvName:     envName,
+		Store:       store,
+		OnProgress: func(step, total int, message string) {
+			fmt.Printf("[%d/%d] %s\n", step, total, message)
+		},
+	}); err != nil {
+		return err
+	}
+	fmt.Println("Deploy key rotated.")
+
+	if !deploy {
+		return nil
+	}
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	return triggerDeploy(cfg.FindProject(name), envName)
+}
+
 func runProjectInspect(cmd *cobra.Command, args []string) error {
 	cfg, err := store.LoadConfig()
 	if err != nil {
@@ -199,8 +282,13 @@ func runProjectCreate(cmd *cobra.Command, args []string) error {
 	projectName := prompt("Project name")
 	repo := prompt("GitHub repo (e.g. github.com/org/repo)")
 	serverName := prompt("Server name")
+	registryName := prompt("Image registry (dockerhub, ghcr, or a host like registry.example.com) [dockerhub]")
+	if err := registry.Validate(registryName); err != nil {
+		return err
+	}
 
 	envChoice := prompt("Environment (dev/prod/both)")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 	var environments []string
 	switch envChoice {
@@ -212,6 +300,31 @@ func runProjectCreate(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid environment: %s (must be dev, prod, or both)", envChoice)
 	}
 
+	var templateName, healthcheckPath string
+	containerPort := 0
+	composeFile := prompt("Compose file in the repo, e.g. compose.prod.yml (empty to generate one)")
+	if composeFile == "" {
+		var templateNames []string
+		for _, t := range project.ComposeTemplates {
+			templateNames = append(templateNames, t.Name)
+		}
+		templateName = prompt(fmt.Sprintf("Compose template (%s) [%s]", strings.Join(templateNames, ", "), project.DefaultComposeTemplate))
+		if _, err := project.LookupComposeTemplate(templateName); err != nil {
+			return err
+		}
+		if s := prompt("Container port the app listens on [80]"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("invalid container port: %s", s)
+			}
+			containerPort = n
+		}
+		healthcheckPath = prompt("Healthcheck path, e.g. /healthz (empty for none)")
+		if err := project.ValidateHealthcheckPath(healthcheckPath); err != nil {
+			return err
+		}
+	}
+
 	// Resolve server IP and peon key for port scanning
 	var serverIP, peonKey string
 	cfg, err := store.LoadConfig()
@@ -256,7 +369,7 @@ func runProjectCreate(cmd *cobra.Command, args []string) error {
 		// Scan used ports and suggest next available
 		var portPrompt string
 		if serverIP != "" && peonKey != "" {
-			usedPorts, scanErr := project.GetUsedPorts(serverIP, peonKey)
+			usedPorts, scanErr := project.GetUsedPorts(serverIP, peonKey, store)
 			if scanErr == nil {
 				suggested := project.SuggestPort(usedPorts)
 				portPrompt = fmt.Sprintf("Port for %s [suggested: %d]", envName, suggested)
@@ -273,6 +386,11 @@ func runProjectCreate(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("invalid port: %s", portStr)
 		}
 
+		var p *plan.Plan
+		if dryRun {
+			p = plan.New(fmt.Sprintf("project create %s %s (%s)", projectName, envName, domain))
+		}
+
 		fmt.Println()
 		if err := project.Setup(project.SetupParams{
 			ProjectName: projectName,
@@ -283,13 +401,96 @@ func runProjectCreate(cmd *cobra.Command, args []string) error {
 			Port:        port,
 			PeonKey:     peonKey,
 			Store:       store,
+
+			ComposeTemplate: templateName,
+			ContainerPort:   containerPort,
+			HealthcheckPath: healthcheckPath,
+			ComposeFile:     composeFile,
+			Registry:        registryName,
 			OnProgress: func(step, total int, message string) {
 				fmt.Printf("Step %d/%d: %s\n", step, total, message)
 			},
+			Plan: p,
 		}); err != nil {
 			return fmt.Errorf("%s setup failed: %w", envName, err)
 		}
+
+		if p != nil {
+			fmt.Println()
+			p.Render(os.Stdout)
+		}
+	}
+
+	return nil
+}
+
+func runProjectDestroy(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	envFlag, _ := cmd.Flags().GetString("env")
+	yes, _ := cmd.Flags().GetBool("yes")
+	keepStack, _ := cmd.Flags().GetBool("keep-stack")
+	keepUser, _ := cmd.Flags().GetBool("keep-user")
+	keepCaddy, _ := cmd.Flags().GetBool("keep-caddy")
+	keepDNS, _ := cmd.Flags().GetBool("keep-dns")
+	keepSecrets, _ := cmd.Flags().GetBool("keep-secrets")
+	keepWorkflow, _ := cmd.Flags().GetBool("keep-workflow")
+	keepConfig, _ := cmd.Flags().GetBool("keep-config")
+
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return err
+	}
+	p := cfg.FindProject(name)
+	if p == nil {
+		return fmt.Errorf("project %q not found", name)
+	}
+
+	var environments []string
+	if envFlag != "" {
+		if _, ok := p.Environments[envFlag]; !ok {
+			return fmt.Errorf("project %q has no %s environment", name, envFlag)
+		}
+		environments = []string{envFlag}
+	} else {
+		for envName := range p.Environments {
+			environments = append(environments, envName)
+		}
+		sort.Strings(environments)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	var confirm func(string) bool
+	if !yes {
+		confirm = func(message string) bool {
+			fmt.Printf("%s? [y/N]: ", message)
+			scanner.Scan()
+			answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+			return answer == "y" || answer == "yes"
+		}
+	}
+
+	for _, envName := range environments {
+		fmt.Printf("\n--- %s environment ---\n", strings.ToUpper(envName))
+		if err := project.Destroy(project.DestroyParams{
+			ProjectName:  name,
+			EnvName:      envName,
+			Store:        store,
+			Confirm:      confirm,
+			KeepStack:    keepStack,
+			KeepUser:     keepUser,
+			KeepCaddy:    keepCaddy,
+			KeepDNS:      keepDNS,
+			KeepSecrets:  keepSecrets,
+			KeepWorkflow: keepWorkflow,
+			KeepConfig:   keepConfig,
+			OnProgress: func(step, total int, message string) {
+				fmt.Printf("Step %d/%d: %s\n", step, total, message)
+			},
+		}); err != nil {
+			return fmt.Errorf("%s teardown failed: %w", envName, err)
+		}
 	}
 
+	fmt.Printf("\nProject %s destroyed.\n", name)
 	return nil
 }
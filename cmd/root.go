@@ -6,11 +6,13 @@ import (
 
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
-	Version = "dev"
-	store   config.Store
+	Version   = "dev"
+	store     config.Store
+	storeSpec string
 )
 
 var rootCmd = &cobra.Command{
@@ -35,14 +37,74 @@ func Execute() {
 
 func init() {
 	rootCmd.Version = Version
+	rootCmd.PersistentFlags().StringVar(&storeSpec, "store", "", "Config store: sqlite, sqlite:<path> or file:<dir> (default $ARNOR_STORE, then sqlite)")
 	cobra.OnInitialize(initStore)
 }
 
 func initStore() {
-	s, err := config.NewSQLiteStore(config.DBPath())
+	spec := storeSpec
+	if spec == "" {
+		spec = os.Getenv("ARNOR_STORE")
+	}
+	s, err := openStore(spec)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: could not open database: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: could not open store: %v\n", err)
 		return
 	}
 	store = s
 }
+
+// openStore opens a store and, for SQLite, sets up the key sources and
+// reports any schema migration.
+func openStore(spec string) (config.Store, error) {
+	s, err := config.OpenStore(spec)
+	if err != nil {
+		return nil, err
+	}
+	if db, ok := s.(*config.SQLiteStore); ok {
+		db.SetKeySources(config.DefaultKeySources(promptPassphrase))
+		if res := db.Migrated(); res.Backup != "" {
+			fmt.Fprintf(os.Stderr, "Migrated database schema from v%d to v%d (backup: %s)\n", res.From, res.To, res.Backup)
+		}
+		if n, err := db.PlaintextSecrets(); err == nil && n > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %d credential(s) and peon key(s) are stored in plaintext; run 'arnor config lock' to encrypt them\n", n)
+		}
+	}
+	return s, nil
+}
+
+// promptPassphrase reads the store passphrase from the terminal. Prompts go
+// to stderr so that commands like "dns export" can still be redirected.
+func promptPassphrase(confirm bool) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("the credential store is locked with a passphrase; set ARNOR_PASSPHRASE when not running interactively")
+	}
+	label := "Store passphrase"
+	if confirm {
+		label = "New store passphrase"
+	}
+	return readPassphrase(label, confirm)
+}
+
+// readPassphrase reads a passphrase from the terminal without echoing it,
+// asking twice if confirm is set.
+func readPassphrase(label string, confirm bool) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		again, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading passphrase: %w", err)
+		}
+		if string(again) != string(pass) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return string(pass), nil
+}
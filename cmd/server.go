@@ -1,13 +1,22 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/dukerupert/arnor/internal/caddy"
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
 	"github.com/dukerupert/arnor/internal/hetzner"
 	"github.com/dukerupert/arnor/internal/peon"
+	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/remote"
+	"github.com/dukerupert/arnor/internal/server"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
@@ -44,6 +53,127 @@ var serverCaddySetupCmd = &cobra.Command{
 	RunE:  runServerCaddySetup,
 }
 
+var serverTrustCmd = &cobra.Command{
+	Use:   "trust <name>",
+	Short: "Pin (or re-pin) a server's SSH host key",
+	Long:  "Connects to a server, shows its current SSH host key fingerprint, and stores it as trusted. Use this after a server has been rebuilt and its host key has legitimately changed.",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServerTrust,
+}
+
+var serverCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a Hetzner server and bootstrap it for projects",
+	Long: `Creates a server in a Hetzner project, waits for it to boot, records it in
+the config, then bootstraps the peon user and installs Caddy as 'server init'
+does. --ssh-key names an SSH key in the Hetzner project whose private half
+is in ~/.ssh, so arnor can log in as root for the bootstrap.`,
+	Args: cobra.NoArgs,
+	RunE: runServerCreate,
+}
+
+var serverRebootCmd = &cobra.Command{
+	Use:   "reboot <name>",
+	Short: "Reboot a server",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServerLifecycle,
+}
+
+var serverPoweroffCmd = &cobra.Command{
+	Use:   "poweroff <name>",
+	Short: "Cut power to a server",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServerLifecycle,
+}
+
+var serverPoweronCmd = &cobra.Command{
+	Use:   "poweron <name>",
+	Short: "Start a stopped server",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServerLifecycle,
+}
+
+var serverResizeCmd = &cobra.Command{
+	Use:   "resize <name>",
+	Short: "Change a server's type",
+	Long: `Changes the server type. A running server is powered off for the resize
+and started again afterwards. Without --upgrade-disk only CPU and memory
+change, so the server can be resized back down later.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runServerLifecycle,
+}
+
+var serverSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <name>",
+	Short: "Take a snapshot of a server's disk",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServerLifecycle,
+}
+
+var serverRebuildCmd = &cobra.Command{
+	Use:   "rebuild <name>",
+	Short: "Reinstall a server from an image, wiping its disk",
+	Long: `Reinstalls the server from an image. Everything on the disk is lost,
+including the peon user, so its peon key and pinned host key are removed from
+the store; run 'arnor server init' afterwards to bootstrap it again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runServerLifecycle,
+}
+
+var serverDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a server and remove it from the config",
+	Long: `Deletes the server at Hetzner and removes its server, peon key and host
+key from the store. Refuses while projects in the config are deployed to it,
+unless --force is given; those projects are left in the config.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runServerLifecycle,
+}
+
+var serverFirewallCmd = &cobra.Command{
+	Use:   "firewall <name>",
+	Short: "Create or update the server's Hetzner firewall",
+	Long: `Creates a Hetzner Cloud firewall named arnor-<name> that only lets in SSH
+(22), HTTP (80) and HTTPS (443), and applies it to the server. App ports stay
+closed; Caddy reaches them on localhost. Add rules with --allow, written as
+PORT[/PROTO][@CIDR,...]:
+
+  --allow 51820/udp                 WireGuard from anywhere
+  --allow 5432/tcp@10.0.0.0/8       Postgres from a private network
+  --allow icmp                      ping
+
+Re-running replaces the firewall's rules, including any added in the console.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runServerFirewall,
+}
+
+var serverSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Update stored servers to match Hetzner",
+	Long: `Reconciles the servers in the store with those in your Hetzner projects.
+Renames, new IPs and recreated servers are picked up, new servers are added,
+and servers that no longer exist are marked orphaned (never deleted).
+
+When a server's IP has changed, the project environments on it are listed
+and you are offered to point their DNS A records and VPS_HOST secrets at the
+new address.`,
+	Args: cobra.NoArgs,
+	RunE: runServerSync,
+}
+
+var serverRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key [name]",
+	Short: "Replace a server's peon SSH key with a new one",
+	Long: `Generates a new ed25519 key, adds it to peon's authorized_keys over the
+current connection, and checks that it logs in. The store and the local copy
+in ~/.ssh are then updated and the old key is removed from the server. If the
+new key can't log in, it is removed again and nothing changes.
+
+Use --all to rotate every server that has a peon key.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runServerRotateKey,
+}
+
 func init() {
 	serverInitCmd.Flags().String("host", "", "Server IP or hostname (required)")
 	serverInitCmd.Flags().String("user", "root", "SSH user to connect as")
@@ -52,10 +182,51 @@ func init() {
 	serverCaddySetupCmd.Flags().String("host", "", "Server IP or hostname (required)")
 	serverCaddySetupCmd.MarkFlagRequired("host")
 
+	serverTrustCmd.Flags().BoolP("yes", "y", false, "Trust the key without prompting")
+
+	serverCreateCmd.Flags().String("name", "", "Server name (required)")
+	serverCreateCmd.Flags().String("type", "cx22", "Server type")
+	serverCreateCmd.Flags().String("location", "fsn1", "Location")
+	serverCreateCmd.Flags().String("image", "ubuntu-24.04", "Image")
+	serverCreateCmd.Flags().String("project", "", "Hetzner project alias (default: the only one configured)")
+	serverCreateCmd.Flags().String("ssh-key", "", "Hetzner SSH key to install for root (required)")
+	serverCreateCmd.MarkFlagRequired("name")
+	serverCreateCmd.MarkFlagRequired("ssh-key")
+
+	for _, c := range []*cobra.Command{serverRebootCmd, serverPoweroffCmd, serverResizeCmd, serverRebuildCmd, serverDeleteCmd} {
+		c.Flags().BoolP("yes", "y", false, "Skip the confirmation prompt")
+	}
+	serverResizeCmd.Flags().String("type", "", "New server type, e.g. cx32 (required)")
+	serverResizeCmd.MarkFlagRequired("type")
+	serverResizeCmd.Flags().Bool("upgrade-disk", false, "Grow the disk too (prevents resizing back down)")
+	serverSnapshotCmd.Flags().String("description", "", "Snapshot description (default: <name>-<timestamp>)")
+	serverRebuildCmd.Flags().String("image", "ubuntu-24.04", "Image to rebuild from")
+	serverDeleteCmd.Flags().Bool("force", false, "Delete even if projects are deployed to the server")
+
+	serverFirewallCmd.Flags().StringArray("allow", nil, "Extra inbound rule, PORT[/PROTO][@CIDR,...] (repeatable)")
+	serverFirewallCmd.Flags().Bool("dry-run", false, "Show the rules without changing anything")
+
+	serverSyncCmd.Flags().Bool("dry-run", false, "Show what would change without changing anything")
+	serverSyncCmd.Flags().BoolP("yes", "y", false, "Update DNS and secrets for changed IPs without asking")
+
+	serverRotateKeyCmd.Flags().Bool("all", false, "Rotate the key of every server with a peon key")
+
 	serverCmd.AddCommand(serverListCmd)
 	serverCmd.AddCommand(serverViewCmd)
 	serverCmd.AddCommand(serverInitCmd)
+	serverCmd.AddCommand(serverCreateCmd)
 	serverCmd.AddCommand(serverCaddySetupCmd)
+	serverCmd.AddCommand(serverTrustCmd)
+	serverCmd.AddCommand(serverRotateKeyCmd)
+	serverCmd.AddCommand(serverRebootCmd)
+	serverCmd.AddCommand(serverPoweroffCmd)
+	serverCmd.AddCommand(serverPoweronCmd)
+	serverCmd.AddCommand(serverResizeCmd)
+	serverCmd.AddCommand(serverSnapshotCmd)
+	serverCmd.AddCommand(serverRebuildCmd)
+	serverCmd.AddCommand(serverDeleteCmd)
+	serverCmd.AddCommand(serverFirewallCmd)
+	serverCmd.AddCommand(serverSyncCmd)
 	rootCmd.AddCommand(serverCmd)
 }
 
@@ -149,7 +320,7 @@ func runServerInit(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Printf("Bootstrapping peon on %s...\n", host)
-	key, err := peon.RunRemote(host, user, auth)
+	key, err := peon.RunRemote(host, user, auth, store)
 	if err != nil {
 		return err
 	}
@@ -170,6 +341,7 @@ func runServerInit(cmd *cobra.Command, args []string) error {
 		ServerIP:   host,
 		PeonKeyPEM: key,
 		CFToken:    cfToken,
+		Store:      store,
 		OnProgress: func(step, total int, message string) {
 			fmt.Printf("[%d/%d] %s\n", step, total, message)
 		},
@@ -199,6 +371,7 @@ func runServerCaddySetup(cmd *cobra.Command, args []string) error {
 		ServerIP:   host,
 		PeonKeyPEM: peonKey,
 		CFToken:    cfToken,
+		Store:      store,
 		OnProgress: func(step, total int, message string) {
 			fmt.Printf("[%d/%d] %s\n", step, total, message)
 		},
@@ -209,6 +382,506 @@ func runServerCaddySetup(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runServerTrust(cmd *cobra.Command, args []string) error {
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	host, err := resolveServerIP(args[0])
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := remote.ScanHostKey(host)
+	if err != nil {
+		return err
+	}
+	pinned, err := store.GetHostKey(host)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Server:      %s (%s)\n", args[0], host)
+	if pinned != "" {
+		fmt.Printf("Pinned key:  %s\n", pinned)
+	}
+	fmt.Printf("Current key: %s\n", fingerprint)
+
+	if pinned == fingerprint {
+		fmt.Println("\nHost key is already trusted.")
+		return nil
+	}
+
+	if !yes {
+		fmt.Print("\nTrust this key? [y/N]: ")
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		if answer := strings.ToLower(strings.TrimSpace(scanner.Text())); answer != "y" && answer != "yes" {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	if err := store.SetHostKey(host, fingerprint); err != nil {
+		return err
+	}
+	fmt.Printf("Trusted host key for %s\n", host)
+	return nil
+}
+
+func runServerCreate(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("name")
+	serverType, _ := cmd.Flags().GetString("type")
+	location, _ := cmd.Flags().GetString("location")
+	image, _ := cmd.Flags().GetString("image")
+	alias, _ := cmd.Flags().GetString("project")
+	sshKey, _ := cmd.Flags().GetString("ssh-key")
+
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.FindServer(name) != nil {
+		return fmt.Errorf("server %q is already in the config", name)
+	}
+	if alias == "" {
+		if len(cfg.HetznerProjects) != 1 {
+			return fmt.Errorf("--project is required when %d Hetzner projects are configured", len(cfg.HetznerProjects))
+		}
+		alias = cfg.HetznerProjects[0].Alias
+	}
+	mgr, err := hetzner.NewManager(cfg.HetznerProjects, store)
+	if err != nil {
+		return err
+	}
+	cloud, err := mgr.CloudClient(alias)
+	if err != nil {
+		return err
+	}
+
+	cfToken := lookupCFToken()
+	if cfToken == "" {
+		fmt.Println("Warning: no Cloudflare API token found — Caddy will be installed without DNS challenge support")
+	}
+
+	srv, err := server.Create(server.CreateParams{
+		Cloud:          cloud,
+		HetznerProject: alias,
+		Name:           name,
+		ServerType:     serverType,
+		Location:       location,
+		Image:          image,
+		SSHKey:         sshKey,
+		CFToken:        cfToken,
+		Auth: peon.SSHAuth{
+			KeyPassphraseFunc: func() ([]byte, error) {
+				fmt.Printf("SSH key passphrase: ")
+				pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+				fmt.Println()
+				return pass, err
+			},
+		},
+		Store: store,
+		OnProgress: func(step, total int, message string) {
+			fmt.Printf("[%d/%d] %s\n", step, total, message)
+		},
+	})
+	if err != nil {
+		switch {
+		case srv != nil && srv.IP == "":
+			fmt.Printf("\n%s was created and recorded without its IP; run 'arnor server sync' to pick it up, then 'arnor server init --host <ip>'.\n", srv.Name)
+		case srv != nil:
+			fmt.Printf("\n%s (%s) was created and recorded; finish with 'arnor server init --host %s'.\n", srv.Name, srv.IP, srv.IP)
+		}
+		return err
+	}
+	fmt.Printf("\nServer %s is ready at %s.\n", srv.Name, srv.IP)
+	return nil
+}
+
+// cloudServer finds a server in the config, falling back to Hetzner, and
+// returns a Cloud API client for its project.
+func cloudServer(name string) (config.Server, *hetzner.CloudClient, error) {
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return config.Server{}, nil, err
+	}
+	mgr, err := hetzner.NewManager(cfg.HetznerProjects, store)
+	if err != nil {
+		return config.Server{}, nil, err
+	}
+	var srv config.Server
+	if s := cfg.FindServer(name); s != nil {
+		srv = *s
+	} else {
+		s, err := mgr.GetServer(name)
+		if err != nil {
+			return config.Server{}, nil, fmt.Errorf("server %q not found in config or Hetzner: %w", name, err)
+		}
+		srv = config.Server{Name: s.Name, IP: s.PublicNet.IPv4.IP, HetznerProject: s.ProjectAlias, HetznerID: s.ID}
+	}
+	cloud, err := mgr.CloudClient(srv.HetznerProject)
+	if err != nil {
+		return config.Server{}, nil, err
+	}
+	return srv, cloud, nil
+}
+
+func runServerLifecycle(cmd *cobra.Command, args []string) error {
+	op := cmd.Name()
+	srv, cloud, err := cloudServer(args[0])
+	if err != nil {
+		return err
+	}
+
+	if op != "poweron" && op != "snapshot" {
+		cfg, err := store.LoadConfig()
+		if err != nil {
+			return err
+		}
+		projects := server.ProjectsOn(cfg, srv.Name)
+		fmt.Printf("Server:   %s (%s)\n", srv.Name, srv.IP)
+		if len(projects) > 0 {
+			fmt.Printf("Projects: %s\n", strings.Join(projects, ", "))
+		} else {
+			fmt.Println("Projects: none")
+		}
+		if op == "delete" && len(projects) > 0 {
+			if force, _ := cmd.Flags().GetBool("force"); !force {
+				return fmt.Errorf("%d project(s) are deployed to %s; destroy them first or use --force", len(projects), srv.Name)
+			}
+		}
+		if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+			fmt.Printf("\n%s %s? [y/N]: ", strings.ToUpper(op[:1])+op[1:], srv.Name)
+			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Scan()
+			answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Aborted.")
+				return nil
+			}
+		}
+	}
+
+	switch op {
+	case "reboot":
+		fmt.Printf("Rebooting %s...\n", srv.Name)
+		err = server.Run(cloud, cloud.Reboot, srv.HetznerID)
+	case "poweroff":
+		fmt.Printf("Powering off %s...\n", srv.Name)
+		err = server.Run(cloud, cloud.PowerOff, srv.HetznerID)
+	case "poweron":
+		fmt.Printf("Powering on %s...\n", srv.Name)
+		err = server.Run(cloud, cloud.PowerOn, srv.HetznerID)
+	case "resize":
+		serverType, _ := cmd.Flags().GetString("type")
+		upgradeDisk, _ := cmd.Flags().GetBool("upgrade-disk")
+		err = server.Resize(cloud, srv.HetznerID, serverType, upgradeDisk, func(message string) { fmt.Println(message) })
+	case "snapshot":
+		description, _ := cmd.Flags().GetString("description")
+		if description == "" {
+			description = fmt.Sprintf("%s-%s", srv.Name, time.Now().UTC().Format("20060102T150405Z"))
+		}
+		fmt.Printf("Taking snapshot %q of %s...\n", description, srv.Name)
+		var imageID int
+		var action *hetzner.Action
+		imageID, action, err = cloud.CreateSnapshot(srv.HetznerID, description)
+		if err == nil {
+			err = cloud.WaitForAction(action, 30*time.Minute)
+		}
+		if err == nil {
+			fmt.Printf("Snapshot image ID: %d\n", imageID)
+		}
+	case "rebuild":
+		image, _ := cmd.Flags().GetString("image")
+		fmt.Printf("Rebuilding %s from %s...\n", srv.Name, image)
+		err = server.Rebuild(cloud, store, srv, image)
+		if err == nil {
+			fmt.Printf("Run 'arnor server init --host %s' to bootstrap it again.\n", srv.IP)
+		}
+	case "delete":
+		fmt.Printf("Deleting %s...\n", srv.Name)
+		err = server.Delete(cloud, store, srv)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+func runServerFirewall(cmd *cobra.Command, args []string) error {
+	allow, _ := cmd.Flags().GetStringArray("allow")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	var extra []hetzner.FirewallRule
+	for _, a := range allow {
+		rule, err := server.ParseRule(a)
+		if err != nil {
+			return err
+		}
+		extra = append(extra, rule)
+	}
+
+	srv, cloud, err := cloudServer(args[0])
+	if err != nil {
+		return err
+	}
+
+	rules := append(server.BaseRules(), extra...)
+	fmt.Printf("Firewall %s for %s (%s):\n\n", server.FirewallName(srv.Name), srv.Name, srv.IP)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "PROTOCOL\tPORT\tSOURCES\tDESCRIPTION")
+	fmt.Fprintln(w, "────────\t────\t───────\t───────────")
+	for _, r := range rules {
+		port := r.Port
+		if port == "" {
+			port = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Protocol, port, strings.Join(r.SourceIPs, ", "), r.Description)
+	}
+	w.Flush()
+
+	if dryRun {
+		return nil
+	}
+
+	fmt.Println()
+	result, err := server.EnsureFirewall(cloud, srv, extra)
+	if err != nil {
+		return err
+	}
+	switch {
+	case result.Created:
+		fmt.Printf("Created firewall %s and applied it to %s.\n", result.Firewall.Name, srv.Name)
+	case result.Applied:
+		fmt.Printf("Updated firewall %s and applied it to %s.\n", result.Firewall.Name, srv.Name)
+	default:
+		fmt.Printf("Updated firewall %s.\n", result.Firewall.Name)
+	}
+	return nil
+}
+
+func runServerSync(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return err
+	}
+	mgr, err := newHetznerManager()
+	if err != nil {
+		return err
+	}
+	live, err := mgr.ListAllServers()
+	if err != nil {
+		return err
+	}
+
+	plan := server.PlanSync(cfg.Servers, live, mgr.Aliases())
+	if len(plan.Changes) == 0 {
+		fmt.Println("Servers are in sync with Hetzner.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tCHANGE\tFROM\tTO")
+	fmt.Fprintln(w, "──────\t──────\t────\t──")
+	for _, c := range plan.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Server, c.Kind, c.Old, c.New)
+	}
+	w.Flush()
+
+	if dryRun {
+		fmt.Println("\nDry run: nothing was changed.")
+		return nil
+	}
+	if err := server.ApplySync(store, plan); err != nil {
+		return err
+	}
+	fmt.Println("\nStore updated.")
+
+	for _, srv := range plan.Servers {
+		if _, ok := plan.Recreated[srv.Name]; ok {
+			fmt.Printf("Warning: %s was recreated, so its peon and host keys were dropped; re-bootstrap it with 'arnor server init --host %s'.\n", srv.Name, srv.IP)
+		}
+	}
+
+	for _, c := range plan.Changes {
+		if c.Kind == "orphaned" {
+			if projects := server.ProjectsOn(cfg, c.Server); len(projects) > 0 {
+				fmt.Printf("Warning: %s is orphaned but still has projects: %s\n", c.Server, strings.Join(projects, ", "))
+			}
+		}
+	}
+
+	// Projects still name the server as it was before the sync.
+	oldNames := make(map[string]string, len(plan.Renames))
+	for oldName, newName := range plan.Renames {
+		oldNames[newName] = oldName
+	}
+	type moved struct {
+		project config.Project
+		env     string
+		oldIP   string
+		newIP   string
+	}
+	var affected []moved
+	for _, srv := range plan.Servers {
+		oldIP, ok := plan.Moved[srv.Name]
+		if !ok {
+			continue
+		}
+		name := srv.Name
+		if oldName, ok := oldNames[name]; ok {
+			name = oldName
+		}
+		for _, p := range cfg.Projects {
+			if p.Server != name {
+				continue
+			}
+			for envName := range p.Environments {
+				affected = append(affected, moved{p, envName, oldIP, srv.IP})
+			}
+		}
+	}
+	if len(affected) == 0 {
+		return nil
+	}
+
+	fmt.Println("\nThese environments are on servers whose IP changed:")
+	for _, a := range affected {
+		fmt.Printf("  - %s/%s (%s): %s → %s\n", a.project.Name, a.env, a.project.Environments[a.env].Domain, a.oldIP, a.newIP)
+	}
+	if !yes {
+		fmt.Print("\nPoint their DNS A records and VPS_HOST secrets at the new IPs? [y/N]: ")
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("Left DNS and secrets alone.")
+			return nil
+		}
+	}
+
+	var failed int
+	secretsSet := make(map[string]bool)
+	for _, a := range affected {
+		env := a.project.Environments[a.env]
+		var provider dns.Provider
+		if env.DNSProvider != "" {
+			provider, err = dns.NewProvider(env.DNSProvider, store)
+		} else {
+			provider, err = dns.ProviderForDomain(env.Domain, cfg, store)
+		}
+		if err == nil {
+			var n int
+			n, err = server.RepointDNS(provider, env.Domain, a.oldIP, a.newIP)
+			if err == nil {
+				fmt.Printf("  %s: updated %d A record(s)\n", env.Domain, n)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", env.Domain, err)
+			failed++
+		}
+
+		// VPS_HOST is shared by the environments of a repo.
+		if a.project.Repo != "" && !secretsSet[a.project.Repo] {
+			secretsSet[a.project.Repo] = true
+			if err := project.SetGitHubSecret(a.project.Repo, "VPS_HOST", a.newIP); err != nil {
+				fmt.Fprintf(os.Stderr, "  %s VPS_HOST: %v\n", a.project.Repo, err)
+				failed++
+			} else {
+				fmt.Printf("  %s: VPS_HOST set to %s\n", a.project.Repo, a.newIP)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d update(s) failed", failed)
+	}
+	return nil
+}
+
+func runServerRotateKey(cmd *cobra.Command, args []string) error {
+	all, _ := cmd.Flags().GetBool("all")
+	if all == (len(args) == 1) {
+		return fmt.Errorf("give a server name or --all")
+	}
+
+	var ips []string
+	if all {
+		keys, err := store.ListPeonKeys()
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			ips = append(ips, k.ServerIP)
+		}
+		if len(ips) == 0 {
+			fmt.Println("No servers have a peon key.")
+			return nil
+		}
+	} else {
+		ip, err := resolveServerIP(args[0])
+		if err != nil {
+			return err
+		}
+		ips = []string{ip}
+	}
+
+	var failed []string
+	for _, ip := range ips {
+		fmt.Printf("Rotating peon key on %s...\n", ip)
+		result, err := peon.Rotate(peon.RotateParams{
+			ServerIP: ip,
+			Store:    store,
+			OnProgress: func(step, total int, message string) {
+				fmt.Printf("[%d/%d] %s\n", step, total, message)
+			},
+		})
+		if err != nil {
+			fmt.Printf("Error: %v\n\n", err)
+			failed = append(failed, ip)
+			continue
+		}
+		fmt.Printf("New key %s saved to %s\n", result.Fingerprint, result.KeyPath)
+		if result.OldKeyKept != nil {
+			fmt.Printf("Warning: the old key is still in authorized_keys on %s: %v\n", ip, result.OldKeyKept)
+		}
+		fmt.Println()
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("rotation failed on %d of %d server(s): %s", len(failed), len(ips), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// resolveServerIP maps a server name to its IP using the config, falling back
+// to Hetzner. An IP address is returned unchanged.
+func resolveServerIP(name string) (string, error) {
+	if net.ParseIP(name) != nil {
+		return name, nil
+	}
+
+	cfg, err := store.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	if srv := cfg.FindServer(name); srv != nil {
+		return srv.IP, nil
+	}
+
+	mgr, err := hetzner.NewManager(cfg.HetznerProjects, store)
+	if err != nil {
+		return "", err
+	}
+	s, err := mgr.GetServer(name)
+	if err != nil {
+		return "", fmt.Errorf("server %q not found in config or Hetzner: %w", name, err)
+	}
+	return s.PublicNet.IPv4.IP, nil
+}
+
 // lookupCFToken returns the Cloudflare API token for Caddy, checking the
 // caddy-specific credential first, then falling back to the default.
 func lookupCFToken() string {
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/dukerupert/arnor/internal/hetzner"
+	"github.com/dukerupert/arnor/internal/plan"
 	"github.com/dukerupert/arnor/internal/project"
 	"github.com/dukerupert/arnor/internal/service"
 	"github.com/spf13/cobra"
@@ -26,6 +27,7 @@ var serviceDeployCmd = &cobra.Command{
 }
 
 func init() {
+	serviceDeployCmd.Flags().Bool("dry-run", false, "Show the commands, files, DNS and config changes without applying them")
 	serviceCmd.AddCommand(serviceDeployCmd)
 	rootCmd.AddCommand(serviceCmd)
 }
@@ -67,7 +69,7 @@ func runServiceDeploy(cmd *cobra.Command, args []string) error {
 	// Port prompt with suggestion
 	var portPrompt string
 	if serverIP != "" && peonKey != "" {
-		usedPorts, scanErr := project.GetUsedPorts(serverIP, peonKey)
+		usedPorts, scanErr := project.GetUsedPorts(serverIP, peonKey, store)
 		if scanErr == nil {
 			suggested := project.SuggestPort(usedPorts)
 			portPrompt = fmt.Sprintf("Port [suggested: %d]", suggested)
@@ -94,8 +96,13 @@ func runServiceDeploy(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("compose file not found: %s", composeFile)
 	}
 
+	var p *plan.Plan
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		p = plan.New(fmt.Sprintf("service deploy %s (%s)", serviceName, domain))
+	}
+
 	fmt.Println()
-	return service.Deploy(service.DeployParams{
+	if err := service.Deploy(service.DeployParams{
 		ServiceName: serviceName,
 		ServerName:  serverName,
 		Domain:      domain,
@@ -106,5 +113,14 @@ func runServiceDeploy(cmd *cobra.Command, args []string) error {
 		OnProgress: func(step, total int, message string) {
 			fmt.Printf("Step %d/%d: %s\n", step, total, message)
 		},
-	})
+		Plan: p,
+	}); err != nil {
+		return err
+	}
+
+	if p != nil {
+		fmt.Println()
+		p.Render(os.Stdout)
+	}
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/arnor/internal/hetzner"
 	"github.com/dukerupert/arnor/internal/project"
 	"github.com/dukerupert/arnor/tui"
@@ -30,6 +31,13 @@ func init() {
 }
 
 func runTUI(cmd *cobra.Command, args []string) error {
+	// Ask for the store passphrase now, not in the middle of a screen.
+	if s, ok := store.(*config.SQLiteStore); ok {
+		if err := s.Unseal(); err != nil {
+			return err
+		}
+	}
+
 	// Check if we have any Hetzner credentials — if not, show setup screen.
 	projects, _ := store.ListHetznerProjects()
 	if len(projects) == 0 {
@@ -0,0 +1,463 @@
+package config
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"filippo.io/age"
+)
+
+// A backup is a gzipped tar of two files, encrypted with age to a
+// passphrase so it can be restored on a machine that has nothing else:
+//
+//	manifest.json   BackupManifest: format, creation time, counts, checksum
+//	store.json      Snapshot: every server, project, credential and key
+//
+// Secrets are written in plaintext inside the archive (it is encrypted as a
+// whole), so a backup of a locked store restores into any store.
+
+const (
+	backupFormat   = 1
+	manifestName   = "manifest.json"
+	snapshotName   = "store.json"
+	maxBackupEntry = 64 << 20
+)
+
+// Snapshot is the full contents of a store.
+type Snapshot struct {
+	Servers     []Server
+	Projects    []Project
+	Credentials []Credential
+	PeonKeys    []PeonKey
+	HostKeys    []HostKey
+}
+
+// BackupManifest describes a backup archive.
+type BackupManifest struct {
+	Format        int
+	CreatedAt     time.Time
+	Hostname      string
+	SchemaVersion int
+	Counts        CopyResult
+	SHA256        string // of store.json
+}
+
+// TakeSnapshot reads everything in s.
+func TakeSnapshot(s Store) (*Snapshot, error) {
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	snap := &Snapshot{Servers: cfg.Servers, Projects: cfg.Projects}
+	if snap.Credentials, err = s.ListCredentials(""); err != nil {
+		return nil, fmt.Errorf("listing credentials: %w", err)
+	}
+	if snap.PeonKeys, err = s.ListPeonKeys(); err != nil {
+		return nil, fmt.Errorf("listing peon keys: %w", err)
+	}
+	if snap.HostKeys, err = s.ListHostKeys(); err != nil {
+		return nil, fmt.Errorf("listing host keys: %w", err)
+	}
+	return snap, nil
+}
+
+// Counts returns the number of records of each kind in the snapshot.
+func (snap *Snapshot) Counts() CopyResult {
+	return CopyResult{
+		Servers:     len(snap.Servers),
+		Projects:    len(snap.Projects),
+		Credentials: len(snap.Credentials),
+		PeonKeys:    len(snap.PeonKeys),
+		HostKeys:    len(snap.HostKeys),
+	}
+}
+
+// WriteBackup writes snap to w as an archive encrypted with passphrase.
+func WriteBackup(w io.Writer, snap *Snapshot, passphrase string) (*BackupManifest, error) {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	hostname, _ := os.Hostname()
+	manifest := &BackupManifest{
+		Format:        backupFormat,
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+		Hostname:      hostname,
+		SchemaVersion: LatestSchemaVersion(),
+		Counts:        snap.Counts(),
+		SHA256:        hex.EncodeToString(sum[:]),
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := age.Encrypt(w, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting backup: %w", err)
+	}
+	gz := gzip.NewWriter(enc)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{manifestName, manifestData}, {snapshotName, data}} {
+		hdr := &tar.Header{Name: f.name, Mode: 0o600, Size: int64(len(f.data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ReadBackup decrypts and validates an archive written by WriteBackup.
+func ReadBackup(r io.Reader, passphrase string) (*BackupManifest, *Snapshot, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	dec, err := age.Decrypt(r, identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, nil, fmt.Errorf("wrong passphrase or not an arnor backup")
+		}
+		return nil, nil, fmt.Errorf("decrypting backup: %w", err)
+	}
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading backup: %w", err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading backup: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxBackupEntry))
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s from backup: %w", hdr.Name, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	manifestData, ok := files[manifestName]
+	if !ok {
+		return nil, nil, fmt.Errorf("backup has no %s", manifestName)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", manifestName, err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, nil, fmt.Errorf("backup format %d is not supported (want %d)", manifest.Format, backupFormat)
+	}
+	if manifest.SchemaVersion > LatestSchemaVersion() {
+		return nil, nil, fmt.Errorf("backup was made with schema version %d, newer than this arnor supports (%d); upgrade arnor", manifest.SchemaVersion, LatestSchemaVersion())
+	}
+
+	data, ok := files[snapshotName]
+	if !ok {
+		return nil, nil, fmt.Errorf("backup has no %s", snapshotName)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return nil, nil, fmt.Errorf("backup is corrupt: %s checksum does not match the manifest", snapshotName)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", snapshotName, err)
+	}
+	if snap.Counts() != manifest.Counts {
+		return nil, nil, fmt.Errorf("backup is corrupt: record counts do not match the manifest")
+	}
+	return &manifest, &snap, nil
+}
+
+// SnapshotChange is one record that restoring a snapshot would add ('+'),
+// change ('~') or remove ('-'). Secret values are never included.
+type SnapshotChange struct {
+	Op   byte
+	Kind string // server, project, credential, peon key or host key
+	Name string
+}
+
+// DiffSnapshots lists what would change if current were replaced by want.
+func DiffSnapshots(current, want *Snapshot) []SnapshotChange {
+	var changes []SnapshotChange
+	diff := func(kind string, have, want map[string]any) {
+		for _, name := range sortedKeys(want) {
+			old, ok := have[name]
+			switch {
+			case !ok:
+				changes = append(changes, SnapshotChange{'+', kind, name})
+			case !reflect.DeepEqual(old, want[name]):
+				changes = append(changes, SnapshotChange{'~', kind, name})
+			}
+		}
+		for _, name := range sortedKeys(have) {
+			if _, ok := want[name]; !ok {
+				changes = append(changes, SnapshotChange{'-', kind, name})
+			}
+		}
+	}
+	diff("server", current.serversByName(), want.serversByName())
+	diff("project", current.projectsByName(), want.projectsByName())
+	diff("credential", current.credentialsByName(), want.credentialsByName())
+	diff("peon key", current.peonKeysByIP(), want.peonKeysByIP())
+	diff("host key", current.hostKeysByHost(), want.hostKeysByHost())
+	return changes
+}
+
+func (snap *Snapshot) serversByName() map[string]any {
+	m := map[string]any{}
+	for _, s := range snap.Servers {
+		m[s.Name] = s
+	}
+	return m
+}
+
+func (snap *Snapshot) projectsByName() map[string]any {
+	m := map[string]any{}
+	for _, p := range snap.Projects {
+		if len(p.Environments) == 0 {
+			p.Environments = nil
+		}
+		m[p.Name] = p
+	}
+	return m
+}
+
+func (snap *Snapshot) credentialsByName() map[string]any {
+	m := map[string]any{}
+	for _, c := range snap.Credentials {
+		m[c.Service+"/"+c.Name+"/"+c.Key] = c.Value
+	}
+	return m
+}
+
+func (snap *Snapshot) peonKeysByIP() map[string]any {
+	m := map[string]any{}
+	for _, k := range snap.PeonKeys {
+		m[k.ServerIP] = k
+	}
+	return m
+}
+
+func (snap *Snapshot) hostKeysByHost() map[string]any {
+	m := map[string]any{}
+	for _, k := range snap.HostKeys {
+		m[k.Host] = k.Fingerprint
+	}
+	return m
+}
+
+// RestoreSnapshot replaces everything in s with snap. Either all of it is
+// replaced or, on error, none of it.
+func RestoreSnapshot(s Store, snap *Snapshot) error {
+	r, ok := s.(interface{ restore(*Snapshot) error })
+	if !ok {
+		return fmt.Errorf("restoring into %T is not supported", s)
+	}
+	return r.restore(snap)
+}
+
+// restore replaces every table in one transaction. Secrets are encrypted
+// under the current master key if the store is locked.
+func (s *SQLiteStore) restore(snap *Snapshot) error {
+	// Fetch the key before the transaction: with a single connection,
+	// masterKey can't query the database while the transaction holds it.
+	key, err := s.masterKey()
+	if err != nil {
+		return err
+	}
+	encrypt := func(value, aad string) (string, error) {
+		if key == nil {
+			return value, nil
+		}
+		return seal(key, value, aad)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"environments", "projects", "servers", "credentials", "peon_keys", "host_keys"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("clearing %s: %w", table, err)
+		}
+	}
+
+	for _, srv := range snap.Servers {
+		if _, err := tx.Exec(
+			"INSERT INTO servers (name, ip, hetzner_project, hetzner_id, orphaned) VALUES (?, ?, ?, ?, ?)",
+			srv.Name, srv.IP, srv.HetznerProject, srv.HetznerID, srv.Orphaned,
+		); err != nil {
+			return fmt.Errorf("restoring server %s: %w", srv.Name, err)
+		}
+	}
+	for _, p := range snap.Projects {
+		res, err := tx.Exec("INSERT INTO projects (name, repo, server, registry) VALUES (?, ?, ?, ?)", p.Name, p.Repo, p.Server, p.Registry)
+		if err != nil {
+			return fmt.Errorf("restoring project %s: %w", p.Name, err)
+		}
+		projectID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for envName, env := range p.Environments {
+			if _, err := tx.Exec(
+				`INSERT INTO environments (project_id, env_name, domain, dns_provider, branch, deploy_path, deploy_user, port, deploy_key_rotated_at, compose_template, container_port, compose_file, healthcheck_path)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				projectID, envName, env.Domain, env.DNSProvider, env.Branch, env.DeployPath, env.DeployUser, env.Port, formatTime(env.DeployKeyRotatedAt),
+				env.ComposeTemplate, env.ContainerPort, env.ComposeFile, env.HealthcheckPath,
+			); err != nil {
+				return fmt.Errorf("restoring environment %s/%s: %w", p.Name, envName, err)
+			}
+		}
+	}
+	for _, c := range snap.Credentials {
+		value, err := encrypt(c.Value, credentialAAD(c.Service, c.Name, c.Key))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO credentials (service, name, key, value) VALUES (?, ?, ?, ?)",
+			c.Service, c.Name, c.Key, value,
+		); err != nil {
+			return fmt.Errorf("restoring credential %s/%s/%s: %w", c.Service, c.Name, c.Key, err)
+		}
+	}
+	for _, k := range snap.PeonKeys {
+		value, err := encrypt(k.PrivateKey, peonKeyAAD(k.ServerIP))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO peon_keys (server_ip, private_key, key_path) VALUES (?, ?, ?)",
+			k.ServerIP, value, k.KeyPath,
+		); err != nil {
+			return fmt.Errorf("restoring peon key for %s: %w", k.ServerIP, err)
+		}
+	}
+	for _, k := range snap.HostKeys {
+		if _, err := tx.Exec("INSERT INTO host_keys (host, fingerprint) VALUES (?, ?)", k.Host, k.Fingerprint); err != nil {
+			return fmt.Errorf("restoring host key for %s: %w", k.Host, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// restore writes the snapshot into a new directory next to the store and
+// swaps it in, keeping recipients.txt.
+func (s *FileStore) restore(snap *Snapshot) error {
+	parent, base := filepath.Dir(s.dir), filepath.Base(s.dir)
+	staging, err := os.MkdirTemp(parent, "."+base+".restore-*")
+	if err != nil {
+		return fmt.Errorf("creating staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	recipients, err := os.ReadFile(filepath.Join(s.dir, recipientsFile))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", recipientsFile, err)
+	}
+	if err := os.WriteFile(filepath.Join(staging, recipientsFile), recipients, 0o644); err != nil {
+		return err
+	}
+
+	tmp := &FileStore{dir: staging, identity: s.identity, recipients: s.recipients}
+	if err := tmp.SaveConfig(&Config{Servers: snap.Servers, Projects: snap.Projects}); err != nil {
+		return err
+	}
+	for _, c := range snap.Credentials {
+		if err := tmp.SetCredential(c.Service, c.Name, c.Key, c.Value); err != nil {
+			return err
+		}
+	}
+	for _, k := range snap.PeonKeys {
+		if err := tmp.SetPeonKey(k.ServerIP, k.PrivateKey, k.KeyPath); err != nil {
+			return err
+		}
+	}
+	for _, k := range snap.HostKeys {
+		if err := tmp.SetHostKey(k.Host, k.Fingerprint); err != nil {
+			return err
+		}
+	}
+
+	// Move the kind directories across rather than the store directory
+	// itself, which may be a git checkout with other files in it.
+	old, err := os.MkdirTemp(parent, "."+base+".old-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(old)
+	kinds := []string{"projects", "servers", "credentials", "peon_keys", "host_keys"}
+	var moved, placed []string
+	rollback := func() {
+		for _, kind := range placed {
+			os.RemoveAll(filepath.Join(s.dir, kind))
+		}
+		for _, kind := range moved {
+			os.Rename(filepath.Join(old, kind), filepath.Join(s.dir, kind))
+		}
+	}
+	for _, kind := range kinds {
+		err := os.Rename(filepath.Join(s.dir, kind), filepath.Join(old, kind))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			rollback()
+			return fmt.Errorf("restoring %s: %w", kind, err)
+		}
+		moved = append(moved, kind)
+	}
+	for _, kind := range kinds {
+		err := os.Rename(filepath.Join(staging, kind), filepath.Join(s.dir, kind))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			rollback()
+			return fmt.Errorf("restoring %s: %w", kind, err)
+		}
+		placed = append(placed, kind)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		Servers: []Server{{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}},
+		Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{
+			"prod": {Domain: "myclient.com", DNSProvider: "porkbun", Branch: "main", DeployPath: "/opt/myclient/prod", DeployUser: "peon", Port: 3000},
+		}}},
+		Credentials: []Credential{
+			{Service: "hetzner", Name: "prod", Key: "api_token", Value: "tok123"},
+			{Service: "porkbun", Name: "default", Key: "api_key", Value: "pk1"},
+		},
+		PeonKeys: []PeonKey{{ServerIP: "1.2.3.4", PrivateKey: "PRIVATE KEY", KeyPath: "/tmp/peon"}},
+		HostKeys: []HostKey{{Host: "1.2.3.4", Fingerprint: "SHA256:abc"}},
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	snap := testSnapshot()
+	var buf bytes.Buffer
+	manifest, err := WriteBackup(&buf, snap, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Counts != snap.Counts() || manifest.SchemaVersion != LatestSchemaVersion() {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("tok123")) {
+		t.Error("backup contains a secret in plaintext")
+	}
+
+	if _, _, err := ReadBackup(bytes.NewReader(buf.Bytes()), "wrong"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("wrong passphrase: err = %v", err)
+	}
+	got, restored, err := ReadBackup(bytes.NewReader(buf.Bytes()), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SHA256 != manifest.SHA256 || !got.CreatedAt.Equal(manifest.CreatedAt) {
+		t.Errorf("manifest = %+v, want %+v", got, manifest)
+	}
+	if !reflect.DeepEqual(restored, snap) {
+		t.Errorf("snapshot = %+v, want %+v", restored, snap)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	current := testSnapshot()
+	want := testSnapshot()
+	if changes := DiffSnapshots(current, want); len(changes) != 0 {
+		t.Errorf("identical snapshots: %+v", changes)
+	}
+
+	want.Servers = append(want.Servers, Server{Name: "web2", IP: "5.6.7.8"})
+	want.Projects[0].Environments = map[string]Environment{"prod": {Domain: "other.com"}}
+	want.Credentials[0].Value = "tok999"
+	want.Credentials = want.Credentials[:1]
+	want.HostKeys = nil
+	got := DiffSnapshots(current, want)
+	expected := []SnapshotChange{
+		{'+', "server", "web2"},
+		{'~', "project", "myclient"},
+		{'~', "credential", "hetzner/prod/api_token"},
+		{'-', "credential", "porkbun/default/api_key"},
+		{'-', "host key", "1.2.3.4"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("DiffSnapshots =\n%+v\nwant\n%+v", got, expected)
+	}
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	snap := testSnapshot()
+	stores := map[string]Store{"sqlite": newTestStore(t)}
+	stores["file"], _ = newTestFileStore(t)
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			// Records not in the snapshot are removed.
+			s.SetCredential("cloudflare", "default", "api_token", "cf1")
+			s.SaveConfig(&Config{Servers: []Server{{Name: "old", IP: "9.9.9.9"}}})
+
+			if err := RestoreSnapshot(s, snap); err != nil {
+				t.Fatalf("RestoreSnapshot: %v", err)
+			}
+			got, err := TakeSnapshot(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changes := DiffSnapshots(got, snap); len(changes) != 0 {
+				t.Errorf("after restore: %+v", changes)
+			}
+		})
+	}
+}
+
+func TestRestoreSnapshotLocked(t *testing.T) {
+	s := newTestStore(t)
+	s.SetKeySources(passphrase("pw"))
+	source, _ := passphrase("pw")("passphrase")
+	if err := s.Lock(source); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreSnapshot(s, testSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range rawValues(t, s) {
+		if !strings.HasPrefix(v, encPrefix) {
+			t.Errorf("restored value stored in plaintext: %q", v)
+		}
+	}
+	if v, _ := s.GetCredential("hetzner", "prod", "api_token"); v != "tok123" {
+		t.Errorf("GetCredential = %q", v)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -21,6 +22,7 @@ type Server struct {
 	IP             string
 	HetznerProject string
 	HetznerID      int
+	Orphaned       bool // no longer found in Hetzner by "server sync"
 }
 
 type Project struct {
@@ -28,6 +30,10 @@ type Project struct {
 	Repo         string
 	Server       string
 	Environments map[string]Environment
+	// Registry is where the project's images are pushed: "" or
+	// "dockerhub", "ghcr", or a self-hosted registry such as
+	// "registry.example.com/team".
+	Registry string
 }
 
 type Environment struct {
@@ -37,6 +43,20 @@ type Environment struct {
 	DeployPath  string
 	DeployUser  string
 	Port        int
+	// DeployKeyRotatedAt is when the deploy user's SSH key was last
+	// generated, or zero if unknown (set up before this was tracked).
+	DeployKeyRotatedAt time.Time
+	// ComposeTemplate names the template docker-compose.yml was generated
+	// from ("" is the plain web template), and ContainerPort is the port the
+	// app listens on inside its container (0 means 80).
+	ComposeTemplate string
+	ContainerPort   int
+	// HealthcheckPath is the path in the app that the generated compose
+	// file's healthcheck requests, e.g. "/healthz"; "" means no healthcheck.
+	HealthcheckPath string
+	// ComposeFile is the path in the repo of a compose file deployed
+	// instead of a generated one; "" means use ComposeTemplate.
+	ComposeFile string
 }
 
 func (c *Config) FindServer(name string) *Server {
@@ -83,8 +103,9 @@ func RootDomain(domain string) (string, error) {
 }
 
 // DetectDNSProvider inspects nameservers for a domain and returns "porkbun",
-// "cloudflare", or an error if unrecognized. If the domain is a subdomain
-// (e.g. project.angmar.dev), it walks up to the parent domain to find NS records.
+// "cloudflare", "hetzner", or an error if unrecognized. If the domain is a
+// subdomain (e.g. project.angmar.dev), it walks up to the parent domain to find
+// NS records.
 func DetectDNSProvider(domain string) (string, error) {
 	candidate := domain
 	for {
@@ -116,6 +137,9 @@ func matchProvider(domain string, nss []*net.NS) (string, error) {
 		if strings.Contains(host, "cloudflare") {
 			return "cloudflare", nil
 		}
+		if strings.Contains(host, "hetzner") {
+			return "hetzner", nil
+		}
 	}
 
 	var nsNames []string
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMatchProvider(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"curitiba.ns.porkbun.com.", "porkbun"},
+		{"ada.ns.cloudflare.com.", "cloudflare"},
+		{"hydrogen.ns.hetzner.com.", "hetzner"},
+		{"HELIUM.NS.HETZNER.DE.", "hetzner"},
+	}
+	for _, tt := range tests {
+		got, err := matchProvider("example.com", []*net.NS{{Host: tt.host}})
+		if err != nil {
+			t.Errorf("matchProvider(%s): %v", tt.host, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("matchProvider(%s) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	if _, err := matchProvider("example.com", []*net.NS{{Host: "ns1.example.net."}}); err == nil {
+		t.Error("expected error for unrecognized nameservers")
+	}
+}
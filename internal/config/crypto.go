@@ -0,0 +1,188 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/argon2"
+)
+
+// Once a store is locked, credential values and peon private keys are
+// encrypted with AES-256-GCM under a master key. The master key comes from a
+// KeySource; the store records which kind of source it was locked with and a
+// random salt, plus a check value to tell a wrong key from corrupt data.
+// Encrypted values are stored as encPrefix followed by base64 of the nonce
+// and ciphertext, so plaintext rows written before locking still read back.
+
+const encPrefix = "enc:v1:"
+
+// keyringService is the service name master keys are saved under in the OS
+// keyring.
+const keyringService = "arnor"
+
+// checkPlaintext is sealed with the master key to verify it on unlock.
+const checkPlaintext = "arnor"
+
+// ErrWrongKey is returned when the master key does not open the store.
+var ErrWrongKey = errors.New("wrong passphrase or key: cannot decrypt the credential store")
+
+// KeySource supplies the master key for a locked store.
+type KeySource interface {
+	// Kind is recorded in the store so that it is unlocked with the same
+	// kind of source it was locked with.
+	Kind() string
+	// Key returns the 32-byte master key for a store with the given salt.
+	// creating is set when locking or rekeying, so the source may ask for a
+	// new passphrase (with confirmation) or generate and save a new key.
+	Key(salt []byte, creating bool) ([]byte, error)
+}
+
+// KeySourceFunc returns the KeySource for a kind recorded in a locked store.
+type KeySourceFunc func(kind string) (KeySource, error)
+
+// PassphraseKeySource derives the master key from a passphrase with
+// argon2id.
+type PassphraseKeySource struct {
+	// Prompt asks for the passphrase. confirm is set when a new passphrase
+	// is being chosen and should be asked for twice.
+	Prompt func(confirm bool) (string, error)
+}
+
+func (p PassphraseKeySource) Kind() string { return "passphrase" }
+
+func (p PassphraseKeySource) Key(salt []byte, creating bool) ([]byte, error) {
+	if p.Prompt == nil {
+		return nil, fmt.Errorf("the credential store is locked with a passphrase; set ARNOR_PASSPHRASE or run interactively")
+	}
+	pass, err := p.Prompt(creating)
+	if err != nil {
+		return nil, err
+	}
+	if pass == "" {
+		return nil, fmt.Errorf("passphrase is required")
+	}
+	return argon2.IDKey([]byte(pass), salt, 3, 64*1024, 4, 32), nil
+}
+
+// KeyringKeySource keeps a random master key in the OS keyring (macOS
+// Keychain, Secret Service on Linux, Windows Credential Manager). Each salt
+// gets its own entry, so a rekey never overwrites the key still in use.
+type KeyringKeySource struct{}
+
+func (KeyringKeySource) Kind() string { return "keyring" }
+
+func (KeyringKeySource) Key(salt []byte, creating bool) ([]byte, error) {
+	account := keyringAccount(salt)
+	if creating {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := keyring.Set(keyringService, account, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return nil, fmt.Errorf("saving master key to the OS keyring: %w", err)
+		}
+		return key, nil
+	}
+	encoded, err := keyring.Get(keyringService, account)
+	if err != nil {
+		return nil, fmt.Errorf("reading master key from the OS keyring: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// Forget removes the keyring entry for salt once the store no longer uses it.
+func (KeyringKeySource) Forget(salt []byte) error {
+	err := keyring.Delete(keyringService, keyringAccount(salt))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func keyringAccount(salt []byte) string {
+	return "master-key-" + hex.EncodeToString(salt[:8])
+}
+
+// DefaultKeySources resolves the built-in key sources. prompt is used for
+// passphrases; ARNOR_PASSPHRASE takes precedence when set, for scripts.
+func DefaultKeySources(prompt func(confirm bool) (string, error)) KeySourceFunc {
+	return func(kind string) (KeySource, error) {
+		switch kind {
+		case "passphrase":
+			if pass := os.Getenv("ARNOR_PASSPHRASE"); pass != "" {
+				return PassphraseKeySource{Prompt: func(bool) (string, error) { return pass, nil }}, nil
+			}
+			return PassphraseKeySource{Prompt: prompt}, nil
+		case "keyring":
+			return KeyringKeySource{}, nil
+		default:
+			return nil, fmt.Errorf("unknown key source %q", kind)
+		}
+	}
+}
+
+// seal encrypts plaintext under key, binding it to aad (the row it belongs
+// to) so that values can't be swapped between rows.
+func seal(key []byte, plaintext, aad string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value written by seal. Values without the prefix are
+// plaintext and returned as they are.
+func open(key []byte, stored, aad string) (string, error) {
+	if !strings.HasPrefix(stored, encPrefix) {
+		return stored, nil
+	}
+	if key == nil {
+		return "", fmt.Errorf("value is encrypted but the store is not locked")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decoding encrypted value: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted value is truncated")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func credentialAAD(service, name, key string) string {
+	return "credentials/" + service + "/" + name + "/" + key
+}
+
+func peonKeyAAD(serverIP string) string {
+	return "peon_keys/" + serverIP
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SetKeySources sets how the store finds the master key when it is locked.
+// Without it, secrets in a locked store can't be read or written.
+func (s *SQLiteStore) SetKeySources(fn KeySourceFunc) {
+	s.keySources = fn
+}
+
+// Encryption returns the kind of key source the store is locked with, or ""
+// if secrets are stored in plaintext.
+func (s *SQLiteStore) Encryption() (string, error) {
+	kind, _, _, err := s.encryptionMeta()
+	return kind, err
+}
+
+func (s *SQLiteStore) encryptionMeta() (kind string, salt []byte, check string, err error) {
+	var encodedSalt string
+	err = s.db.QueryRow("SELECT key_source, salt, check_value FROM encryption WHERE id = 1").Scan(&kind, &encodedSalt, &check)
+	if err == sql.ErrNoRows {
+		return "", nil, "", nil
+	}
+	if err != nil {
+		return "", nil, "", fmt.Errorf("reading encryption settings: %w", err)
+	}
+	salt, err = base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("decoding salt: %w", err)
+	}
+	return kind, salt, check, nil
+}
+
+// PlaintextSecrets counts the credentials and peon keys stored unencrypted.
+// Upgrading the schema doesn't encrypt them; only Lock does.
+func (s *SQLiteStore) PlaintextSecrets() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM credentials WHERE value NOT LIKE ? || '%') +
+		(SELECT COUNT(*) FROM peon_keys WHERE private_key NOT LIKE ? || '%')`,
+		encPrefix, encPrefix).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting plaintext secrets: %w", err)
+	}
+	return n, nil
+}
+
+// Unseal loads and verifies the master key of a locked store, prompting if
+// the key source needs to. It is done on first use anyway; call it up front
+// when a prompt later on would be awkward, e.g. before starting the TUI.
+func (s *SQLiteStore) Unseal() error {
+	_, err := s.masterKey()
+	return err
+}
+
+// masterKey returns the key secrets are encrypted with, or nil if the store
+// is not locked. The key is cached for the life of the store.
+func (s *SQLiteStore) masterKey() ([]byte, error) {
+	if s.key != nil {
+		return s.key, nil
+	}
+	kind, salt, check, err := s.encryptionMeta()
+	if err != nil || kind == "" {
+		return nil, err
+	}
+	if s.keySources == nil {
+		return nil, fmt.Errorf("the credential store is locked (%s) and no key source is configured", kind)
+	}
+	source, err := s.keySources(kind)
+	if err != nil {
+		return nil, err
+	}
+	key, err := source.Key(salt, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := open(key, check, "check"); err != nil {
+		return nil, err
+	}
+	s.key = key
+	return key, nil
+}
+
+func (s *SQLiteStore) encrypt(value, aad string) (string, error) {
+	key, err := s.masterKey()
+	if err != nil || key == nil {
+		return value, err
+	}
+	return seal(key, value, aad)
+}
+
+func (s *SQLiteStore) decrypt(value, aad string) (string, error) {
+	if !strings.HasPrefix(value, encPrefix) {
+		return value, nil
+	}
+	key, err := s.masterKey()
+	if err != nil {
+		return "", err
+	}
+	return open(key, value, aad)
+}
+
+// Lock encrypts every credential and peon key with a master key from
+// source. Rows are rewritten in place in one transaction.
+func (s *SQLiteStore) Lock(source KeySource) error {
+	kind, _, _, err := s.encryptionMeta()
+	if err != nil {
+		return err
+	}
+	if kind != "" {
+		return fmt.Errorf("the credential store is already locked (%s); use rekey to change the key", kind)
+	}
+	return s.reencrypt(nil, source)
+}
+
+// Unlock decrypts every secret back to plaintext and removes the
+// encryption settings.
+func (s *SQLiteStore) Unlock() error {
+	kind, salt, _, err := s.encryptionMeta()
+	if err != nil {
+		return err
+	}
+	if kind == "" {
+		return fmt.Errorf("the credential store is not locked")
+	}
+	oldKey, err := s.masterKey()
+	if err != nil {
+		return err
+	}
+	if err := s.reencrypt(oldKey, nil); err != nil {
+		return err
+	}
+	s.forget(kind, salt)
+	return nil
+}
+
+// Rekey re-encrypts every secret under a new master key from source, which
+// may be of a different kind than the current one.
+func (s *SQLiteStore) Rekey(source KeySource) error {
+	kind, salt, _, err := s.encryptionMeta()
+	if err != nil {
+		return err
+	}
+	if kind == "" {
+		return fmt.Errorf("the credential store is not locked; use lock first")
+	}
+	oldKey, err := s.masterKey()
+	if err != nil {
+		return err
+	}
+	if err := s.reencrypt(oldKey, source); err != nil {
+		return err
+	}
+	s.forget(kind, salt)
+	return nil
+}
+
+// forget drops a key source's copy of a master key that is no longer used.
+func (s *SQLiteStore) forget(kind string, salt []byte) {
+	if s.keySources == nil {
+		return
+	}
+	if source, err := s.keySources(kind); err == nil {
+		forgetKey(source, salt)
+	}
+}
+
+// forgetKey calls Forget on sources that save keys, such as the keyring.
+// Failing to is harmless, so errors are ignored.
+func forgetKey(source KeySource, salt []byte) {
+	if f, ok := source.(interface{ Forget(salt []byte) error }); ok {
+		_ = f.Forget(salt)
+	}
+}
+
+// reencrypt rewrites all secrets, decrypting with oldKey (nil for
+// plaintext) and encrypting under a new key from source (nil for
+// plaintext), and updates the encryption settings to match.
+func (s *SQLiteStore) reencrypt(oldKey []byte, source KeySource) error {
+	var newKey []byte
+	var salt []byte
+	if source != nil {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		key, err := source.Key(salt, true)
+		if err != nil {
+			return err
+		}
+		newKey = key
+	}
+	committed := false
+	defer func() {
+		if source != nil && !committed {
+			forgetKey(source, salt)
+		}
+	}()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rewrite := func(stored, aad string) (string, error) {
+		plain, err := open(oldKey, stored, aad)
+		if err != nil {
+			return "", err
+		}
+		if newKey == nil {
+			return plain, nil
+		}
+		return seal(newKey, plain, aad)
+	}
+
+	type row struct {
+		id         int
+		value, aad string
+	}
+	var creds, peons []row
+
+	rows, err := tx.Query("SELECT id, service, name, key, value FROM credentials")
+	if err != nil {
+		return fmt.Errorf("reading credentials: %w", err)
+	}
+	for rows.Next() {
+		var r row
+		var service, name, key string
+		if err := rows.Scan(&r.id, &service, &name, &key, &r.value); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning credential: %w", err)
+		}
+		r.aad = credentialAAD(service, name, key)
+		creds = append(creds, r)
+	}
+	rows.Close()
+
+	rows, err = tx.Query("SELECT id, server_ip, private_key FROM peon_keys")
+	if err != nil {
+		return fmt.Errorf("reading peon keys: %w", err)
+	}
+	for rows.Next() {
+		var r row
+		var ip string
+		if err := rows.Scan(&r.id, &ip, &r.value); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning peon key: %w", err)
+		}
+		r.aad = peonKeyAAD(ip)
+		peons = append(peons, r)
+	}
+	rows.Close()
+
+	for _, r := range creds {
+		value, err := rewrite(r.value, r.aad)
+		if err != nil {
+			return fmt.Errorf("re-encrypting %s: %w", r.aad, err)
+		}
+		if _, err := tx.Exec("UPDATE credentials SET value = ? WHERE id = ?", value, r.id); err != nil {
+			return fmt.Errorf("updating credential: %w", err)
+		}
+	}
+	for _, r := range peons {
+		value, err := rewrite(r.value, r.aad)
+		if err != nil {
+			return fmt.Errorf("re-encrypting %s: %w", r.aad, err)
+		}
+		if _, err := tx.Exec("UPDATE peon_keys SET private_key = ? WHERE id = ?", value, r.id); err != nil {
+			return fmt.Errorf("updating peon key: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM encryption"); err != nil {
+		return fmt.Errorf("clearing encryption settings: %w", err)
+	}
+	if newKey != nil {
+		check, err := seal(newKey, checkPlaintext, "check")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO encryption (id, key_source, salt, check_value) VALUES (1, ?, ?, ?)",
+			source.Kind(), base64.StdEncoding.EncodeToString(salt), check,
+		); err != nil {
+			return fmt.Errorf("saving encryption settings: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	s.key = newKey
+	return nil
+}
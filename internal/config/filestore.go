@@ -0,0 +1,612 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// FileStore implements Store as a directory of age-encrypted YAML files, so
+// that a team can share one configuration through a private git repository.
+// Each project, server, credential service, peon key and host key has its
+// own file, which keeps merge conflicts to the records two people actually
+// both changed:
+//
+//	recipients.txt                  age public keys of everyone with access
+//	projects/<name>.yaml.age
+//	servers/<name>.yaml.age
+//	credentials/<service>.yaml.age
+//	peon_keys/<server ip>.yaml.age
+//	host_keys/<host>.yaml.age
+//
+// Files are encrypted to every key in recipients.txt plus the local
+// identity. After adding someone to recipients.txt, run Rekey so they can
+// read the existing files.
+type FileStore struct {
+	dir        string
+	identity   *age.X25519Identity
+	recipients []age.Recipient
+}
+
+const recipientsFile = "recipients.txt"
+
+// IdentityPath returns the default path of the local age identity.
+func IdentityPath() string {
+	if p := os.Getenv("ARNOR_AGE_IDENTITY"); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "arnor", "age.key")
+}
+
+// NewFileStore opens the store in dir using the age identity at
+// identityPath. A missing directory is created, with a recipients.txt
+// holding just the local identity.
+func NewFileStore(dir, identityPath string) (*FileStore, error) {
+	identity, err := loadIdentity(identityPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating store dir: %w", err)
+	}
+
+	s := &FileStore{dir: dir, identity: identity}
+	recipientsPath := filepath.Join(dir, recipientsFile)
+	if _, err := os.Stat(recipientsPath); errors.Is(err, fs.ErrNotExist) {
+		line := fmt.Sprintf("# age public keys that can read this store, one per line\n%s\n", identity.Recipient())
+		if err := os.WriteFile(recipientsPath, []byte(line), 0o644); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", recipientsFile, err)
+		}
+	}
+	if err := s.loadRecipients(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadIdentity reads the X25519 identity at path.
+func loadIdentity(path string) (*age.X25519Identity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no age identity at %s; create one with 'arnor config migrate-store' or age-keygen", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading age identity: %w", err)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing age identity %s: %w", path, err)
+	}
+	for _, id := range identities {
+		if x, ok := id.(*age.X25519Identity); ok {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("%s holds no X25519 identity", path)
+}
+
+// GenerateIdentity writes a new age identity to path unless one exists, and
+// returns its public key.
+func GenerateIdentity(path string) (string, error) {
+	if identity, err := loadIdentity(path); err == nil {
+		return identity.Recipient().String(), nil
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	data := fmt.Sprintf("# public key: %s\n%s\n", identity.Recipient(), identity)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		return "", fmt.Errorf("writing age identity: %w", err)
+	}
+	return identity.Recipient().String(), nil
+}
+
+func (s *FileStore) loadRecipients() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, recipientsFile))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", recipientsFile, err)
+	}
+	recipients, err := age.ParseRecipients(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", recipientsFile, err)
+	}
+	s.recipients = append(recipients, s.identity.Recipient())
+	return nil
+}
+
+// Dir returns the store directory.
+func (s *FileStore) Dir() string { return s.dir }
+
+func (s *FileStore) Close() error { return nil }
+
+// --- Files ---
+
+func (s *FileStore) path(kind, name string) string {
+	return filepath.Join(s.dir, kind, url.PathEscape(name)+".yaml.age")
+}
+
+// read decrypts and decodes one file into v. It reports false if the file
+// does not exist.
+func (s *FileStore) read(kind, name string, v any) (bool, error) {
+	return s.readFile(s.path(kind, name), v)
+}
+
+func (s *FileStore) readFile(path string, v any) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r, err := age.Decrypt(f, s.identity)
+	if err != nil {
+		return false, fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return false, fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// write encodes and encrypts v into one file, replacing it atomically.
+func (s *FileStore) write(kind, name string, v any) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := s.path(kind, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w, err := age.Encrypt(tmp, s.recipients...)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("encrypting %s: %w", path, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) remove(kind, name string) error {
+	err := os.Remove(s.path(kind, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// names lists the records of a kind, sorted.
+func (s *FileStore) names(kind string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, kind))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		base, ok := strings.CutSuffix(e.Name(), ".yaml.age")
+		if !ok || e.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(base)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Rekey re-encrypts every file to the keys currently in recipients.txt, e.g.
+// after adding or removing a teammate.
+func (s *FileStore) Rekey() error {
+	if err := s.loadRecipients(); err != nil {
+		return err
+	}
+	for _, kind := range []string{"projects", "servers", "credentials", "peon_keys", "host_keys"} {
+		names, err := s.names(kind)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			var v any
+			if _, err := s.read(kind, name, &v); err != nil {
+				return err
+			}
+			if err := s.write(kind, name, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// --- Credentials ---
+
+// credentialFile is one service's credentials: name -> key -> value.
+type credentialFile map[string]map[string]string
+
+func (s *FileStore) GetCredential(service, name, key string) (string, error) {
+	var creds credentialFile
+	if _, err := s.read("credentials", service, &creds); err != nil {
+		return "", err
+	}
+	value, ok := creds[name][key]
+	if !ok {
+		return "", fmt.Errorf("credential not found: %s/%s/%s", service, name, key)
+	}
+	return value, nil
+}
+
+func (s *FileStore) SetCredential(service, name, key, value string) error {
+	var creds credentialFile
+	if _, err := s.read("credentials", service, &creds); err != nil {
+		return err
+	}
+	if creds == nil {
+		creds = make(credentialFile)
+	}
+	if creds[name] == nil {
+		creds[name] = make(map[string]string)
+	}
+	creds[name][key] = value
+	if err := s.write("credentials", service, creds); err != nil {
+		return fmt.Errorf("setting credential: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) ListCredentials(service string) ([]Credential, error) {
+	services := []string{service}
+	if service == "" {
+		var err error
+		if services, err = s.names("credentials"); err != nil {
+			return nil, fmt.Errorf("listing credentials: %w", err)
+		}
+	}
+
+	var out []Credential
+	for _, svc := range services {
+		var creds credentialFile
+		if _, err := s.read("credentials", svc, &creds); err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(creds) {
+			for _, key := range sortedKeys(creds[name]) {
+				out = append(out, Credential{Service: svc, Name: name, Key: key, Value: creds[name][key]})
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *FileStore) DeleteCredential(service, name string) error {
+	var creds credentialFile
+	found, err := s.read("credentials", service, &creds)
+	if err != nil || !found {
+		return err
+	}
+	delete(creds, name)
+	if len(creds) == 0 {
+		return s.remove("credentials", service)
+	}
+	return s.write("credentials", service, creds)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// --- Peon Keys ---
+
+type peonKeyFile struct {
+	PrivateKey string `yaml:"private_key"`
+	KeyPath    string `yaml:"key_path"`
+}
+
+func (s *FileStore) GetPeonKey(serverIP string) (string, error) {
+	var k peonKeyFile
+	found, err := s.read("peon_keys", serverIP, &k)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("peon key not found for %s", serverIP)
+	}
+	return k.PrivateKey, nil
+}
+
+func (s *FileStore) SetPeonKey(serverIP, privateKey, keyPath string) error {
+	if err := s.write("peon_keys", serverIP, peonKeyFile{PrivateKey: privateKey, KeyPath: keyPath}); err != nil {
+		return fmt.Errorf("setting peon key: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) ListPeonKeys() ([]PeonKey, error) {
+	ips, err := s.names("peon_keys")
+	if err != nil {
+		return nil, fmt.Errorf("listing peon keys: %w", err)
+	}
+	var keys []PeonKey
+	for _, ip := range ips {
+		var k peonKeyFile
+		if _, err := s.read("peon_keys", ip, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, PeonKey{ServerIP: ip, PrivateKey: k.PrivateKey, KeyPath: k.KeyPath})
+	}
+	return keys, nil
+}
+
+func (s *FileStore) DeletePeonKey(serverIP string) error {
+	if err := s.remove("peon_keys", serverIP); err != nil {
+		return fmt.Errorf("deleting peon key for %s: %w", serverIP, err)
+	}
+	return nil
+}
+
+// --- Host Keys ---
+
+type hostKeyFile struct {
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+// GetHostKey returns the pinned SHA256 fingerprint for host, or "" if the
+// host has not been seen before.
+func (s *FileStore) GetHostKey(host string) (string, error) {
+	var k hostKeyFile
+	if _, err := s.read("host_keys", host, &k); err != nil {
+		return "", err
+	}
+	return k.Fingerprint, nil
+}
+
+func (s *FileStore) SetHostKey(host, fingerprint string) error {
+	if err := s.write("host_keys", host, hostKeyFile{Fingerprint: fingerprint}); err != nil {
+		return fmt.Errorf("setting host key: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) ListHostKeys() ([]HostKey, error) {
+	hosts, err := s.names("host_keys")
+	if err != nil {
+		return nil, fmt.Errorf("listing host keys: %w", err)
+	}
+	var keys []HostKey
+	for _, host := range hosts {
+		fingerprint, err := s.GetHostKey(host)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, HostKey{Host: host, Fingerprint: fingerprint})
+	}
+	return keys, nil
+}
+
+func (s *FileStore) DeleteHostKey(host string) error {
+	if err := s.remove("host_keys", host); err != nil {
+		return fmt.Errorf("deleting host key for %s: %w", host, err)
+	}
+	return nil
+}
+
+// --- Hetzner Projects ---
+
+func (s *FileStore) ListHetznerProjects() ([]HetznerProject, error) {
+	creds, err := s.ListCredentials("hetzner")
+	if err != nil {
+		return nil, fmt.Errorf("listing hetzner projects: %w", err)
+	}
+	var projects []HetznerProject
+	for _, c := range creds {
+		if c.Key == "api_token" {
+			projects = append(projects, HetznerProject{Alias: c.Name})
+		}
+	}
+	return projects, nil
+}
+
+// --- Config (LoadConfig / SaveConfig) ---
+
+type serverFile struct {
+	IP             string `yaml:"ip"`
+	HetznerProject string `yaml:"hetzner_project"`
+	HetznerID      int    `yaml:"hetzner_id"`
+	Orphaned       bool   `yaml:"orphaned,omitempty"`
+}
+
+type projectFile struct {
+	Repo         string                     `yaml:"repo"`
+	Server       string                     `yaml:"server"`
+	Environments map[string]environmentFile `yaml:"environments"`
+	Registry     string                     `yaml:"registry,omitempty"`
+}
+
+type environmentFile struct {
+	Domain      string `yaml:"domain"`
+	DNSProvider string `yaml:"dns_provider"`
+	Branch      string `yaml:"branch"`
+	DeployPath  string `yaml:"deploy_path"`
+	DeployUser  string `yaml:"deploy_user"`
+	Port        int    `yaml:"port"`
+
+	DeployKeyRotatedAt time.Time `yaml:"deploy_key_rotated_at,omitempty"`
+	ComposeTemplate    string    `yaml:"compose_template,omitempty"`
+	ContainerPort      int       `yaml:"container_port,omitempty"`
+	HealthcheckPath    string    `yaml:"healthcheck_path,omitempty"`
+	ComposeFile        string    `yaml:"compose_file,omitempty"`
+}
+
+func (s *FileStore) LoadConfig() (*Config, error) {
+	cfg := &Config{}
+	cfg.HetznerProjects, _ = s.ListHetznerProjects()
+
+	servers, err := s.names("servers")
+	if err != nil {
+		return nil, fmt.Errorf("loading servers: %w", err)
+	}
+	for _, name := range servers {
+		var f serverFile
+		if _, err := s.read("servers", name, &f); err != nil {
+			return nil, err
+		}
+		cfg.Servers = append(cfg.Servers, Server{Name: name, IP: f.IP, HetznerProject: f.HetznerProject, HetznerID: f.HetznerID, Orphaned: f.Orphaned})
+	}
+
+	projects, err := s.names("projects")
+	if err != nil {
+		return nil, fmt.Errorf("loading projects: %w", err)
+	}
+	for _, name := range projects {
+		var f projectFile
+		if _, err := s.read("projects", name, &f); err != nil {
+			return nil, err
+		}
+		p := Project{Name: name, Repo: f.Repo, Server: f.Server, Environments: make(map[string]Environment), Registry: f.Registry}
+		for envName, e := range f.Environments {
+			p.Environments[envName] = Environment(e)
+		}
+		cfg.Projects = append(cfg.Projects, p)
+	}
+	return cfg, nil
+}
+
+// SaveConfig writes every server and project in cfg. Like the SQLite
+// store, it upserts: records missing from cfg are left alone.
+func (s *FileStore) SaveConfig(cfg *Config) error {
+	for _, srv := range cfg.Servers {
+		f := serverFile{IP: srv.IP, HetznerProject: srv.HetznerProject, HetznerID: srv.HetznerID, Orphaned: srv.Orphaned}
+		if err := s.write("servers", srv.Name, f); err != nil {
+			return fmt.Errorf("saving server %s: %w", srv.Name, err)
+		}
+	}
+	for _, p := range cfg.Projects {
+		// Environments are upserted too, so merge with what is stored.
+		var f projectFile
+		if _, err := s.read("projects", p.Name, &f); err != nil {
+			return err
+		}
+		f.Repo, f.Server, f.Registry = p.Repo, p.Server, p.Registry
+		if f.Environments == nil {
+			f.Environments = make(map[string]environmentFile)
+		}
+		for envName, env := range p.Environments {
+			f.Environments[envName] = environmentFile(env)
+		}
+		if err := s.write("projects", p.Name, f); err != nil {
+			return fmt.Errorf("saving project %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteEnvironment removes a single environment from a project.
+func (s *FileStore) DeleteEnvironment(projectName, envName string) error {
+	var f projectFile
+	found, err := s.read("projects", projectName, &f)
+	if err != nil || !found {
+		return err
+	}
+	delete(f.Environments, envName)
+	if err := s.write("projects", projectName, f); err != nil {
+		return fmt.Errorf("deleting environment %s/%s: %w", projectName, envName, err)
+	}
+	return nil
+}
+
+// DeleteProject removes a project and its environments.
+func (s *FileStore) DeleteProject(name string) error {
+	if err := s.remove("projects", name); err != nil {
+		return fmt.Errorf("deleting project %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteServer removes a server. Projects that name it are left alone.
+func (s *FileStore) DeleteServer(name string) error {
+	if err := s.remove("servers", name); err != nil {
+		return fmt.Errorf("deleting server %s: %w", name, err)
+	}
+	return nil
+}
+
+// RenameServer renames a server and repoints the projects deployed to it.
+// Projects are updated first, so an interrupted rename leaves them pointing
+// at a name that is written next rather than at nothing.
+func (s *FileStore) RenameServer(oldName, newName string) error {
+	var srv serverFile
+	found, err := s.read("servers", oldName, &srv)
+	if err != nil || !found {
+		return err
+	}
+	projects, err := s.names("projects")
+	if err != nil {
+		return fmt.Errorf("loading projects: %w", err)
+	}
+	for _, name := range projects {
+		var f projectFile
+		if _, err := s.read("projects", name, &f); err != nil {
+			return err
+		}
+		if f.Server != oldName {
+			continue
+		}
+		f.Server = newName
+		if err := s.write("projects", name, f); err != nil {
+			return fmt.Errorf("updating project %s: %w", name, err)
+		}
+	}
+	if err := s.write("servers", newName, srv); err != nil {
+		return fmt.Errorf("renaming server %s to %s: %w", oldName, newName, err)
+	}
+	return s.remove("servers", oldName)
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestFileStore(t *testing.T) (*FileStore, string) {
+	t.Helper()
+	tmp := t.TempDir()
+	identity := filepath.Join(tmp, "age.key")
+	if _, err := GenerateIdentity(identity); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "store")
+	s, err := NewFileStore(dir, identity)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return s, dir
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	s, dir := newTestFileStore(t)
+
+	s.SetCredential("hetzner", "prod", "api_token", "tok123")
+	s.SetCredential("hetzner", "dev", "api_token", "tok456")
+	s.SetCredential("porkbun", "default", "api_key", "pk1")
+	if v, err := s.GetCredential("hetzner", "prod", "api_token"); err != nil || v != "tok123" {
+		t.Errorf("GetCredential = %q, %v", v, err)
+	}
+	if _, err := s.GetCredential("hetzner", "staging", "api_token"); err == nil {
+		t.Error("expected error for missing credential")
+	}
+	all, _ := s.ListCredentials("")
+	if len(all) != 3 || all[0].Name != "dev" || all[2].Service != "porkbun" {
+		t.Errorf("ListCredentials(\"\") = %+v", all)
+	}
+	projects, _ := s.ListHetznerProjects()
+	if !reflect.DeepEqual(projects, []HetznerProject{{"dev"}, {"prod"}}) {
+		t.Errorf("ListHetznerProjects = %+v", projects)
+	}
+	s.DeleteCredential("hetzner", "dev")
+	if creds, _ := s.ListCredentials("hetzner"); len(creds) != 1 {
+		t.Errorf("after delete: %+v", creds)
+	}
+
+	s.SetPeonKey("1.2.3.4", "PRIVATE KEY", "/home/me/.ssh/peon")
+	if v, err := s.GetPeonKey("1.2.3.4"); err != nil || v != "PRIVATE KEY" {
+		t.Errorf("GetPeonKey = %q, %v", v, err)
+	}
+	if fp, err := s.GetHostKey("1.2.3.4"); err != nil || fp != "" {
+		t.Errorf("unknown host key = %q, %v", fp, err)
+	}
+	s.SetHostKey("1.2.3.4", "SHA256:abc")
+	if fp, _ := s.GetHostKey("1.2.3.4"); fp != "SHA256:abc" {
+		t.Errorf("GetHostKey = %q", fp)
+	}
+
+	cfg := &Config{
+		Servers: []Server{{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}},
+		Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{
+			"prod": {Domain: "myclient.com", DNSProvider: "porkbun", Branch: "main", DeployPath: "/opt/myclient/prod", DeployUser: "peon", Port: 3000},
+		}}},
+	}
+	if err := s.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Saving another environment adds to the project rather than replacing it.
+	s.SaveConfig(&Config{Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{
+		"dev": {Domain: "dev.myclient.com", Port: 3001},
+	}}}})
+	loaded, err := s.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Servers) != 1 || loaded.Servers[0] != cfg.Servers[0] {
+		t.Errorf("servers = %+v", loaded.Servers)
+	}
+	if len(loaded.Projects) != 1 || len(loaded.Projects[0].Environments) != 2 {
+		t.Errorf("projects = %+v", loaded.Projects)
+	}
+	s.DeleteEnvironment("myclient", "dev")
+	s.DeleteProject("nonexistent")
+	loaded, _ = s.LoadConfig()
+	if _, ok := loaded.Projects[0].Environments["dev"]; ok {
+		t.Error("dev environment not deleted")
+	}
+
+	// Nothing is stored in plaintext.
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if info.IsDir() || info.Name() == recipientsFile {
+			return nil
+		}
+		data, _ := os.ReadFile(path)
+		for _, secret := range []string{"tok123", "PRIVATE KEY", "myclient.com"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s contains %q in plaintext", path, secret)
+			}
+		}
+		return nil
+	})
+}
+
+func TestFileStoreSharedWithTeammate(t *testing.T) {
+	s, dir := newTestFileStore(t)
+	s.SetCredential("cloudflare", "default", "api_token", "cf1")
+
+	// A teammate generates a key and is added to recipients.txt.
+	teammateKey := filepath.Join(t.TempDir(), "age.key")
+	pub, err := GenerateIdentity(teammateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teammate, err := NewFileStore(dir, teammateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := teammate.GetCredential("cloudflare", "default", "api_token"); err == nil {
+		t.Fatal("teammate read a file before being added to recipients")
+	}
+
+	f, _ := os.OpenFile(filepath.Join(dir, recipientsFile), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(pub + "\n")
+	f.Close()
+	if err := s.Rekey(); err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	if v, err := teammate.GetCredential("cloudflare", "default", "api_token"); err != nil || v != "cf1" {
+		t.Errorf("teammate GetCredential = %q, %v", v, err)
+	}
+}
+
+func TestCopyStore(t *testing.T) {
+	src := newTestStore(t)
+	src.SetCredential("hetzner", "prod", "api_token", "tok123")
+	src.SetCredential("porkbun", "default", "secret_key", "sk1")
+	src.SetPeonKey("1.2.3.4", "PRIVATE KEY", "/tmp/peon")
+	src.SetHostKey("1.2.3.4", "SHA256:abc")
+	src.SaveConfig(&Config{
+		Servers:  []Server{{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}},
+		Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{"prod": {Domain: "myclient.com", Port: 3000}}}},
+	})
+
+	dst, _ := newTestFileStore(t)
+	res, err := CopyStore(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (CopyResult{Servers: 1, Projects: 1, Credentials: 2, PeonKeys: 1, HostKeys: 1}) {
+		t.Errorf("CopyStore = %+v", res)
+	}
+
+	srcCfg, _ := src.LoadConfig()
+	dstCfg, _ := dst.LoadConfig()
+	if !reflect.DeepEqual(srcCfg, dstCfg) {
+		t.Errorf("config differs:\n%+v\n%+v", srcCfg, dstCfg)
+	}
+	srcCreds, _ := src.ListCredentials("")
+	dstCreds, _ := dst.ListCredentials("")
+	if !reflect.DeepEqual(srcCreds, dstCreds) {
+		t.Errorf("credentials differ:\n%+v\n%+v", srcCreds, dstCreds)
+	}
+	if v, _ := dst.GetPeonKey("1.2.3.4"); v != "PRIVATE KEY" {
+		t.Errorf("peon key = %q", v)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	if _, err := OpenStore("etcd:foo"); err == nil {
+		t.Error("expected error for unknown store kind")
+	}
+	s, err := OpenStore("sqlite:" + filepath.Join(t.TempDir(), "other.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.(*SQLiteStore); !ok {
+		t.Errorf("OpenStore(sqlite:...) = %T", s)
+	}
+}
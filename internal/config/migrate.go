@@ -0,0 +1,195 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Migration is one step in the schema history. Migrations run in order, each
+// in its own transaction together with the schema_version update, so a
+// failure leaves the database at the last version that fully applied.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// migrations is the schema history. Append new migrations to the end; never
+// edit one that has shipped.
+var migrations = []Migration{
+	{1, "initial schema", execAll(
+		`CREATE TABLE IF NOT EXISTS credentials (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			service TEXT NOT NULL,
+			name    TEXT NOT NULL,
+			key     TEXT NOT NULL,
+			value   TEXT NOT NULL,
+			UNIQUE(service, name, key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS servers (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			name            TEXT NOT NULL UNIQUE,
+			ip              TEXT NOT NULL,
+			hetzner_project TEXT NOT NULL,
+			hetzner_id      INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS peon_keys (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			server_ip   TEXT NOT NULL UNIQUE,
+			private_key TEXT NOT NULL,
+			key_path    TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS host_keys (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			host        TEXT NOT NULL UNIQUE,
+			fingerprint TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS projects (
+			id     INTEGER PRIMARY KEY AUTOINCREMENT,
+			name   TEXT NOT NULL UNIQUE,
+			repo   TEXT NOT NULL,
+			server TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS environments (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id   INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			env_name     TEXT NOT NULL,
+			domain       TEXT NOT NULL,
+			dns_provider TEXT NOT NULL,
+			branch       TEXT NOT NULL,
+			deploy_path  TEXT NOT NULL,
+			deploy_user  TEXT NOT NULL,
+			port         INTEGER NOT NULL,
+			UNIQUE(project_id, env_name)
+		)`,
+	)},
+	{2, "encryption settings for credentials and peon keys", execAll(
+		`CREATE TABLE IF NOT EXISTS encryption (
+			id          INTEGER PRIMARY KEY CHECK (id = 1),
+			key_source  TEXT NOT NULL,
+			salt        TEXT NOT NULL,
+			check_value TEXT NOT NULL
+		)`,
+	)},
+	{3, "deploy key rotation date for environments", execAll(
+		`ALTER TABLE environments ADD COLUMN deploy_key_rotated_at TEXT NOT NULL DEFAULT ''`,
+	)},
+	{4, "orphaned flag for servers", execAll(
+		`ALTER TABLE servers ADD COLUMN orphaned INTEGER NOT NULL DEFAULT 0`,
+	)},
+	{5, "compose template and container port for environments", execAll(
+		`ALTER TABLE environments ADD COLUMN compose_template TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE environments ADD COLUMN container_port INTEGER NOT NULL DEFAULT 0`,
+	)},
+	{6, "repo compose file for environments", execAll(
+		`ALTER TABLE environments ADD COLUMN compose_file TEXT NOT NULL DEFAULT ''`,
+	)},
+	{7, "container registry for projects", execAll(
+		`ALTER TABLE projects ADD COLUMN registry TEXT NOT NULL DEFAULT ''`,
+	)},
+	{8, "healthcheck path for environments", execAll(
+		`ALTER TABLE environments ADD COLUMN healthcheck_path TEXT NOT NULL DEFAULT ''`,
+	)},
+}
+
+// LatestSchemaVersion is the version a database is at once fully migrated.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrations returns the schema history, oldest first.
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+func execAll(stmts ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// MigrationResult describes what opening the store did to the schema.
+type MigrationResult struct {
+	From, To int
+	Applied  []Migration
+	Backup   string // copy of the database taken before migrating, if any
+}
+
+// schemaVersionOf returns the version recorded in db, or 0 for a new
+// database.
+func schemaVersionOf(db *sql.DB) (int, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return 0, fmt.Errorf("creating schema_version: %w", err)
+	}
+	var version int
+	err := db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("checking schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate brings db up to the latest schema. Before touching an existing
+// database (version 1 or later) it writes a copy next to dbPath.
+func migrate(db *sql.DB, dbPath string) (MigrationResult, error) {
+	from, err := schemaVersionOf(db)
+	if err != nil {
+		return MigrationResult{}, err
+	}
+	res := MigrationResult{From: from, To: from}
+	if from > LatestSchemaVersion() {
+		return res, fmt.Errorf("database schema is version %d, newer than this arnor supports (%d); upgrade arnor", from, LatestSchemaVersion())
+	}
+	if from == LatestSchemaVersion() {
+		return res, nil
+	}
+
+	if from > 0 && dbPath != ":memory:" {
+		res.Backup = fmt.Sprintf("%s.v%d-%s.bak", dbPath, from, time.Now().UTC().Format("20060102T150405Z"))
+		if _, err := db.Exec("VACUUM INTO ?", res.Backup); err != nil {
+			return res, fmt.Errorf("backing up database before migrating: %w", err)
+		}
+		_ = os.Chmod(res.Backup, 0o600)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= from {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return res, fmt.Errorf("migrating schema to version %d (%s): %w", m.Version, m.Description, err)
+		}
+		res.To = m.Version
+		res.Applied = append(res.Applied, m)
+	}
+	return res, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM schema_version"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES (?)", m.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFixture creates a database at dbPath from a SQL file in testdata.
+func loadFixture(t *testing.T, dbPath, name string) {
+	t.Helper()
+	script, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+}
+
+func TestMigrateV1Fixture(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "arnor.db")
+	loadFixture(t, dbPath, "v1.sql")
+
+	s, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	res := s.Migrated()
+	if res.From != 1 || res.To != LatestSchemaVersion() {
+		t.Errorf("migrated %d -> %d, want 1 -> %d", res.From, res.To, LatestSchemaVersion())
+	}
+	if len(res.Applied) != LatestSchemaVersion()-1 || res.Applied[0].Version != 2 {
+		t.Errorf("applied = %+v", res.Applied)
+	}
+	if version, err := schemaVersionOf(s.db); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("schema_version = %d, %v", version, err)
+	}
+
+	// The data survives.
+	if v, err := s.GetCredential("hetzner", "prod", "api_token"); err != nil || v != "tok123" {
+		t.Errorf("GetCredential = %q, %v", v, err)
+	}
+	if v, err := s.GetPeonKey("1.2.3.4"); err != nil || v != "PRIVATE KEY" {
+		t.Errorf("GetPeonKey = %q, %v", v, err)
+	}
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Projects) != 1 || cfg.Projects[0].Environments["prod"].Domain != "myclient.com" {
+		t.Errorf("projects = %+v", cfg.Projects)
+	}
+
+	// New features work on the migrated database.
+	if kind, err := s.Encryption(); err != nil || kind != "" {
+		t.Errorf("Encryption() = %q, %v", kind, err)
+	}
+
+	// The backup is the untouched v1 database.
+	if !strings.HasPrefix(filepath.Base(res.Backup), "arnor.db.v1-") {
+		t.Fatalf("backup = %q", res.Backup)
+	}
+	backup, err := sql.Open("sqlite", res.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if version, err := schemaVersionOf(backup); err != nil || version != 1 {
+		t.Errorf("backup schema_version = %d, %v", version, err)
+	}
+
+	// Opening again finds nothing to do.
+	s.Close()
+	s, err = NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := s.Migrated(); len(res.Applied) != 0 || res.Backup != "" {
+		t.Errorf("second open migrated again: %+v", res)
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "arnor.db")
+	s, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	res := s.Migrated()
+	if res.From != 0 || res.To != LatestSchemaVersion() || res.Backup != "" {
+		t.Errorf("new database: %+v", res)
+	}
+}
+
+func TestMigrateFailureRollsBack(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "arnor.db")
+	loadFixture(t, dbPath, "v1.sql")
+
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = append(Migrations(), Migration{
+		Version:     saved[len(saved)-1].Version + 1,
+		Description: "broken",
+		Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER)"); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		},
+	})
+
+	if _, err := NewSQLiteStore(dbPath); err == nil || !strings.Contains(err.Error(), "(broken): boom") {
+		t.Fatalf("err = %v", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Earlier migrations stuck; the failed one left nothing behind.
+	if version, _ := schemaVersionOf(db); version != saved[len(saved)-1].Version {
+		t.Errorf("schema_version = %d after failed migration", version)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'").Scan(&n)
+	if n != 0 {
+		t.Error("failed migration was not rolled back")
+	}
+}
+
+func TestNewerSchemaRefused(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "arnor.db")
+	loadFixture(t, dbPath, "v1.sql")
+	db, _ := sql.Open("sqlite", dbPath)
+	db.Exec("UPDATE schema_version SET version = 99")
+	db.Close()
+
+	if _, err := NewSQLiteStore(dbPath); err == nil || !strings.Contains(err.Error(), "newer than this arnor") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -5,63 +5,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-const schemaVersion = 1
-
-var schemaDDL = []string{
-	`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`,
-
-	`CREATE TABLE IF NOT EXISTS credentials (
-		id      INTEGER PRIMARY KEY AUTOINCREMENT,
-		service TEXT NOT NULL,
-		name    TEXT NOT NULL,
-		key     TEXT NOT NULL,
-		value   TEXT NOT NULL,
-		UNIQUE(service, name, key)
-	)`,
-
-	`CREATE TABLE IF NOT EXISTS servers (
-		id              INTEGER PRIMARY KEY AUTOINCREMENT,
-		name            TEXT NOT NULL UNIQUE,
-		ip              TEXT NOT NULL,
-		hetzner_project TEXT NOT NULL,
-		hetzner_id      INTEGER NOT NULL
-	)`,
-
-	`CREATE TABLE IF NOT EXISTS peon_keys (
-		id          INTEGER PRIMARY KEY AUTOINCREMENT,
-		server_ip   TEXT NOT NULL UNIQUE,
-		private_key TEXT NOT NULL,
-		key_path    TEXT NOT NULL
-	)`,
-
-	`CREATE TABLE IF NOT EXISTS projects (
-		id     INTEGER PRIMARY KEY AUTOINCREMENT,
-		name   TEXT NOT NULL UNIQUE,
-		repo   TEXT NOT NULL,
-		server TEXT NOT NULL
-	)`,
-
-	`CREATE TABLE IF NOT EXISTS environments (
-		id           INTEGER PRIMARY KEY AUTOINCREMENT,
-		project_id   INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
-		env_name     TEXT NOT NULL,
-		domain       TEXT NOT NULL,
-		dns_provider TEXT NOT NULL,
-		branch       TEXT NOT NULL,
-		deploy_path  TEXT NOT NULL,
-		deploy_user  TEXT NOT NULL,
-		port         INTEGER NOT NULL,
-		UNIQUE(project_id, env_name)
-	)`,
-}
-
-// SQLiteStore implements Store backed by a SQLite database.
+// SQLiteStore implements Store backed by a SQLite database. Once locked,
+// credential values and peon private keys are encrypted at rest; see Lock.
 type SQLiteStore struct {
-	db *sql.DB
+	db         *sql.DB
+	migrated   MigrationResult
+	keySources KeySourceFunc
+	key        []byte // master key, once unsealed
 }
 
 // DBPath returns the default database path.
@@ -69,8 +24,9 @@ func DBPath() string {
 	return filepath.Join(os.Getenv("HOME"), ".config", "arnor", "arnor.db")
 }
 
-// NewSQLiteStore opens (or creates) a SQLite database at dbPath and ensures
-// the schema is up to date. File permissions are set to 0600.
+// NewSQLiteStore opens (or creates) a SQLite database at dbPath and migrates
+// the schema to the latest version, backing the file up first if it already
+// existed. File permissions are set to 0600.
 func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -96,31 +52,21 @@ func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 		return nil, fmt.Errorf("enabling foreign keys: %w", err)
 	}
 
-	// Create schema — execute each statement individually.
-	for _, stmt := range schemaDDL {
-		if _, err := db.Exec(stmt); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("creating schema: %w", err)
-		}
-	}
-
-	// Set version if empty.
-	var count int
-	if err := db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&count); err != nil {
+	migrated, err := migrate(db, dbPath)
+	if err != nil {
 		db.Close()
-		return nil, fmt.Errorf("checking schema version: %w", err)
-	}
-	if count == 0 {
-		if _, err := db.Exec("INSERT INTO schema_version (version) VALUES (?)", schemaVersion); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("setting schema version: %w", err)
-		}
+		return nil, err
 	}
 
 	// Restrict file permissions (best-effort on the file).
 	_ = os.Chmod(dbPath, 0o600)
 
-	return &SQLiteStore{db: db}, nil
+	return &SQLiteStore{db: db, migrated: migrated}, nil
+}
+
+// Migrated reports the schema migrations applied when the store was opened.
+func (s *SQLiteStore) Migrated() MigrationResult {
+	return s.migrated
 }
 
 func (s *SQLiteStore) Close() error {
@@ -141,11 +87,15 @@ func (s *SQLiteStore) GetCredential(service, name, key string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("querying credential: %w", err)
 	}
-	return value, nil
+	return s.decrypt(value, credentialAAD(service, name, key))
 }
 
 func (s *SQLiteStore) SetCredential(service, name, key, value string) error {
-	_, err := s.db.Exec(
+	value, err := s.encrypt(value, credentialAAD(service, name, key))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
 		`INSERT INTO credentials (service, name, key, value) VALUES (?, ?, ?, ?)
 		 ON CONFLICT(service, name, key) DO UPDATE SET value = excluded.value`,
 		service, name, key, value,
@@ -158,7 +108,7 @@ func (s *SQLiteStore) SetCredential(service, name, key, value string) error {
 
 func (s *SQLiteStore) ListCredentials(service string) ([]Credential, error) {
 	rows, err := s.db.Query(
-		"SELECT service, name, key, value FROM credentials WHERE service = ? ORDER BY name, key",
+		"SELECT service, name, key, value FROM credentials WHERE ? IN ('', service) ORDER BY service, name, key",
 		service,
 	)
 	if err != nil {
@@ -174,7 +124,21 @@ func (s *SQLiteStore) ListCredentials(service string) ([]Credential, error) {
 		}
 		creds = append(creds, c)
 	}
-	return creds, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Decrypt after the rows are closed: unsealing the key needs the
+	// connection (MaxOpenConns=1).
+	for i, c := range creds {
+		value, err := s.decrypt(c.Value, credentialAAD(c.Service, c.Name, c.Key))
+		if err != nil {
+			return nil, err
+		}
+		creds[i].Value = value
+	}
+	return creds, nil
 }
 
 func (s *SQLiteStore) DeleteCredential(service, name string) error {
@@ -202,11 +166,15 @@ func (s *SQLiteStore) GetPeonKey(serverIP string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("querying peon key: %w", err)
 	}
-	return privateKey, nil
+	return s.decrypt(privateKey, peonKeyAAD(serverIP))
 }
 
 func (s *SQLiteStore) SetPeonKey(serverIP, privateKey, keyPath string) error {
-	_, err := s.db.Exec(
+	privateKey, err := s.encrypt(privateKey, peonKeyAAD(serverIP))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
 		`INSERT INTO peon_keys (server_ip, private_key, key_path) VALUES (?, ?, ?)
 		 ON CONFLICT(server_ip) DO UPDATE SET private_key = excluded.private_key, key_path = excluded.key_path`,
 		serverIP, privateKey, keyPath,
@@ -217,6 +185,99 @@ func (s *SQLiteStore) SetPeonKey(serverIP, privateKey, keyPath string) error {
 	return nil
 }
 
+func (s *SQLiteStore) ListPeonKeys() ([]PeonKey, error) {
+	rows, err := s.db.Query("SELECT server_ip, private_key, key_path FROM peon_keys ORDER BY server_ip")
+	if err != nil {
+		return nil, fmt.Errorf("listing peon keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []PeonKey
+	for rows.Next() {
+		var k PeonKey
+		if err := rows.Scan(&k.ServerIP, &k.PrivateKey, &k.KeyPath); err != nil {
+			return nil, fmt.Errorf("scanning peon key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i, k := range keys {
+		privateKey, err := s.decrypt(k.PrivateKey, peonKeyAAD(k.ServerIP))
+		if err != nil {
+			return nil, err
+		}
+		keys[i].PrivateKey = privateKey
+	}
+	return keys, nil
+}
+
+func (s *SQLiteStore) DeletePeonKey(serverIP string) error {
+	if _, err := s.db.Exec("DELETE FROM peon_keys WHERE server_ip = ?", serverIP); err != nil {
+		return fmt.Errorf("deleting peon key for %s: %w", serverIP, err)
+	}
+	return nil
+}
+
+// --- Host Keys ---
+
+// GetHostKey returns the pinned SHA256 fingerprint for host, or "" if the
+// host has not been seen before.
+func (s *SQLiteStore) GetHostKey(host string) (string, error) {
+	var fingerprint string
+	err := s.db.QueryRow(
+		"SELECT fingerprint FROM host_keys WHERE host = ?",
+		host,
+	).Scan(&fingerprint)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("querying host key: %w", err)
+	}
+	return fingerprint, nil
+}
+
+func (s *SQLiteStore) SetHostKey(host, fingerprint string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO host_keys (host, fingerprint) VALUES (?, ?)
+		 ON CONFLICT(host) DO UPDATE SET fingerprint = excluded.fingerprint`,
+		host, fingerprint,
+	)
+	if err != nil {
+		return fmt.Errorf("setting host key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListHostKeys() ([]HostKey, error) {
+	rows, err := s.db.Query("SELECT host, fingerprint FROM host_keys ORDER BY host")
+	if err != nil {
+		return nil, fmt.Errorf("listing host keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []HostKey
+	for rows.Next() {
+		var k HostKey
+		if err := rows.Scan(&k.Host, &k.Fingerprint); err != nil {
+			return nil, fmt.Errorf("scanning host key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteHostKey(host string) error {
+	if _, err := s.db.Exec("DELETE FROM host_keys WHERE host = ?", host); err != nil {
+		return fmt.Errorf("deleting host key for %s: %w", host, err)
+	}
+	return nil
+}
+
 // --- Hetzner Projects ---
 
 func (s *SQLiteStore) ListHetznerProjects() ([]HetznerProject, error) {
@@ -248,13 +309,13 @@ func (s *SQLiteStore) LoadConfig() (*Config, error) {
 	cfg.HetznerProjects, _ = s.ListHetznerProjects()
 
 	// Load servers.
-	serverRows, err := s.db.Query("SELECT name, ip, hetzner_project, hetzner_id FROM servers ORDER BY name")
+	serverRows, err := s.db.Query("SELECT name, ip, hetzner_project, hetzner_id, orphaned FROM servers ORDER BY name")
 	if err != nil {
 		return nil, fmt.Errorf("loading servers: %w", err)
 	}
 	for serverRows.Next() {
 		var srv Server
-		if err := serverRows.Scan(&srv.Name, &srv.IP, &srv.HetznerProject, &srv.HetznerID); err != nil {
+		if err := serverRows.Scan(&srv.Name, &srv.IP, &srv.HetznerProject, &srv.HetznerID, &srv.Orphaned); err != nil {
 			serverRows.Close()
 			return nil, fmt.Errorf("scanning server: %w", err)
 		}
@@ -269,8 +330,9 @@ func (s *SQLiteStore) LoadConfig() (*Config, error) {
 	// Load projects and environments with a LEFT JOIN to avoid nested queries
 	// (needed because we use MaxOpenConns=1).
 	rows, err := s.db.Query(`
-		SELECT p.name, p.repo, p.server,
-		       e.env_name, e.domain, e.dns_provider, e.branch, e.deploy_path, e.deploy_user, e.port
+		SELECT p.name, p.repo, p.server, p.registry,
+		       e.env_name, e.domain, e.dns_provider, e.branch, e.deploy_path, e.deploy_user, e.port,
+		       e.deploy_key_rotated_at, e.compose_template, e.container_port, e.compose_file, e.healthcheck_path
 		FROM projects p
 		LEFT JOIN environments e ON e.project_id = p.id
 		ORDER BY p.name, e.env_name
@@ -284,11 +346,11 @@ func (s *SQLiteStore) LoadConfig() (*Config, error) {
 	var projectOrder []string
 
 	for rows.Next() {
-		var pName, pRepo, pServer string
-		var envName, domain, dnsProvider, branch, deployPath, deployUser sql.NullString
-		var port sql.NullInt64
+		var pName, pRepo, pServer, pRegistry string
+		var envName, domain, dnsProvider, branch, deployPath, deployUser, keyRotatedAt, composeTemplate, composeFile, healthcheckPath sql.NullString
+		var port, containerPort sql.NullInt64
 
-		if err := rows.Scan(&pName, &pRepo, &pServer, &envName, &domain, &dnsProvider, &branch, &deployPath, &deployUser, &port); err != nil {
+		if err := rows.Scan(&pName, &pRepo, &pServer, &pRegistry, &envName, &domain, &dnsProvider, &branch, &deployPath, &deployUser, &port, &keyRotatedAt, &composeTemplate, &containerPort, &composeFile, &healthcheckPath); err != nil {
 			return nil, fmt.Errorf("scanning project row: %w", err)
 		}
 
@@ -299,6 +361,7 @@ func (s *SQLiteStore) LoadConfig() (*Config, error) {
 				Repo:         pRepo,
 				Server:       pServer,
 				Environments: make(map[string]Environment),
+				Registry:     pRegistry,
 			}
 			projectMap[pName] = p
 			projectOrder = append(projectOrder, pName)
@@ -312,6 +375,19 @@ func (s *SQLiteStore) LoadConfig() (*Config, error) {
 				DeployPath:  deployPath.String,
 				DeployUser:  deployUser.String,
 				Port:        int(port.Int64),
+
+				ComposeTemplate: composeTemplate.String,
+				ContainerPort:   int(containerPort.Int64),
+				HealthcheckPath: healthcheckPath.String,
+				ComposeFile:     composeFile.String,
+			}
+			if keyRotatedAt.String != "" {
+				env := p.Environments[envName.String]
+				env.DeployKeyRotatedAt, err = time.Parse(time.RFC3339, keyRotatedAt.String)
+				if err != nil {
+					return nil, fmt.Errorf("parsing deploy key rotation date of %s/%s: %w", pName, envName.String, err)
+				}
+				p.Environments[envName.String] = env
 			}
 		}
 	}
@@ -326,6 +402,14 @@ func (s *SQLiteStore) LoadConfig() (*Config, error) {
 	return cfg, nil
 }
 
+// formatTime stores a time as RFC 3339 in UTC, or "" for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 func (s *SQLiteStore) SaveConfig(cfg *Config) error {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -336,9 +420,9 @@ func (s *SQLiteStore) SaveConfig(cfg *Config) error {
 	// Upsert servers.
 	for _, srv := range cfg.Servers {
 		_, err := tx.Exec(
-			`INSERT INTO servers (name, ip, hetzner_project, hetzner_id) VALUES (?, ?, ?, ?)
-			 ON CONFLICT(name) DO UPDATE SET ip = excluded.ip, hetzner_project = excluded.hetzner_project, hetzner_id = excluded.hetzner_id`,
-			srv.Name, srv.IP, srv.HetznerProject, srv.HetznerID,
+			`INSERT INTO servers (name, ip, hetzner_project, hetzner_id, orphaned) VALUES (?, ?, ?, ?, ?)
+			 ON CONFLICT(name) DO UPDATE SET ip = excluded.ip, hetzner_project = excluded.hetzner_project, hetzner_id = excluded.hetzner_id, orphaned = excluded.orphaned`,
+			srv.Name, srv.IP, srv.HetznerProject, srv.HetznerID, srv.Orphaned,
 		)
 		if err != nil {
 			return fmt.Errorf("upserting server %s: %w", srv.Name, err)
@@ -348,9 +432,9 @@ func (s *SQLiteStore) SaveConfig(cfg *Config) error {
 	// Upsert projects and environments.
 	for _, p := range cfg.Projects {
 		_, err := tx.Exec(
-			`INSERT INTO projects (name, repo, server) VALUES (?, ?, ?)
-			 ON CONFLICT(name) DO UPDATE SET repo = excluded.repo, server = excluded.server`,
-			p.Name, p.Repo, p.Server,
+			`INSERT INTO projects (name, repo, server, registry) VALUES (?, ?, ?, ?)
+			 ON CONFLICT(name) DO UPDATE SET repo = excluded.repo, server = excluded.server, registry = excluded.registry`,
+			p.Name, p.Repo, p.Server, p.Registry,
 		)
 		if err != nil {
 			return fmt.Errorf("upserting project %s: %w", p.Name, err)
@@ -363,16 +447,22 @@ func (s *SQLiteStore) SaveConfig(cfg *Config) error {
 
 		for envName, env := range p.Environments {
 			_, err := tx.Exec(
-				`INSERT INTO environments (project_id, env_name, domain, dns_provider, branch, deploy_path, deploy_user, port)
-				 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+				`INSERT INTO environments (project_id, env_name, domain, dns_provider, branch, deploy_path, deploy_user, port, deploy_key_rotated_at, compose_template, container_port, compose_file, healthcheck_path)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 				 ON CONFLICT(project_id, env_name) DO UPDATE SET
 				   domain = excluded.domain,
 				   dns_provider = excluded.dns_provider,
 				   branch = excluded.branch,
 				   deploy_path = excluded.deploy_path,
 				   deploy_user = excluded.deploy_user,
-				   port = excluded.port`,
-				projectID, envName, env.Domain, env.DNSProvider, env.Branch, env.DeployPath, env.DeployUser, env.Port,
+				   port = excluded.port,
+				   deploy_key_rotated_at = excluded.deploy_key_rotated_at,
+				   compose_template = excluded.compose_template,
+				   container_port = excluded.container_port,
+				   compose_file = excluded.compose_file,
+				   healthcheck_path = excluded.healthcheck_path`,
+				projectID, envName, env.Domain, env.DNSProvider, env.Branch, env.DeployPath, env.DeployUser, env.Port, formatTime(env.DeployKeyRotatedAt),
+				env.ComposeTemplate, env.ContainerPort, env.ComposeFile, env.HealthcheckPath,
 			)
 			if err != nil {
 				return fmt.Errorf("upserting environment %s/%s: %w", p.Name, envName, err)
@@ -382,3 +472,49 @@ func (s *SQLiteStore) SaveConfig(cfg *Config) error {
 
 	return tx.Commit()
 }
+
+// DeleteEnvironment removes a single environment row from a project.
+func (s *SQLiteStore) DeleteEnvironment(projectName, envName string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM environments
+		 WHERE env_name = ? AND project_id = (SELECT id FROM projects WHERE name = ?)`,
+		envName, projectName,
+	)
+	if err != nil {
+		return fmt.Errorf("deleting environment %s/%s: %w", projectName, envName, err)
+	}
+	return nil
+}
+
+// DeleteProject removes a project and, via ON DELETE CASCADE, its environments.
+func (s *SQLiteStore) DeleteProject(name string) error {
+	if _, err := s.db.Exec("DELETE FROM projects WHERE name = ?", name); err != nil {
+		return fmt.Errorf("deleting project %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteServer removes a server row. Projects that name it are left alone.
+func (s *SQLiteStore) DeleteServer(name string) error {
+	if _, err := s.db.Exec("DELETE FROM servers WHERE name = ?", name); err != nil {
+		return fmt.Errorf("deleting server %s: %w", name, err)
+	}
+	return nil
+}
+
+// RenameServer renames a server and repoints the projects deployed to it.
+func (s *SQLiteStore) RenameServer(oldName, newName string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE servers SET name = ? WHERE name = ?", newName, oldName); err != nil {
+		return fmt.Errorf("renaming server %s to %s: %w", oldName, newName, err)
+	}
+	if _, err := tx.Exec("UPDATE projects SET server = ? WHERE server = ?", newName, oldName); err != nil {
+		return fmt.Errorf("updating projects on %s: %w", oldName, err)
+	}
+	return tx.Commit()
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 func newTestStore(t *testing.T) *SQLiteStore {
@@ -109,6 +110,34 @@ func TestPeonKeyNotFound(t *testing.T) {
 	}
 }
 
+func TestHostKeyRoundTrip(t *testing.T) {
+	s := newTestStore(t)
+
+	// Unknown hosts return an empty fingerprint, not an error.
+	fp, err := s.GetHostKey("1.2.3.4")
+	if err != nil {
+		t.Fatalf("GetHostKey (unknown): %v", err)
+	}
+	if fp != "" {
+		t.Errorf("got %q for unknown host, want empty", fp)
+	}
+
+	if err := s.SetHostKey("1.2.3.4", "SHA256:abc"); err != nil {
+		t.Fatalf("SetHostKey: %v", err)
+	}
+	if err := s.SetHostKey("1.2.3.4", "SHA256:def"); err != nil {
+		t.Fatalf("SetHostKey (upsert): %v", err)
+	}
+
+	fp, err = s.GetHostKey("1.2.3.4")
+	if err != nil {
+		t.Fatalf("GetHostKey: %v", err)
+	}
+	if fp != "SHA256:def" {
+		t.Errorf("got %q, want %q", fp, "SHA256:def")
+	}
+}
+
 func TestListHetznerProjects(t *testing.T) {
 	s := newTestStore(t)
 
@@ -264,6 +293,56 @@ func TestSaveConfigUpsert(t *testing.T) {
 	}
 }
 
+func TestDeleteEnvironmentAndProject(t *testing.T) {
+	s := newTestStore(t)
+
+	cfg := &Config{
+		Projects: []Project{{
+			Name: "myapp", Repo: "org/myapp", Server: "web1",
+			Environments: map[string]Environment{
+				"dev":  {Domain: "myapp.angmar.dev", DNSProvider: "porkbun", Branch: "dev", DeployPath: "/opt/myapp-dev", DeployUser: "myapp-dev-deploy", Port: 3001},
+				"prod": {Domain: "myapp.com", DNSProvider: "cloudflare", Branch: "main", DeployPath: "/opt/myapp", DeployUser: "myapp-deploy", Port: 3000},
+			},
+		}},
+	}
+	if err := s.SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+
+	if err := s.DeleteEnvironment("myapp", "dev"); err != nil {
+		t.Fatalf("DeleteEnvironment: %v", err)
+	}
+	// Deleting again is a no-op.
+	if err := s.DeleteEnvironment("myapp", "dev"); err != nil {
+		t.Fatalf("DeleteEnvironment (again): %v", err)
+	}
+
+	loaded, err := s.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	envs := loaded.Projects[0].Environments
+	if _, ok := envs["dev"]; ok || len(envs) != 1 {
+		t.Errorf("environments after delete = %v, want only prod", envs)
+	}
+
+	if err := s.DeleteProject("myapp"); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	loaded, err = s.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if len(loaded.Projects) != 0 {
+		t.Errorf("got %d projects after delete, want 0", len(loaded.Projects))
+	}
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM environments").Scan(&count)
+	if count != 0 {
+		t.Errorf("got %d orphaned environment rows, want 0", count)
+	}
+}
+
 func TestEmptyConfigLoad(t *testing.T) {
 	s := newTestStore(t)
 
@@ -278,3 +357,143 @@ func TestEmptyConfigLoad(t *testing.T) {
 		t.Errorf("got %d projects, want 0", len(cfg.Projects))
 	}
 }
+
+func TestDeployKeyRotatedAt(t *testing.T) {
+	rotated := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	fs, _ := newTestFileStore(t)
+	for name, s := range map[string]Store{"sqlite": newTestStore(t), "file": fs} {
+		t.Run(name, func(t *testing.T) {
+			s.SaveConfig(&Config{Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{
+				"prod": {Domain: "myclient.com", Port: 3000, DeployKeyRotatedAt: rotated},
+				"dev":  {Domain: "dev.myclient.com", Port: 3001},
+			}}}})
+			cfg, err := s.LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			envs := cfg.Projects[0].Environments
+			if !envs["prod"].DeployKeyRotatedAt.Equal(rotated) {
+				t.Errorf("prod rotated at %v, want %v", envs["prod"].DeployKeyRotatedAt, rotated)
+			}
+			if !envs["dev"].DeployKeyRotatedAt.IsZero() {
+				t.Errorf("dev rotated at %v, want zero", envs["dev"].DeployKeyRotatedAt)
+			}
+		})
+	}
+}
+
+func TestComposeTemplateRoundTrip(t *testing.T) {
+	fs, _ := newTestFileStore(t)
+	for name, s := range map[string]Store{"sqlite": newTestStore(t), "file": fs} {
+		t.Run(name, func(t *testing.T) {
+			s.SaveConfig(&Config{Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{
+				"prod": {Domain: "myclient.com", Port: 3000, ComposeTemplate: "web+postgres", ContainerPort: 8080},
+				"dev":  {Domain: "dev.myclient.com", Port: 3001, ComposeFile: "deploy/compose.dev.yml"},
+			}}}})
+			cfg, err := s.LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			envs := cfg.Projects[0].Environments
+			if got := envs["prod"]; got.ComposeTemplate != "web+postgres" || got.ContainerPort != 8080 {
+				t.Errorf("prod = %q on %d, want web+postgres on 8080", got.ComposeTemplate, got.ContainerPort)
+			}
+			if got := envs["dev"]; got.ComposeTemplate != "" || got.ContainerPort != 0 {
+				t.Errorf("dev = %q on %d, want unset", got.ComposeTemplate, got.ContainerPort)
+			}
+			if got := envs["dev"].ComposeFile; got != "deploy/compose.dev.yml" {
+				t.Errorf("dev compose file = %q, want deploy/compose.dev.yml", got)
+			}
+		})
+	}
+}
+
+func TestHealthcheckPathRoundTrip(t *testing.T) {
+	fs, _ := newTestFileStore(t)
+	for name, s := range map[string]Store{"sqlite": newTestStore(t), "file": fs} {
+		t.Run(name, func(t *testing.T) {
+			s.SaveConfig(&Config{Projects: []Project{{Name: "myclient", Repo: "me/myclient", Server: "web1", Environments: map[string]Environment{
+				"prod": {Domain: "myclient.com", Port: 3000, HealthcheckPath: "/healthz"},
+				"dev":  {Domain: "dev.myclient.com", Port: 3001},
+			}}}})
+			cfg, err := s.LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			envs := cfg.Projects[0].Environments
+			if got := envs["prod"].HealthcheckPath; got != "/healthz" {
+				t.Errorf("prod healthcheck path = %q, want /healthz", got)
+			}
+			if got := envs["dev"].HealthcheckPath; got != "" {
+				t.Errorf("dev healthcheck path = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestProjectRegistryRoundTrip(t *testing.T) {
+	fs, _ := newTestFileStore(t)
+	for name, s := range map[string]Store{"sqlite": newTestStore(t), "file": fs} {
+		t.Run(name, func(t *testing.T) {
+			s.SaveConfig(&Config{Projects: []Project{
+				{Name: "myclient", Repo: "me/myclient", Server: "web1", Registry: "registry.example.com/team"},
+				{Name: "legacy", Repo: "me/legacy", Server: "web1"},
+			}})
+			s.SaveConfig(&Config{Projects: []Project{{Name: "legacy", Repo: "me/legacy", Server: "web1", Registry: "ghcr"}}})
+			cfg, err := s.LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.FindProject("myclient").Registry; got != "registry.example.com/team" {
+				t.Errorf("myclient registry = %q, want registry.example.com/team", got)
+			}
+			if got := cfg.FindProject("legacy").Registry; got != "ghcr" {
+				t.Errorf("legacy registry = %q, want ghcr after update", got)
+			}
+		})
+	}
+}
+
+func TestRenameServerAndOrphaned(t *testing.T) {
+	fs, _ := newTestFileStore(t)
+	for name, s := range map[string]Store{"sqlite": newTestStore(t), "file": fs} {
+		t.Run(name, func(t *testing.T) {
+			s.SaveConfig(&Config{
+				Servers: []Server{
+					{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42},
+					{Name: "old", IP: "5.6.7.8", HetznerProject: "prod", HetznerID: 43, Orphaned: true},
+				},
+				Projects: []Project{
+					{Name: "blog", Server: "web1", Environments: map[string]Environment{"prod": {Domain: "blog.com"}}},
+					{Name: "shop", Server: "web2"},
+				},
+			})
+			if err := s.RenameServer("web1", "edge1"); err != nil {
+				t.Fatalf("RenameServer: %v", err)
+			}
+			if err := s.RenameServer("missing", "other"); err != nil {
+				t.Errorf("renaming a missing server: %v", err)
+			}
+
+			cfg, err := s.LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.FindServer("web1") != nil || cfg.FindServer("other") != nil {
+				t.Errorf("servers = %+v", cfg.Servers)
+			}
+			if srv := cfg.FindServer("edge1"); srv == nil || srv.IP != "1.2.3.4" || srv.HetznerID != 42 || srv.Orphaned {
+				t.Errorf("edge1 = %+v", srv)
+			}
+			if srv := cfg.FindServer("old"); srv == nil || !srv.Orphaned {
+				t.Errorf("old = %+v, want orphaned", srv)
+			}
+			if p := cfg.FindProject("blog"); p == nil || p.Server != "edge1" || p.Environments["prod"].Domain != "blog.com" {
+				t.Errorf("blog = %+v", p)
+			}
+			if p := cfg.FindProject("shop"); p == nil || p.Server != "web2" {
+				t.Errorf("shop = %+v", p)
+			}
+		})
+	}
+}
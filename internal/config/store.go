@@ -1,5 +1,12 @@
 package config
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 // Credential represents a stored credential entry.
 type Credential struct {
 	Service string
@@ -8,6 +15,19 @@ type Credential struct {
 	Value   string
 }
 
+// PeonKey is a stored peon private key for a server.
+type PeonKey struct {
+	ServerIP   string
+	PrivateKey string
+	KeyPath    string
+}
+
+// HostKey is a pinned SSH host key fingerprint.
+type HostKey struct {
+	Host        string
+	Fingerprint string
+}
+
 // Store abstracts over the backing storage for arnor configuration and credentials.
 type Store interface {
 	// Config (replaces Load/Save)
@@ -17,15 +37,117 @@ type Store interface {
 	// Credentials (replaces os.Getenv for secrets)
 	GetCredential(service, name, key string) (string, error)
 	SetCredential(service, name, key, value string) error
+	// ListCredentials lists the credentials of service, or of every
+	// service if service is "".
 	ListCredentials(service string) ([]Credential, error)
 	DeleteCredential(service, name string) error
 
 	// Peon keys (replaces PEON_SSH_KEY_<host> env vars)
 	GetPeonKey(serverIP string) (string, error)
 	SetPeonKey(serverIP, privateKey, keyPath string) error
+	ListPeonKeys() ([]PeonKey, error)
+	DeletePeonKey(serverIP string) error
+
+	// SSH host keys (pinned on first contact, replaces InsecureIgnoreHostKey)
+	GetHostKey(host string) (string, error)
+	SetHostKey(host, fingerprint string) error
+	ListHostKeys() ([]HostKey, error)
+	DeleteHostKey(host string) error
+
+	// Project and server teardown. Deleting a row that does not exist is
+	// not an error.
+	DeleteEnvironment(projectName, envName string) error
+	DeleteProject(name string) error
+	DeleteServer(name string) error
+
+	// RenameServer renames a server and repoints the projects deployed to
+	// it. Renaming a server that does not exist is not an error.
+	RenameServer(oldName, newName string) error
 
 	// Hetzner project management
 	ListHetznerProjects() ([]HetznerProject, error)
 
 	Close() error
 }
+
+// OpenStore opens the store named by spec: "sqlite" for the default
+// database, "sqlite:<path>" for another one, or "file:<dir>" for a FileStore
+// using the local age identity.
+func OpenStore(spec string) (Store, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "sqlite":
+		if arg == "" {
+			arg = DBPath()
+		}
+		return NewSQLiteStore(arg)
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("file store needs a directory, e.g. file:~/arnor-config")
+		}
+		return NewFileStore(expandHome(arg), IdentityPath())
+	default:
+		return nil, fmt.Errorf("unknown store %q (want sqlite, sqlite:<path> or file:<dir>)", spec)
+	}
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(os.Getenv("HOME"), rest)
+	}
+	return path
+}
+
+// CopyResult counts what CopyStore copied.
+type CopyResult struct {
+	Servers, Projects, Credentials, PeonKeys, HostKeys int
+}
+
+// CopyStore copies everything in src into dst. Existing records in dst with
+// the same keys are overwritten; others are left alone.
+func CopyStore(dst, src Store) (CopyResult, error) {
+	var res CopyResult
+
+	cfg, err := src.LoadConfig()
+	if err != nil {
+		return res, fmt.Errorf("loading config: %w", err)
+	}
+	if err := dst.SaveConfig(cfg); err != nil {
+		return res, fmt.Errorf("saving config: %w", err)
+	}
+	res.Servers, res.Projects = len(cfg.Servers), len(cfg.Projects)
+
+	creds, err := src.ListCredentials("")
+	if err != nil {
+		return res, err
+	}
+	for _, c := range creds {
+		if err := dst.SetCredential(c.Service, c.Name, c.Key, c.Value); err != nil {
+			return res, err
+		}
+		res.Credentials++
+	}
+
+	peonKeys, err := src.ListPeonKeys()
+	if err != nil {
+		return res, err
+	}
+	for _, k := range peonKeys {
+		if err := dst.SetPeonKey(k.ServerIP, k.PrivateKey, k.KeyPath); err != nil {
+			return res, err
+		}
+		res.PeonKeys++
+	}
+
+	hostKeys, err := src.ListHostKeys()
+	if err != nil {
+		return res, err
+	}
+	for _, k := range hostKeys {
+		if err := dst.SetHostKey(k.Host, k.Fingerprint); err != nil {
+			return res, err
+		}
+		res.HostKeys++
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+-- An arnor.db as written by schema version 1, before migrations existed.
+CREATE TABLE schema_version (version INTEGER NOT NULL);
+INSERT INTO schema_version (version) VALUES (1);
+
+CREATE TABLE credentials (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	service TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	key     TEXT NOT NULL,
+	value   TEXT NOT NULL,
+	UNIQUE(service, name, key)
+);
+CREATE TABLE servers (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	name            TEXT NOT NULL UNIQUE,
+	ip              TEXT NOT NULL,
+	hetzner_project TEXT NOT NULL,
+	hetzner_id      INTEGER NOT NULL
+);
+CREATE TABLE peon_keys (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	server_ip   TEXT NOT NULL UNIQUE,
+	private_key TEXT NOT NULL,
+	key_path    TEXT NOT NULL
+);
+CREATE TABLE host_keys (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	host        TEXT NOT NULL UNIQUE,
+	fingerprint TEXT NOT NULL
+);
+CREATE TABLE projects (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	name   TEXT NOT NULL UNIQUE,
+	repo   TEXT NOT NULL,
+	server TEXT NOT NULL
+);
+CREATE TABLE environments (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	project_id   INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+	env_name     TEXT NOT NULL,
+	domain       TEXT NOT NULL,
+	dns_provider TEXT NOT NULL,
+	branch       TEXT NOT NULL,
+	deploy_path  TEXT NOT NULL,
+	deploy_user  TEXT NOT NULL,
+	port         INTEGER NOT NULL,
+	UNIQUE(project_id, env_name)
+);
+
+INSERT INTO credentials (service, name, key, value) VALUES ('hetzner', 'prod', 'api_token', 'tok123');
+INSERT INTO credentials (service, name, key, value) VALUES ('porkbun', 'default', 'api_key', 'pk1');
+INSERT INTO servers (name, ip, hetzner_project, hetzner_id) VALUES ('web1', '1.2.3.4', 'prod', 42);
+INSERT INTO peon_keys (server_ip, private_key, key_path) VALUES ('1.2.3.4', 'PRIVATE KEY', '/home/me/.ssh/peon');
+INSERT INTO host_keys (host, fingerprint) VALUES ('1.2.3.4', 'SHA256:abc');
+INSERT INTO projects (name, repo, server) VALUES ('myclient', 'me/myclient', 'web1');
+INSERT INTO environments (project_id, env_name, domain, dns_provider, branch, deploy_path, deploy_user, port)
+	VALUES (1, 'prod', 'myclient.com', 'porkbun', 'main', '/opt/myclient/prod', 'peon', 3000);
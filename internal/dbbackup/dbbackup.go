@@ -0,0 +1,163 @@
+// Package dbbackup dumps and restores the database of a deployed compose
+// stack over the peon SSH connection, and schedules dumps on the server.
+package dbbackup
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// Kind is the type of database a dump holds.
+type Kind string
+
+const (
+	Postgres Kind = "postgres"
+	SQLite   Kind = "sqlite"
+)
+
+// Database is the database inside an environment's compose stack.
+type Database struct {
+	Kind    Kind
+	Service string // compose service it runs in, or that has the file
+	Path    string // SQLite only: the file inside the service's container
+}
+
+// Ext is the file extension of a compressed dump of the database.
+func (d Database) Ext() string {
+	if d.Kind == SQLite {
+		return ".db.gz"
+	}
+	return ".sql.gz"
+}
+
+func (d Database) String() string {
+	if d.Kind == SQLite {
+		return fmt.Sprintf("SQLite %s in service %s", d.Path, d.Service)
+	}
+	return "PostgreSQL in service " + d.Service
+}
+
+// KindOfFile returns the kind of dump a file name holds, judging by its
+// extension, or "" if it is neither.
+func KindOfFile(name string) Kind {
+	switch {
+	case strings.HasSuffix(name, ".sql.gz"):
+		return Postgres
+	case strings.HasSuffix(name, ".db.gz"):
+		return SQLite
+	}
+	return ""
+}
+
+// Streamer is a Runner that can also stream a command's input and output;
+// *remote.Client is one.
+type Streamer interface {
+	remote.Runner
+	Stream(command string, r io.Reader, w io.Writer) error
+}
+
+// Resolve finds the database of the stack in deployPath. With sqlitePath
+// it is that file in service (default "web"). Otherwise it is the
+// PostgreSQL service: service if given, or the one running service whose
+// image is postgres.
+func Resolve(client remote.Runner, deployPath, service, sqlitePath string) (Database, error) {
+	if sqlitePath != "" {
+		if !path.IsAbs(sqlitePath) {
+			return Database{}, fmt.Errorf("SQLite path %q must be absolute inside the container", sqlitePath)
+		}
+		if service == "" {
+			service = "web"
+		}
+		return Database{Kind: SQLite, Service: service, Path: sqlitePath}, nil
+	}
+	if service != "" {
+		return Database{Kind: Postgres, Service: service}, nil
+	}
+
+	out, err := client.Output(fmt.Sprintf("cd %s && sudo docker compose ps --format '{{.Service}}\t{{.Image}}'", deployPath))
+	if err != nil {
+		return Database{}, fmt.Errorf("listing services in %s: %w", deployPath, err)
+	}
+	var found []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		svc, image, ok := strings.Cut(line, "\t")
+		if ok && isPostgresImage(image) {
+			found = append(found, svc)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Database{}, fmt.Errorf("no running PostgreSQL service in %s; pass --sqlite for a SQLite file", deployPath)
+	case 1:
+		return Database{Kind: Postgres, Service: found[0]}, nil
+	}
+	return Database{}, fmt.Errorf("several PostgreSQL services in %s (%s); pass --service", deployPath, strings.Join(found, ", "))
+}
+
+// isPostgresImage matches postgres, postgis/postgis, bitnami/postgresql
+// and the like, with any tag.
+func isPostgresImage(image string) bool {
+	name := image[strings.LastIndex(image, "/")+1:]
+	name, _, _ = strings.Cut(name, ":")
+	return strings.HasPrefix(name, "postgres") || strings.HasPrefix(name, "postgis")
+}
+
+// pgConnArgs picks the user and database the official image was started
+// with, inside the container.
+const pgConnArgs = `-U "${POSTGRES_USER:-postgres}" -d "${POSTGRES_DB:-${POSTGRES_USER:-postgres}}"`
+
+// dumpPipeline is a shell pipeline, run in the stack's directory, that
+// writes a gzipped dump of db to stdout. sudo is prepended to docker when
+// not running as root. The caller must enable pipefail.
+func dumpPipeline(db Database, sudo string) string {
+	if db.Kind == SQLite {
+		// sqlite3's .backup is consistent while the app writes; images
+		// without the CLI get a plain copy.
+		script := `if command -v sqlite3 >/dev/null; then t=$(mktemp) && sqlite3 "$1" ".backup '$t'" && cat "$t" && rm -f "$t"; else cat "$1"; fi`
+		return fmt.Sprintf("%sdocker compose exec -T %s sh -c %s sh %s | gzip -c", sudo, db.Service, shellQuote(script), shellQuote(db.Path))
+	}
+	script := "pg_dump " + pgConnArgs + " --clean --if-exists --no-owner"
+	return fmt.Sprintf("%sdocker compose exec -T %s sh -c %s | gzip -c", sudo, db.Service, shellQuote(script))
+}
+
+// restorePipeline reads a gzipped dump on stdin and loads it into db.
+func restorePipeline(db Database, sudo string) string {
+	if db.Kind == SQLite {
+		// Swap the file in under a new name, drop the old journal, and
+		// restart the service so the app opens the restored database.
+		script := `cat > "$1.arnor-restore" && rm -f "$1-wal" "$1-shm" && mv "$1.arnor-restore" "$1"`
+		return fmt.Sprintf("gunzip -c | %sdocker compose exec -T %s sh -c %s sh %s && %sdocker compose restart %s",
+			sudo, db.Service, shellQuote(script), shellQuote(db.Path), sudo, db.Service)
+	}
+	script := "psql -q -v ON_ERROR_STOP=1 --single-transaction " + pgConnArgs
+	return fmt.Sprintf("gunzip -c | %sdocker compose exec -T %s sh -c %s > /dev/null", sudo, db.Service, shellQuote(script))
+}
+
+// Dump writes a gzipped dump of db in the stack at deployPath to w.
+func Dump(client Streamer, deployPath string, db Database, w io.Writer) error {
+	command := fmt.Sprintf("set -o pipefail && cd %s && %s", deployPath, dumpPipeline(db, "sudo "))
+	if err := client.Stream(command, nil, w); err != nil {
+		return fmt.Errorf("dumping %s: %w", db, err)
+	}
+	return nil
+}
+
+// Restore loads a gzipped dump read from r into db, replacing its data.
+// PostgreSQL dumps are loaded in one transaction, so a failed restore
+// leaves the database as it was.
+func Restore(client Streamer, deployPath string, db Database, r io.Reader) error {
+	command := fmt.Sprintf("set -o pipefail && cd %s && %s", deployPath, restorePipeline(db, "sudo "))
+	if err := client.Stream(command, r, nil); err != nil {
+		return fmt.Errorf("restoring %s: %w", db, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,210 @@
+package dbbackup
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fakeClient answers Output with a canned result and records the commands
+// it is given. Stream writes out to w and records what it reads from r.
+type fakeClient struct {
+	out      string
+	commands []string
+	streamed string
+}
+
+func (f *fakeClient) Run(command string) error {
+	f.commands = append(f.commands, command)
+	return nil
+}
+
+func (f *fakeClient) Output(command string) (string, error) {
+	f.commands = append(f.commands, command)
+	return f.out, nil
+}
+
+func (f *fakeClient) WriteFile(path, content string) error {
+	f.commands = append(f.commands, "write "+path)
+	return nil
+}
+
+func (f *fakeClient) Close() error { return nil }
+
+func (f *fakeClient) Stream(command string, r io.Reader, w io.Writer) error {
+	f.commands = append(f.commands, command)
+	if r != nil {
+		b, _ := io.ReadAll(r)
+		f.streamed = string(b)
+	}
+	if w != nil {
+		io.WriteString(w, f.out)
+	}
+	return nil
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name, ps, service, sqlite string
+		want                      Database
+		wantErr                   string
+	}{
+		{name: "postgres", ps: "web\tmyapp:latest\ndb\tpostgres:16-alpine\n", want: Database{Kind: Postgres, Service: "db"}},
+		{name: "postgis", ps: "web\tmyapp\ngis\tpostgis/postgis:16-3.4\n", want: Database{Kind: Postgres, Service: "gis"}},
+		{name: "service flag", service: "pg", want: Database{Kind: Postgres, Service: "pg"}},
+		{name: "sqlite", sqlite: "/data/app.db", want: Database{Kind: SQLite, Service: "web", Path: "/data/app.db"}},
+		{name: "sqlite service", sqlite: "/data/app.db", service: "api", want: Database{Kind: SQLite, Service: "api", Path: "/data/app.db"}},
+		{name: "sqlite relative", sqlite: "app.db", wantErr: "must be absolute"},
+		{name: "none", ps: "web\tmyapp\n", wantErr: "no running PostgreSQL"},
+		{name: "several", ps: "a\tpostgres:16\nb\tbitnami/postgresql\n", wantErr: "a, b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(&fakeClient{out: tt.ps}, "/opt/myapp-prod", tt.service, tt.sqlite)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	pg := Database{Kind: Postgres, Service: "db"}
+	c := &fakeClient{out: "dump"}
+	var buf bytes.Buffer
+	if err := Dump(c, "/opt/myapp-prod", pg, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "dump" {
+		t.Errorf("dump = %q", buf.String())
+	}
+	want := "set -o pipefail && cd /opt/myapp-prod && sudo docker compose exec -T db sh -c 'pg_dump " + pgConnArgs + " --clean --if-exists --no-owner' | gzip -c"
+	if c.commands[0] != want {
+		t.Errorf("dump command =\n%s\nwant\n%s", c.commands[0], want)
+	}
+
+	c = &fakeClient{}
+	if err := Restore(c, "/opt/myapp-prod", pg, strings.NewReader("gz")); err != nil {
+		t.Fatal(err)
+	}
+	if c.streamed != "gz" {
+		t.Errorf("restore read %q", c.streamed)
+	}
+	if !strings.Contains(c.commands[0], "gunzip -c | sudo docker compose exec -T db sh -c 'psql -q -v ON_ERROR_STOP=1 --single-transaction") {
+		t.Errorf("restore command = %s", c.commands[0])
+	}
+
+	lite := Database{Kind: SQLite, Service: "web", Path: "/data/it's.db"}
+	c = &fakeClient{}
+	if err := Restore(c, "/opt/myapp-prod", lite, strings.NewReader("gz")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(c.commands[0], `sh '/data/it'\''s.db' && sudo docker compose restart web`) {
+		t.Errorf("restore command = %s", c.commands[0])
+	}
+}
+
+func TestKindOfFile(t *testing.T) {
+	for name, want := range map[string]Kind{
+		"myapp-prod-20260101T030000Z.sql.gz": Postgres,
+		"s3://b/myapp-prod.db.gz":            SQLite,
+		"dump.tar":                           "",
+	} {
+		if got := KindOfFile(name); got != want {
+			t.Errorf("KindOfFile(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestScheduleFiles(t *testing.T) {
+	base := Schedule{
+		Name:       "myapp-prod",
+		DeployPath: "/opt/myapp-prod",
+		DB:         Database{Kind: Postgres, Service: "db"},
+		Calendar:   "daily",
+		Keep:       7,
+	}
+
+	local := base
+	local.Target = "/var/backups/myapp/"
+	files, err := local.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want script, service and timer", len(files))
+	}
+	script := files[0]
+	if script.Path != "/etc/arnor/dbbackup/myapp-prod.sh" || script.Mode != "700" {
+		t.Errorf("script = %s mode %s", script.Path, script.Mode)
+	}
+	for _, want := range []string{
+		"cd /opt/myapp-prod\n",
+		"docker compose exec -T db sh -c 'pg_dump",
+		`install -m 600 "$tmp/$file" /var/backups/myapp/"$file"`,
+		"ls -1 /var/backups/myapp/myapp-prod-*.sql.gz | sort -r | tail -n +8 |",
+	} {
+		if !strings.Contains(script.Content, want) {
+			t.Errorf("script lacks %q:\n%s", want, script.Content)
+		}
+	}
+	if strings.Contains(script.Content, "sudo") {
+		t.Errorf("script runs as root and should not use sudo:\n%s", script.Content)
+	}
+	if strings.Contains(files[1].Content, "EnvironmentFile") {
+		t.Errorf("local target should not have an environment file:\n%s", files[1].Content)
+	}
+	if files[2].Path != "/etc/systemd/system/arnor-dbbackup-myapp-prod.timer" || !strings.Contains(files[2].Content, "OnCalendar=daily\n") {
+		t.Errorf("timer %s:\n%s", files[2].Path, files[2].Content)
+	}
+	if bash, err := exec.LookPath("bash"); err == nil {
+		cmd := exec.Command(bash, "-n")
+		cmd.Stdin = strings.NewReader(script.Content)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("script does not parse: %v\n%s", err, out)
+		}
+	}
+
+	remote := base
+	remote.Target = "s3://backups/arnor"
+	remote.S3 = NewS3Client("http://minio:9000", "us-east-1", "AK", "SK")
+	files, err = remote.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("got %d files, want env, script, service and timer", len(files))
+	}
+	if files[0].Mode != "600" || !strings.Contains(files[0].Content, "S3_ENDPOINT=http://minio:9000\n") {
+		t.Errorf("env file %s mode %s:\n%s", files[0].Path, files[0].Mode, files[0].Content)
+	}
+	if !strings.Contains(files[1].Content, `aws s3 cp "/backup/$file" "s3://backups/arnor/$file"`) {
+		t.Errorf("script:\n%s", files[1].Content)
+	}
+	if !strings.Contains(files[2].Content, "EnvironmentFile=/etc/arnor/dbbackup/myapp-prod.env\n") {
+		t.Errorf("service:\n%s", files[2].Content)
+	}
+
+	for _, bad := range []Schedule{
+		{Name: "x", Keep: 0, Target: "/backups"},
+		{Name: "x", Keep: 1, Target: "backups"},
+		{Name: "x", Keep: 1, Target: "/backups; rm -rf /"},
+		{Name: "x", Keep: 1, Target: "s3://backups"},
+		{Name: "x", Keep: 1, Target: "s3://backups/$(id)", S3: remote.S3},
+	} {
+		if _, err := bad.Files(); err == nil {
+			t.Errorf("Files() for target %q keep %d succeeded", bad.Target, bad.Keep)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package dbbackup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// S3Client reads and writes objects in an S3-compatible store (AWS, MinIO,
+// Hetzner Object Storage, ...) using path-style URLs and SigV4 signing.
+type S3Client struct {
+	Endpoint        string // e.g. https://fsn1.your-objectstorage.com
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	http *http.Client
+	now  func() time.Time
+}
+
+// NewS3Client returns a client for the store at endpoint.
+func NewS3Client(endpoint, region, accessKeyID, secretAccessKey string) *S3Client {
+	return &S3Client{
+		Endpoint:        strings.TrimSuffix(endpoint, "/"),
+		Region:          region,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		http:            &http.Client{Timeout: 30 * time.Minute},
+		now:             time.Now,
+	}
+}
+
+// LoadS3Client builds a client from the s3/default credentials. region
+// defaults to us-east-1 and endpoint to AWS in that region.
+func LoadS3Client(store config.Store) (*S3Client, error) {
+	get := func(key string) (string, error) {
+		v, err := store.GetCredential("s3", "default", key)
+		if err != nil {
+			return "", fmt.Errorf("s3 %s: %w", key, err)
+		}
+		return v, nil
+	}
+	accessKeyID, err := get("access_key_id")
+	if err != nil {
+		return nil, err
+	}
+	secretAccessKey, err := get("secret_access_key")
+	if err != nil {
+		return nil, err
+	}
+	region, _ := store.GetCredential("s3", "default", "region")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint, _ := store.GetCredential("s3", "default", "endpoint")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return NewS3Client(endpoint, region, accessKeyID, secretAccessKey), nil
+}
+
+// ParseS3URL splits s3://bucket/key into bucket and key. key may be empty
+// or a prefix ending in "/".
+func ParseS3URL(s string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/key URL", s)
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q has no bucket", s)
+	}
+	return bucket, key, nil
+}
+
+// Put uploads size bytes from body to bucket/key.
+func (c *S3Client) Put(bucket, key string, body io.ReadSeeker, size int64) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", c.objectURL(bucket, key), io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	c.sign(req, hex.EncodeToString(h.Sum(nil)))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkS3Response(resp, "PUT", bucket, key)
+}
+
+// Get downloads bucket/key. The caller closes the returned body.
+func (c *S3Client) Get(bucket, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", c.objectURL(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, emptySHA256)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkS3Response(resp, "GET", bucket, key); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *S3Client) objectURL(bucket, key string) string {
+	return c.Endpoint + "/" + bucket + "/" + escapeS3Key(key)
+}
+
+func checkS3Response(resp *http.Response, method, bucket, key string) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var detail string
+	// S3 errors are XML; the <Message> is the useful part.
+	if _, rest, ok := strings.Cut(string(body), "<Message>"); ok {
+		detail, _, _ = strings.Cut(rest, "</Message>")
+	}
+	if detail == "" {
+		detail = resp.Status
+	}
+	return fmt.Errorf("S3 %s s3://%s/%s: %s", method, bucket, key, detail)
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds SigV4 headers to req, signing the host and every header
+// already set on it.
+func (c *S3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // no query string
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// escapeS3Key percent-encodes an object key the way SigV4 expects: every
+// byte but unreserved characters and "/".
+func escapeS3Key(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
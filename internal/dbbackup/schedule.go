@@ -0,0 +1,220 @@
+package dbbackup
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// scheduleDir holds the backup scripts and S3 settings on the server. It is
+// readable by root only.
+const scheduleDir = "/etc/arnor/dbbackup"
+
+// awsCLIImage uploads to S3 from the server, so nothing needs installing
+// besides Docker.
+const awsCLIImage = "amazon/aws-cli"
+
+// safeDirRe and safeS3Re limit targets to characters that need no quoting
+// in the backup script.
+var (
+	safeDirRe = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+	safeS3Re  = regexp.MustCompile(`^s3://[A-Za-z0-9._-]+(/[A-Za-z0-9._/-]*)?$`)
+)
+
+// Schedule is a systemd timer on the server that dumps a stack's database
+// and keeps the newest dumps in a directory on the server or in S3.
+type Schedule struct {
+	Name       string // the stack's directory name, e.g. "myapp-dev"
+	DeployPath string
+	DB         Database
+	Calendar   string // systemd OnCalendar, e.g. "daily" or "*-*-* 03:00"
+	Keep       int    // dumps to keep; older ones are deleted
+
+	// Target is a directory on the server or s3://bucket/prefix. S3 is
+	// required for the latter; its credentials are copied to the server.
+	Target string
+	S3     *S3Client
+}
+
+// UnitName is the name of the systemd service and timer for a stack.
+func UnitName(name string) string {
+	return "arnor-dbbackup-" + name
+}
+
+type scheduleData struct {
+	Schedule
+	Dump     string
+	Ext      string
+	KeepFrom int // first line of a newest-first listing to delete
+	Dir      string
+	Bucket   string
+	Prefix   string
+	AWSImage string
+	EnvFile  string
+	Script   string
+}
+
+var scriptTmpl = template.Must(template.New("script").Parse(`#!/bin/bash
+# Written by arnor: backs up {{ .DB }} of {{ .DeployPath }}.
+set -euo pipefail
+file={{ .Name }}-$(date -u +%Y%m%dT%H%M%SZ){{ .Ext }}
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+cd {{ .DeployPath }}
+{{ .Dump }} > "$tmp/$file"
+{{- if .Dir }}
+mkdir -p {{ .Dir }}
+install -m 600 "$tmp/$file" {{ .Dir }}/"$file"
+ls -1 {{ .Dir }}/{{ .Name }}-*{{ .Ext }} | sort -r | tail -n +{{ .KeepFrom }} | xargs -r rm -f --
+{{- else }}
+aws() {
+  docker run --rm -v "$tmp:/backup" -e AWS_ACCESS_KEY_ID -e AWS_SECRET_ACCESS_KEY -e AWS_DEFAULT_REGION \
+    {{ .AWSImage }} --endpoint-url "$S3_ENDPOINT" "$@"
+}
+aws s3 cp "/backup/$file" "s3://{{ .Bucket }}/{{ .Prefix }}$file" --only-show-errors
+aws s3 ls "s3://{{ .Bucket }}/{{ .Prefix }}{{ .Name }}-" | awk '{print $4}' | sort -r | tail -n +{{ .KeepFrom }} | while read -r old; do
+  aws s3 rm "s3://{{ .Bucket }}/{{ .Prefix }}$old" --only-show-errors
+done
+{{- end }}
+`))
+
+var serviceTmpl = template.Must(template.New("service").Parse(`[Unit]
+Description=Back up the {{ .Name }} database
+Wants=docker.service
+After=docker.service
+
+[Service]
+Type=oneshot
+{{- if .EnvFile }}
+EnvironmentFile={{ .EnvFile }}
+{{- end }}
+ExecStart={{ .Script }}
+`))
+
+var timerTmpl = template.Must(template.New("timer").Parse(`[Unit]
+Description=Back up the {{ .Name }} database on a schedule
+
+[Timer]
+OnCalendar={{ .Calendar }}
+RandomizedDelaySec=15m
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+// ScheduleFile is a file Install writes, with its mode.
+type ScheduleFile struct {
+	Path    string
+	Mode    string
+	Content string
+}
+
+// Files renders the script, S3 settings, service and timer for s.
+func (s Schedule) Files() ([]ScheduleFile, error) {
+	if s.Keep < 1 {
+		return nil, fmt.Errorf("keep must be at least 1")
+	}
+	d := scheduleData{
+		Schedule: s,
+		Dump:     dumpPipeline(s.DB, ""),
+		Ext:      s.DB.Ext(),
+		KeepFrom: s.Keep + 1,
+		AWSImage: awsCLIImage,
+		Script:   scheduleDir + "/" + s.Name + ".sh",
+	}
+
+	var files []ScheduleFile
+	if strings.HasPrefix(s.Target, "s3://") {
+		bucket, prefix, err := ParseS3URL(s.Target)
+		if err != nil {
+			return nil, err
+		}
+		if !safeS3Re.MatchString(s.Target) {
+			return nil, fmt.Errorf("target %q may only use letters, digits, '.', '_', '-' and '/'", s.Target)
+		}
+		if s.S3 == nil {
+			return nil, fmt.Errorf("S3 credentials are required for %s", s.Target)
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		d.Bucket, d.Prefix = bucket, prefix
+		d.EnvFile = scheduleDir + "/" + s.Name + ".env"
+		env := fmt.Sprintf("AWS_ACCESS_KEY_ID=%s\nAWS_SECRET_ACCESS_KEY=%s\nAWS_DEFAULT_REGION=%s\nS3_ENDPOINT=%s\n",
+			s.S3.AccessKeyID, s.S3.SecretAccessKey, s.S3.Region, s.S3.Endpoint)
+		files = append(files, ScheduleFile{Path: d.EnvFile, Mode: "600", Content: env})
+	} else {
+		if !safeDirRe.MatchString(s.Target) {
+			return nil, fmt.Errorf("target %q must be an absolute directory on the server or s3://bucket/prefix", s.Target)
+		}
+		d.Dir = strings.TrimSuffix(s.Target, "/")
+	}
+
+	for _, f := range []struct {
+		path, mode string
+		tmpl       *template.Template
+	}{
+		{d.Script, "700", scriptTmpl},
+		{"/etc/systemd/system/" + UnitName(s.Name) + ".service", "644", serviceTmpl},
+		{"/etc/systemd/system/" + UnitName(s.Name) + ".timer", "644", timerTmpl},
+	} {
+		var buf bytes.Buffer
+		if err := f.tmpl.Execute(&buf, d); err != nil {
+			return nil, err
+		}
+		files = append(files, ScheduleFile{Path: f.path, Mode: f.mode, Content: buf.String()})
+	}
+	return files, nil
+}
+
+// Install writes the schedule's files, starts its timer and runs one backup
+// straight away, so a bad target or credentials show up now rather than
+// at the first scheduled run.
+func Install(client remote.Runner, s Schedule) error {
+	if out, err := client.Output(fmt.Sprintf("systemd-analyze calendar %s 2>&1", shellQuote(s.Calendar))); err != nil {
+		return fmt.Errorf("invalid schedule %q: %s", s.Calendar, strings.TrimSpace(out))
+	}
+	files, err := s.Files()
+	if err != nil {
+		return err
+	}
+	if err := client.Run(fmt.Sprintf("sudo install -d -m 700 %s", scheduleDir)); err != nil {
+		return fmt.Errorf("creating %s: %w", scheduleDir, err)
+	}
+	for _, f := range files {
+		if err := client.WriteFile(f.Path, f.Content); err != nil {
+			return fmt.Errorf("writing %s: %w", f.Path, err)
+		}
+		if err := client.Run(fmt.Sprintf("sudo chmod %s %s", f.Mode, f.Path)); err != nil {
+			return fmt.Errorf("setting mode of %s: %w", f.Path, err)
+		}
+	}
+
+	unit := UnitName(s.Name)
+	if err := client.Run(fmt.Sprintf("sudo systemctl daemon-reload && sudo systemctl enable --now %s.timer", unit)); err != nil {
+		return fmt.Errorf("enabling %s.timer: %w", unit, err)
+	}
+	if err := client.Run(fmt.Sprintf("sudo systemctl start %s.service", unit)); err != nil {
+		journal, _ := client.Output(fmt.Sprintf("sudo journalctl -u %s.service -n 20 --no-pager 2>&1", unit))
+		return fmt.Errorf("first backup failed: %w\njournal output:\n%s", err, strings.TrimSpace(journal))
+	}
+	return nil
+}
+
+// Uninstall stops and removes a stack's backup timer. Dumps already taken
+// are left alone. Removing a timer that isn't installed is not an error.
+func Uninstall(client remote.Runner, name string) error {
+	unit := UnitName(name)
+	client.Run(fmt.Sprintf("sudo systemctl disable --now %s.timer 2>/dev/null", unit))
+	command := fmt.Sprintf("sudo rm -f /etc/systemd/system/%[1]s.service /etc/systemd/system/%[1]s.timer %[2]s/%[3]s.sh %[2]s/%[3]s.env && sudo systemctl daemon-reload",
+		unit, scheduleDir, name)
+	if err := client.Run(command); err != nil {
+		return fmt.Errorf("removing %s: %w", unit, err)
+	}
+	return nil
+}
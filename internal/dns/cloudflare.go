@@ -1,17 +1,30 @@
 package dns
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/gwaihir/pkg/cloudflare"
 )
 
+// cloudflareAPIBase is used for listing and writing records, since the
+// gwaihir client has no priority, SRV/CAA data or proxied flag.
+const cloudflareAPIBase = "https://api.cloudflare.com/client/v4"
+
 // CloudflareProvider adapts the gwaihir Cloudflare client to the Provider interface.
 type CloudflareProvider struct {
 	client    *cloudflare.Client
 	accountID string
+	token     string
+	baseURL   string
+	http      *http.Client
 	zoneIDs   map[string]string // domain -> zoneID cache
 }
 
@@ -27,6 +40,9 @@ func NewCloudflareProvider(store config.Store) (*CloudflareProvider, error) {
 	return &CloudflareProvider{
 		client:    cloudflare.NewClient(token),
 		accountID: accountID,
+		token:     token,
+		baseURL:   cloudflareAPIBase,
+		http:      &http.Client{Timeout: 30 * time.Second},
 		zoneIDs:   make(map[string]string),
 	}, nil
 }
@@ -45,39 +61,75 @@ func (c *CloudflareProvider) getZoneID(domain string) (string, error) {
 	return id, nil
 }
 
-func (c *CloudflareProvider) CreateRecord(domain, name, recordType, content, ttl string) (string, error) {
+// cloudflareRecord is a record as the API sends and receives it.
+type cloudflareRecord struct {
+	ID       string         `json:"id,omitempty"`
+	Type     string         `json:"type"`
+	Name     string         `json:"name"`
+	Content  string         `json:"content,omitempty"`
+	TTL      int            `json:"ttl"`
+	Priority *int           `json:"priority,omitempty"`
+	Proxied  *bool          `json:"proxied,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+func (c *CloudflareProvider) CreateRecord(domain string, rec DNSRecord) (string, error) {
 	zoneID, err := c.getZoneID(domain)
 	if err != nil {
 		return "", err
 	}
+	body, err := toCloudflare(domain, rec)
+	if err != nil {
+		return "", err
+	}
+	var created cloudflareRecord
+	if err := c.do(http.MethodPost, fmt.Sprintf("/zones/%s/dns_records", zoneID), body, &created); err != nil {
+		return "", fmt.Errorf("creating %s record: %w", rec.Type, err)
+	}
+	return created.ID, nil
+}
 
-	// Cloudflare expects FQDN for the name field. Porkbun uses just the subdomain.
-	fqdn := name
-	if name == "" {
-		fqdn = domain
-	} else if name != domain && !isSubdomainOf(name, domain) {
-		fqdn = name + "." + domain
+// UpdateRecord PATCHes the record, so it keeps its ID.
+func (c *CloudflareProvider) UpdateRecord(domain, id string, rec DNSRecord) error {
+	zoneID, err := c.getZoneID(domain)
+	if err != nil {
+		return err
+	}
+	body, err := toCloudflare(domain, rec)
+	if err != nil {
+		return err
 	}
+	if err := c.do(http.MethodPatch, fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, id), body, nil); err != nil {
+		return fmt.Errorf("updating record %s: %w", id, err)
+	}
+	return nil
+}
 
-	ttlInt := 1 // Cloudflare automatic
-	if ttl != "" {
-		if v, err := strconv.Atoi(ttl); err == nil {
-			ttlInt = v
+func (c *CloudflareProvider) UpsertRecord(domain string, rec DNSRecord) (string, error) {
+	records, err := c.ListRecords(domain)
+	if err != nil {
+		return "", err
+	}
+	fqdn := fqdnFor(rec.Name, domain)
+	var matches []DNSRecord
+	for _, r := range records {
+		if r.Name == fqdn && r.Type == rec.Type {
+			matches = append(matches, r)
 		}
 	}
-
-	record := cloudflare.DNSRecord{
-		Type:    recordType,
-		Name:    fqdn,
-		Content: content,
-		TTL:     ttlInt,
+	if len(matches) == 0 {
+		return c.CreateRecord(domain, rec)
 	}
 
-	result, err := c.client.CreateRecord(zoneID, record)
-	if err != nil {
+	if err := c.UpdateRecord(domain, matches[0].ID, rec); err != nil {
 		return "", err
 	}
-	return result.ID, nil
+	for _, r := range matches[1:] {
+		if err := c.DeleteRecord(domain, r.ID); err != nil {
+			return "", fmt.Errorf("removing duplicate %s record %s: %w", rec.Type, r.ID, err)
+		}
+	}
+	return matches[0].ID, nil
 }
 
 func (c *CloudflareProvider) DeleteRecord(domain, id string) error {
@@ -94,22 +146,185 @@ func (c *CloudflareProvider) ListRecords(domain string) ([]DNSRecord, error) {
 		return nil, err
 	}
 
-	records, err := c.client.ListRecords(zoneID)
+	var out []DNSRecord
+	for page := 1; ; page++ {
+		var records []cloudflareRecord
+		info, err := c.request(http.MethodGet, fmt.Sprintf("/zones/%s/dns_records?per_page=100&page=%d", zoneID, page), nil, &records)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			out = append(out, fromCloudflare(r))
+		}
+		if page >= info.TotalPages {
+			return out, nil
+		}
+	}
+}
+
+type cloudflareResultInfo struct {
+	TotalPages int `json:"total_pages"`
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result     json.RawMessage      `json:"result"`
+	ResultInfo cloudflareResultInfo `json:"result_info"`
+}
+
+func (c *CloudflareProvider) do(method, path string, body, out any) error {
+	_, err := c.request(method, path, body, out)
+	return err
+}
+
+// request calls the API and decodes the result field of the response
+// envelope into out.
+func (c *CloudflareProvider) request(method, path string, body, out any) (cloudflareResultInfo, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return cloudflareResultInfo{}, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
 	if err != nil {
-		return nil, err
+		return cloudflareResultInfo{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return cloudflareResultInfo{}, err
 	}
+	defer resp.Body.Close()
 
-	out := make([]DNSRecord, len(records))
-	for i, r := range records {
-		out[i] = DNSRecord{
-			ID:      r.ID,
-			Name:    r.Name,
-			Type:    r.Type,
-			Content: r.Content,
-			TTL:     strconv.Itoa(r.TTL),
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cloudflareResultInfo{}, err
+	}
+	var result cloudflareResponse
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return cloudflareResultInfo{}, fmt.Errorf("parsing cloudflare response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if !result.Success {
+		if len(result.Errors) > 0 {
+			return cloudflareResultInfo{}, fmt.Errorf("cloudflare: %s (code %d)", result.Errors[0].Message, result.Errors[0].Code)
+		}
+		return cloudflareResultInfo{}, fmt.Errorf("cloudflare: HTTP %d", resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.Unmarshal(result.Result, out); err != nil {
+			return cloudflareResultInfo{}, fmt.Errorf("parsing cloudflare result: %w", err)
 		}
 	}
-	return out, nil
+	return result.ResultInfo, nil
+}
+
+// toCloudflare converts rec into a request body. SRV and CAA records are
+// sent as structured data rather than content.
+func toCloudflare(domain string, rec DNSRecord) (cloudflareRecord, error) {
+	r := cloudflareRecord{
+		Type:    rec.Type,
+		Name:    fqdnFor(rec.Name, domain),
+		Content: rec.Content,
+		TTL:     cloudflareTTL(rec.TTL),
+	}
+	switch rec.Type {
+	case "A", "AAAA", "CNAME":
+		proxied := rec.Proxied
+		r.Proxied = &proxied
+	case "MX":
+		priority := rec.Priority
+		r.Priority = &priority
+	case "SRV":
+		r.Content = ""
+		r.Data = map[string]any{
+			"priority": rec.Priority,
+			"weight":   rec.Weight,
+			"port":     rec.Port,
+			"target":   rec.Content,
+		}
+	case "CAA":
+		flags, tag, value, err := splitCAA(rec.Content)
+		if err != nil {
+			return r, err
+		}
+		r.Content = ""
+		r.Data = map[string]any{"flags": flags, "tag": tag, "value": value}
+	}
+	return r, nil
+}
+
+func fromCloudflare(r cloudflareRecord) DNSRecord {
+	rec := DNSRecord{
+		ID:      r.ID,
+		Name:    r.Name,
+		Type:    r.Type,
+		Content: r.Content,
+		TTL:     strconv.Itoa(r.TTL),
+	}
+	if r.Priority != nil {
+		rec.Priority = *r.Priority
+	}
+	if r.Proxied != nil {
+		rec.Proxied = *r.Proxied
+	}
+	switch r.Type {
+	case "SRV":
+		// Content is "weight port target"; the priority comes separately.
+		var target string
+		if n, _ := fmt.Sscanf(r.Content, "%d %d %s", &rec.Weight, &rec.Port, &target); n == 3 {
+			rec.Content = target
+		}
+	case "CAA":
+		if r.Data != nil {
+			rec.Content = fmt.Sprintf("%v %v %q", r.Data["flags"], r.Data["tag"], r.Data["value"])
+		}
+	}
+	return rec
+}
+
+// splitCAA parses CAA content of the form `0 issue "letsencrypt.org"`.
+func splitCAA(content string) (int, string, string, error) {
+	fields := strings.SplitN(strings.TrimSpace(content), " ", 3)
+	if len(fields) != 3 {
+		return 0, "", "", fmt.Errorf("CAA content %q should look like: 0 issue \"letsencrypt.org\"", content)
+	}
+	flags, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", "", fmt.Errorf("CAA flags %q: %w", fields[0], err)
+	}
+	return flags, fields[1], strings.Trim(fields[2], `"`), nil
+}
+
+// fqdnFor expands a record name relative to domain into the full name, as
+// Cloudflare expects. Porkbun uses just the subdomain.
+func fqdnFor(name, domain string) string {
+	if name == "" {
+		return domain
+	}
+	if name != domain && !isSubdomainOf(name, domain) {
+		return name + "." + domain
+	}
+	return name
+}
+
+// cloudflareTTL parses ttl, defaulting to 1, which Cloudflare treats as
+// automatic.
+func cloudflareTTL(ttl string) int {
+	if v, err := strconv.Atoi(ttl); err == nil {
+		return v
+	}
+	return 1
 }
 
 // isSubdomainOf checks if name is already a subdomain of domain (e.g. "www.example.com" of "example.com").
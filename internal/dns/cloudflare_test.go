@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCloudflareRecords(t *testing.T) {
+	var created map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`))
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("page") == "1":
+			w.Write([]byte(`{"success":true,"result_info":{"total_pages":2},"result":[
+				{"id":"a","type":"A","name":"example.com","content":"1.2.3.4","ttl":1,"proxied":true},
+				{"id":"b","type":"MX","name":"example.com","content":"smtp.google.com","ttl":3600,"priority":1}
+			]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"success":true,"result_info":{"total_pages":2},"result":[
+				{"id":"c","type":"SRV","name":"_sip._tcp.example.com","content":"60 5060 sip.example.com","ttl":300,"priority":10,
+				 "data":{"priority":10,"weight":60,"port":5060,"target":"sip.example.com"}},
+				{"id":"d","type":"CAA","name":"example.com","content":"0 issue \"letsencrypt.org\"","ttl":300,
+				 "data":{"flags":0,"tag":"issue","value":"letsencrypt.org"}}
+			]}`))
+		case r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"success":true,"result":{"id":"e"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := &CloudflareProvider{token: "tok", baseURL: srv.URL, http: srv.Client(), zoneIDs: map[string]string{"example.com": "z1"}}
+
+	records, err := c.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DNSRecord{
+		{ID: "a", Name: "example.com", Type: "A", Content: "1.2.3.4", TTL: "1", Proxied: true},
+		{ID: "b", Name: "example.com", Type: "MX", Content: "smtp.google.com", TTL: "3600", Priority: 1},
+		{ID: "c", Name: "_sip._tcp.example.com", Type: "SRV", Content: "sip.example.com", TTL: "300", Priority: 10, Weight: 60, Port: 5060},
+		{ID: "d", Name: "example.com", Type: "CAA", Content: `0 issue "letsencrypt.org"`, TTL: "300"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("ListRecords:\n%+v\nwant:\n%+v", records, want)
+	}
+
+	id, err := c.CreateRecord("example.com", DNSRecord{Name: "_sip._tcp", Type: "SRV", Content: "sip.example.com", Priority: 10, Weight: 60, Port: 5060})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "e" {
+		t.Errorf("ID = %q, want e", id)
+	}
+	wantBody := map[string]any{
+		"type": "SRV", "name": "_sip._tcp.example.com", "ttl": float64(1),
+		"data": map[string]any{"priority": float64(10), "weight": float64(60), "port": float64(5060), "target": "sip.example.com"},
+	}
+	if !reflect.DeepEqual(created, wantBody) {
+		t.Errorf("create body = %v, want %v", created, wantBody)
+	}
+
+	c.token = "wrong"
+	if _, err := c.ListRecords("example.com"); err == nil || err.Error() != "cloudflare: Authentication error (code 10000)" {
+		t.Errorf("err = %v", err)
+	}
+}
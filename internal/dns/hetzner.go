@@ -0,0 +1,343 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// hetznerAPIBase is the Hetzner Cloud API, which also serves DNS zones.
+const hetznerAPIBase = "https://api.hetzner.cloud/v1"
+
+// HetznerProvider manages zones through the Hetzner Cloud DNS API. It reuses
+// the API tokens stored for each Hetzner project and finds the project that
+// owns a zone on first use.
+type HetznerProvider struct {
+	baseURL string
+	tokens  map[string]string // project alias -> API token
+	owners  map[string]string // zone -> API token of the owning project
+	http    *http.Client
+}
+
+func NewHetznerProvider(store config.Store) (*HetznerProvider, error) {
+	projects, err := store.ListHetznerProjects()
+	if err != nil {
+		return nil, fmt.Errorf("listing Hetzner projects: %w", err)
+	}
+	if len(projects) == 0 {
+		return nil, fmt.Errorf("no Hetzner projects configured")
+	}
+	tokens := make(map[string]string, len(projects))
+	for _, p := range projects {
+		token, err := store.GetCredential("hetzner", p.Alias, "api_token")
+		if err != nil {
+			return nil, fmt.Errorf("credential for Hetzner project %q: %w", p.Alias, err)
+		}
+		tokens[p.Alias] = token
+	}
+	return newHetznerProvider(hetznerAPIBase, tokens), nil
+}
+
+func newHetznerProvider(baseURL string, tokens map[string]string) *HetznerProvider {
+	return &HetznerProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		tokens:  tokens,
+		owners:  make(map[string]string),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (h *HetznerProvider) Name() string { return "hetzner" }
+
+type hetznerRRSet struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TTL     *int   `json:"ttl"`
+	Records []struct {
+		Value string `json:"value"`
+	} `json:"records"`
+}
+
+func (h *HetznerProvider) CreateRecord(domain string, rec DNSRecord) (string, error) {
+	rrName := hetznerName(rec.Name, domain)
+	value := zoneData(rec)
+
+	body := map[string]any{
+		"records": []map[string]string{{"value": value}},
+	}
+	if rec.TTL != "" {
+		if v, err := strconv.Atoi(rec.TTL); err == nil {
+			body["ttl"] = v
+		}
+	}
+	if err := h.rrsetAction(domain, rrName, rec.Type, "add_records", body); err != nil {
+		return "", err
+	}
+	return hetznerID(rrName, rec.Type, value), nil
+}
+
+// UpdateRecord adds the new value before removing the old one, so the name
+// never stops resolving. The record's ID changes with its value. The TTL
+// belongs to the RRSet and add_records leaves an existing one's alone, so
+// a changed TTL is set separately.
+func (h *HetznerProvider) UpdateRecord(domain, id string, rec DNSRecord) error {
+	parts := strings.SplitN(id, "/", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid Hetzner record ID %q", id)
+	}
+	newID, err := h.CreateRecord(domain, rec)
+	if err != nil {
+		return err
+	}
+	if err := h.changeTTL(domain, rec); err != nil {
+		return err
+	}
+	if newID == id {
+		return nil
+	}
+	return h.DeleteRecord(domain, id)
+}
+
+// UpsertRecord replaces the values of an existing RRSet in one call, or
+// creates the RRSet if there isn't one.
+func (h *HetznerProvider) UpsertRecord(domain string, rec DNSRecord) (string, error) {
+	records, err := h.ListRecords(domain)
+	if err != nil {
+		return "", err
+	}
+	rrName := hetznerName(rec.Name, domain)
+	fqdn := hetznerFQDN(rrName, domain)
+	var current *DNSRecord
+	for i, r := range records {
+		if r.Name == fqdn && r.Type == rec.Type {
+			current = &records[i]
+			break
+		}
+	}
+	if current == nil {
+		return h.CreateRecord(domain, rec)
+	}
+
+	value := zoneData(rec)
+	body := map[string]any{
+		"records": []map[string]string{{"value": value}},
+	}
+	if err := h.rrsetAction(domain, rrName, rec.Type, "set_records", body); err != nil {
+		return "", err
+	}
+	if rec.TTL != "" && rec.TTL != current.TTL {
+		if v, err := strconv.Atoi(rec.TTL); err == nil {
+			if err := h.rrsetAction(domain, rrName, rec.Type, "change_ttl", map[string]any{"ttl": v}); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hetznerID(rrName, rec.Type, value), nil
+}
+
+// changeTTL sets the TTL of rec's RRSet to rec.TTL if the live one differs.
+func (h *HetznerProvider) changeTTL(domain string, rec DNSRecord) error {
+	ttl, err := strconv.Atoi(rec.TTL)
+	if err != nil {
+		return nil
+	}
+	token, err := h.owner(domain)
+	if err != nil {
+		return err
+	}
+	rrName := hetznerName(rec.Name, domain)
+	var resp struct {
+		RRSet hetznerRRSet `json:"rrset"`
+	}
+	path := fmt.Sprintf("/zones/%s/rrsets/%s/%s",
+		url.PathEscape(domain), url.PathEscape(rrName), url.PathEscape(rec.Type))
+	if err := h.do(token, http.MethodGet, path, nil, &resp); err != nil {
+		return err
+	}
+	if resp.RRSet.TTL != nil && *resp.RRSet.TTL == ttl {
+		return nil
+	}
+	return h.rrsetAction(domain, rrName, rec.Type, "change_ttl", map[string]any{"ttl": ttl})
+}
+
+func (h *HetznerProvider) DeleteRecord(domain, id string) error {
+	parts := strings.SplitN(id, "/", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid Hetzner record ID %q", id)
+	}
+	body := map[string]any{
+		"records": []map[string]string{{"value": parts[2]}},
+	}
+	return h.rrsetAction(domain, parts[0], parts[1], "remove_records", body)
+}
+
+func (h *HetznerProvider) ListRecords(domain string) ([]DNSRecord, error) {
+	token, err := h.owner(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []DNSRecord
+	for page := 1; page > 0; {
+		var resp struct {
+			RRSets []hetznerRRSet `json:"rrsets"`
+			Meta   struct {
+				Pagination struct {
+					NextPage *int `json:"next_page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		path := fmt.Sprintf("/zones/%s/rrsets?per_page=100&page=%d", url.PathEscape(domain), page)
+		if err := h.do(token, http.MethodGet, path, nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, rr := range resp.RRSets {
+			ttl := ""
+			if rr.TTL != nil {
+				ttl = strconv.Itoa(*rr.TTL)
+			}
+			for _, rec := range rr.Records {
+				r := DNSRecord{
+					ID:   hetznerID(rr.Name, rr.Type, rec.Value),
+					Name: hetznerFQDN(rr.Name, domain),
+					Type: rr.Type,
+					TTL:  ttl,
+				}
+				parseZoneData(&r, rec.Value)
+				out = append(out, r)
+			}
+		}
+		page = 0
+		if next := resp.Meta.Pagination.NextPage; next != nil {
+			page = *next
+		}
+	}
+	return out, nil
+}
+
+// owner finds the API token of the project that holds the zone.
+func (h *HetznerProvider) owner(domain string) (string, error) {
+	if token, ok := h.owners[domain]; ok {
+		return token, nil
+	}
+
+	// Iterate in a stable order so errors are reproducible.
+	aliases := make([]string, 0, len(h.tokens))
+	for alias := range h.tokens {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		token := h.tokens[alias]
+		var resp struct {
+			Zones []struct {
+				Name string `json:"name"`
+			} `json:"zones"`
+		}
+		if err := h.do(token, http.MethodGet, "/zones?name="+url.QueryEscape(domain), nil, &resp); err != nil {
+			return "", fmt.Errorf("looking up zone %s in Hetzner project %q: %w", domain, alias, err)
+		}
+		for _, z := range resp.Zones {
+			if z.Name == domain {
+				h.owners[domain] = token
+				return token, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("zone %s not found in any Hetzner project", domain)
+}
+
+func (h *HetznerProvider) rrsetAction(domain, name, recordType, action string, body any) error {
+	token, err := h.owner(domain)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/zones/%s/rrsets/%s/%s/actions/%s",
+		url.PathEscape(domain), url.PathEscape(name), url.PathEscape(recordType), action)
+	return h.do(token, http.MethodPost, path, body, nil)
+}
+
+func (h *HetznerProvider) do(token, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, h.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("hetzner API %s %s: %s (%s)", method, path, apiErr.Error.Message, apiErr.Error.Code)
+		}
+		return fmt.Errorf("hetzner API %s %s: HTTP %d", method, path, resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("parsing hetzner API response: %w", err)
+		}
+	}
+	return nil
+}
+
+// hetznerID identifies a single record within an RRSet. Values go last since
+// they may themselves contain slashes.
+func hetznerID(name, recordType, value string) string {
+	return name + "/" + recordType + "/" + value
+}
+
+// hetznerName converts a subdomain or FQDN into the zone-relative name the
+// API expects, with "@" for the apex.
+func hetznerName(name, domain string) string {
+	switch {
+	case name == "" || name == domain:
+		return "@"
+	case isSubdomainOf(name, domain):
+		return strings.TrimSuffix(name, "."+domain)
+	default:
+		return name
+	}
+}
+
+func hetznerFQDN(name, domain string) string {
+	if name == "@" || name == "" {
+		return domain
+	}
+	return name + "." + domain
+}
@@ -0,0 +1,253 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHetznerProvider(t *testing.T) {
+	type call struct {
+		Method, Path, Token string
+		Body                map[string]any
+	}
+	var calls []call
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := call{Method: r.Method, Path: r.URL.Path, Token: r.Header.Get("Authorization")}
+		if r.Body != nil && r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&c.Body)
+		}
+		calls = append(calls, c)
+
+		switch {
+		case r.URL.Path == "/zones" && c.Token == "Bearer tok-b" && r.URL.Query().Get("name") == "example.com":
+			w.Write([]byte(`{"zones":[{"id":42,"name":"example.com"}]}`))
+		case r.URL.Path == "/zones":
+			w.Write([]byte(`{"zones":[]}`))
+		case r.URL.Path == "/zones/example.com/rrsets" && r.URL.Query().Get("page") == "1":
+			w.Write([]byte(`{"rrsets":[
+				{"name":"@","type":"A","ttl":3600,"records":[{"value":"1.2.3.4"}]},
+				{"name":"www","type":"CNAME","ttl":null,"records":[{"value":"example.com."}]}
+			],"meta":{"pagination":{"next_page":2}}}`))
+		case r.URL.Path == "/zones/example.com/rrsets" && r.URL.Query().Get("page") == "2":
+			w.Write([]byte(`{"rrsets":[
+				{"name":"@","type":"TXT","ttl":300,"records":[{"value":"\"v=spf1 -all\""}]}
+			],"meta":{"pagination":{"next_page":null}}}`))
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"action":{"id":1,"status":"running"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"not_found","message":"not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	h := newHetznerProvider(srv.URL, map[string]string{"a": "tok-a", "b": "tok-b"})
+
+	records, err := h.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DNSRecord{
+		{ID: "@/A/1.2.3.4", Name: "example.com", Type: "A", Content: "1.2.3.4", TTL: "3600"},
+		{ID: "www/CNAME/example.com.", Name: "www.example.com", Type: "CNAME", Content: "example.com"},
+		{ID: `@/TXT/"v=spf1 -all"`, Name: "example.com", Type: "TXT", Content: "v=spf1 -all", TTL: "300"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("ListRecords =\n%+v\nwant\n%+v", records, want)
+	}
+
+	calls = nil
+	id, err := h.CreateRecord("example.com", DNSRecord{Name: "app.example.com", Type: "CNAME", Content: "example.com", TTL: "600"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "app/CNAME/example.com." {
+		t.Errorf("CreateRecord ID = %q", id)
+	}
+	// The owning project is cached, so only the action is sent.
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1: %+v", len(calls), calls)
+	}
+	got := calls[0]
+	if got.Path != "/zones/example.com/rrsets/app/CNAME/actions/add_records" || got.Token != "Bearer tok-b" {
+		t.Errorf("CreateRecord sent %s %s as %s", got.Method, got.Path, got.Token)
+	}
+	if got.Body["ttl"] != float64(600) {
+		t.Errorf("ttl = %v, want 600", got.Body["ttl"])
+	}
+
+	calls = nil
+	if err := h.DeleteRecord("example.com", id); err != nil {
+		t.Fatal(err)
+	}
+	if calls[0].Path != "/zones/example.com/rrsets/app/CNAME/actions/remove_records" {
+		t.Errorf("DeleteRecord sent %s", calls[0].Path)
+	}
+	recs := calls[0].Body["records"].([]any)
+	if recs[0].(map[string]any)["value"] != "example.com." {
+		t.Errorf("DeleteRecord body = %v", calls[0].Body)
+	}
+
+	if _, err := h.ListRecords("other.org"); err == nil {
+		t.Error("expected error for a zone in no project")
+	}
+}
+
+func TestHetznerUpsertRecord(t *testing.T) {
+	var actions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/zones":
+			w.Write([]byte(`{"zones":[{"name":"example.com"}]}`))
+		case r.URL.Path == "/zones/example.com/rrsets":
+			w.Write([]byte(`{"rrsets":[{"name":"app","type":"A","ttl":600,"records":[{"value":"1.1.1.1"},{"value":"2.2.2.2"}]}]}`))
+		default:
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			data, _ := json.Marshal(body)
+			actions = append(actions, r.URL.Path+" "+string(data))
+			w.Write([]byte(`{"action":{"id":1}}`))
+		}
+	}))
+	defer srv.Close()
+
+	h := newHetznerProvider(srv.URL, map[string]string{"a": "tok"})
+
+	id, err := h.UpsertRecord("example.com", DNSRecord{Name: "app", Type: "A", Content: "3.3.3.3", TTL: "300"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "app/A/3.3.3.3" {
+		t.Errorf("ID = %q", id)
+	}
+	want := []string{
+		`/zones/example.com/rrsets/app/A/actions/set_records {"records":[{"value":"3.3.3.3"}]}`,
+		`/zones/example.com/rrsets/app/A/actions/change_ttl {"ttl":300}`,
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions =\n%v\nwant\n%v", actions, want)
+	}
+
+	actions = nil
+	if _, err := h.UpsertRecord("example.com", DNSRecord{Name: "www.app", Type: "CNAME", Content: "app.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{`/zones/example.com/rrsets/www.app/CNAME/actions/add_records {"records":[{"value":"app.example.com."}]}`}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions =\n%v\nwant\n%v", actions, want)
+	}
+
+	// UpdateRecord adds the new value before removing the old one.
+	actions = nil
+	if err := h.UpdateRecord("example.com", "app/A/1.1.1.1", DNSRecord{Name: "app", Type: "A", Content: "4.4.4.4"}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		`/zones/example.com/rrsets/app/A/actions/add_records {"records":[{"value":"4.4.4.4"}]}`,
+		`/zones/example.com/rrsets/app/A/actions/remove_records {"records":[{"value":"1.1.1.1"}]}`,
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions =\n%v\nwant\n%v", actions, want)
+	}
+}
+
+// fakeHetznerZone serves one zone whose RRSets change with the actions sent
+// to it. Like the real API, add_records skips values the RRSet already has
+// and ignores the TTL of an existing RRSet.
+func fakeHetznerZone(t *testing.T, rrsets []hetznerRRSet) *httptest.Server {
+	t.Helper()
+	find := func(name, typ string) int {
+		for i, rr := range rrsets {
+			if rr.Name == name && rr.Type == typ {
+				return i
+			}
+		}
+		return -1
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/zones" {
+			w.Write([]byte(`{"zones":[{"name":"example.com"}]}`))
+			return
+		}
+		if r.URL.Path == "/zones/example.com/rrsets" {
+			json.NewEncoder(w).Encode(map[string]any{"rrsets": rrsets})
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/zones/example.com/rrsets/"), "/")
+		i := find(parts[0], parts[1])
+		if r.Method == http.MethodGet {
+			if i < 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"code":"not_found","message":"rrset not found"}}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"rrset": rrsets[i]})
+			return
+		}
+		var body hetznerRRSet
+		json.NewDecoder(r.Body).Decode(&body)
+		switch parts[3] {
+		case "add_records":
+			if i < 0 {
+				rrsets = append(rrsets, hetznerRRSet{Name: parts[0], Type: parts[1], TTL: body.TTL})
+				i = len(rrsets) - 1
+			}
+			if !slices.Contains(rrsets[i].Records, body.Records[0]) {
+				rrsets[i].Records = append(rrsets[i].Records, body.Records[0])
+			}
+		case "remove_records":
+			kept := rrsets[i].Records[:0]
+			for _, rec := range rrsets[i].Records {
+				if rec != body.Records[0] {
+					kept = append(kept, rec)
+				}
+			}
+			rrsets[i].Records = kept
+		case "change_ttl":
+			rrsets[i].TTL = body.TTL
+		}
+		w.Write([]byte(`{"action":{"id":1}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHetznerApplyZoneDiffChangesTTL(t *testing.T) {
+	ttl := 3600
+	srv := fakeHetznerZone(t, []hetznerRRSet{{
+		Name: "app", Type: "A", TTL: &ttl,
+		Records: []struct {
+			Value string `json:"value"`
+		}{{Value: "1.1.1.1"}},
+	}})
+	h := newHetznerProvider(srv.URL, map[string]string{"a": "tok"})
+
+	live, err := h.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DNSRecord{{Name: "app.example.com", Type: "A", Content: "1.1.1.1", TTL: "300"}}
+	diff := DiffZone("example.com", live, want, false)
+	if len(diff.Update) != 1 {
+		t.Fatalf("diff = %+v, want one update", diff)
+	}
+	if err := ApplyZoneDiff(h, "example.com", diff); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := h.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLive := []DNSRecord{{ID: "app/A/1.1.1.1", Name: "app.example.com", Type: "A", Content: "1.1.1.1", TTL: "300"}}
+	if !reflect.DeepEqual(got, wantLive) {
+		t.Errorf("records after apply =\n%+v\nwant\n%+v", got, wantLive)
+	}
+}
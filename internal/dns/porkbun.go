@@ -1,15 +1,30 @@
 package dns
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/shadowfax/pkg/porkbun"
 )
 
+// porkbunAPIBase is used for creating and editing records, since the
+// shadowfax client has no way to set a priority.
+const porkbunAPIBase = "https://api.porkbun.com/api/json/v3"
+
 // PorkbunProvider adapts the shadowfax Porkbun client to the Provider interface.
 type PorkbunProvider struct {
-	client *porkbun.Client
+	client    *porkbun.Client
+	apiKey    string
+	secretKey string
+	baseURL   string
+	http      *http.Client
 }
 
 func NewPorkbunProvider(store config.Store) (*PorkbunProvider, error) {
@@ -21,13 +36,64 @@ func NewPorkbunProvider(store config.Store) (*PorkbunProvider, error) {
 	if err != nil {
 		return nil, fmt.Errorf("porkbun secret_key: %w", err)
 	}
-	return &PorkbunProvider{client: porkbun.NewClient(apiKey, secretKey)}, nil
+	return &PorkbunProvider{
+		client:    porkbun.NewClient(apiKey, secretKey),
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		baseURL:   porkbunAPIBase,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
 }
 
 func (p *PorkbunProvider) Name() string { return "porkbun" }
 
-func (p *PorkbunProvider) CreateRecord(domain, name, recordType, content, ttl string) (string, error) {
-	return p.client.CreateRecord(domain, name, recordType, content, ttl)
+func (p *PorkbunProvider) CreateRecord(domain string, rec DNSRecord) (string, error) {
+	var resp struct {
+		ID json.Number `json:"id"`
+	}
+	if err := p.post("/dns/create/"+domain, porkbunFields(domain, rec), &resp); err != nil {
+		return "", err
+	}
+	return resp.ID.String(), nil
+}
+
+func (p *PorkbunProvider) UpdateRecord(domain, id string, rec DNSRecord) error {
+	return p.post(fmt.Sprintf("/dns/edit/%s/%s", domain, id), porkbunFields(domain, rec), nil)
+}
+
+func (p *PorkbunProvider) UpsertRecord(domain string, rec DNSRecord) (string, error) {
+	sub := porkbunSubdomain(rec.Name, domain)
+	suffix := domain + "/" + rec.Type
+	if sub != "" {
+		suffix += "/" + sub
+	}
+
+	var existing struct {
+		Records []struct {
+			ID json.Number `json:"id"`
+		} `json:"records"`
+	}
+	if err := p.post("/dns/retrieveByNameType/"+suffix, nil, &existing); err != nil {
+		return "", fmt.Errorf("looking up %s records: %w", rec.Type, err)
+	}
+	if len(existing.Records) == 0 {
+		return p.CreateRecord(domain, rec)
+	}
+
+	fields := porkbunFields(domain, rec)
+	delete(fields, "name")
+	delete(fields, "type")
+	if err := p.post("/dns/editByNameType/"+suffix, fields, nil); err != nil {
+		return "", err
+	}
+	// editByNameType gives every record the same content, so drop the
+	// duplicates to leave exactly one.
+	for _, r := range existing.Records[1:] {
+		if err := p.DeleteRecord(domain, r.ID.String()); err != nil {
+			return "", fmt.Errorf("removing duplicate %s record %s: %w", rec.Type, r.ID, err)
+		}
+	}
+	return existing.Records[0].ID.String(), nil
 }
 
 func (p *PorkbunProvider) DeleteRecord(domain, id string) error {
@@ -49,6 +115,84 @@ func (p *PorkbunProvider) ListRecords(domain string) ([]DNSRecord, error) {
 			Content: r.Content,
 			TTL:     r.TTL,
 		}
+		if r.Type == "MX" || r.Type == "SRV" {
+			out[i].Priority, _ = strconv.Atoi(r.Prio)
+		}
+		// Porkbun keeps SRV weight and port in the content.
+		if r.Type == "SRV" {
+			var target string
+			if n, _ := fmt.Sscanf(r.Content, "%d %d %s", &out[i].Weight, &out[i].Port, &target); n == 3 {
+				out[i].Content = target
+			}
+		}
 	}
 	return out, nil
 }
+
+// porkbunFields builds the request fields for rec. Empty fields are left
+// out by post, so Porkbun applies its defaults.
+func porkbunFields(domain string, rec DNSRecord) map[string]string {
+	fields := map[string]string{
+		"name":    porkbunSubdomain(rec.Name, domain),
+		"type":    rec.Type,
+		"content": rec.Content,
+		"ttl":     rec.TTL,
+	}
+	switch rec.Type {
+	case "MX":
+		fields["prio"] = strconv.Itoa(rec.Priority)
+	case "SRV":
+		fields["prio"] = strconv.Itoa(rec.Priority)
+		fields["content"] = fmt.Sprintf("%d %d %s", rec.Weight, rec.Port, rec.Content)
+	}
+	return fields
+}
+
+// post calls a Porkbun endpoint. Every call is a POST with the keys in the
+// JSON body; failures come back as status "ERROR" with a message.
+func (p *PorkbunProvider) post(path string, fields map[string]string, out any) error {
+	body := map[string]string{"apikey": p.apiKey, "secretapikey": p.secretKey}
+	for k, v := range fields {
+		if v != "" {
+			body[k] = v
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.http.Post(p.baseURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return fmt.Errorf("parsing porkbun response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if status.Status != "SUCCESS" {
+		return fmt.Errorf("porkbun %s: %s", path, status.Message)
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// porkbunSubdomain converts a name to the subdomain Porkbun expects, with ""
+// for the apex.
+func porkbunSubdomain(name, domain string) string {
+	if name == domain {
+		return ""
+	}
+	return strings.TrimSuffix(name, "."+domain)
+}
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPorkbunUpsertRecord(t *testing.T) {
+	var paths []string
+	var bodies []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["apikey"] != "pk" || body["secretapikey"] != "sk" {
+			w.Write([]byte(`{"status":"ERROR","message":"Invalid API key."}`))
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, body)
+
+		switch r.URL.Path {
+		case "/dns/retrieveByNameType/example.com/A/app":
+			w.Write([]byte(`{"status":"SUCCESS","records":[{"id":"101","name":"app.example.com","type":"A","content":"1.1.1.1"}]}`))
+		default:
+			w.Write([]byte(`{"status":"SUCCESS"}`))
+		}
+	}))
+	defer srv.Close()
+
+	p := &PorkbunProvider{apiKey: "pk", secretKey: "sk", baseURL: srv.URL, http: srv.Client()}
+
+	id, err := p.UpsertRecord("example.com", DNSRecord{Name: "app.example.com", Type: "A", Content: "2.2.2.2", TTL: "600"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "101" {
+		t.Errorf("ID = %q, want 101", id)
+	}
+	if len(paths) != 2 || paths[1] != "/dns/editByNameType/example.com/A/app" {
+		t.Fatalf("calls = %v", paths)
+	}
+	if bodies[1]["content"] != "2.2.2.2" || bodies[1]["ttl"] != "600" {
+		t.Errorf("edit body = %v", bodies[1])
+	}
+
+	paths, bodies = nil, nil
+	if err := p.UpdateRecord("example.com", "101", DNSRecord{Type: "A", Content: "3.3.3.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if paths[0] != "/dns/edit/example.com/101" {
+		t.Errorf("UpdateRecord path = %s", paths[0])
+	}
+	// Empty fields are left out so Porkbun keeps its defaults.
+	want := map[string]string{"apikey": "pk", "secretapikey": "sk", "type": "A", "content": "3.3.3.3"}
+	if !reflect.DeepEqual(bodies[0], want) {
+		t.Errorf("UpdateRecord body = %v, want %v", bodies[0], want)
+	}
+
+	p.secretKey = "wrong"
+	if err := p.UpdateRecord("example.com", "101", DNSRecord{Type: "A", Content: "3.3.3.3"}); err == nil || err.Error() != "porkbun /dns/edit/example.com/101: Invalid API key." {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PresetOptions carries the per-domain values a preset can't know.
+type PresetOptions struct {
+	DKIM        string // DKIM TXT value, for providers that generate a key per domain
+	DMARCPolicy string // none, quarantine or reject; defaults to none
+	DMARCReport string // address for aggregate reports (rua), optional
+}
+
+// Preset is a named set of records for a hosted service such as a mail
+// provider.
+type Preset struct {
+	Name        string
+	Description string
+	// NeedsDKIM is set when the provider generates the DKIM key itself, so
+	// the value has to be passed in PresetOptions.DKIM. Without it the DKIM
+	// record is left out.
+	NeedsDKIM bool
+	records   func(domain string, opts PresetOptions) []DNSRecord
+}
+
+// Presets lists the available presets by name.
+var Presets = []Preset{
+	{
+		Name:        "google-workspace",
+		Description: "Google Workspace (Gmail) MX, SPF, DKIM and DMARC",
+		NeedsDKIM:   true,
+		records: func(domain string, opts PresetOptions) []DNSRecord {
+			records := []DNSRecord{
+				{Type: "MX", Content: "smtp.google.com", Priority: 1},
+				{Type: "TXT", Content: "v=spf1 include:_spf.google.com ~all"},
+			}
+			if opts.DKIM != "" {
+				records = append(records, DNSRecord{Name: "google._domainkey", Type: "TXT", Content: opts.DKIM})
+			}
+			return records
+		},
+	},
+	{
+		Name:        "fastmail",
+		Description: "Fastmail MX, SPF, DKIM and DMARC",
+		records: func(domain string, opts PresetOptions) []DNSRecord {
+			records := []DNSRecord{
+				{Type: "MX", Content: "in1-smtp.messagingengine.com", Priority: 10},
+				{Type: "MX", Content: "in2-smtp.messagingengine.com", Priority: 20},
+				{Type: "TXT", Content: "v=spf1 include:spf.messagingengine.com ?all"},
+			}
+			for _, selector := range []string{"fm1", "fm2", "fm3"} {
+				records = append(records, DNSRecord{
+					Name:    selector + "._domainkey",
+					Type:    "CNAME",
+					Content: fmt.Sprintf("%s.%s.dkim.fmhosted.com", selector, domain),
+				})
+			}
+			return records
+		},
+	},
+}
+
+// FindPreset returns the preset with the given name.
+func FindPreset(name string) (Preset, error) {
+	var names []string
+	for _, p := range Presets {
+		if p.Name == name {
+			return p, nil
+		}
+		names = append(names, p.Name)
+	}
+	return Preset{}, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// Records returns the full record set for domain, including DMARC.
+func (p Preset) Records(domain string, opts PresetOptions) ([]DNSRecord, error) {
+	policy := opts.DMARCPolicy
+	if policy == "" {
+		policy = "none"
+	}
+	switch policy {
+	case "none", "quarantine", "reject":
+	default:
+		return nil, fmt.Errorf("invalid DMARC policy %q (want none, quarantine or reject)", policy)
+	}
+	dmarc := "v=DMARC1; p=" + policy
+	if opts.DMARCReport != "" {
+		dmarc += "; rua=mailto:" + opts.DMARCReport
+	}
+
+	records := p.records(domain, opts)
+	records = append(records, DNSRecord{Name: "_dmarc", Type: "TXT", Content: dmarc})
+	for i := range records {
+		if records[i].TTL == "" {
+			records[i].TTL = "3600"
+		}
+	}
+	return records, nil
+}
+
+// RecordChange is one step taken by SyncRecords.
+type RecordChange struct {
+	Action string // "create", "delete" or "keep"
+	Record DNSRecord
+}
+
+// SyncRecords makes the zone contain the wanted records. Existing records
+// that fill the same role are replaced: all MX records at a name, CNAMEs at
+// a name, and TXT records at a name that start with the same "v=" tag (so
+// an SPF update leaves verification TXT records alone). Records that already
+// match are kept. New MX and TXT records are created before old ones are
+// deleted so that mail keeps flowing throughout; a name holds only one
+// CNAME, so an old CNAME is deleted before its replacement is created.
+func SyncRecords(p Provider, domain string, want []DNSRecord) ([]RecordChange, error) {
+	existing, err := p.ListRecords(domain)
+	if err != nil {
+		return nil, fmt.Errorf("listing records for %s: %w", domain, err)
+	}
+
+	roles := make(map[string]bool)
+	for _, w := range want {
+		roles[recordRole(w, domain)] = true
+	}
+
+	// kept[j] is the index of the existing record that already matches
+	// want[j], or -1 if it has to be created.
+	kept := make([]int, len(want))
+	matched := make(map[int]bool)
+	for j, w := range want {
+		kept[j] = -1
+		for i, e := range existing {
+			if !matched[i] && recordRole(e, domain) == recordRole(w, domain) && sameData(e, w) {
+				matched[i] = true
+				kept[j] = i
+				break
+			}
+		}
+	}
+
+	var changes, deletes []RecordChange
+	deleteStale := func(types func(string) bool) error {
+		for i, e := range existing {
+			if matched[i] || !roles[recordRole(e, domain)] || !types(e.Type) {
+				continue
+			}
+			if err := p.DeleteRecord(domain, e.ID); err != nil {
+				return fmt.Errorf("deleting %s record %s: %w", e.Type, e.Name, err)
+			}
+			matched[i] = true
+			deletes = append(deletes, RecordChange{Action: "delete", Record: e})
+		}
+		return nil
+	}
+	isCNAME := func(recordType string) bool { return recordType == "CNAME" }
+
+	if err := deleteStale(isCNAME); err != nil {
+		return deletes, err
+	}
+	for j, w := range want {
+		if kept[j] >= 0 {
+			changes = append(changes, RecordChange{Action: "keep", Record: existing[kept[j]]})
+			continue
+		}
+		if _, err := p.CreateRecord(domain, w); err != nil {
+			return append(changes, deletes...), fmt.Errorf("creating %s record %s: %w", w.Type, fqdnFor(w.Name, domain), err)
+		}
+		w.Name = fqdnFor(w.Name, domain)
+		changes = append(changes, RecordChange{Action: "create", Record: w})
+	}
+	if err := deleteStale(func(recordType string) bool { return !isCNAME(recordType) }); err != nil {
+		return append(changes, deletes...), err
+	}
+	return append(changes, deletes...), nil
+}
+
+// recordRole identifies what a record is for, so that SyncRecords knows
+// which existing records a wanted one replaces.
+func recordRole(r DNSRecord, domain string) string {
+	role := strings.ToLower(fqdnFor(r.Name, domain)) + " " + r.Type
+	if r.Type == "TXT" {
+		tag, _, _ := strings.Cut(unquoteTXT(r.Content), ";")
+		tag, _, _ = strings.Cut(tag, " ")
+		if strings.HasPrefix(strings.ToLower(tag), "v=") {
+			role += " " + strings.ToLower(tag)
+		} else {
+			role += " " + r.Content
+		}
+	}
+	return role
+}
+
+func sameData(a, b DNSRecord) bool {
+	norm := func(r DNSRecord) string {
+		if r.Type == "TXT" {
+			return unquoteTXT(r.Content)
+		}
+		r.Content = strings.ToLower(strings.TrimSuffix(r.Content, "."))
+		return r.Value()
+	}
+	return norm(a) == norm(b)
+}
+
+// unquoteTXT joins quoted character-strings back into one value, as some
+// providers return TXT content in zone-file form.
+func unquoteTXT(s string) string {
+	if !strings.HasPrefix(s, `"`) {
+		return s
+	}
+	var b strings.Builder
+	inQuote, escaped := false, false
+	for _, c := range s {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case c == '\\' && inQuote:
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
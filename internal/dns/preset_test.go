@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// memProvider is an in-memory Provider. Like Cloudflare and Porkbun, it
+// refuses a CNAME next to any other record at the same name.
+type memProvider struct {
+	records []DNSRecord
+	calls   []string
+	nextID  int
+}
+
+func (m *memProvider) Name() string { return "mem" }
+
+func (m *memProvider) CreateRecord(domain string, rec DNSRecord) (string, error) {
+	rec.Name = fqdnFor(rec.Name, domain)
+	for _, r := range m.records {
+		if strings.EqualFold(r.Name, rec.Name) && (r.Type == "CNAME" || rec.Type == "CNAME") {
+			return "", fmt.Errorf("a %s record already exists at %s", r.Type, r.Name)
+		}
+	}
+	m.nextID++
+	rec.ID = string(rune('a' + m.nextID))
+	m.records = append(m.records, rec)
+	m.calls = append(m.calls, "create "+rec.Name+" "+rec.Type+" "+rec.Value())
+	return rec.ID, nil
+}
+
+func (m *memProvider) UpdateRecord(domain, id string, rec DNSRecord) error {
+	for i, r := range m.records {
+		if r.ID == id {
+			rec.ID = id
+			rec.Name = fqdnFor(rec.Name, domain)
+			m.records[i] = rec
+			m.calls = append(m.calls, "update "+rec.Name+" "+rec.Type+" "+rec.Value())
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memProvider) UpsertRecord(domain string, rec DNSRecord) (string, error) { return "", nil }
+
+func (m *memProvider) DeleteRecord(domain, id string) error {
+	for i, r := range m.records {
+		if r.ID == id {
+			m.records = append(m.records[:i], m.records[i+1:]...)
+			m.calls = append(m.calls, "delete "+r.Name+" "+r.Type+" "+r.Value())
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memProvider) ListRecords(domain string) ([]DNSRecord, error) {
+	return append([]DNSRecord(nil), m.records...), nil
+}
+
+func TestPresetRecords(t *testing.T) {
+	p, err := FindPreset("google-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := p.Records("example.com", PresetOptions{DMARCPolicy: "quarantine", DMARCReport: "dmarc@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Name+" "+r.Type+" "+r.Value()+" "+r.TTL)
+	}
+	want := []string{
+		" MX 1 smtp.google.com 3600",
+		" TXT v=spf1 include:_spf.google.com ~all 3600",
+		"_dmarc TXT v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com 3600",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("records:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := p.Records("example.com", PresetOptions{DMARCPolicy: "block"}); err == nil {
+		t.Error("expected an error for an invalid DMARC policy")
+	}
+	if _, err := FindPreset("protonmail"); err == nil || !strings.Contains(err.Error(), "google-workspace, fastmail") {
+		t.Errorf("FindPreset error = %v", err)
+	}
+}
+
+func TestSyncRecords(t *testing.T) {
+	m := &memProvider{records: []DNSRecord{
+		{ID: "1", Name: "example.com", Type: "MX", Content: "mx.oldhost.net", Priority: 10},
+		{ID: "2", Name: "example.com", Type: "TXT", Content: "v=spf1 include:oldhost.net -all"},
+		{ID: "3", Name: "example.com", Type: "TXT", Content: "google-site-verification=abc"},
+		{ID: "4", Name: "_dmarc.example.com", Type: "TXT", Content: `"v=DMARC1; p=none"`},
+		{ID: "5", Name: "example.com", Type: "A", Content: "1.2.3.4"},
+	}}
+	p, _ := FindPreset("google-workspace")
+	want, _ := p.Records("example.com", PresetOptions{})
+
+	changes, err := SyncRecords(m, "example.com", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCalls := []string{
+		"create example.com MX 1 smtp.google.com",
+		"create example.com TXT v=spf1 include:_spf.google.com ~all",
+		"delete example.com MX 10 mx.oldhost.net",
+		"delete example.com TXT v=spf1 include:oldhost.net -all",
+	}
+	if strings.Join(m.calls, "\n") != strings.Join(wantCalls, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(m.calls, "\n"), strings.Join(wantCalls, "\n"))
+	}
+	if len(changes) != 5 || changes[2].Action != "keep" || changes[2].Record.ID != "4" {
+		t.Errorf("changes = %+v", changes)
+	}
+
+	// A second run finds everything in place.
+	m.calls = nil
+	if _, err := SyncRecords(m, "example.com", want); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.calls) != 0 {
+		t.Errorf("second sync made calls: %v", m.calls)
+	}
+}
+
+func TestSyncRecordsReplacesCNAME(t *testing.T) {
+	p, _ := FindPreset("fastmail")
+	want, _ := p.Records("example.com", PresetOptions{})
+	m := &memProvider{}
+	if _, err := SyncRecords(m, "example.com", want); err != nil {
+		t.Fatal(err)
+	}
+
+	// The DKIM key for fm1 moves to a new target.
+	for i, w := range want {
+		if w.Name == "fm1._domainkey" {
+			want[i].Content = "fm1.example.com.dkim2.fmhosted.com"
+		}
+	}
+	m.calls = nil
+	changes, err := SyncRecords(m, "example.com", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCalls := []string{
+		"delete fm1._domainkey.example.com CNAME fm1.example.com.dkim.fmhosted.com",
+		"create fm1._domainkey.example.com CNAME fm1.example.com.dkim2.fmhosted.com",
+	}
+	if strings.Join(m.calls, "\n") != strings.Join(wantCalls, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(m.calls, "\n"), strings.Join(wantCalls, "\n"))
+	}
+	if len(changes) != 8 || changes[3].Action != "create" || changes[7].Action != "delete" {
+		t.Errorf("changes = %+v", changes)
+	}
+}
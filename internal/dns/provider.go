@@ -6,23 +6,55 @@ import (
 	"github.com/dukerupert/arnor/internal/config"
 )
 
-// DNSRecord is the unified record type used across providers.
+// DNSRecord is the unified record type used across providers. Content holds
+// the target host for MX and SRV records, whose other fields are carried
+// separately; CAA content is the full "flags tag value" string.
 type DNSRecord struct {
-	ID      string
-	Name    string
-	Type    string
-	Content string
-	TTL     string
+	ID       string
+	Name     string
+	Type     string
+	Content  string
+	TTL      string
+	Priority int  // MX and SRV
+	Weight   int  // SRV
+	Port     int  // SRV
+	Proxied  bool // Cloudflare only
 }
 
-// Provider is the common interface for DNS operations.
+// Value renders the record data the way it appears in a zone file, minus
+// trailing dots, e.g. "10 mail.example.com" for an MX record.
+func (r DNSRecord) Value() string {
+	switch r.Type {
+	case "MX":
+		return fmt.Sprintf("%d %s", r.Priority, r.Content)
+	case "SRV":
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Content)
+	}
+	return r.Content
+}
+
+// Provider is the common interface for DNS operations. Record names are
+// relative to domain ("" for the apex), though a full name is also accepted.
+// Record IDs are ignored on writes.
 type Provider interface {
-	CreateRecord(domain, name, recordType, content, ttl string) (string, error)
+	CreateRecord(domain string, rec DNSRecord) (string, error)
+	// UpdateRecord changes the record with the given ID in place.
+	UpdateRecord(domain, id string, rec DNSRecord) error
+	// UpsertRecord makes rec the only record of its name and type, editing
+	// any existing records or creating one if there are none. It returns the
+	// ID of the resulting record.
+	UpsertRecord(domain string, rec DNSRecord) (string, error)
 	DeleteRecord(domain, id string) error
 	ListRecords(domain string) ([]DNSRecord, error)
 	Name() string
 }
 
+// SupportsProxy reports whether p can proxy records, which only Cloudflare
+// does. Other providers ignore DNSRecord.Proxied.
+func SupportsProxy(p Provider) bool {
+	return p.Name() == "cloudflare"
+}
+
 // NewProvider creates a DNS provider by name. Credentials are resolved from the Store.
 func NewProvider(providerName string, store config.Store) (Provider, error) {
 	switch providerName {
@@ -30,13 +62,18 @@ func NewProvider(providerName string, store config.Store) (Provider, error) {
 		return NewPorkbunProvider(store)
 	case "cloudflare":
 		return NewCloudflareProvider(store)
+	case "hetzner":
+		return NewHetznerProvider(store)
+	case "rfc2136":
+		return NewRFC2136Provider(store)
 	default:
 		return nil, fmt.Errorf("unknown DNS provider: %s", providerName)
 	}
 }
 
 // ProviderForDomain determines the DNS provider for a domain. It checks the
-// config first, then falls back to NS-based detection.
+// config first, then the zones configured for RFC 2136 (whose nameservers
+// could be anything), then falls back to NS-based detection.
 func ProviderForDomain(domain string, cfg *config.Config, store config.Store) (Provider, error) {
 	// Check config for known projects with this domain
 	if cfg != nil {
@@ -49,6 +86,14 @@ func ProviderForDomain(domain string, cfg *config.Config, store config.Store) (P
 		}
 	}
 
+	if zones, err := store.GetCredential("rfc2136", "default", "zones"); err == nil {
+		for _, zone := range SplitZones(zones) {
+			if domain == zone || isSubdomainOf(domain, zone) {
+				return NewRFC2136Provider(store)
+			}
+		}
+	}
+
 	// Fall back to nameserver detection
 	providerName, err := config.DetectDNSProvider(domain)
 	if err != nil {
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/dukerupert/arnor/internal/plan"
+)
+
+// Recorder wraps a Provider for dry runs. Reads go to the real provider so the
+// plan reflects the live zone; writes are recorded into the plan instead.
+type Recorder struct {
+	provider Provider
+	plan     *plan.Plan
+	seen     map[string]DNSRecord // id -> record, from ListRecords
+	next     int
+}
+
+// NewRecorder returns a Recorder that reads from provider and records into p.
+func NewRecorder(provider Provider, p *plan.Plan) *Recorder {
+	return &Recorder{provider: provider, plan: p, seen: make(map[string]DNSRecord)}
+}
+
+func (r *Recorder) Name() string { return r.provider.Name() }
+
+func (r *Recorder) ListRecords(domain string) ([]DNSRecord, error) {
+	records, err := r.provider.ListRecords(domain)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		r.seen[rec.ID] = rec
+	}
+	return records, nil
+}
+
+func (r *Recorder) CreateRecord(domain string, rec DNSRecord) (string, error) {
+	r.record("create", domain, rec)
+	r.next++
+	return fmt.Sprintf("dry-run-%d", r.next), nil
+}
+
+func (r *Recorder) UpdateRecord(domain, id string, rec DNSRecord) error {
+	r.record("update", domain, rec)
+	return nil
+}
+
+func (r *Recorder) UpsertRecord(domain string, rec DNSRecord) (string, error) {
+	r.record("upsert", domain, rec)
+	r.next++
+	return fmt.Sprintf("dry-run-%d", r.next), nil
+}
+
+func (r *Recorder) record(action, domain string, rec DNSRecord) {
+	r.plan.AddDNS(plan.DNSChange{
+		Action:  action,
+		Domain:  domain,
+		Name:    fqdnFor(rec.Name, domain),
+		Type:    rec.Type,
+		Content: rec.Value(),
+		TTL:     rec.TTL,
+	})
+}
+
+func (r *Recorder) DeleteRecord(domain, id string) error {
+	rec, ok := r.seen[id]
+	if !ok {
+		rec = DNSRecord{ID: id, Name: "record " + id}
+	}
+	r.plan.AddDNS(plan.DNSChange{
+		Action:  "delete",
+		Domain:  domain,
+		Name:    rec.Name,
+		Type:    rec.Type,
+		Content: rec.Value(),
+		TTL:     rec.TTL,
+	})
+	return nil
+}
@@ -0,0 +1,267 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	mdns "github.com/miekg/dns"
+)
+
+// rfc2136DefaultTTL is used when the caller doesn't specify one. Zone-file
+// servers have no "automatic" TTL like Cloudflare does.
+const rfc2136DefaultTTL = 600
+
+// RFC2136Provider manages zones on an authoritative server (BIND, Knot,
+// PowerDNS, ...) via TSIG-signed dynamic updates. Records are listed with a
+// signed zone transfer, so the key must also be allowed to AXFR.
+type RFC2136Provider struct {
+	server    string // host:port
+	keyName   string // canonical FQDN
+	keySecret string // base64, as in a BIND key file
+	algorithm string // canonical FQDN, e.g. hmac-sha256.
+	zones     []string
+	timeout   time.Duration
+}
+
+func NewRFC2136Provider(store config.Store) (*RFC2136Provider, error) {
+	get := func(key string) (string, error) {
+		v, err := store.GetCredential("rfc2136", "default", key)
+		if err != nil {
+			return "", fmt.Errorf("rfc2136 %s: %w", key, err)
+		}
+		return v, nil
+	}
+	server, err := get("server")
+	if err != nil {
+		return nil, err
+	}
+	keyName, err := get("key_name")
+	if err != nil {
+		return nil, err
+	}
+	keySecret, err := get("key_secret")
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := get("algorithm")
+	if err != nil {
+		return nil, err
+	}
+	zones, err := get("zones")
+	if err != nil {
+		return nil, err
+	}
+	return NewRFC2136(server, keyName, keySecret, algorithm, SplitZones(zones)), nil
+}
+
+// NewRFC2136 builds a provider from explicit settings. A server without a
+// port uses 53.
+func NewRFC2136(server, keyName, keySecret, algorithm string, zones []string) *RFC2136Provider {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	if algorithm == "" {
+		algorithm = mdns.HmacSHA256
+	}
+	return &RFC2136Provider{
+		server:    server,
+		keyName:   mdns.CanonicalName(keyName),
+		keySecret: keySecret,
+		algorithm: mdns.CanonicalName(algorithm),
+		zones:     zones,
+		timeout:   10 * time.Second,
+	}
+}
+
+// SplitZones parses the comma-separated zones credential.
+func SplitZones(s string) []string {
+	var zones []string
+	for _, z := range strings.Split(s, ",") {
+		z = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(z)), ".")
+		if z != "" {
+			zones = append(zones, z)
+		}
+	}
+	return zones
+}
+
+func (r *RFC2136Provider) Name() string { return "rfc2136" }
+
+// Serves reports whether domain is one of the configured zones or inside one.
+func (r *RFC2136Provider) Serves(domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for _, z := range r.zones {
+		if domain == z || isSubdomainOf(domain, z) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ping sends a signed SOA query for each configured zone, which checks both
+// that the server is reachable and that it accepts the key.
+func (r *RFC2136Provider) Ping() error {
+	if len(r.zones) == 0 {
+		return fmt.Errorf("no zones configured")
+	}
+	for _, zone := range r.zones {
+		m := new(mdns.Msg)
+		m.SetQuestion(mdns.Fqdn(zone), mdns.TypeSOA)
+		resp, err := r.exchange(m)
+		if err != nil {
+			return fmt.Errorf("querying SOA for %s: %w", zone, err)
+		}
+		if len(resp.Answer) == 0 {
+			return fmt.Errorf("%s is not authoritative for %s", r.server, zone)
+		}
+	}
+	return nil
+}
+
+func (r *RFC2136Provider) CreateRecord(domain string, rec DNSRecord) (string, error) {
+	rr, err := rfc2136RR(domain, rec)
+	if err != nil {
+		return "", err
+	}
+
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.Insert([]mdns.RR{rr})
+	if _, err := r.exchange(m); err != nil {
+		return "", fmt.Errorf("adding %s record for %s: %w", rec.Type, rr.Header().Name, err)
+	}
+	return rr.String(), nil
+}
+
+// UpdateRecord removes the old record and adds the new one in a single
+// update message, which the server applies atomically.
+func (r *RFC2136Provider) UpdateRecord(domain, id string, rec DNSRecord) error {
+	old, err := mdns.NewRR(id)
+	if err != nil {
+		return fmt.Errorf("invalid RFC 2136 record ID %q: %w", id, err)
+	}
+	rr, err := rfc2136RR(domain, rec)
+	if err != nil {
+		return err
+	}
+
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.Remove([]mdns.RR{old})
+	m.Insert([]mdns.RR{rr})
+	if _, err := r.exchange(m); err != nil {
+		return fmt.Errorf("updating %s record for %s: %w", rec.Type, rr.Header().Name, err)
+	}
+	return nil
+}
+
+// UpsertRecord replaces the whole RRset atomically.
+func (r *RFC2136Provider) UpsertRecord(domain string, rec DNSRecord) (string, error) {
+	rr, err := rfc2136RR(domain, rec)
+	if err != nil {
+		return "", err
+	}
+
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.RemoveRRset([]mdns.RR{rr})
+	m.Insert([]mdns.RR{rr})
+	if _, err := r.exchange(m); err != nil {
+		return "", fmt.Errorf("replacing %s records for %s: %w", rec.Type, rr.Header().Name, err)
+	}
+	return rr.String(), nil
+}
+
+// DeleteRecord removes exactly the record described by id, which is its
+// presentation form as returned by CreateRecord and ListRecords.
+func (r *RFC2136Provider) DeleteRecord(domain, id string) error {
+	rr, err := mdns.NewRR(id)
+	if err != nil {
+		return fmt.Errorf("invalid RFC 2136 record ID %q: %w", id, err)
+	}
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(domain))
+	m.Remove([]mdns.RR{rr})
+	if _, err := r.exchange(m); err != nil {
+		return fmt.Errorf("removing record from %s: %w", domain, err)
+	}
+	return nil
+}
+
+func (r *RFC2136Provider) ListRecords(domain string) ([]DNSRecord, error) {
+	m := new(mdns.Msg)
+	m.SetAxfr(mdns.Fqdn(domain))
+	m.SetTsig(r.keyName, r.algorithm, 300, time.Now().Unix())
+
+	t := &mdns.Transfer{
+		DialTimeout: r.timeout,
+		ReadTimeout: r.timeout,
+		TsigSecret:  map[string]string{r.keyName: r.keySecret},
+	}
+	envelopes, err := t.In(m, r.server)
+	if err != nil {
+		return nil, fmt.Errorf("zone transfer for %s: %w", domain, err)
+	}
+
+	var out []DNSRecord
+	for env := range envelopes {
+		if env.Error != nil {
+			return nil, fmt.Errorf("zone transfer for %s: %w", domain, env.Error)
+		}
+		for _, rr := range env.RR {
+			hdr := rr.Header()
+			// The SOA opens and closes every transfer and isn't something
+			// arnor manages.
+			if hdr.Rrtype == mdns.TypeSOA {
+				continue
+			}
+			rec := DNSRecord{
+				ID:   rr.String(),
+				Name: strings.TrimSuffix(hdr.Name, "."),
+				Type: mdns.TypeToString[hdr.Rrtype],
+				TTL:  strconv.FormatUint(uint64(hdr.Ttl), 10),
+			}
+			fillFromRR(&rec, rr)
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// exchange signs and sends m over TCP and fails on any non-success rcode.
+func (r *RFC2136Provider) exchange(m *mdns.Msg) (*mdns.Msg, error) {
+	m.SetTsig(r.keyName, r.algorithm, 300, time.Now().Unix())
+	c := &mdns.Client{
+		Net:        "tcp",
+		Timeout:    r.timeout,
+		TsigSecret: map[string]string{r.keyName: r.keySecret},
+	}
+	resp, _, err := c.Exchange(m, r.server)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != mdns.RcodeSuccess {
+		return nil, fmt.Errorf("server returned %s", mdns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// rfc2136RR builds the resource record for rec in domain.
+func rfc2136RR(domain string, rec DNSRecord) (mdns.RR, error) {
+	ttl := rfc2136DefaultTTL
+	if rec.TTL != "" {
+		if v, err := strconv.Atoi(rec.TTL); err == nil {
+			ttl = v
+		}
+	}
+
+	rr, err := mdns.NewRR(fmt.Sprintf("%s %d IN %s %s", mdns.Fqdn(fqdnFor(rec.Name, domain)), ttl, rec.Type, zoneData(rec)))
+	if err != nil {
+		return nil, fmt.Errorf("building %s record: %w", rec.Type, err)
+	}
+	return rr, nil
+}
@@ -0,0 +1,216 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+const (
+	testKeyName   = "arnor."
+	testKeySecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA=="
+)
+
+// stubZone is a tiny authoritative server for one zone that answers SOA
+// queries, AXFR and UPDATE, and rejects anything not signed with the test key.
+type stubZone struct {
+	mu      sync.Mutex
+	origin  string
+	soa     mdns.RR
+	records []mdns.RR
+}
+
+func (z *stubZone) ServeDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	m := new(mdns.Msg)
+	m.SetReply(req)
+
+	if req.IsTsig() == nil || w.TsigStatus() != nil {
+		m.Rcode = mdns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+	sign := func(m *mdns.Msg) {
+		m.SetTsig(testKeyName, mdns.HmacSHA256, 300, int64(req.IsTsig().TimeSigned))
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	switch {
+	case req.Opcode == mdns.OpcodeUpdate:
+		for _, rr := range req.Ns {
+			switch rr.Header().Class {
+			case mdns.ClassNONE:
+				z.remove(rr)
+			case mdns.ClassANY:
+				z.removeRRset(rr.Header().Name, rr.Header().Rrtype)
+			default:
+				z.records = append(z.records, rr)
+			}
+		}
+	case req.Question[0].Qtype == mdns.TypeAXFR:
+		ch := make(chan *mdns.Envelope)
+		tr := new(mdns.Transfer)
+		go func() {
+			ch <- &mdns.Envelope{RR: append(append([]mdns.RR{z.soa}, z.records...), z.soa)}
+			close(ch)
+		}()
+		tr.Out(w, req, ch)
+		return
+	case req.Question[0].Qtype == mdns.TypeSOA:
+		m.Answer = []mdns.RR{z.soa}
+	}
+	sign(m)
+	w.WriteMsg(m)
+}
+
+func (z *stubZone) remove(rr mdns.RR) {
+	rr.Header().Class = mdns.ClassINET
+	kept := z.records[:0]
+	for _, have := range z.records {
+		if !mdns.IsDuplicate(have, rr) {
+			kept = append(kept, have)
+		}
+	}
+	z.records = kept
+}
+
+func (z *stubZone) removeRRset(name string, rrtype uint16) {
+	kept := z.records[:0]
+	for _, have := range z.records {
+		if have.Header().Name != name || have.Header().Rrtype != rrtype {
+			kept = append(kept, have)
+		}
+	}
+	z.records = kept
+}
+
+func startStubZone(t *testing.T, origin string) (*stubZone, string) {
+	t.Helper()
+	soa, _ := mdns.NewRR(origin + ". 3600 IN SOA ns1." + origin + ". admin." + origin + ". 1 7200 3600 1209600 300")
+	zone := &stubZone{origin: origin, soa: soa}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &mdns.Server{
+		Listener:          ln,
+		Handler:           zone,
+		TsigSecret:        map[string]string{testKeyName: testKeySecret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default accept func answers UPDATE with NOTIMP.
+		MsgAcceptFunc: func(mdns.Header) mdns.MsgAcceptAction { return mdns.MsgAccept },
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return zone, ln.Addr().String()
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	_, addr := startStubZone(t, "example.com")
+	p := NewRFC2136(addr, "arnor", testKeySecret, "hmac-sha256", SplitZones(" Example.com., "))
+
+	if err := p.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if !p.Serves("app.example.com") || p.Serves("example.org") {
+		t.Error("Serves matched the wrong zones")
+	}
+
+	aID, err := p.CreateRecord("example.com", DNSRecord{Name: "app", Type: "A", Content: "1.2.3.4"})
+	if err != nil {
+		t.Fatalf("CreateRecord A: %v", err)
+	}
+	if _, err := p.CreateRecord("example.com", DNSRecord{Name: "www.app.example.com", Type: "CNAME", Content: "app.example.com", TTL: "300"}); err != nil {
+		t.Fatalf("CreateRecord CNAME: %v", err)
+	}
+	if _, err := p.CreateRecord("example.com", DNSRecord{Type: "TXT", Content: "v=spf1 -all"}); err != nil {
+		t.Fatalf("CreateRecord TXT: %v", err)
+	}
+
+	records, err := p.ListRecords("example.com")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Name+" "+r.Type+" "+r.Content+" "+r.TTL)
+	}
+	want := []string{
+		"app.example.com A 1.2.3.4 600",
+		"www.app.example.com CNAME app.example.com 300",
+		"example.com TXT v=spf1 -all 600",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ListRecords =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := p.DeleteRecord("example.com", aID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	records, err = p.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Type != "CNAME" {
+		t.Errorf("after delete: %+v", records)
+	}
+}
+
+func TestRFC2136ProviderUpdateAndUpsert(t *testing.T) {
+	zone, addr := startStubZone(t, "example.com")
+	p := NewRFC2136(addr, "arnor", testKeySecret, "hmac-sha256", []string{"example.com"})
+
+	id1, err := p.CreateRecord("example.com", DNSRecord{Name: "app", Type: "A", Content: "1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CreateRecord("example.com", DNSRecord{Name: "app", Type: "A", Content: "2.2.2.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UpdateRecord("example.com", id1, DNSRecord{Name: "app", Type: "A", Content: "3.3.3.3", TTL: "300"}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if got := zoneContents(zone); got != "app.example.com.\t600\tIN\tA\t2.2.2.2\napp.example.com.\t300\tIN\tA\t3.3.3.3" {
+		t.Errorf("after update:\n%s", got)
+	}
+
+	if _, err := p.UpsertRecord("example.com", DNSRecord{Name: "app", Type: "A", Content: "4.4.4.4"}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if _, err := p.UpsertRecord("example.com", DNSRecord{Name: "www.app", Type: "CNAME", Content: "app.example.com"}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if got := zoneContents(zone); got != "app.example.com.\t600\tIN\tA\t4.4.4.4\nwww.app.example.com.\t600\tIN\tCNAME\tapp.example.com." {
+		t.Errorf("after upsert:\n%s", got)
+	}
+}
+
+func zoneContents(z *stubZone) string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	lines := make([]string, len(z.records))
+	for i, rr := range z.records {
+		lines[i] = rr.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestRFC2136ProviderBadKey(t *testing.T) {
+	_, addr := startStubZone(t, "example.com")
+	p := NewRFC2136(addr, "arnor", "d3Jvbmcta2V5", "hmac-sha256", []string{"example.com"})
+
+	if err := p.Ping(); err == nil {
+		t.Error("Ping succeeded with the wrong key")
+	}
+	if _, err := p.CreateRecord("example.com", DNSRecord{Name: "app", Type: "A", Content: "1.2.3.4"}); err == nil {
+		t.Error("CreateRecord succeeded with the wrong key")
+	}
+}
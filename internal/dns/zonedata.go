@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// zoneData renders the data of rec in zone-file presentation format, with
+// absolute hostnames and quoted TXT strings. Hetzner and RFC 2136 servers
+// both take records in this form.
+func zoneData(rec DNSRecord) string {
+	switch rec.Type {
+	case "CNAME", "NS", "PTR", "ALIAS":
+		return mdns.Fqdn(rec.Content)
+	case "MX":
+		return fmt.Sprintf("%d %s", rec.Priority, mdns.Fqdn(rec.Content))
+	case "SRV":
+		return fmt.Sprintf("%d %d %d %s", rec.Priority, rec.Weight, rec.Port, mdns.Fqdn(rec.Content))
+	case "TXT":
+		return quoteTXT(rec.Content)
+	}
+	return rec.Content
+}
+
+// quoteTXT quotes s as one or more character-strings. Each is limited to 255
+// bytes, so long values such as DKIM keys are split.
+func quoteTXT(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		return s
+	}
+	var parts []string
+	for {
+		chunk := s
+		if len(chunk) > 255 {
+			chunk = s[:255]
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(chunk)
+		parts = append(parts, `"`+escaped+`"`)
+		s = s[len(chunk):]
+		if s == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+// parseZoneData is the inverse of zoneData: it fills the data fields of rec
+// from a value in presentation format. Values that don't parse are kept
+// verbatim as the content.
+func parseZoneData(rec *DNSRecord, data string) {
+	rr, err := mdns.NewRR(". 0 IN " + rec.Type + " " + data)
+	if err != nil || rr == nil {
+		rec.Content = data
+		return
+	}
+	fillFromRR(rec, rr)
+}
+
+// fillFromRR sets the data fields of rec from a parsed resource record.
+func fillFromRR(rec *DNSRecord, rr mdns.RR) {
+	switch v := rr.(type) {
+	case *mdns.A:
+		rec.Content = v.A.String()
+	case *mdns.AAAA:
+		rec.Content = v.AAAA.String()
+	case *mdns.CNAME:
+		rec.Content = strings.TrimSuffix(v.Target, ".")
+	case *mdns.NS:
+		rec.Content = strings.TrimSuffix(v.Ns, ".")
+	case *mdns.PTR:
+		rec.Content = strings.TrimSuffix(v.Ptr, ".")
+	case *mdns.MX:
+		rec.Priority = int(v.Preference)
+		rec.Content = strings.TrimSuffix(v.Mx, ".")
+	case *mdns.SRV:
+		rec.Priority = int(v.Priority)
+		rec.Weight = int(v.Weight)
+		rec.Port = int(v.Port)
+		rec.Content = strings.TrimSuffix(v.Target, ".")
+	case *mdns.TXT:
+		var b strings.Builder
+		for _, txt := range v.Txt {
+			b.WriteString(unescapeTXT(txt))
+		}
+		rec.Content = b.String()
+	case *mdns.CAA:
+		rec.Content = fmt.Sprintf("%d %s %q", v.Flag, v.Tag, v.Value)
+	default:
+		rec.Content = strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+}
+
+// unescapeTXT undoes the escaping miekg/dns keeps in TXT strings: \X for
+// quotes and backslashes and \DDD for non-printable bytes.
+func unescapeTXT(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')*100 + (s[i+2]-'0')*10 + (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		i++
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZoneDataRoundTrip(t *testing.T) {
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 400)
+	tests := []struct {
+		rec  DNSRecord
+		data string
+	}{
+		{DNSRecord{Type: "A", Content: "1.2.3.4"}, "1.2.3.4"},
+		{DNSRecord{Type: "CNAME", Content: "example.com"}, "example.com."},
+		{DNSRecord{Type: "MX", Content: "smtp.google.com", Priority: 1}, "1 smtp.google.com."},
+		{DNSRecord{Type: "SRV", Content: "sip.example.com", Priority: 10, Weight: 60, Port: 5060}, "10 60 5060 sip.example.com."},
+		{DNSRecord{Type: "CAA", Content: `0 issue "letsencrypt.org"`}, `0 issue "letsencrypt.org"`},
+		{DNSRecord{Type: "TXT", Content: `v=spf1 include:"x" -all`}, `"v=spf1 include:\"x\" -all"`},
+		{DNSRecord{Type: "TXT", Content: dkim}, `"` + dkim[:255] + `" "` + dkim[255:] + `"`},
+	}
+	for _, tt := range tests {
+		if got := zoneData(tt.rec); got != tt.data {
+			t.Errorf("zoneData(%s %s) = %s, want %s", tt.rec.Type, tt.rec.Content, got, tt.data)
+		}
+		got := DNSRecord{Type: tt.rec.Type}
+		parseZoneData(&got, tt.data)
+		if got != tt.rec {
+			t.Errorf("parseZoneData(%s) = %+v, want %+v", tt.data, got, tt.rec)
+		}
+	}
+}
+
+func TestRecordValue(t *testing.T) {
+	tests := []struct {
+		rec  DNSRecord
+		want string
+	}{
+		{DNSRecord{Type: "A", Content: "1.2.3.4"}, "1.2.3.4"},
+		{DNSRecord{Type: "MX", Content: "mx.example.com", Priority: 10}, "10 mx.example.com"},
+		{DNSRecord{Type: "SRV", Content: "sip.example.com", Priority: 10, Weight: 60, Port: 5060}, "10 60 5060 sip.example.com"},
+	}
+	for _, tt := range tests {
+		if got := tt.rec.Value(); got != tt.want {
+			t.Errorf("Value() = %q, want %q", got, tt.want)
+		}
+	}
+}
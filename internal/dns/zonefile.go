@@ -0,0 +1,216 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// defaultZoneTTL is written as $TTL and used for records without a TTL of
+// their own.
+const defaultZoneTTL = 3600
+
+// proxiedTag marks Cloudflare-proxied records in a zone file, in the same
+// form Cloudflare's own export uses.
+const proxiedTag = "cf_tags=cf-proxied:true"
+
+// WriteZone writes records as an RFC 1035 zone file for domain. Records
+// without a TTL, or with Cloudflare's "automatic" TTL of 1, use the $TTL
+// default. Types that are not standard DNS, such as Porkbun's ALIAS, are
+// written as comments so the file still parses.
+func WriteZone(w io.Writer, domain string, records []DNSRecord) error {
+	sorted := append([]DNSRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := zoneOwner(sorted[i].Name, domain), zoneOwner(sorted[j].Name, domain)
+		if a != b {
+			return a == "@" || (b != "@" && a < b)
+		}
+		return sorted[i].Type < sorted[j].Type
+	})
+
+	fmt.Fprintf(w, "$ORIGIN %s\n", mdns.Fqdn(domain))
+	fmt.Fprintf(w, "$TTL %d\n", defaultZoneTTL)
+	for _, rec := range sorted {
+		ttl := ""
+		if v := zoneTTL(rec.TTL); v != defaultZoneTTL {
+			ttl = strconv.Itoa(v)
+		}
+		line := fmt.Sprintf("%s\t%s\tIN\t%s\t%s", zoneOwner(rec.Name, domain), ttl, rec.Type, zoneData(rec))
+		if _, ok := mdns.StringToType[rec.Type]; !ok {
+			line = fmt.Sprintf("; %s (%s is not a standard record type)", line, rec.Type)
+		} else if rec.Proxied {
+			line += " ; " + proxiedTag
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadZone parses a zone file for domain. Names are relative to domain
+// unless the file sets its own $ORIGIN, and $INCLUDE is not allowed. SOA
+// records are skipped, since each provider manages its own.
+func ReadZone(r io.Reader, domain string) ([]DNSRecord, error) {
+	zp := mdns.NewZoneParser(r, mdns.Fqdn(domain), "")
+	zp.SetDefaultTTL(defaultZoneTTL)
+
+	var records []DNSRecord
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		if hdr.Rrtype == mdns.TypeSOA {
+			continue
+		}
+		name := strings.TrimSuffix(hdr.Name, ".")
+		if !strings.EqualFold(name, domain) && !isSubdomainOf(strings.ToLower(name), strings.ToLower(domain)) {
+			return nil, fmt.Errorf("record %s is outside %s", name, domain)
+		}
+		rec := DNSRecord{
+			Name:    name,
+			Type:    mdns.TypeToString[hdr.Rrtype],
+			TTL:     strconv.FormatUint(uint64(hdr.Ttl), 10),
+			Proxied: strings.Contains(zp.Comment(), proxiedTag),
+		}
+		fillFromRR(&rec, rr)
+		records = append(records, rec)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("parsing zone file: %w", err)
+	}
+	return records, nil
+}
+
+// zoneOwner returns name relative to domain, with "@" for the apex.
+func zoneOwner(name, domain string) string {
+	name = strings.TrimSuffix(fqdnFor(name, domain), ".")
+	if strings.EqualFold(name, domain) {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+domain)
+}
+
+// ZoneDiff is the set of changes that makes a live zone match a zone file.
+type ZoneDiff struct {
+	Create []DNSRecord
+	Update []DNSRecord // live IDs with the wanted TTL and proxied flag
+	Delete []DNSRecord
+}
+
+// Empty reports whether the zones already match.
+func (d ZoneDiff) Empty() bool {
+	return len(d.Create) == 0 && len(d.Update) == 0 && len(d.Delete) == 0
+}
+
+// DiffZone compares the live records of domain with the wanted ones.
+// Records are matched on name, type and data; a match whose TTL or proxied
+// flag differs is updated in place. The proxied flag is only compared when
+// proxied is set, since providers other than Cloudflare never report it.
+// SOA records and the NS records at the apex are left out on both sides, as
+// they belong to whichever provider hosts the zone, and so are types a zone
+// file can't hold.
+func DiffZone(domain string, live, want []DNSRecord, proxied bool) ZoneDiff {
+	var diff ZoneDiff
+	matched := make(map[int]bool)
+	for _, w := range want {
+		if providerManaged(w, domain) {
+			continue
+		}
+		found := false
+		for i, l := range live {
+			if matched[i] || providerManaged(l, domain) || !sameRecord(l, w, domain) {
+				continue
+			}
+			matched[i] = true
+			found = true
+			if zoneTTL(w.TTL) != zoneTTL(l.TTL) || (proxied && w.Proxied != l.Proxied) {
+				w.ID = l.ID
+				w.Name = l.Name
+				diff.Update = append(diff.Update, w)
+			}
+			break
+		}
+		if !found {
+			diff.Create = append(diff.Create, w)
+		}
+	}
+	for i, l := range live {
+		if !matched[i] && !providerManaged(l, domain) {
+			diff.Delete = append(diff.Delete, l)
+		}
+	}
+	return diff
+}
+
+// ApplyZoneDiff makes the changes in diff, creating records before it
+// deletes any so that names keep resolving throughout. The exception is a
+// record that can't coexist with a new one: a name with a CNAME holds no
+// other record, so old records at a name that gets a CNAME, and old CNAMEs
+// at a name that gets any record, are deleted first.
+func ApplyZoneDiff(p Provider, domain string, diff ZoneDiff) error {
+	deleted := make(map[int]bool)
+	for i, rec := range diff.Delete {
+		if !conflictsWithCreate(rec, diff.Create, domain) {
+			continue
+		}
+		if err := p.DeleteRecord(domain, rec.ID); err != nil {
+			return fmt.Errorf("deleting %s record %s: %w", rec.Type, rec.Name, err)
+		}
+		deleted[i] = true
+	}
+	for _, rec := range diff.Create {
+		if _, err := p.CreateRecord(domain, rec); err != nil {
+			return fmt.Errorf("creating %s record %s: %w", rec.Type, fqdnFor(rec.Name, domain), err)
+		}
+	}
+	for _, rec := range diff.Update {
+		if err := p.UpdateRecord(domain, rec.ID, rec); err != nil {
+			return fmt.Errorf("updating %s record %s: %w", rec.Type, rec.Name, err)
+		}
+	}
+	for i, rec := range diff.Delete {
+		if deleted[i] {
+			continue
+		}
+		if err := p.DeleteRecord(domain, rec.ID); err != nil {
+			return fmt.Errorf("deleting %s record %s: %w", rec.Type, rec.Name, err)
+		}
+	}
+	return nil
+}
+
+// conflictsWithCreate reports whether rec has to go before any of creates
+// can be added: it shares a name with one of them and either is a CNAME.
+func conflictsWithCreate(rec DNSRecord, creates []DNSRecord, domain string) bool {
+	for _, c := range creates {
+		if (rec.Type == "CNAME" || c.Type == "CNAME") &&
+			strings.EqualFold(fqdnFor(rec.Name, domain), fqdnFor(c.Name, domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func providerManaged(r DNSRecord, domain string) bool {
+	if _, ok := mdns.StringToType[r.Type]; !ok {
+		return true
+	}
+	return r.Type == "SOA" || (r.Type == "NS" && zoneOwner(r.Name, domain) == "@")
+}
+
+// zoneTTL normalises a TTL for comparison, treating a missing or automatic
+// TTL as the zone file default, which is what WriteZone writes for it.
+func zoneTTL(ttl string) int {
+	if v, err := strconv.Atoi(ttl); err == nil && v > 1 {
+		return v
+	}
+	return defaultZoneTTL
+}
+
+func sameRecord(a, b DNSRecord, domain string) bool {
+	return strings.EqualFold(fqdnFor(a.Name, domain), fqdnFor(b.Name, domain)) &&
+		a.Type == b.Type && sameData(a, b)
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestZoneRoundTrip(t *testing.T) {
+	records := []DNSRecord{
+		{ID: "1", Name: "www.example.com", Type: "CNAME", Content: "example.com", TTL: "600", Proxied: true},
+		{ID: "2", Name: "example.com", Type: "A", Content: "1.2.3.4", TTL: "1"},
+		{ID: "3", Name: "example.com", Type: "MX", Content: "smtp.google.com", Priority: 1, TTL: "3600"},
+		{ID: "4", Name: "_sip._tcp.example.com", Type: "SRV", Content: "sip.example.com", Priority: 10, Weight: 60, Port: 5060, TTL: "300"},
+		{ID: "5", Name: "example.com", Type: "TXT", Content: `v=spf1 include:_spf.google.com ~all`, TTL: "3600"},
+		{ID: "6", Name: "example.com", Type: "CAA", Content: `0 issue "letsencrypt.org"`, TTL: "3600"},
+		{ID: "7", Name: "shop.example.com", Type: "ALIAS", Content: "shops.myshopify.com", TTL: "600"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteZone(&buf, "example.com", records); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"$ORIGIN example.com.",
+		"$TTL 3600",
+		"@\t\tIN\tA\t1.2.3.4",
+		"@\t\tIN\tCAA\t0 issue \"letsencrypt.org\"",
+		"@\t\tIN\tMX\t1 smtp.google.com.",
+		"@\t\tIN\tTXT\t\"v=spf1 include:_spf.google.com ~all\"",
+		"_sip._tcp\t300\tIN\tSRV\t10 60 5060 sip.example.com.",
+		"; shop\t600\tIN\tALIAS\tshops.myshopify.com. (ALIAS is not a standard record type)",
+		"www\t600\tIN\tCNAME\texample.com. ; cf_tags=cf-proxied:true",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("zone file:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	parsed, err := ReadZone(&buf, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 6 {
+		t.Fatalf("parsed %d records, want 6: %+v", len(parsed), parsed)
+	}
+	if got := parsed[5]; !got.Proxied || got.Name != "www.example.com" || got.Content != "example.com" {
+		t.Errorf("CNAME = %+v", got)
+	}
+
+	// Importing the export back changes nothing, ALIAS included.
+	if diff := DiffZone("example.com", records, parsed, true); !diff.Empty() {
+		t.Errorf("round trip diff = %+v", diff)
+	}
+}
+
+func TestReadZoneRejectsOtherDomains(t *testing.T) {
+	_, err := ReadZone(strings.NewReader("mail.example.org. 300 IN A 1.2.3.4\n"), "example.com")
+	if err == nil || err.Error() != "record mail.example.org is outside example.com" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDiffZone(t *testing.T) {
+	live := []DNSRecord{
+		{ID: "1", Name: "example.com", Type: "A", Content: "1.2.3.4", TTL: "600"},
+		{ID: "2", Name: "example.com", Type: "NS", Content: "curitiba.ns.porkbun.com", TTL: "86400"},
+		{ID: "3", Name: "old.example.com", Type: "A", Content: "5.6.7.8", TTL: "600"},
+		{ID: "4", Name: "www.example.com", Type: "CNAME", Content: "example.com", TTL: "600"},
+	}
+	zone := `
+$ORIGIN example.com.
+@      3600 IN SOA ns1.example.net. admin.example.com. 1 7200 3600 1209600 3600
+@      300  IN A     1.2.3.4
+@      3600 IN NS    ns1.cloudflare.com.
+www    600  IN CNAME example.com.
+mail   600  IN A     9.9.9.9
+`
+	want, err := ReadZone(strings.NewReader(zone), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := DiffZone("example.com", live, want, false)
+	got := map[string][]string{}
+	for action, recs := range map[string][]DNSRecord{"create": diff.Create, "update": diff.Update, "delete": diff.Delete} {
+		for _, r := range recs {
+			got[action] = append(got[action], r.ID+" "+r.Name+" "+r.Type+" "+r.Value()+" "+r.TTL)
+		}
+	}
+	wantDiff := map[string][]string{
+		"create": {" mail.example.com A 9.9.9.9 600"},
+		"update": {"1 example.com A 1.2.3.4 300"},
+		"delete": {"3 old.example.com A 5.6.7.8 600"},
+	}
+	if !reflect.DeepEqual(got, wantDiff) {
+		t.Errorf("diff = %v, want %v", got, wantDiff)
+	}
+
+	m := &memProvider{records: live}
+	if err := ApplyZoneDiff(m, "example.com", diff); err != nil {
+		t.Fatal(err)
+	}
+	wantCalls := []string{
+		"create mail.example.com A 9.9.9.9",
+		"update example.com A 1.2.3.4",
+		"delete old.example.com A 5.6.7.8",
+	}
+	if !reflect.DeepEqual(m.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", m.calls, wantCalls)
+	}
+	if m.records[0].TTL != "300" {
+		t.Errorf("TTL after update = %q, want 300", m.records[0].TTL)
+	}
+	if diff := DiffZone("example.com", m.records, want, false); !diff.Empty() {
+		t.Errorf("zone differs after apply: %+v", diff)
+	}
+}
+
+func TestDiffZoneProxied(t *testing.T) {
+	live := []DNSRecord{{ID: "1", Name: "app.example.com", Type: "A", Content: "1.2.3.4", TTL: "300"}}
+	want := []DNSRecord{{Name: "app.example.com", Type: "A", Content: "1.2.3.4", TTL: "300", Proxied: true}}
+
+	// Providers that can't proxy never report it, so the flag is ignored
+	// rather than planned as an update that never takes.
+	if diff := DiffZone("example.com", live, want, false); !diff.Empty() {
+		t.Errorf("without proxy support: diff = %+v, want empty", diff)
+	}
+
+	diff := DiffZone("example.com", live, want, true)
+	if len(diff.Update) != 1 || diff.Update[0].ID != "1" || !diff.Update[0].Proxied {
+		t.Fatalf("with proxy support: diff = %+v, want an update of 1", diff)
+	}
+	m := &memProvider{records: live}
+	if err := ApplyZoneDiff(m, "example.com", diff); err != nil {
+		t.Fatal(err)
+	}
+	if !m.records[0].Proxied {
+		t.Error("record not proxied after apply")
+	}
+	if diff := DiffZone("example.com", m.records, want, true); !diff.Empty() {
+		t.Errorf("zone differs after apply: %+v", diff)
+	}
+}
+
+func TestApplyZoneDiffReplacesCNAMEs(t *testing.T) {
+	live := []DNSRecord{
+		{ID: "1", Name: "www.example.com", Type: "CNAME", Content: "example.com", TTL: "600"},
+		{ID: "2", Name: "shop.example.com", Type: "A", Content: "1.2.3.4", TTL: "600"},
+		{ID: "3", Name: "blog.example.com", Type: "CNAME", Content: "example.ghost.io", TTL: "600"},
+		{ID: "4", Name: "example.com", Type: "A", Content: "1.2.3.4", TTL: "600"},
+		{ID: "5", Name: "example.com", Type: "A", Content: "5.6.7.8", TTL: "600"},
+	}
+	zone := `
+$ORIGIN example.com.
+@      600 IN A     1.2.3.4
+@      600 IN A     9.9.9.9
+www    600 IN CNAME web.example.net.
+shop   600 IN CNAME shops.myshopify.com.
+blog   600 IN A     5.6.7.8
+`
+	want, err := ReadZone(strings.NewReader(zone), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &memProvider{records: live}
+	if err := ApplyZoneDiff(m, "example.com", DiffZone("example.com", live, want, false)); err != nil {
+		t.Fatal(err)
+	}
+	wantCalls := []string{
+		// Records that would clash with a new one go first...
+		"delete www.example.com CNAME example.com",
+		"delete shop.example.com A 1.2.3.4",
+		"delete blog.example.com CNAME example.ghost.io",
+		"create example.com A 9.9.9.9",
+		"create www.example.com CNAME web.example.net",
+		"create shop.example.com CNAME shops.myshopify.com",
+		"create blog.example.com A 5.6.7.8",
+		// ...and the rest only once the new records are in place.
+		"delete example.com A 5.6.7.8",
+	}
+	if !reflect.DeepEqual(m.calls, wantCalls) {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(m.calls, "\n"), strings.Join(wantCalls, "\n"))
+	}
+	if diff := DiffZone("example.com", m.records, want, false); !diff.Empty() {
+		t.Errorf("zone differs after apply: %+v", diff)
+	}
+}
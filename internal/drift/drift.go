@@ -0,0 +1,312 @@
+package drift
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/caddy"
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	StatusOK    Status = "ok"
+	StatusDrift Status = "drift"
+	StatusError Status = "error" // the check itself could not run
+)
+
+// Names of the individual checks, as reported in Result.Check.
+const (
+	CheckCaddy      = "caddy"
+	CheckDNS        = "dns"
+	CheckPort       = "port"
+	CheckExposed    = "exposed"
+	CheckDeployUser = "deploy_user"
+	CheckDeployPath = "deploy_path"
+	CheckSecrets    = "secrets"
+	CheckWorkflow   = "workflow"
+)
+
+// Result is one check for one project environment.
+type Result struct {
+	Project string `json:"project"`
+	Env     string `json:"env"`
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Report is the full set of results from a drift run.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Drifted reports whether any check found drift or failed to run.
+func (r *Report) Drifted() bool {
+	for _, res := range r.Results {
+		if res.Status != StatusOK {
+			return true
+		}
+	}
+	return false
+}
+
+// GitHub is the read-only view of a repository that the checks need.
+type GitHub interface {
+	SecretNames(repo string) ([]string, error)
+	FileExists(repo, path string) (bool, error)
+}
+
+// Checker compares stored project environments with the live servers, DNS
+// providers and GitHub repos. It never changes anything.
+type Checker struct {
+	Config *config.Config
+	Store  config.Store
+
+	// Dial, DNS, RootDomain and GitHub default to the real implementations
+	// in NewChecker and can be replaced in tests.
+	Dial       func(server *config.Server) (remote.Runner, error)
+	DNS        func(domain string, env config.Environment) (dns.Provider, error)
+	RootDomain func(domain string) (string, error)
+	GitHub     GitHub
+}
+
+// NewChecker returns a Checker that talks to the real infrastructure.
+func NewChecker(cfg *config.Config, store config.Store) *Checker {
+	return &Checker{
+		Config: cfg,
+		Store:  store,
+		Dial: func(server *config.Server) (remote.Runner, error) {
+			peonKey, err := store.GetPeonKey(server.IP)
+			if err != nil {
+				return nil, fmt.Errorf("peon key for %s: %w", server.IP, err)
+			}
+			return remote.DialPeon(server.IP, peonKey, store)
+		},
+		DNS: func(domain string, env config.Environment) (dns.Provider, error) {
+			if env.DNSProvider != "" {
+				return dns.NewProvider(env.DNSProvider, store)
+			}
+			return dns.ProviderForDomain(domain, cfg, store)
+		},
+		RootDomain: config.RootDomain,
+		GitHub:     &ghCLI{secrets: make(map[string][]string), branches: make(map[string]string)},
+	}
+}
+
+// CheckAll checks every environment of every project in the config.
+func (c *Checker) CheckAll() *Report {
+	report := &Report{}
+	for _, p := range c.Config.Projects {
+		envNames := make([]string, 0, len(p.Environments))
+		for name := range p.Environments {
+			envNames = append(envNames, name)
+		}
+		slices.Sort(envNames)
+		for _, envName := range envNames {
+			report.Results = append(report.Results, c.CheckEnvironment(p, envName)...)
+		}
+	}
+	return report
+}
+
+// CheckEnvironment runs every check for one environment. Secrets and
+// workflow checks are skipped for services, which have no repo.
+func (c *Checker) CheckEnvironment(p config.Project, envName string) []Result {
+	env := p.Environments[envName]
+	var results []Result
+	add := func(check string, status Status, detail string) {
+		results = append(results, Result{Project: p.Name, Env: envName, Check: check, Status: status, Detail: detail})
+	}
+	addErr := func(check string, err error) {
+		add(check, StatusError, err.Error())
+	}
+
+	serverChecks := []string{CheckCaddy, CheckDNS, CheckPort, CheckExposed, CheckDeployUser, CheckDeployPath}
+	server, err := project.LookupServer(c.Config, p.Server, c.Store)
+	if err != nil {
+		for _, check := range serverChecks {
+			addErr(check, err)
+		}
+	} else {
+		c.checkServer(server, env, add, addErr)
+		c.checkDNS(server, env, add, addErr)
+	}
+
+	if p.Repo != "" {
+		c.checkGitHub(p.Repo, p.Registry, envName, add, addErr)
+	}
+	return results
+}
+
+func (c *Checker) checkServer(server *config.Server, env config.Environment, add func(string, Status, string), addErr func(string, error)) {
+	client, err := c.Dial(server)
+	if err != nil {
+		for _, check := range []string{CheckCaddy, CheckPort, CheckExposed, CheckDeployUser, CheckDeployPath} {
+			addErr(check, err)
+		}
+		return
+	}
+	defer client.Close()
+
+	caddyPath := fmt.Sprintf("/etc/caddy/conf.d/%s.caddy", env.Domain)
+	if client.Run(fmt.Sprintf("sudo test -f %s", caddyPath)) != nil {
+		add(CheckCaddy, StatusDrift, caddyPath+" is missing")
+	} else if got, err := client.Output(fmt.Sprintf("sudo cat %s", caddyPath)); err != nil {
+		addErr(CheckCaddy, fmt.Errorf("reading %s: %w", caddyPath, err))
+	} else if got != caddy.Generate(env.Domain, env.Port, env.DNSProvider) {
+		add(CheckCaddy, StatusDrift, caddyPath+" differs from the generated config")
+	} else {
+		add(CheckCaddy, StatusOK, "")
+	}
+
+	// The app should only be reachable through Caddy, so its port must be
+	// bound to localhost.
+	bindings, err := project.PortBindings(client)
+	if err != nil {
+		addErr(CheckPort, err)
+		addErr(CheckExposed, err)
+	} else {
+		bound := false
+		var public []string
+		for _, b := range bindings {
+			if b.Port != env.Port {
+				continue
+			}
+			bound = true
+			if b.Public() {
+				public = append(public, b.Addr)
+			}
+		}
+		if bound {
+			add(CheckPort, StatusOK, "")
+		} else {
+			add(CheckPort, StatusDrift, fmt.Sprintf("no container is bound to port %d", env.Port))
+		}
+		if len(public) > 0 {
+			add(CheckExposed, StatusDrift, fmt.Sprintf("port %d is published on %s, bypassing Caddy; bind it to 127.0.0.1", env.Port, strings.Join(public, ", ")))
+		} else {
+			add(CheckExposed, StatusOK, "")
+		}
+	}
+
+	if client.Run(fmt.Sprintf("id -u %s", env.DeployUser)) != nil {
+		add(CheckDeployUser, StatusDrift, fmt.Sprintf("user %s does not exist", env.DeployUser))
+	} else {
+		add(CheckDeployUser, StatusOK, "")
+	}
+
+	if client.Run(fmt.Sprintf("sudo test -d %s", env.DeployPath)) != nil {
+		add(CheckDeployPath, StatusDrift, env.DeployPath+" does not exist")
+	} else {
+		add(CheckDeployPath, StatusOK, "")
+	}
+}
+
+func (c *Checker) checkDNS(server *config.Server, env config.Environment, add func(string, Status, string), addErr func(string, error)) {
+	rootDomain, err := c.RootDomain(env.Domain)
+	if err != nil {
+		addErr(CheckDNS, fmt.Errorf("resolving root domain for %s: %w", env.Domain, err))
+		return
+	}
+	provider, err := c.DNS(env.Domain, env)
+	if err != nil {
+		addErr(CheckDNS, fmt.Errorf("DNS provider for %s: %w", env.Domain, err))
+		return
+	}
+	records, err := provider.ListRecords(rootDomain)
+	if err != nil {
+		addErr(CheckDNS, fmt.Errorf("listing DNS records for %s: %w", rootDomain, err))
+		return
+	}
+
+	var elsewhere []string
+	for _, r := range records {
+		if r.Name != env.Domain || r.Type != "A" {
+			continue
+		}
+		if r.Content == server.IP {
+			add(CheckDNS, StatusOK, "")
+			return
+		}
+		elsewhere = append(elsewhere, r.Content)
+	}
+	if len(elsewhere) > 0 {
+		add(CheckDNS, StatusDrift, fmt.Sprintf("A record points to %s, not %s", strings.Join(elsewhere, ", "), server.IP))
+		return
+	}
+	add(CheckDNS, StatusDrift, "no A record for "+env.Domain)
+}
+
+func (c *Checker) checkGitHub(repo, registryName, envName string, add func(string, Status, string), addErr func(string, error)) {
+	names, err := c.GitHub.SecretNames(repo)
+	if err != nil {
+		addErr(CheckSecrets, err)
+	} else {
+		expected := append(project.EnvironmentSecretNames(strings.ToUpper(envName)), project.SharedSecretNames(registryName)...)
+		var missing []string
+		for _, name := range expected {
+			if !slices.Contains(names, name) {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			add(CheckSecrets, StatusDrift, "missing "+strings.Join(missing, ", "))
+		} else {
+			add(CheckSecrets, StatusOK, "")
+		}
+	}
+
+	path := ".github/workflows/" + project.WorkflowFile(envName)
+	exists, err := c.GitHub.FileExists(repo, path)
+	switch {
+	case err != nil:
+		addErr(CheckWorkflow, err)
+	case !exists:
+		add(CheckWorkflow, StatusDrift, path+" is missing")
+	default:
+		add(CheckWorkflow, StatusOK, "")
+	}
+}
+
+// ghCLI reads from GitHub through the gh CLI, caching per repo since dev and
+// prod share one.
+type ghCLI struct {
+	secrets  map[string][]string
+	branches map[string]string
+}
+
+func (g *ghCLI) SecretNames(repo string) ([]string, error) {
+	if names, ok := g.secrets[repo]; ok {
+		return names, nil
+	}
+	secrets, err := project.ListGitHubSecrets(repo)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(secrets))
+	for i, s := range secrets {
+		names[i] = s.Name
+	}
+	g.secrets[repo] = names
+	return names, nil
+}
+
+func (g *ghCLI) FileExists(repo, path string) (bool, error) {
+	branch, ok := g.branches[repo]
+	if !ok {
+		var err error
+		branch, err = project.DefaultBranch(repo)
+		if err != nil {
+			return false, err
+		}
+		g.branches[repo] = branch
+	}
+	return project.RepoFileExists(repo, path, branch), nil
+}
@@ -0,0 +1,206 @@
+package drift
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/caddy"
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// fakeServer answers commands from a fixed table; anything else fails.
+type fakeServer struct {
+	outputs map[string]string
+}
+
+func (f *fakeServer) Run(command string) error {
+	if _, ok := f.outputs[command]; ok {
+		return nil
+	}
+	return errors.New("exit status 1")
+}
+
+func (f *fakeServer) Output(command string) (string, error) {
+	if out, ok := f.outputs[command]; ok {
+		return out, nil
+	}
+	return "", errors.New("exit status 1")
+}
+
+func (f *fakeServer) WriteFile(path, content string) error { return errors.New("read-only") }
+func (f *fakeServer) Close() error                         { return nil }
+
+type fakeDNS struct {
+	records []dns.DNSRecord
+}
+
+func (f *fakeDNS) Name() string { return "porkbun" }
+func (f *fakeDNS) CreateRecord(domain string, rec dns.DNSRecord) (string, error) {
+	return "", errors.New("read-only")
+}
+func (f *fakeDNS) UpdateRecord(domain, id string, rec dns.DNSRecord) error {
+	return errors.New("read-only")
+}
+func (f *fakeDNS) UpsertRecord(domain string, rec dns.DNSRecord) (string, error) {
+	return "", errors.New("read-only")
+}
+func (f *fakeDNS) DeleteRecord(domain, id string) error { return errors.New("read-only") }
+func (f *fakeDNS) ListRecords(domain string) ([]dns.DNSRecord, error) {
+	return f.records, nil
+}
+
+type fakeGitHub struct {
+	secrets []string
+	files   map[string]bool
+}
+
+func (f *fakeGitHub) SecretNames(repo string) ([]string, error) { return f.secrets, nil }
+func (f *fakeGitHub) FileExists(repo, path string) (bool, error) {
+	return f.files[path], nil
+}
+
+func TestCheckEnvironment(t *testing.T) {
+	env := config.Environment{
+		Domain:      "myapp.example.com",
+		DNSProvider: "porkbun",
+		Branch:      "main",
+		DeployPath:  "/opt/myapp",
+		DeployUser:  "myapp-deploy",
+		Port:        3000,
+	}
+	cfg := &config.Config{
+		Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}},
+		Projects: []config.Project{{
+			Name: "myapp", Repo: "org/myapp", Server: "web1",
+			Environments: map[string]config.Environment{"prod": env},
+		}},
+	}
+
+	server := &fakeServer{outputs: map[string]string{
+		"sudo test -f /etc/caddy/conf.d/myapp.example.com.caddy": "",
+		"sudo cat /etc/caddy/conf.d/myapp.example.com.caddy":     caddy.Generate(env.Domain, env.Port, env.DNSProvider),
+		"docker ps --format '{{.Ports}}'":                        "0.0.0.0:3001->80/tcp\n",
+		"id -u myapp-deploy":                                     "1001",
+		// deploy path is missing
+	}}
+	provider := &fakeDNS{records: []dns.DNSRecord{
+		{ID: "1", Name: "myapp.example.com", Type: "A", Content: "9.9.9.9"},
+	}}
+	gh := &fakeGitHub{
+		secrets: []string{"PROD_VPS_USER", "PROD_VPS_DEPLOY_PATH", "PROD_VPS_SSH_KEY", "PROD_PORT", "VPS_HOST", "DOCKERHUB_USERNAME"},
+		files:   map[string]bool{".github/workflows/deploy-prod.yml": true},
+	}
+
+	c := &Checker{
+		Config:     cfg,
+		Dial:       func(*config.Server) (remote.Runner, error) { return server, nil },
+		DNS:        func(string, config.Environment) (dns.Provider, error) { return provider, nil },
+		GitHub:     gh,
+		RootDomain: func(string) (string, error) { return "example.com", nil },
+	}
+	report := c.CheckAll()
+
+	want := map[string]string{
+		CheckCaddy:      "ok",
+		CheckDNS:        "drift: A record points to 9.9.9.9, not 1.2.3.4",
+		CheckPort:       "drift: no container is bound to port 3000",
+		CheckExposed:    "ok",
+		CheckDeployUser: "ok",
+		CheckDeployPath: "drift: /opt/myapp does not exist",
+		CheckSecrets:    "drift: missing DOCKERHUB_TOKEN",
+		CheckWorkflow:   "ok",
+	}
+	if len(report.Results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(report.Results), len(want), report.Results)
+	}
+	for _, r := range report.Results {
+		got := string(r.Status)
+		if r.Detail != "" {
+			got += ": " + r.Detail
+		}
+		if got != want[r.Check] {
+			t.Errorf("%s = %q, want %q", r.Check, got, want[r.Check])
+		}
+	}
+	if !report.Drifted() {
+		t.Error("Drifted() = false, want true")
+	}
+}
+
+func TestCheckEnvironmentUnreachableServer(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}},
+		Projects: []config.Project{{
+			Name: "kuma", Server: "web1",
+			Environments: map[string]config.Environment{"prod": {Domain: "status.example.com", DeployPath: "/opt/kuma", DeployUser: "peon", Port: 3100}},
+		}},
+	}
+	c := &Checker{
+		Config: cfg,
+		Dial:   func(*config.Server) (remote.Runner, error) { return nil, errors.New("connection refused") },
+		DNS: func(string, config.Environment) (dns.Provider, error) {
+			return &fakeDNS{records: []dns.DNSRecord{{Name: "status.example.com", Type: "A", Content: "1.2.3.4"}}}, nil
+		},
+		RootDomain: func(string) (string, error) { return "example.com", nil },
+	}
+
+	report := c.CheckAll()
+	for _, r := range report.Results {
+		if r.Check == CheckSecrets || r.Check == CheckWorkflow {
+			t.Errorf("service without a repo should skip %s", r.Check)
+		}
+		if r.Check == CheckDNS {
+			if r.Status != StatusOK {
+				t.Errorf("dns = %s, want ok", r.Status)
+			}
+			continue
+		}
+		if r.Status != StatusError || !strings.Contains(r.Detail, "connection refused") {
+			t.Errorf("%s = %s %q, want error", r.Check, r.Status, r.Detail)
+		}
+	}
+}
+
+func TestCheckExposedPort(t *testing.T) {
+	env := config.Environment{Domain: "myapp.example.com", DeployPath: "/opt/myapp", DeployUser: "myapp-deploy", Port: 3000}
+	cfg := &config.Config{
+		Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}},
+		Projects: []config.Project{{
+			Name: "myapp", Server: "web1",
+			Environments: map[string]config.Environment{"prod": env},
+		}},
+	}
+
+	for _, tc := range []struct {
+		ports string
+		want  string
+	}{
+		{"127.0.0.1:3000->80/tcp\n", "ok"},
+		{"0.0.0.0:3000->80/tcp, [::]:3000->80/tcp\n", "drift: port 3000 is published on 0.0.0.0, ::, bypassing Caddy; bind it to 127.0.0.1"},
+		{":::3000->80/tcp\n127.0.0.1:3001->80/tcp\n", "drift: port 3000 is published on ::, bypassing Caddy; bind it to 127.0.0.1"},
+		{"0.0.0.0:3001->80/tcp\n", "ok"},
+	} {
+		server := &fakeServer{outputs: map[string]string{"docker ps --format '{{.Ports}}'": tc.ports}}
+		c := &Checker{
+			Config:     cfg,
+			Dial:       func(*config.Server) (remote.Runner, error) { return server, nil },
+			DNS:        func(string, config.Environment) (dns.Provider, error) { return &fakeDNS{}, nil },
+			RootDomain: func(string) (string, error) { return "example.com", nil },
+		}
+		for _, r := range c.CheckAll().Results {
+			if r.Check != CheckExposed {
+				continue
+			}
+			got := string(r.Status)
+			if r.Detail != "" {
+				got += ": " + r.Detail
+			}
+			if got != tc.want {
+				t.Errorf("ports %q: exposed = %q, want %q", tc.ports, got, tc.want)
+			}
+		}
+	}
+}
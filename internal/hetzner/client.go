@@ -22,18 +22,20 @@ type SSHKeyWithProject struct {
 // Manager holds clients for multiple Hetzner projects.
 type Manager struct {
 	clients map[string]*fhetzner.Client // alias -> client
+	tokens  map[string]string           // alias -> API token
 }
 
 // NewManager creates a Manager from config, resolving each project's token
 // from the Store.
 func NewManager(projects []config.HetznerProject, store config.Store) (*Manager, error) {
-	m := &Manager{clients: make(map[string]*fhetzner.Client)}
+	m := &Manager{clients: make(map[string]*fhetzner.Client), tokens: make(map[string]string)}
 	for _, p := range projects {
 		token, err := store.GetCredential("hetzner", p.Alias, "api_token")
 		if err != nil {
 			return nil, fmt.Errorf("credential for Hetzner project %q: %w", p.Alias, err)
 		}
 		m.clients[p.Alias] = fhetzner.NewClient(token)
+		m.tokens[p.Alias] = token
 	}
 	return m, nil
 }
@@ -0,0 +1,375 @@
+package hetzner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cloudAPIBase is used for the calls the fornost client doesn't have, such
+// as creating servers; fornost can only list and get them.
+const cloudAPIBase = "https://api.hetzner.cloud/v1"
+
+// CloudClient calls the Hetzner Cloud API for one project.
+type CloudClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+
+	// PollInterval is how often Wait* methods check progress.
+	PollInterval time.Duration
+}
+
+// NewCloudClient returns a client for the project the token belongs to.
+func NewCloudClient(token string) *CloudClient {
+	return NewCloudClientAt(cloudAPIBase, token)
+}
+
+// NewCloudClientAt returns a client for an API at baseURL, e.g. a local
+// stand-in in tests.
+func NewCloudClientAt(baseURL, token string) *CloudClient {
+	return &CloudClient{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		token:        token,
+		http:         &http.Client{Timeout: 30 * time.Second},
+		PollInterval: 2 * time.Second,
+	}
+}
+
+// CloudClient returns a Cloud API client for a project alias.
+func (m *Manager) CloudClient(alias string) (*CloudClient, error) {
+	token, ok := m.tokens[alias]
+	if !ok {
+		return nil, fmt.Errorf("unknown Hetzner project: %s", alias)
+	}
+	return NewCloudClient(token), nil
+}
+
+// Aliases returns the project aliases the manager has clients for.
+func (m *Manager) Aliases() []string {
+	aliases := make([]string, 0, len(m.tokens))
+	for alias := range m.tokens {
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+// CloudServer is a server as the Cloud API returns it.
+type CloudServer struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	PublicNet struct {
+		IPv4 struct {
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+	} `json:"public_net"`
+	ServerType struct {
+		Name string `json:"name"`
+	} `json:"server_type"`
+}
+
+// CreateServerOpts are the inputs for CreateServer.
+type CreateServerOpts struct {
+	Name       string            `json:"name"`
+	ServerType string            `json:"server_type"`
+	Location   string            `json:"location,omitempty"`
+	Image      string            `json:"image"`
+	SSHKeys    []string          `json:"ssh_keys,omitempty"` // names or IDs of project SSH keys
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// CreateServer starts creating a server. It returns as soon as the API has
+// accepted the request; use WaitForStatus to wait until it is running.
+func (c *CloudClient) CreateServer(opts CreateServerOpts) (*CloudServer, error) {
+	var resp struct {
+		Server CloudServer `json:"server"`
+	}
+	if err := c.do("POST", "/servers", opts, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Server, nil
+}
+
+// Server fetches a server by ID.
+func (c *CloudClient) Server(id int) (*CloudServer, error) {
+	var resp struct {
+		Server CloudServer `json:"server"`
+	}
+	if err := c.do("GET", fmt.Sprintf("/servers/%d", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Server, nil
+}
+
+// WaitForStatus polls a server until it reaches status (e.g. "running") or
+// timeout passes.
+func (c *CloudClient) WaitForStatus(id int, status string, timeout time.Duration) (*CloudServer, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		s, err := c.Server(id)
+		if err != nil {
+			return nil, err
+		}
+		if s.Status == status {
+			return s, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("server %s is still %s after %s, want %s", s.Name, s.Status, timeout, status)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// Action is an asynchronous operation on a server.
+type Action struct {
+	ID      int    `json:"id"`
+	Command string `json:"command"`
+	Status  string `json:"status"` // running, success or error
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// serverAction starts one of the /servers/{id}/actions/... operations.
+func (c *CloudClient) serverAction(id int, action string, body any) (*Action, error) {
+	var resp struct {
+		Action Action `json:"action"`
+	}
+	if body == nil {
+		body = struct{}{}
+	}
+	if err := c.do("POST", fmt.Sprintf("/servers/%d/actions/%s", id, action), body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Action, nil
+}
+
+// Reboot restarts a server gracefully (ACPI).
+func (c *CloudClient) Reboot(id int) (*Action, error) {
+	return c.serverAction(id, "reboot", nil)
+}
+
+// Shutdown asks a server's OS to shut down (ACPI). The action finishes once
+// the request is sent; use WaitForStatus to wait until the server is off.
+func (c *CloudClient) Shutdown(id int) (*Action, error) {
+	return c.serverAction(id, "shutdown", nil)
+}
+
+// PowerOff cuts power to a server, like pulling the plug.
+func (c *CloudClient) PowerOff(id int) (*Action, error) {
+	return c.serverAction(id, "poweroff", nil)
+}
+
+// PowerOn starts a stopped server.
+func (c *CloudClient) PowerOn(id int) (*Action, error) {
+	return c.serverAction(id, "poweron", nil)
+}
+
+// ChangeType resizes a stopped server. With upgradeDisk the disk grows too,
+// after which the server can't be moved back to a smaller type.
+func (c *CloudClient) ChangeType(id int, serverType string, upgradeDisk bool) (*Action, error) {
+	body := map[string]any{"server_type": serverType, "upgrade_disk": upgradeDisk}
+	return c.serverAction(id, "change_type", body)
+}
+
+// Rebuild reinstalls a server from an image, wiping its disk.
+func (c *CloudClient) Rebuild(id int, image string) (*Action, error) {
+	return c.serverAction(id, "rebuild", map[string]any{"image": image})
+}
+
+// CreateSnapshot starts a snapshot of a server's disk and returns the ID of
+// the image being created.
+func (c *CloudClient) CreateSnapshot(id int, description string) (int, *Action, error) {
+	var resp struct {
+		Image struct {
+			ID int `json:"id"`
+		} `json:"image"`
+		Action Action `json:"action"`
+	}
+	body := map[string]any{"type": "snapshot", "description": description}
+	if err := c.do("POST", fmt.Sprintf("/servers/%d/actions/create_image", id), body, &resp); err != nil {
+		return 0, nil, err
+	}
+	return resp.Image.ID, &resp.Action, nil
+}
+
+// DeleteServer deletes a server and its disk.
+func (c *CloudClient) DeleteServer(id int) (*Action, error) {
+	var resp struct {
+		Action Action `json:"action"`
+	}
+	if err := c.do("DELETE", fmt.Sprintf("/servers/%d", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Action, nil
+}
+
+// WaitForAction polls an action until it finishes, returning an error if it
+// failed or timeout passes.
+func (c *CloudClient) WaitForAction(a *Action, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for a.Status == "running" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is still running after %s", a.Command, timeout)
+		}
+		time.Sleep(c.PollInterval)
+		var resp struct {
+			Action Action `json:"action"`
+		}
+		if err := c.do("GET", fmt.Sprintf("/actions/%d", a.ID), nil, &resp); err != nil {
+			return err
+		}
+		a = &resp.Action
+	}
+	if a.Status == "error" {
+		if a.Error != nil {
+			return fmt.Errorf("%s failed: %s (%s)", a.Command, a.Error.Message, a.Error.Code)
+		}
+		return fmt.Errorf("%s failed", a.Command)
+	}
+	return nil
+}
+
+func (c *CloudClient) do(method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("hetzner API %s %s: %s (%s)", method, path, apiErr.Error.Message, apiErr.Error.Code)
+		}
+		return fmt.Errorf("hetzner API %s %s: HTTP %d", method, path, resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("parsing hetzner API response: %w", err)
+		}
+	}
+	return nil
+}
+
+// FirewallRule is one inbound or outbound rule of a Cloud firewall.
+type FirewallRule struct {
+	Direction   string   `json:"direction"`      // in or out
+	Protocol    string   `json:"protocol"`       // tcp, udp, icmp, esp or gre
+	Port        string   `json:"port,omitempty"` // a port or range like "8000-8100"; tcp and udp only
+	SourceIPs   []string `json:"source_ips,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Firewall is a Cloud firewall and the servers it is applied to.
+type Firewall struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	Rules     []FirewallRule `json:"rules"`
+	AppliedTo []struct {
+		Type   string `json:"type"`
+		Server struct {
+			ID int `json:"id"`
+		} `json:"server"`
+	} `json:"applied_to"`
+}
+
+// AppliedToServer reports whether the firewall is applied to server id.
+func (f *Firewall) AppliedToServer(id int) bool {
+	for _, a := range f.AppliedTo {
+		if a.Type == "server" && a.Server.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// FirewallByName returns the firewall called name, or nil if there is none.
+func (c *CloudClient) FirewallByName(name string) (*Firewall, error) {
+	var resp struct {
+		Firewalls []Firewall `json:"firewalls"`
+	}
+	if err := c.do("GET", "/firewalls?name="+url.QueryEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Firewalls) == 0 {
+		return nil, nil
+	}
+	return &resp.Firewalls[0], nil
+}
+
+// CreateFirewall creates a firewall with rules, applied to serverID.
+func (c *CloudClient) CreateFirewall(name string, rules []FirewallRule, serverID int) (*Firewall, []Action, error) {
+	body := map[string]any{
+		"name":     name,
+		"rules":    rules,
+		"labels":   map[string]string{"managed-by": "arnor"},
+		"apply_to": []any{serverResource(serverID)},
+	}
+	var resp struct {
+		Firewall Firewall `json:"firewall"`
+		Actions  []Action `json:"actions"`
+	}
+	if err := c.do("POST", "/firewalls", body, &resp); err != nil {
+		return nil, nil, err
+	}
+	return &resp.Firewall, resp.Actions, nil
+}
+
+// SetFirewallRules replaces all of a firewall's rules.
+func (c *CloudClient) SetFirewallRules(id int, rules []FirewallRule) ([]Action, error) {
+	return c.firewallAction(id, "set_rules", map[string]any{"rules": rules})
+}
+
+// ApplyFirewall applies a firewall to a server.
+func (c *CloudClient) ApplyFirewall(id, serverID int) ([]Action, error) {
+	return c.firewallAction(id, "apply_to_resources", map[string]any{"apply_to": []any{serverResource(serverID)}})
+}
+
+func (c *CloudClient) firewallAction(id int, action string, body any) ([]Action, error) {
+	var resp struct {
+		Actions []Action `json:"actions"`
+	}
+	if err := c.do("POST", fmt.Sprintf("/firewalls/%d/actions/%s", id, action), body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Actions, nil
+}
+
+func serverResource(id int) map[string]any {
+	return map[string]any{"type": "server", "server": map[string]int{"id": id}}
+}
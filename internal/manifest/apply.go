@@ -0,0 +1,97 @@
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/plan"
+	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/service"
+)
+
+// ApplyParams contains all inputs for converging a manifest.
+type ApplyParams struct {
+	Manifest *Manifest
+	Changes  []Change // from Diff
+	Store    config.Store
+
+	// OnChange is called before each change is applied.
+	OnChange   func(c Change)
+	OnProgress func(step, total int, message string)
+
+	// NewPlan, if non-nil, runs every change as a dry run recorded into the
+	// plan it returns.
+	NewPlan func(c Change) *plan.Plan
+}
+
+// Apply runs project.Setup or service.Deploy for each change, in order. It
+// stops at the first failure.
+func Apply(params ApplyParams) error {
+	for _, c := range params.Changes {
+		mp := params.Manifest.find(c.Project)
+		if mp == nil {
+			return fmt.Errorf("project %q is not in the manifest", c.Project)
+		}
+		env := mp.Environments[c.Env]
+
+		if params.OnChange != nil {
+			params.OnChange(c)
+		}
+		var p *plan.Plan
+		if params.NewPlan != nil {
+			p = params.NewPlan(c)
+		}
+
+		var err error
+		if mp.IsService() {
+			if mp.ComposeFile == "" {
+				return fmt.Errorf("service %q: compose_file is required to deploy", mp.Name)
+			}
+			err = service.Deploy(service.DeployParams{
+				ServiceName: mp.Name,
+				ServerName:  mp.Server,
+				Domain:      env.Domain,
+				Port:        env.Port,
+				ComposeFile: mp.ComposeFile,
+				DNSProvider: env.DNSProvider,
+				Store:       params.Store,
+				OnProgress:  params.OnProgress,
+				Plan:        p,
+			})
+		} else {
+			err = project.Setup(project.SetupParams{
+				ProjectName: mp.Name,
+				Repo:        mp.Repo,
+				ServerName:  mp.Server,
+				EnvName:     c.Env,
+				Domain:      env.Domain,
+				Port:        env.Port,
+				Branch:      env.Branch,
+				DNSProvider: env.DNSProvider,
+				Store:       params.Store,
+
+				ComposeTemplate: env.Template,
+				ContainerPort:   env.ContainerPort,
+				HealthcheckPath: env.HealthcheckPath,
+				ComposeFile:     env.RepoComposeFile,
+				Registry:        mp.Registry,
+
+				OnProgress: params.OnProgress,
+				Plan:       p,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s/%s: %w", c.Action, c.Project, c.Env, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) find(name string) *Project {
+	for i := range m.Projects {
+		if m.Projects[i].Name == name {
+			return &m.Projects[i]
+		}
+	}
+	return nil
+}
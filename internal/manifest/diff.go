@@ -0,0 +1,199 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/project"
+)
+
+// Action says how apply converges an environment.
+type Action string
+
+const (
+	ActionCreate   Action = "create"   // not in the store yet
+	ActionUpdate   Action = "update"   // stored config differs from the manifest
+	ActionConverge Action = "converge" // stored config matches but the live state does not
+	ActionError    Action = "error"    // the live state could not be checked; apply skips it
+)
+
+// Change is one environment that apply would (re-)run setup for, or that
+// could not be checked.
+type Change struct {
+	Project string
+	Env     string
+	Action  Action
+	Reasons []string
+}
+
+// Result is the outcome of comparing a manifest with the current state.
+type Result struct {
+	Changes []Change
+	// Unmanaged lists "project/env" entries in the store that the manifest
+	// does not mention. Apply never removes them.
+	Unmanaged []string
+}
+
+// LiveCheck inspects an environment on the server and at the DNS provider
+// and returns a reason for every difference from what setup would produce.
+type LiveCheck func(p config.Project, envName string, env config.Environment) ([]string, error)
+
+// Diff compares m with cfg. If live is non-nil it is consulted for every
+// environment whose stored config already matches the manifest. An
+// environment that can't be checked is reported as an ActionError change
+// so that the others are still compared.
+func Diff(m *Manifest, cfg *config.Config, live LiveCheck) *Result {
+	res := &Result{}
+	declared := make(map[string]bool)
+
+	for _, mp := range m.Projects {
+		stored := cfg.FindProject(mp.Name)
+
+		for _, envName := range sortedEnvNames(mp.Environments) {
+			declared[mp.Name+"/"+envName] = true
+			want := mp.Environments[envName]
+
+			var have config.Environment
+			var ok bool
+			if stored != nil {
+				have, ok = stored.Environments[envName]
+			}
+			if !ok {
+				res.Changes = append(res.Changes, Change{
+					Project: mp.Name,
+					Env:     envName,
+					Action:  ActionCreate,
+					Reasons: []string{"not in store"},
+				})
+				continue
+			}
+
+			reasons := compare(mp, stored, envName, want, have)
+			if len(reasons) > 0 {
+				res.Changes = append(res.Changes, Change{Project: mp.Name, Env: envName, Action: ActionUpdate, Reasons: reasons})
+				continue
+			}
+
+			if live == nil {
+				continue
+			}
+			reasons, err := live(*stored, envName, have)
+			if err != nil {
+				res.Changes = append(res.Changes, Change{Project: mp.Name, Env: envName, Action: ActionError, Reasons: []string{err.Error()}})
+				continue
+			}
+			if len(reasons) > 0 {
+				res.Changes = append(res.Changes, Change{Project: mp.Name, Env: envName, Action: ActionConverge, Reasons: reasons})
+			}
+		}
+	}
+
+	for _, p := range cfg.Projects {
+		for _, envName := range sortedEnvNames(p.Environments) {
+			if !declared[p.Name+"/"+envName] {
+				res.Unmanaged = append(res.Unmanaged, p.Name+"/"+envName)
+			}
+		}
+	}
+
+	return res
+}
+
+// compare returns a reason for every declared field that differs from the
+// stored project and environment.
+func compare(mp Project, stored *config.Project, envName string, want Environment, have config.Environment) []string {
+	var reasons []string
+	diff := func(field, from, to string) {
+		if from != to {
+			reasons = append(reasons, fmt.Sprintf("%s: %q → %q", field, from, to))
+		}
+	}
+
+	diff("repo", stored.Repo, mp.Repo)
+	diff("server", stored.Server, mp.Server)
+	if mp.Registry != "" {
+		haveRegistry := stored.Registry
+		if haveRegistry == "" {
+			haveRegistry = "dockerhub"
+		}
+		diff("registry", haveRegistry, mp.Registry)
+	}
+	diff("domain", have.Domain, want.Domain)
+	diff("port", fmt.Sprint(have.Port), fmt.Sprint(want.Port))
+	if want.Branch != "" || !mp.IsService() {
+		diff("branch", have.Branch, wantBranch(mp, envName, want))
+	}
+	if want.DNSProvider != "" {
+		diff("dns_provider", have.DNSProvider, want.DNSProvider)
+	}
+	if want.Template != "" || want.RepoComposeFile != "" {
+		diff("repo_compose_file", have.ComposeFile, want.RepoComposeFile)
+	}
+	if want.Template != "" {
+		haveTemplate := have.ComposeTemplate
+		if haveTemplate == "" {
+			haveTemplate = project.DefaultComposeTemplate
+		}
+		diff("template", haveTemplate, want.Template)
+	}
+	if want.ContainerPort != 0 {
+		diff("container_port", fmt.Sprint(containerPort(have.ContainerPort)), fmt.Sprint(want.ContainerPort))
+	}
+	if want.HealthcheckPath != "" {
+		diff("healthcheck_path", have.HealthcheckPath, want.HealthcheckPath)
+	}
+	return reasons
+}
+
+// containerPort applies the default of 80 for environments stored before
+// container ports were recorded.
+func containerPort(port int) int {
+	if port == 0 {
+		return 80
+	}
+	return port
+}
+
+// wantBranch applies the same default project.Setup does.
+func wantBranch(mp Project, envName string, env Environment) string {
+	if env.Branch != "" || mp.IsService() {
+		return env.Branch
+	}
+	if envName == "prod" {
+		return "main"
+	}
+	return "dev"
+}
+
+func sortedEnvNames[T any](envs map[string]T) []string {
+	names := make([]string, 0, len(envs))
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pending returns the changes apply would make, leaving out the
+// environments that could not be checked.
+func (r *Result) Pending() []Change {
+	var pending []Change
+	for _, c := range r.Changes {
+		if c.Action != ActionError {
+			pending = append(pending, c)
+		}
+	}
+	return pending
+}
+
+// Failed returns the environments that could not be checked.
+func (r *Result) Failed() []Change {
+	var failed []Change
+	for _, c := range r.Changes {
+		if c.Action == ActionError {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
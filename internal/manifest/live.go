@@ -0,0 +1,53 @@
+package manifest
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/drift"
+)
+
+// NewLiveCheck returns a LiveCheck backed by the drift checks. Drift is
+// reported as reasons so that apply re-runs setup for it. A check that could
+// not run, such as one against an unreachable server, is an error instead:
+// re-running setup would fail the same way.
+func NewLiveCheck(cfg *config.Config, store config.Store) LiveCheck {
+	return liveCheck(drift.NewChecker(cfg, store))
+}
+
+func liveCheck(checker *drift.Checker) LiveCheck {
+	return func(p config.Project, envName string, env config.Environment) ([]string, error) {
+		p.Environments = map[string]config.Environment{envName: env}
+
+		var reasons []string
+		// One failure, such as an SSH dial error, usually fails several
+		// checks; name them all next to it once.
+		var failures []string
+		failedChecks := map[string][]string{}
+		for _, r := range checker.CheckEnvironment(p, envName) {
+			// Project containers are started by CI, not by setup, so a
+			// missing container is not something apply can fix.
+			if r.Check == drift.CheckPort && p.Repo != "" {
+				continue
+			}
+			switch r.Status {
+			case drift.StatusDrift:
+				reasons = append(reasons, r.Check+": "+r.Detail)
+			case drift.StatusError:
+				if _, ok := failedChecks[r.Detail]; !ok {
+					failures = append(failures, r.Detail)
+				}
+				failedChecks[r.Detail] = append(failedChecks[r.Detail], r.Check)
+			}
+		}
+		if len(failures) > 0 {
+			msgs := make([]string, len(failures))
+			for i, detail := range failures {
+				msgs[i] = strings.Join(failedChecks[detail], ", ") + ": " + detail
+			}
+			return nil, errors.New(strings.Join(msgs, "; "))
+		}
+		return reasons, nil
+	}
+}
@@ -0,0 +1,64 @@
+package manifest
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/drift"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// staticDNS is a read-only DNS provider with a fixed zone.
+type staticDNS struct {
+	dns.Provider
+	records []dns.DNSRecord
+}
+
+func (s staticDNS) Name() string { return "porkbun" }
+
+func (s staticDNS) ListRecords(domain string) ([]dns.DNSRecord, error) { return s.records, nil }
+
+func TestLiveCheckUnreachableServer(t *testing.T) {
+	cfg := &config.Config{Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}}}
+	p := config.Project{Name: "kuma", Server: "web1"}
+	env := config.Environment{Domain: "status.example.com", DeployPath: "/opt/kuma", DeployUser: "peon", Port: 3100}
+
+	checker := &drift.Checker{
+		Config: cfg,
+		Dial:   func(*config.Server) (remote.Runner, error) { return nil, errors.New("connection refused") },
+		DNS: func(string, config.Environment) (dns.Provider, error) {
+			return staticDNS{records: []dns.DNSRecord{{Name: "status.example.com", Type: "A", Content: "5.6.7.8"}}}, nil
+		},
+		RootDomain: func(string) (string, error) { return "example.com", nil },
+	}
+
+	reasons, err := liveCheck(checker)(p, "prod", env)
+	want := "caddy, port, exposed, deploy_user, deploy_path: connection refused"
+	if err == nil || err.Error() != want {
+		t.Fatalf("err = %v, want %q", err, want)
+	}
+	if reasons != nil {
+		t.Errorf("reasons = %q, want none alongside the error", reasons)
+	}
+
+	// With the server reachable again, only real drift is left.
+	checker.Dial = func(*config.Server) (remote.Runner, error) { return okServer{}, nil }
+	reasons, err = liveCheck(checker)(p, "prod", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(reasons, "dns: A record points to 5.6.7.8, not 1.2.3.4") {
+		t.Errorf("reasons = %q", reasons)
+	}
+}
+
+// okServer is a server on which every command succeeds with no output.
+type okServer struct{}
+
+func (okServer) Run(command string) error              { return nil }
+func (okServer) Output(command string) (string, error) { return "", nil }
+func (okServer) WriteFile(path, content string) error  { return errors.New("read-only") }
+func (okServer) Close() error                          { return nil }
@@ -0,0 +1,192 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the desired state of every project, as checked into arnor.yaml.
+type Manifest struct {
+	Projects []Project `yaml:"projects"`
+}
+
+// Project mirrors config.Project. A project without a repo is a service
+// deployed from a local compose file, as with `arnor service deploy`.
+type Project struct {
+	Name        string `yaml:"name"`
+	Repo        string `yaml:"repo,omitempty"`
+	Server      string `yaml:"server"`
+	ComposeFile string `yaml:"compose_file,omitempty"` // services only, relative to the manifest
+	// Registry is where a project's images are pushed: dockerhub, ghcr or
+	// a registry host. Left out, the stored choice (or DockerHub) stays.
+	Registry     string                 `yaml:"registry,omitempty"`
+	Environments map[string]Environment `yaml:"environments"`
+}
+
+// Environment mirrors the user-facing fields of config.Environment. Deploy
+// path and user are derived from the project name and are not declared.
+type Environment struct {
+	Domain      string `yaml:"domain"`
+	Port        int    `yaml:"port"`
+	Branch      string `yaml:"branch,omitempty"`
+	DNSProvider string `yaml:"dns_provider,omitempty"`
+
+	// Template, ContainerPort and HealthcheckPath choose the generated
+	// docker-compose.yml; projects only. Left out, the stored choice (or
+	// web on port 80 without a healthcheck) stays.
+	Template        string `yaml:"template,omitempty"`
+	ContainerPort   int    `yaml:"container_port,omitempty"`
+	HealthcheckPath string `yaml:"healthcheck_path,omitempty"`
+	// RepoComposeFile is a compose file in the project's repo to deploy
+	// instead of a template.
+	RepoComposeFile string `yaml:"repo_compose_file,omitempty"`
+}
+
+// IsService reports whether the project is a compose service rather than a
+// GitHub-backed project.
+func (p Project) IsService() bool {
+	return p.Repo == ""
+}
+
+// Load reads and validates a manifest. Relative compose_file paths are
+// resolved against the manifest's directory.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range m.Projects {
+		if cf := m.Projects[i].ComposeFile; cf != "" && !filepath.IsAbs(cf) {
+			m.Projects[i].ComposeFile = filepath.Join(dir, cf)
+		}
+	}
+	return m, nil
+}
+
+// Parse decodes and validates manifest YAML.
+func Parse(data []byte) (*Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks that every project is complete and names are unique.
+func (m *Manifest) Validate() error {
+	seen := make(map[string]bool)
+	for _, p := range m.Projects {
+		if p.Name == "" {
+			return fmt.Errorf("project with no name")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("project %q declared twice", p.Name)
+		}
+		seen[p.Name] = true
+		if p.Server == "" {
+			return fmt.Errorf("project %q: server is required", p.Name)
+		}
+		if len(p.Environments) == 0 {
+			return fmt.Errorf("project %q: at least one environment is required", p.Name)
+		}
+		if p.Registry != "" {
+			if p.IsService() {
+				return fmt.Errorf("project %q: registry doesn't apply to services", p.Name)
+			}
+			if err := registry.Validate(p.Registry); err != nil {
+				return fmt.Errorf("project %q: %w", p.Name, err)
+			}
+		}
+		for envName, env := range p.Environments {
+			if p.IsService() && envName != "prod" {
+				return fmt.Errorf("project %q: services only have a prod environment, got %q", p.Name, envName)
+			}
+			if !p.IsService() && envName != "dev" && envName != "prod" {
+				return fmt.Errorf("project %q: environment must be dev or prod, got %q", p.Name, envName)
+			}
+			if env.Domain == "" {
+				return fmt.Errorf("project %q %s: domain is required", p.Name, envName)
+			}
+			if env.Port <= 0 {
+				return fmt.Errorf("project %q %s: port is required", p.Name, envName)
+			}
+			if env.Template != "" || env.ContainerPort != 0 || env.HealthcheckPath != "" || env.RepoComposeFile != "" {
+				if p.IsService() {
+					return fmt.Errorf("project %q %s: template, container_port, healthcheck_path and repo_compose_file don't apply to services", p.Name, envName)
+				}
+				if env.RepoComposeFile != "" && (env.Template != "" || env.ContainerPort != 0 || env.HealthcheckPath != "") {
+					return fmt.Errorf("project %q %s: repo_compose_file replaces template, container_port and healthcheck_path", p.Name, envName)
+				}
+				if _, err := project.LookupComposeTemplate(env.Template); err != nil {
+					return fmt.Errorf("project %q %s: %w", p.Name, envName, err)
+				}
+				if err := project.ValidateHealthcheckPath(env.HealthcheckPath); err != nil {
+					return fmt.Errorf("project %q %s: %w", p.Name, envName, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Marshal encodes the manifest as YAML.
+func (m *Manifest) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FromConfig builds a manifest describing every project in cfg, sorted by name.
+func FromConfig(cfg *config.Config) *Manifest {
+	m := &Manifest{}
+	for _, p := range cfg.Projects {
+		mp := Project{
+			Name:         p.Name,
+			Repo:         p.Repo,
+			Server:       p.Server,
+			Registry:     p.Registry,
+			Environments: make(map[string]Environment, len(p.Environments)),
+		}
+		for envName, env := range p.Environments {
+			mp.Environments[envName] = Environment{
+				Domain:      env.Domain,
+				Port:        env.Port,
+				Branch:      env.Branch,
+				DNSProvider: env.DNSProvider,
+
+				Template:        env.ComposeTemplate,
+				ContainerPort:   env.ContainerPort,
+				HealthcheckPath: env.HealthcheckPath,
+				RepoComposeFile: env.ComposeFile,
+			}
+		}
+		m.Projects = append(m.Projects, mp)
+	}
+	sort.Slice(m.Projects, func(i, j int) bool { return m.Projects[i].Name < m.Projects[j].Name })
+	return m
+}
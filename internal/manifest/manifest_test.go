@@ -0,0 +1,173 @@
+package manifest
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+const sample = `projects:
+  - name: myapp
+    repo: github.com/org/myapp
+    server: web1
+    registry: dockerhub
+    environments:
+      dev:
+        domain: myapp.angmar.dev
+        port: 3001
+      prod:
+        domain: myapp.com
+        port: 3000
+        dns_provider: cloudflare
+  - name: kuma
+    server: web1
+    compose_file: services/kuma.yml
+    environments:
+      prod:
+        domain: status.example.com
+        port: 3100
+`
+
+func TestParseValidates(t *testing.T) {
+	if _, err := Parse([]byte(sample)); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	bad := map[string]string{
+		"unknown field":        "projects:\n  - name: a\n    server: s\n    colour: red\n",
+		"missing server":       "projects:\n  - name: a\n    environments:\n      dev: {domain: a.dev, port: 1}\n",
+		"bad env name":         "projects:\n  - name: a\n    repo: o/a\n    server: s\n    environments:\n      staging: {domain: a.dev, port: 1}\n",
+		"service dev env":      "projects:\n  - name: a\n    server: s\n    environments:\n      dev: {domain: a.dev, port: 1}\n",
+		"missing port":         "projects:\n  - name: a\n    repo: o/a\n    server: s\n    environments:\n      dev: {domain: a.dev}\n",
+		"bad template":         "projects:\n  - name: a\n    repo: o/a\n    server: s\n    environments:\n      dev: {domain: a.dev, port: 1, template: web+mysql}\n",
+		"template and file":    "projects:\n  - name: a\n    repo: o/a\n    server: s\n    environments:\n      dev: {domain: a.dev, port: 1, template: web, repo_compose_file: compose.yml}\n",
+		"unknown registry":     "projects:\n  - name: a\n    repo: o/a\n    server: s\n    registry: quay\n    environments:\n      dev: {domain: a.dev, port: 1}\n",
+		"service registry":     "projects:\n  - name: a\n    server: s\n    registry: ghcr\n    environments:\n      prod: {domain: a.dev, port: 1}\n",
+		"bad healthcheck":      "projects:\n  - name: a\n    repo: o/a\n    server: s\n    environments:\n      dev: {domain: a.dev, port: 1, healthcheck_path: healthz}\n",
+		"service healthcheck":  "projects:\n  - name: a\n    server: s\n    environments:\n      prod: {domain: a.dev, port: 1, healthcheck_path: /healthz}\n",
+		"healthcheck and file": "projects:\n  - name: a\n    repo: o/a\n    server: s\n    environments:\n      dev: {domain: a.dev, port: 1, healthcheck_path: /up, repo_compose_file: compose.yml}\n",
+	}
+	for name, doc := range bad {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	cfg := &config.Config{Projects: []config.Project{{
+		Name: "myapp", Repo: "github.com/org/myapp", Server: "web1", Registry: "ghcr",
+		Environments: map[string]config.Environment{
+			"dev": {Domain: "myapp.angmar.dev", DNSProvider: "porkbun", Branch: "dev", DeployPath: "/opt/myapp-dev", DeployUser: "myapp-dev-deploy", Port: 3001},
+		},
+	}}}
+
+	data, err := FromConfig(cfg).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse exported manifest: %v\n%s", err, data)
+	}
+
+	res := Diff(m, cfg, nil)
+	if len(res.Changes) != 0 || len(res.Unmanaged) != 0 {
+		t.Errorf("exported manifest should match its source, got %+v", res)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	m, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	cfg := &config.Config{Projects: []config.Project{
+		{
+			Name: "myapp", Repo: "github.com/org/myapp", Server: "web1",
+			Environments: map[string]config.Environment{
+				"dev":  {Domain: "myapp.angmar.dev", DNSProvider: "porkbun", Branch: "dev", Port: 3001},
+				"prod": {Domain: "myapp.com", DNSProvider: "cloudflare", Branch: "main", Port: 3002},
+			},
+		},
+		{
+			Name: "legacy", Server: "web1",
+			Environments: map[string]config.Environment{"prod": {Domain: "old.example.com", Port: 4000}},
+		},
+	}}
+
+	live := func(p config.Project, envName string, env config.Environment) ([]string, error) {
+		if p.Name == "myapp" && envName == "dev" {
+			return []string{"no A record for myapp.angmar.dev"}, nil
+		}
+		return nil, nil
+	}
+
+	res := Diff(m, cfg, live)
+
+	var got []string
+	for _, c := range res.Changes {
+		got = append(got, string(c.Action)+" "+c.Project+"/"+c.Env+": "+strings.Join(c.Reasons, "; "))
+	}
+	want := []string{
+		"converge myapp/dev: no A record for myapp.angmar.dev",
+		`update myapp/prod: port: "3002" → "3000"`,
+		"create kuma/prod: not in store",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got %q\nwant %q", got, want)
+	}
+	if !reflect.DeepEqual(res.Unmanaged, []string{"legacy/prod"}) {
+		t.Errorf("unmanaged = %v, want [legacy/prod]", res.Unmanaged)
+	}
+}
+
+func TestDiffLiveCheckError(t *testing.T) {
+	m, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	cfg := &config.Config{Projects: []config.Project{{
+		Name: "myapp", Repo: "github.com/org/myapp", Server: "web1",
+		Environments: map[string]config.Environment{
+			"dev":  {Domain: "myapp.angmar.dev", Branch: "dev", Port: 3001},
+			"prod": {Domain: "myapp.com", DNSProvider: "cloudflare", Branch: "main", Port: 3000},
+		},
+	}}}
+
+	// dev's server is unreachable; prod must still be checked.
+	live := func(p config.Project, envName string, env config.Environment) ([]string, error) {
+		if envName == "dev" {
+			return nil, errors.New("SSH dial to web1: connection refused")
+		}
+		return []string{"no A record for myapp.com"}, nil
+	}
+
+	res := Diff(m, cfg, live)
+	var got []string
+	for _, c := range res.Changes {
+		got = append(got, string(c.Action)+" "+c.Project+"/"+c.Env+": "+strings.Join(c.Reasons, "; "))
+	}
+	want := []string{
+		"error myapp/dev: SSH dial to web1: connection refused",
+		"converge myapp/prod: no A record for myapp.com",
+		"create kuma/prod: not in store",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got %q\nwant %q", got, want)
+	}
+
+	var pending []string
+	for _, c := range res.Pending() {
+		pending = append(pending, c.Project+"/"+c.Env)
+	}
+	if !reflect.DeepEqual(pending, []string{"myapp/prod", "kuma/prod"}) {
+		t.Errorf("pending = %v", pending)
+	}
+	if failed := res.Failed(); len(failed) != 1 || failed[0].Env != "dev" {
+		t.Errorf("failed = %+v", failed)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/remote"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -80,25 +82,14 @@ func buildAuthMethods(auth SSHAuth) ([]ssh.AuthMethod, error) {
 
 // RunRemote connects to the remote host via SSH and executes the embedded
 // peon.sh bootstrap script. It returns the peon private key extracted from
-// the script output.
-func RunRemote(host, user string, auth SSHAuth) (string, error) {
+// the script output. The host key is pinned in the Store on first contact.
+func RunRemote(host, user string, auth SSHAuth, store config.Store) (string, error) {
 	methods, err := buildAuthMethods(auth)
 	if err != nil {
 		return "", err
 	}
 
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            methods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-
-	addr := host
-	if !strings.Contains(host, ":") {
-		addr = host + ":22"
-	}
-
-	client, err := ssh.Dial("tcp", addr, config)
+	client, err := remote.Dial(host, user, methods, store)
 	if err != nil {
 		return "", fmt.Errorf("SSH connect failed: %w", err)
 	}
@@ -0,0 +1,160 @@
+package peon
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/remote"
+	"golang.org/x/crypto/ssh"
+)
+
+const authorizedKeys = "/home/peon/.ssh/authorized_keys"
+
+// RotateParams contains all inputs for rotating a server's peon key.
+type RotateParams struct {
+	ServerIP   string
+	Store      config.Store
+	OnProgress func(step, total int, message string)
+}
+
+// RotateResult describes a completed rotation.
+type RotateResult struct {
+	KeyPath     string // local copy of the new private key
+	Fingerprint string // SHA256 fingerprint of the new public key
+	// OldKeyKept is set if the old key could not be removed from
+	// authorized_keys. The new key is in use either way.
+	OldKeyKept error
+}
+
+// GenerateKey returns a new ed25519 key as an OpenSSH PEM private key and
+// its public key.
+func GenerateKey(comment string) (string, ssh.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", nil, err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(string(pem.EncodeToMemory(block))), sshPub, nil
+}
+
+// Rotate replaces the peon key of a server. The new key is added to
+// authorized_keys over the existing connection and a fresh login with it is
+// verified before anything is changed locally. Only once the store holds the
+// new key is the old one removed from the server, so a failure at any step
+// leaves a key in the store that still logs in.
+func Rotate(params RotateParams) (*RotateResult, error) {
+	const totalSteps = 5
+	report := func(step int, message string) {
+		if params.OnProgress != nil {
+			params.OnProgress(step, totalSteps, message)
+		}
+	}
+
+	oldKey, err := params.Store.GetPeonKey(params.ServerIP)
+	if err != nil {
+		return nil, fmt.Errorf("no peon key for %s: %w", params.ServerIP, err)
+	}
+	oldSigner, err := ssh.ParsePrivateKey([]byte(oldKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing current peon key: %w", err)
+	}
+
+	// Step 1: Connect with the current key
+	report(1, "Connecting with the current key...")
+	client, err := remote.DialPeon(params.ServerIP, oldKey, params.Store)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// Step 2: Generate and install the new key
+	report(2, "Installing a new ed25519 key...")
+	comment := fmt.Sprintf("peon@arnor-%s", time.Now().UTC().Format("20060102"))
+	newKey, newPub, err := GenerateKey(comment)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newPub))) + " " + comment
+	if err := appendAuthorizedKey(client, line); err != nil {
+		return nil, fmt.Errorf("installing new key: %w", err)
+	}
+	rollback := func(cause error) error {
+		if err := removeAuthorizedKey(client, newPub); err != nil {
+			return fmt.Errorf("%w (removing the new key from authorized_keys also failed: %v)", cause, err)
+		}
+		return fmt.Errorf("%w; the new key was removed and the current key is unchanged", cause)
+	}
+
+	// Step 3: Verify a login with the new key
+	report(3, "Verifying login with the new key...")
+	verify, err := remote.DialPeon(params.ServerIP, newKey, params.Store)
+	if err == nil {
+		err = verify.Run("true")
+		verify.Close()
+	}
+	if err != nil {
+		return nil, rollback(fmt.Errorf("logging in with the new key: %w", err))
+	}
+
+	// Step 4: Save the new key locally
+	report(4, "Saving the new key...")
+	saved, err := SavePeonKey(params.ServerIP, newKey, params.Store)
+	if err != nil {
+		return nil, rollback(err)
+	}
+	result := &RotateResult{KeyPath: saved.KeyPath, Fingerprint: ssh.FingerprintSHA256(newPub)}
+
+	// Step 5: Remove the old key from the server
+	report(5, "Removing the old key...")
+	if err := removeAuthorizedKey(client, oldSigner.PublicKey()); err != nil {
+		result.OldKeyKept = err
+	}
+	return result, nil
+}
+
+// appendAuthorizedKey adds line to peon's authorized_keys. A file whose last
+// line has no newline gets one first, or the key would be glued onto that
+// line and removing either key later would take out both.
+func appendAuthorizedKey(client *remote.Client, line string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(line + "\n")
+	out, err := session.CombinedOutput(fmt.Sprintf(
+		`{ [ ! -s %[1]s ] || [ -z "$(tail -c 1 %[1]s)" ] || echo; cat; } >> %[1]s`,
+		authorizedKeys,
+	))
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeAuthorizedKey drops every line for key from peon's authorized_keys.
+// The file is rewritten through a temporary copy so a failure part way
+// leaves it intact.
+func removeAuthorizedKey(client *remote.Client, key ssh.PublicKey) error {
+	// The base64 blob only contains [A-Za-z0-9+/=], so it is safe to quote.
+	blob := base64.StdEncoding.EncodeToString(key.Marshal())
+	// grep exits 1 when no lines are left, which is fine; anything else
+	// (e.g. a missing file) must not replace authorized_keys.
+	return client.Run(fmt.Sprintf(
+		"{ grep -vF '%[2]s' %[1]s || [ $? -eq 1 ]; } > %[1]s.tmp && chmod 600 %[1]s.tmp && mv %[1]s.tmp %[1]s",
+		authorizedKeys, blob,
+	))
+}
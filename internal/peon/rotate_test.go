@@ -0,0 +1,286 @@
+package peon
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKey(t *testing.T) {
+	pemKey, pub, err := GenerateKey("peon@test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(pemKey))
+	if err != nil {
+		t.Fatalf("parsing generated key: %v", err)
+	}
+	if pub.Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("key type = %s", pub.Type())
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+		t.Error("public key does not match the private key")
+	}
+}
+
+// fakePeonServer is an in-process SSH server standing in for a server's
+// peon account. It accepts the keys listed in a local authorized_keys file
+// and runs commands with sh, pointed at that file instead of peon's.
+type fakePeonServer struct {
+	addr           string
+	authorizedKeys string
+	// fail makes commands it matches exit 1 without running.
+	fail func(command string) bool
+}
+
+func newFakePeonServer(t *testing.T, authorized string) *fakePeonServer {
+	t.Helper()
+	s := &fakePeonServer{
+		authorizedKeys: filepath.Join(t.TempDir(), "authorized_keys"),
+		fail:           func(string) bool { return false },
+	}
+	if err := os.WriteFile(s.authorizedKeys, []byte(authorized), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			data, _ := os.ReadFile(s.authorizedKeys)
+			if strings.Contains(string(data), base64.StdEncoding.EncodeToString(key.Marshal())) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key not authorized")
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *fakePeonServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				status := 1
+				if !s.fail(payload.Command) {
+					command := strings.ReplaceAll(payload.Command, authorizedKeys, s.authorizedKeys)
+					cmd := exec.Command("sh", "-c", command)
+					cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+					status = 0
+					if err := cmd.Run(); err != nil {
+						status = 1
+					}
+				}
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+				return
+			}
+		}()
+	}
+}
+
+func (s *fakePeonServer) keys(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(s.authorizedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// failingStore refuses to store peon keys.
+type failingStore struct {
+	*config.SQLiteStore
+}
+
+func (failingStore) SetPeonKey(serverIP, privateKey, keyPath string) error {
+	return errors.New("database is locked")
+}
+
+// setupRotate returns a server whose authorized_keys holds the current peon
+// key, written without a trailing newline, and a store holding that key.
+func setupRotate(t *testing.T) (*fakePeonServer, *config.SQLiteStore, ssh.PublicKey) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	if err := os.Mkdir(filepath.Join(os.Getenv("HOME"), ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey, oldPub, err := GenerateKey("peon@old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakePeonServer(t, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(oldPub)))+" peon@old")
+
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.SetPeonKey(srv.addr, oldKey, ""); err != nil {
+		t.Fatal(err)
+	}
+	return srv, store, oldPub
+}
+
+func blob(key ssh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+func TestRotate(t *testing.T) {
+	srv, store, oldPub := setupRotate(t)
+
+	var steps []int
+	res, err := Rotate(RotateParams{
+		ServerIP:   srv.addr,
+		Store:      store,
+		OnProgress: func(step, total int, message string) { steps = append(steps, step) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OldKeyKept != nil {
+		t.Errorf("OldKeyKept = %v", res.OldKeyKept)
+	}
+	if !reflect.DeepEqual(steps, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("steps = %v", steps)
+	}
+
+	// The store and the local copy hold the new key, under the host name
+	// without its port.
+	newKey, err := store.GetPeonKey("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ssh.FingerprintSHA256(signer.PublicKey()); got != res.Fingerprint {
+		t.Errorf("stored key %s, want %s", got, res.Fingerprint)
+	}
+	file, err := os.ReadFile(res.KeyPath)
+	if err != nil || string(file) != newKey+"\n" {
+		t.Errorf("key file = %q, %v", file, err)
+	}
+
+	// Only the new key is left, on its own line.
+	keys := srv.keys(t)
+	lines := strings.Split(strings.TrimSuffix(keys, "\n"), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], blob(signer.PublicKey())) || strings.Contains(keys, blob(oldPub)) {
+		t.Errorf("authorized_keys =\n%s", keys)
+	}
+}
+
+func TestRotateRollsBackWhenLoginFails(t *testing.T) {
+	srv, store, oldPub := setupRotate(t)
+	srv.fail = func(command string) bool { return command == "true" }
+	before := srv.keys(t)
+
+	_, err := Rotate(RotateParams{ServerIP: srv.addr, Store: store})
+	if err == nil || !strings.Contains(err.Error(), "the new key was removed") {
+		t.Fatalf("err = %v", err)
+	}
+	if after := srv.keys(t); strings.TrimSpace(after) != before {
+		t.Errorf("authorized_keys =\n%s\nwant\n%s", after, before)
+	}
+	if !strings.Contains(srv.keys(t), blob(oldPub)) {
+		t.Error("old key removed")
+	}
+	if _, err := store.GetPeonKey("127.0.0.1"); err == nil {
+		t.Error("new key stored")
+	}
+}
+
+func TestRotateRollsBackWhenStoreFails(t *testing.T) {
+	srv, store, _ := setupRotate(t)
+	before := srv.keys(t)
+	keyPath := filepath.Join(os.Getenv("HOME"), ".ssh", "peon_ed25519_127.0.0.1")
+	if err := os.WriteFile(keyPath, []byte("current\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Rotate(RotateParams{ServerIP: srv.addr, Store: failingStore{store}})
+	if err == nil || !strings.Contains(err.Error(), "database is locked") {
+		t.Fatalf("err = %v", err)
+	}
+	if after := srv.keys(t); strings.TrimSpace(after) != before {
+		t.Errorf("authorized_keys =\n%s\nwant\n%s", after, before)
+	}
+	if data, _ := os.ReadFile(keyPath); string(data) != "current\n" {
+		t.Errorf("key file = %q, want the previous one", data)
+	}
+}
+
+func TestRotateKeepsOldKeyWhenRemovalFails(t *testing.T) {
+	srv, store, oldPub := setupRotate(t)
+	srv.fail = func(command string) bool { return strings.Contains(command, blob(oldPub)) }
+
+	res, err := Rotate(RotateParams{ServerIP: srv.addr, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OldKeyKept == nil {
+		t.Error("OldKeyKept not set")
+	}
+	if _, err := store.GetPeonKey("127.0.0.1"); err != nil {
+		t.Errorf("new key not stored: %v", err)
+	}
+	if !strings.Contains(srv.keys(t), blob(oldPub)) {
+		t.Error("old key removed")
+	}
+}
@@ -15,7 +15,9 @@ type SaveResult struct {
 }
 
 // SavePeonKey writes the peon private key to ~/.ssh/peon_ed25519_<host> and
-// stores the key content + path in the Store.
+// stores the key content + path in the Store. If the Store can't be updated
+// the previous key file is put back, so the file never holds a key the
+// Store doesn't.
 func SavePeonKey(host, key string, store config.Store) (*SaveResult, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -30,12 +32,19 @@ func SavePeonKey(host, key string, store config.Store) (*SaveResult, error) {
 
 	keyFile := fmt.Sprintf("peon_ed25519_%s", hostName)
 	keyPath := filepath.Join(home, ".ssh", keyFile)
+	prev, prevErr := os.ReadFile(keyPath)
 	if err := os.WriteFile(keyPath, []byte(key+"\n"), 0600); err != nil {
 		return nil, fmt.Errorf("failed to write key to %s: %w", keyPath, err)
 	}
 
 	// Store key in the database.
 	if err := store.SetPeonKey(hostName, key, keyPath); err != nil {
+		switch {
+		case prevErr == nil:
+			os.WriteFile(keyPath, prev, 0600)
+		case os.IsNotExist(prevErr):
+			os.Remove(keyPath)
+		}
 		return nil, fmt.Errorf("failed to store peon key: %w", err)
 	}
 
@@ -0,0 +1,222 @@
+package plan
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// RemoteOp is one operation against a server: either a command or a file write.
+type RemoteOp struct {
+	Host    string
+	Command string
+	Path    string // non-empty for file writes
+	Content string
+}
+
+// DNSChange is a record that would be created, changed or deleted.
+type DNSChange struct {
+	Action  string // "create", "update", "upsert" or "delete"
+	Domain  string
+	Name    string
+	Type    string
+	Content string
+	TTL     string
+}
+
+// RepoFile is a file that would be committed to a GitHub repository.
+type RepoFile struct {
+	Repo    string
+	Path    string
+	Branch  string
+	Content string
+}
+
+// Plan collects every change a dry run would have made, in the order the
+// orchestration would have made them.
+type Plan struct {
+	Title     string
+	Remote    []RemoteOp
+	Reads     []RemoteOp // probes such as "test -f" that change nothing; not rendered
+	DNS       []DNSChange
+	Secrets   []string // "repo: NAME" — values are never recorded
+	RepoFiles []RepoFile
+	External  []string // other API calls, e.g. DockerHub repo creation
+	Config    []string
+}
+
+// New returns an empty plan with a heading used when rendering.
+func New(title string) *Plan {
+	return &Plan{Title: title}
+}
+
+func (p *Plan) AddCommand(host, command string) {
+	p.Remote = append(p.Remote, RemoteOp{Host: host, Command: command})
+}
+
+// AddRead records a command that only inspects the server. It is kept out
+// of Remote so the plan lists only what would change.
+func (p *Plan) AddRead(host, command string) {
+	p.Reads = append(p.Reads, RemoteOp{Host: host, Command: command})
+}
+
+func (p *Plan) AddFile(host, path, content string) {
+	p.Remote = append(p.Remote, RemoteOp{Host: host, Path: path, Content: content})
+}
+
+func (p *Plan) AddDNS(c DNSChange) {
+	p.DNS = append(p.DNS, c)
+}
+
+func (p *Plan) AddSecret(repo, name string) {
+	p.Secrets = append(p.Secrets, repo+": "+name)
+}
+
+func (p *Plan) AddRepoFile(f RepoFile) {
+	p.RepoFiles = append(p.RepoFiles, f)
+}
+
+func (p *Plan) AddExternal(description string) {
+	p.External = append(p.External, description)
+}
+
+// SetEnvironment records the config rows that saving env under
+// projectName/envName would insert or update. registry is "" for projects
+// without one, such as services.
+func (p *Plan) SetEnvironment(cfg *config.Config, projectName, repo, server, registry, envName string, env config.Environment) {
+	existing := cfg.FindProject(projectName)
+	if existing == nil {
+		if registry != "" {
+			p.Config = append(p.Config, fmt.Sprintf("projects: insert %s (repo=%s, server=%s, registry=%s)", projectName, repo, server, registry))
+		} else {
+			p.Config = append(p.Config, fmt.Sprintf("projects: insert %s (repo=%s, server=%s)", projectName, repo, server))
+		}
+	} else {
+		if existing.Repo != repo {
+			p.Config = append(p.Config, fmt.Sprintf("projects: update %s repo: %s → %s", projectName, existing.Repo, repo))
+		}
+		if existing.Server != server {
+			p.Config = append(p.Config, fmt.Sprintf("projects: update %s server: %s → %s", projectName, existing.Server, server))
+		}
+		// Projects from before the registry was stored push to DockerHub.
+		if old := existing.Registry; registry != "" && old != registry && !(old == "" && registry == "dockerhub") {
+			p.Config = append(p.Config, fmt.Sprintf("projects: update %s registry: %s → %s", projectName, old, registry))
+		}
+	}
+
+	key := projectName + "/" + envName
+	var old config.Environment
+	var ok bool
+	if existing != nil {
+		old, ok = existing.Environments[envName]
+	}
+	if !ok {
+		p.Config = append(p.Config, fmt.Sprintf("environments: insert %s (domain=%s, dns_provider=%s, branch=%s, deploy_path=%s, deploy_user=%s, port=%d)",
+			key, env.Domain, env.DNSProvider, env.Branch, env.DeployPath, env.DeployUser, env.Port))
+		return
+	}
+
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"domain", old.Domain, env.Domain},
+		{"dns_provider", old.DNSProvider, env.DNSProvider},
+		{"branch", old.Branch, env.Branch},
+		{"deploy_path", old.DeployPath, env.DeployPath},
+		{"deploy_user", old.DeployUser, env.DeployUser},
+		{"port", fmt.Sprint(old.Port), fmt.Sprint(env.Port)},
+	}
+	changed := false
+	for _, f := range fields {
+		if f.old != f.new {
+			p.Config = append(p.Config, fmt.Sprintf("environments: update %s %s: %s → %s", key, f.name, f.old, f.new))
+			changed = true
+		}
+	}
+	if !changed {
+		p.Config = append(p.Config, fmt.Sprintf("environments: %s unchanged", key))
+	}
+}
+
+// Render writes a human-readable plan: a summary of every section followed by
+// the full content of each file that would be written.
+func (p *Plan) Render(w io.Writer) {
+	fmt.Fprintf(w, "── Plan: %s ──\n", p.Title)
+
+	if len(p.External) > 0 {
+		fmt.Fprintln(w, "\nExternal API calls")
+		for _, e := range p.External {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+
+	if len(p.Remote) > 0 {
+		fmt.Fprintln(w, "\nRemote commands")
+		for _, op := range p.Remote {
+			if op.Path != "" {
+				fmt.Fprintf(w, "  [%s] write %s\n", op.Host, op.Path)
+			} else {
+				fmt.Fprintf(w, "  [%s] $ %s\n", op.Host, op.Command)
+			}
+		}
+	}
+
+	if len(p.DNS) > 0 {
+		fmt.Fprintln(w, "\nDNS changes")
+		for _, c := range p.DNS {
+			sign := "+"
+			switch c.Action {
+			case "delete":
+				sign = "-"
+			case "update", "upsert":
+				sign = "~"
+			}
+			name := c.Name
+			if name == "" {
+				name = "@"
+			}
+			fmt.Fprintf(w, "  %s %s %s %s (%s, ttl %s)\n", sign, c.Type, name, c.Content, c.Domain, c.TTL)
+		}
+	}
+
+	if len(p.Secrets) > 0 {
+		fmt.Fprintln(w, "\nGitHub secrets to set")
+		for _, s := range p.Secrets {
+			fmt.Fprintf(w, "  %s\n", s)
+		}
+	}
+
+	if len(p.RepoFiles) > 0 {
+		fmt.Fprintln(w, "\nGitHub files to commit")
+		for _, f := range p.RepoFiles {
+			fmt.Fprintf(w, "  %s:%s (branch %s)\n", f.Repo, f.Path, f.Branch)
+		}
+	}
+
+	if len(p.Config) > 0 {
+		fmt.Fprintln(w, "\nConfig changes")
+		for _, c := range p.Config {
+			fmt.Fprintf(w, "  %s\n", c)
+		}
+	}
+
+	for _, op := range p.Remote {
+		if op.Path != "" {
+			renderFile(w, fmt.Sprintf("%s:%s", op.Host, op.Path), op.Content)
+		}
+	}
+	for _, f := range p.RepoFiles {
+		renderFile(w, fmt.Sprintf("%s:%s", f.Repo, f.Path), f.Content)
+	}
+}
+
+func renderFile(w io.Writer, label, content string) {
+	fmt.Fprintf(w, "\n── %s ──\n", label)
+	fmt.Fprint(w, content)
+	if !strings.HasSuffix(content, "\n") {
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,58 @@
+package plan
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+func TestSetEnvironment(t *testing.T) {
+	cfg := &config.Config{Projects: []config.Project{{
+		Name: "myapp",
+		Environments: map[string]config.Environment{
+			"prod": {Domain: "myapp.com", Port: 3000, DeployUser: "myapp-deploy"},
+		},
+	}}}
+
+	p := New("test")
+	p.SetEnvironment(cfg, "myapp", "", "", "dockerhub", "prod", config.Environment{Domain: "myapp.com", Port: 3001, DeployUser: "myapp-deploy"})
+	if len(p.Config) != 1 || !strings.Contains(p.Config[0], "port: 3000 → 3001") {
+		t.Errorf("update: got %v", p.Config)
+	}
+
+	p = New("test")
+	p.SetEnvironment(cfg, "other", "org/other", "web1", "ghcr", "dev", config.Environment{Domain: "other.dev"})
+	if len(p.Config) != 2 || !strings.HasPrefix(p.Config[0], "projects: insert other") || !strings.HasPrefix(p.Config[1], "environments: insert other/dev") {
+		t.Errorf("insert: got %v", p.Config)
+	}
+}
+
+func TestRender(t *testing.T) {
+	p := New("service deploy kuma")
+	p.AddCommand("1.2.3.4", "sudo mkdir -p /opt/kuma")
+	p.AddFile("1.2.3.4", "/etc/caddy/conf.d/kuma.example.com.caddy", "kuma.example.com {\n}")
+	p.AddDNS(DNSChange{Action: "delete", Domain: "example.com", Name: "kuma.example.com", Type: "A", Content: "5.6.7.8", TTL: "600"})
+	p.AddDNS(DNSChange{Action: "create", Domain: "example.com", Name: "kuma.example.com", Type: "A", Content: "1.2.3.4", TTL: "600"})
+	p.AddDNS(DNSChange{Action: "upsert", Domain: "example.com", Name: "www.kuma.example.com", Type: "CNAME", Content: "kuma.example.com", TTL: "600"})
+	p.AddSecret("org/kuma", "VPS_HOST")
+
+	var buf bytes.Buffer
+	p.Render(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"[1.2.3.4] $ sudo mkdir -p /opt/kuma",
+		"[1.2.3.4] write /etc/caddy/conf.d/kuma.example.com.caddy",
+		"- A kuma.example.com 5.6.7.8",
+		"+ A kuma.example.com 1.2.3.4",
+		"~ CNAME www.kuma.example.com kuma.example.com",
+		"org/kuma: VPS_HOST",
+		"── 1.2.3.4:/etc/caddy/conf.d/kuma.example.com.caddy ──\nkuma.example.com {\n}\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("render output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package project
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeTemplate is one of the docker-compose.yml layouts project setup
+// can generate.
+type ComposeTemplate struct {
+	Name        string
+	Description string
+	Static      bool // the image serves files; it gets no .env
+	Postgres    bool
+	Redis       bool
+}
+
+// DefaultComposeTemplate is used when an environment doesn't name one.
+const DefaultComposeTemplate = "web"
+
+// ComposeTemplates are the available templates, in the order they are
+// offered.
+var ComposeTemplates = []ComposeTemplate{
+	{Name: "web", Description: "the app container alone"},
+	{Name: "static", Description: "a static site image such as nginx; no app settings", Static: true},
+	{Name: "web+postgres", Description: "the app with a PostgreSQL database", Postgres: true},
+	{Name: "web+redis", Description: "the app with Redis", Redis: true},
+	{Name: "web+postgres+redis", Description: "the app with PostgreSQL and Redis", Postgres: true, Redis: true},
+}
+
+// LookupComposeTemplate finds a template by name; "" is the default.
+func LookupComposeTemplate(name string) (ComposeTemplate, error) {
+	if name == "" {
+		name = DefaultComposeTemplate
+	}
+	var names []string
+	for _, t := range ComposeTemplates {
+		if t.Name == name {
+			return t, nil
+		}
+		names = append(names, t.Name)
+	}
+	return ComposeTemplate{}, fmt.Errorf("unknown compose template %q (choose from %s)", name, strings.Join(names, ", "))
+}
+
+// ComposeParams are the inputs for RenderCompose.
+type ComposeParams struct {
+	Template      ComposeTemplate
+	DockerImage   string
+	Port          int // host port on localhost that Caddy proxies to
+	ContainerPort int // port the app listens on in its container; 0 means 80
+	// HealthcheckPath is requested inside the app container to tell
+	// whether it is healthy, e.g. "/healthz"; "" means no healthcheck.
+	HealthcheckPath string
+}
+
+var composeTmpl = template.Must(template.New("compose").Parse(`services:
+  web:
+    image: ${DOCKER_IMAGE:-{{ .DockerImage }}}
+    ports:
+      - "127.0.0.1:${LISTEN_PORT:-{{ .Port }}}:{{ .ContainerPort }}"
+{{- if not .Template.Static }}
+    env_file: .env
+{{- end }}
+{{- if or .Template.Postgres .Template.Redis }}
+    depends_on:
+{{- if .Template.Postgres }}
+      postgres:
+        condition: service_healthy
+{{- end }}
+{{- if .Template.Redis }}
+      redis:
+        condition: service_healthy
+{{- end }}
+{{- end }}
+{{- if .HealthcheckPath }}
+    healthcheck:
+      test: ["CMD-SHELL", "wget -q -O /dev/null http://localhost:{{ .ContainerPort }}{{ .HealthcheckPath }} || curl -fs -o /dev/null http://localhost:{{ .ContainerPort }}{{ .HealthcheckPath }} || exit 1"]
+      interval: 30s
+      timeout: 5s
+      retries: 3
+      start_period: 30s
+{{- end }}
+    restart: unless-stopped
+{{- if .Template.Postgres }}
+
+  postgres:
+    image: postgres:17-alpine
+    environment:
+      POSTGRES_USER: app
+      POSTGRES_DB: app
+      POSTGRES_PASSWORD: ${POSTGRES_PASSWORD:?set it with arnor env set}
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+    healthcheck:
+      test: ["CMD-SHELL", "pg_isready -U app -d app"]
+      interval: 10s
+      timeout: 5s
+      retries: 5
+    restart: unless-stopped
+{{- end }}
+{{- if .Template.Redis }}
+
+  redis:
+    image: redis:7-alpine
+    command: ["redis-server", "--appendonly", "yes"]
+    volumes:
+      - redisdata:/data
+    healthcheck:
+      test: ["CMD", "redis-cli", "ping"]
+      interval: 10s
+      timeout: 5s
+      retries: 5
+    restart: unless-stopped
+{{- end }}
+{{- if or .Template.Postgres .Template.Redis }}
+
+volumes:
+{{- if .Template.Postgres }}
+  pgdata:
+{{- end }}
+{{- if .Template.Redis }}
+  redisdata:
+{{- end }}
+{{- end }}
+`))
+
+// RenderCompose generates docker-compose.yml for a template. The app is
+// published on localhost only, so it is reachable through Caddy and not
+// directly on its port; databases aren't published at all. The app's
+// settings come from the .env that WriteEnvFile writes next to it. The app
+// only gets a healthcheck if it names a path to check, as not every image
+// answers on "/" or ships wget or curl.
+func RenderCompose(p ComposeParams) (string, error) {
+	if p.ContainerPort == 0 {
+		p.ContainerPort = 80
+	}
+	if err := ValidateHealthcheckPath(p.HealthcheckPath); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := composeTmpl.Execute(&buf, p); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var healthcheckPathRe = regexp.MustCompile(`^/[A-Za-z0-9._~/?=&%-]*$`)
+
+// ValidateHealthcheckPath checks that path is an absolute URL path that can
+// go into the healthcheck command as it is. "" is valid and means none.
+func ValidateHealthcheckPath(path string) error {
+	if path != "" && !healthcheckPathRe.MatchString(path) {
+		return fmt.Errorf("invalid healthcheck path %q: it must start with / and hold only URL path and query characters", path)
+	}
+	return nil
+}
+
+// ensureTemplateEnvVars stores the variables a template's services need
+// that the environment doesn't have yet: a generated database password, and
+// URLs the app can connect with. Existing values, such as the password of
+// a database that already holds data, are never replaced.
+func ensureTemplateEnvVars(store config.Store, projectName, envName string, t ComposeTemplate) error {
+	vars, err := ListEnvVars(store, projectName, envName)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]string, len(vars))
+	for _, v := range vars {
+		have[v.Key] = v.Value
+	}
+
+	var want []EnvVar
+	if t.Postgres {
+		password, ok := have["POSTGRES_PASSWORD"]
+		if !ok {
+			if password, err = randomHex(24); err != nil {
+				return err
+			}
+		}
+		want = append(want,
+			EnvVar{Key: "POSTGRES_PASSWORD", Value: password},
+			EnvVar{Key: "DATABASE_URL", Value: "postgres://app:" + password + "@postgres:5432/app?sslmode=disable"},
+		)
+	}
+	if t.Redis {
+		want = append(want, EnvVar{Key: "REDIS_URL", Value: "redis://redis:6379/0"})
+	}
+
+	for _, v := range want {
+		if _, ok := have[v.Key]; ok {
+			continue
+		}
+		if err := SetEnvVar(store, projectName, envName, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating password: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ValidateCompose checks a compose file brought from a project's repo: a
+// service must run the project's image, and that service must publish port
+// on the host for Caddy to proxy to. No service may publish a port beyond
+// localhost, so that Caddy stays the only way in.
+//
+// The image matches if it is taken from ${DOCKER_IMAGE}, which the deploy
+// workflow sets, or names dockerImage with any tag. Variables are resolved
+// to their ${VAR:-default} defaults.
+func ValidateCompose(content, dockerImage string, port int) error {
+	var f struct {
+		Services map[string]struct {
+			Image string `yaml:"image"`
+			Ports []any  `yaml:"ports"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &f); err != nil {
+		return fmt.Errorf("parsing compose file: %w", err)
+	}
+	if len(f.Services) == 0 {
+		return fmt.Errorf("compose file has no services")
+	}
+
+	names := make([]string, 0, len(f.Services))
+	for name := range f.Services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		for _, entry := range f.Services[name].Ports {
+			if p := parseComposePort(entry); !isLoopback(p.HostIP) {
+				return fmt.Errorf("service %s publishes %v beyond localhost; bind it to 127.0.0.1, e.g. \"127.0.0.1:%d:80\", so that it is only reachable through Caddy", name, entry, port)
+			}
+		}
+	}
+
+	var images, matched []string
+	for _, name := range names {
+		svc := f.Services[name]
+		if !composeImageMatches(svc.Image, dockerImage) {
+			images = append(images, fmt.Sprintf("%s (%s)", name, svc.Image))
+			continue
+		}
+		matched = append(matched, name)
+		for _, p := range svc.Ports {
+			if parseComposePort(p).Published == strconv.Itoa(port) {
+				return nil
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return fmt.Errorf("no service runs %s or ${DOCKER_IMAGE}; found %s", dockerImage, strings.Join(images, ", "))
+	}
+	return fmt.Errorf("service %s doesn't publish port %d", strings.Join(matched, ", "), port)
+}
+
+var composeDefaultRe = regexp.MustCompile(`\$\{\w+:?-([^}]*)\}`)
+
+// expandComposeDefaults replaces ${VAR:-default} and ${VAR-default} with
+// their defaults. Variables without one are left as they are.
+func expandComposeDefaults(s string) string {
+	return composeDefaultRe.ReplaceAllString(s, "$1")
+}
+
+func composeImageMatches(image, dockerImage string) bool {
+	if strings.HasPrefix(image, "${DOCKER_IMAGE") {
+		return true
+	}
+	image = expandComposeDefaults(image)
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "index.docker.io/")
+	return image == dockerImage
+}
+
+// composePort is where a compose ports entry publishes on the host.
+type composePort struct {
+	HostIP    string // "" for all interfaces
+	Published string // host port; "" for one Docker picks
+}
+
+// parseComposePort reads a compose ports entry in either the short
+// "[ip:][host:]container[/proto]" or the long mapping syntax. An IPv6 host
+// IP is bracketed in the short syntax, e.g. "[::1]:3000:80".
+func parseComposePort(entry any) composePort {
+	if e, ok := entry.(map[string]any); ok {
+		var p composePort
+		if ip, ok := e["host_ip"]; ok {
+			p.HostIP = expandComposeDefaults(fmt.Sprint(ip))
+		}
+		if published, ok := e["published"]; ok {
+			p.Published = expandComposeDefaults(fmt.Sprint(published))
+		}
+		return p
+	}
+
+	spec := expandComposeDefaults(fmt.Sprint(entry))
+	spec, _, _ = strings.Cut(spec, "/")
+	var p composePort
+	if strings.HasPrefix(spec, "[") {
+		if ip, rest, ok := strings.Cut(spec[1:], "]:"); ok {
+			p.HostIP, spec = ip, rest
+		}
+	}
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 2:
+		p.Published = parts[0]
+	case 3:
+		p.HostIP, p.Published = parts[0], parts[1]
+	}
+	return p
+}
+
+// isLoopback reports whether a port published on ip is only reachable from
+// the server itself.
+func isLoopback(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsLoopback()
+}
@@ -0,0 +1,301 @@
+package project
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dbbackup"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// DestroyParams contains all inputs for tearing down one project environment.
+type DestroyParams struct {
+	ProjectName string
+	EnvName     string
+	PeonKey     string // PEM-encoded peon SSH key; looked up in the Store if empty
+	Store       config.Store
+	OnProgress  ProgressFunc
+
+	// Confirm is asked before each step that removes something. Returning
+	// false skips that step. A nil Confirm approves every step.
+	Confirm func(message string) bool
+
+	// Keep* opt out of removing individual resources.
+	KeepStack    bool
+	KeepUser     bool
+	KeepCaddy    bool
+	KeepDNS      bool
+	KeepSecrets  bool
+	KeepWorkflow bool
+	KeepConfig   bool
+}
+
+// Destroy reverses Setup for a single environment. Every step checks whether
+// its resource still exists first, so Destroy can be re-run after a partial
+// failure.
+func Destroy(params DestroyParams) error {
+	const totalSteps = 7
+	report := func(step int, message string) {
+		if params.OnProgress != nil {
+			params.OnProgress(step, totalSteps, message)
+		}
+	}
+
+	cfg, err := params.Store.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	proj := cfg.FindProject(params.ProjectName)
+	if proj == nil {
+		return fmt.Errorf("project %q not found", params.ProjectName)
+	}
+	env, ok := proj.Environments[params.EnvName]
+	if !ok {
+		return fmt.Errorf("project %q has no %s environment", params.ProjectName, params.EnvName)
+	}
+
+	// run skips a step when its resource is kept or the user declines it.
+	run := func(step int, keep bool, description string, fn func() error) error {
+		if keep {
+			report(step, "Keeping: "+description)
+			return nil
+		}
+		if params.Confirm != nil && !params.Confirm(description) {
+			report(step, "Skipped: "+description)
+			return nil
+		}
+		report(step, description+"...")
+		return fn()
+	}
+
+	// The server and SSH connection are only resolved if a step needs them.
+	var server *config.Server
+	getServer := func() (*config.Server, error) {
+		if server != nil {
+			return server, nil
+		}
+		s, err := LookupServer(cfg, proj.Server, params.Store)
+		if err != nil {
+			return nil, err
+		}
+		server = s
+		return server, nil
+	}
+	var client *remote.Client
+	connect := func() (*remote.Client, error) {
+		if client != nil {
+			return client, nil
+		}
+		s, err := getServer()
+		if err != nil {
+			return nil, err
+		}
+		peonKey := params.PeonKey
+		if peonKey == "" {
+			peonKey, err = params.Store.GetPeonKey(s.IP)
+			if err != nil {
+				return nil, fmt.Errorf("peon key for %s: %w", s.IP, err)
+			}
+		}
+		client, err = remote.DialPeon(s.IP, peonKey, params.Store)
+		return client, err
+	}
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	// Step 1: Stop and remove the compose stack
+	err = run(1, params.KeepStack, "Remove compose stack at "+env.DeployPath, func() error {
+		if !strings.HasPrefix(env.DeployPath, "/opt/") {
+			return fmt.Errorf("refusing to remove deploy path %q outside /opt", env.DeployPath)
+		}
+		c, err := connect()
+		if err != nil {
+			return err
+		}
+		// A scheduled database backup would fail once the stack is gone.
+		dbbackup.Uninstall(c, path.Base(env.DeployPath))
+		if c.Run(fmt.Sprintf("sudo test -d %s", env.DeployPath)) != nil {
+			report(1, env.DeployPath+" already removed")
+			return nil
+		}
+		if c.Run(fmt.Sprintf("sudo test -f %s/docker-compose.yml", env.DeployPath)) == nil {
+			if err := c.Run(fmt.Sprintf("cd %s && docker compose down --remove-orphans", env.DeployPath)); err != nil {
+				return fmt.Errorf("stopping compose stack: %w", err)
+			}
+		}
+		if err := c.Run(fmt.Sprintf("sudo rm -rf %s", env.DeployPath)); err != nil {
+			return fmt.Errorf("removing %s: %w", env.DeployPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Step 2: Delete the deploy user
+	keepUser := params.KeepUser
+	if env.DeployUser == "" || env.DeployUser == "peon" {
+		// Services deploy as the shared peon user, which must survive.
+		keepUser = true
+	}
+	err = run(2, keepUser, "Delete deploy user "+env.DeployUser, func() error {
+		c, err := connect()
+		if err != nil {
+			return err
+		}
+		if c.Run(fmt.Sprintf("id -u %s", env.DeployUser)) != nil {
+			report(2, "User "+env.DeployUser+" already removed")
+			return nil
+		}
+		if err := c.Run(fmt.Sprintf("sudo userdel -r %s", env.DeployUser)); err != nil {
+			return fmt.Errorf("deleting user %s: %w", env.DeployUser, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Step 3: Remove the Caddy config and reload
+	caddyPath := fmt.Sprintf("/etc/caddy/conf.d/%s.caddy", env.Domain)
+	err = run(3, params.KeepCaddy, "Remove "+caddyPath, func() error {
+		c, err := connect()
+		if err != nil {
+			return err
+		}
+		if c.Run(fmt.Sprintf("sudo test -f %s", caddyPath)) != nil {
+			report(3, caddyPath+" already removed")
+			return nil
+		}
+		if err := c.Run(fmt.Sprintf("sudo rm -f %s", caddyPath)); err != nil {
+			return fmt.Errorf("removing caddy config: %w", err)
+		}
+		if err := c.Run("sudo systemctl reload caddy"); err != nil {
+			journalOut, _ := c.Output("sudo journalctl -u caddy -n 20 --no-pager 2>&1")
+			return fmt.Errorf("reloading caddy: %w\njournal output:\n%s", err, strings.TrimSpace(journalOut))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Step 4: Delete DNS records
+	err = run(4, params.KeepDNS, "Delete DNS records for "+env.Domain, func() error {
+		s, err := getServer()
+		if err != nil {
+			return err
+		}
+		var provider dns.Provider
+		if env.DNSProvider != "" {
+			provider, err = newDNSProvider(env.DNSProvider, params.Store)
+		} else {
+			provider, err = dnsProviderForDomain(env.Domain, cfg, params.Store)
+		}
+		if err != nil {
+			return fmt.Errorf("DNS provider for %s: %w", env.Domain, err)
+		}
+		rootDomain, err := lookupRootDomain(env.Domain)
+		if err != nil {
+			return fmt.Errorf("resolving root domain for %s: %w", env.Domain, err)
+		}
+		records, err := provider.ListRecords(rootDomain)
+		if err != nil {
+			return fmt.Errorf("listing DNS records: %w", err)
+		}
+
+		deleted := 0
+		for _, r := range records {
+			switch {
+			case r.Name == env.Domain && r.Type == "A" && r.Content != s.IP:
+				// The domain has been pointed elsewhere since Setup ran.
+				report(4, fmt.Sprintf("Leaving A record %s -> %s (not this server)", r.Name, r.Content))
+				continue
+			case r.Name == env.Domain && r.Type == "A":
+			case r.Name == "www."+env.Domain && r.Type == "CNAME":
+			default:
+				continue
+			}
+			if err := provider.DeleteRecord(rootDomain, r.ID); err != nil {
+				return fmt.Errorf("deleting %s record %s: %w", r.Type, r.Name, err)
+			}
+			deleted++
+		}
+		if deleted == 0 {
+			report(4, "No DNS records left to delete")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Steps 5 and 6 only apply to projects with a GitHub repo.
+	hasRepo := proj.Repo != ""
+
+	// Step 5: Delete GitHub secrets
+	prefix := strings.ToUpper(params.EnvName)
+	err = run(5, params.KeepSecrets || !hasRepo, fmt.Sprintf("Delete %s_* GitHub secrets", prefix), func() error {
+		existing, err := ListGitHubSecrets(proj.Repo)
+		if err != nil {
+			return err
+		}
+		present := make(map[string]bool, len(existing))
+		for _, s := range existing {
+			present[s.Name] = true
+		}
+		// Shared secrets (VPS_HOST, the registry's) are left for other environments.
+		for _, name := range EnvironmentSecretNames(prefix) {
+			if !present[name] {
+				continue
+			}
+			if err := DeleteGitHubSecret(proj.Repo, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Step 6: Delete the workflow file
+	workflowPath := ".github/workflows/" + WorkflowFile(params.EnvName)
+	err = run(6, params.KeepWorkflow || !hasRepo, "Delete "+workflowPath, func() error {
+		branch, err := DefaultBranch(proj.Repo)
+		if err != nil {
+			return fmt.Errorf("detecting default branch: %w", err)
+		}
+		removed, err := DeleteRepoFile(proj.Repo, workflowPath, branch, fmt.Sprintf("Remove %s deploy workflow", params.EnvName))
+		if err != nil {
+			return err
+		}
+		if !removed {
+			report(6, workflowPath+" already removed")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Step 7: Remove from config
+	return run(7, params.KeepConfig, fmt.Sprintf("Remove %s/%s from config", params.ProjectName, params.EnvName), func() error {
+		if err := params.Store.DeleteEnvironment(params.ProjectName, params.EnvName); err != nil {
+			return err
+		}
+		if err := DeleteEnvVars(params.Store, params.ProjectName, params.EnvName); err != nil {
+			return err
+		}
+		if len(proj.Environments) == 1 {
+			return params.Store.DeleteProject(params.ProjectName)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,151 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// fakeGH puts a gh script on PATH that answers the calls Destroy makes for
+// repo o/app. contentsStatus is how the Contents API lookup of the workflow
+// fails, e.g. "Not Found (HTTP 404)", or "" if the file exists.
+func fakeGH(t *testing.T, contentsStatus string) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	lookup := "echo abc123"
+	if contentsStatus != "" {
+		lookup = "echo 'gh: " + contentsStatus + "' >&2; exit 1"
+	}
+	script := `#!/bin/sh
+echo "$*" >> ` + log + `
+case "$*" in
+"api repos/o/app --jq .default_branch") echo main ;;
+"api repos/o/app/contents/.github/workflows/deploy-prod.yml?ref=main --jq .sha") ` + lookup + ` ;;
+"api -X DELETE repos/o/app/contents/.github/workflows/deploy-prod.yml "*) ;;
+*) echo "unexpected gh $*" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "gh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func newDestroyStore(t *testing.T) *config.SQLiteStore {
+	t.Helper()
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	store.SaveConfig(&config.Config{
+		Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}},
+		Projects: []config.Project{{Name: "app", Repo: "o/app", Server: "web1", Environments: map[string]config.Environment{
+			"prod": {Domain: "app.example.com", DeployPath: "/opt/app/prod", DeployUser: "deploy-app"},
+			"dev":  {Domain: "dev.app.example.com", DeployPath: "/opt/app/dev", DeployUser: "deploy-app-dev"},
+		}}},
+	})
+	SetEnvVar(store, "app", "prod", EnvVar{Key: "API_KEY", Value: "abc"})
+	return store
+}
+
+// destroyGitHubOnly keeps everything on the server and in DNS, which leaves
+// the workflow and config steps to run.
+func destroyGitHubOnly(store config.Store, steps *[]string) DestroyParams {
+	return DestroyParams{
+		ProjectName: "app",
+		EnvName:     "prod",
+		Store:       store,
+		KeepStack:   true,
+		KeepUser:    true,
+		KeepCaddy:   true,
+		KeepDNS:     true,
+		KeepSecrets: true,
+		OnProgress:  func(step, total int, message string) { *steps = append(*steps, message) },
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	log := fakeGH(t, "")
+	store := newDestroyStore(t)
+
+	var steps []string
+	if err := Destroy(destroyGitHubOnly(store, &steps)); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, _ := os.ReadFile(log)
+	if !strings.Contains(string(calls), "api -X DELETE repos/o/app/contents/.github/workflows/deploy-prod.yml -f message=Remove prod deploy workflow -f sha=abc123 -f branch=main") {
+		t.Errorf("gh calls =\n%s", calls)
+	}
+	cfg, _ := store.LoadConfig()
+	p := cfg.FindProject("app")
+	if p == nil {
+		t.Fatal("project deleted with an environment left")
+	}
+	if _, ok := p.Environments["prod"]; ok || len(p.Environments) != 1 {
+		t.Errorf("environments = %v, want only dev", p.Environments)
+	}
+	if vars, _ := ListEnvVars(store, "app", "prod"); len(vars) != 0 {
+		t.Errorf("env vars = %+v, want none", vars)
+	}
+	if len(steps) != 7 || steps[0] != "Keeping: Remove compose stack at /opt/app/prod" {
+		t.Errorf("steps = %q", steps)
+	}
+}
+
+func TestDestroyWorkflowAlreadyRemoved(t *testing.T) {
+	fakeGH(t, "Not Found (HTTP 404)")
+	store := newDestroyStore(t)
+
+	var steps []string
+	params := destroyGitHubOnly(store, &steps)
+	params.KeepConfig = true
+	if err := Destroy(params); err != nil {
+		t.Fatal(err)
+	}
+	want := "Delete .github/workflows/deploy-prod.yml..."
+	if len(steps) < 7 || steps[5] != want || steps[6] != ".github/workflows/deploy-prod.yml already removed" {
+		t.Errorf("steps = %q", steps)
+	}
+}
+
+func TestDestroyStopsOnLookupError(t *testing.T) {
+	fakeGH(t, "Bad credentials (HTTP 401)")
+	store := newDestroyStore(t)
+
+	var steps []string
+	err := Destroy(destroyGitHubOnly(store, &steps))
+	if err == nil || !strings.Contains(err.Error(), "Bad credentials (HTTP 401)") {
+		t.Fatalf("err = %v, want the lookup error", err)
+	}
+	// The config still points at the workflow, so a re-run can finish.
+	cfg, _ := store.LoadConfig()
+	if _, ok := cfg.FindProject("app").Environments["prod"]; !ok {
+		t.Error("prod removed from config after a failed step")
+	}
+}
+
+func TestDestroyConfirmSkipsStep(t *testing.T) {
+	store := newDestroyStore(t)
+
+	var steps []string
+	params := destroyGitHubOnly(store, &steps)
+	params.KeepWorkflow = true
+	params.Confirm = func(message string) bool { return false }
+	if err := Destroy(params); err != nil {
+		t.Fatal(err)
+	}
+	if got := steps[len(steps)-1]; got != "Skipped: Remove app/prod from config" {
+		t.Errorf("last step = %q", got)
+	}
+	cfg, _ := store.LoadConfig()
+	if _, ok := cfg.FindProject("app").Environments["prod"]; !ok {
+		t.Error("prod removed from config though the step was declined")
+	}
+}
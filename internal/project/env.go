@@ -0,0 +1,185 @@
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// Environment variables are stored as credentials, one per variable, named
+// "<project>/<env>/<KEY>" under this service, so they are encrypted and
+// backed up like any other secret.
+const envVarService = "env"
+
+// EnvVar is one variable of an environment's .env file.
+type EnvVar struct {
+	Key   string
+	Value string
+}
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnvAssignment splits a KEY=VALUE argument.
+func ParseEnvAssignment(s string) (EnvVar, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return EnvVar{}, fmt.Errorf("%q is not KEY=VALUE", s)
+	}
+	if !envKeyRe.MatchString(key) {
+		return EnvVar{}, fmt.Errorf("invalid variable name %q", key)
+	}
+	return EnvVar{Key: key, Value: value}, nil
+}
+
+func envVarName(projectName, envName, key string) string {
+	return projectName + "/" + envName + "/" + key
+}
+
+// ListEnvVars returns an environment's variables sorted by key.
+func ListEnvVars(store config.Store, projectName, envName string) ([]EnvVar, error) {
+	creds, err := store.ListCredentials(envVarService)
+	if err != nil {
+		return nil, fmt.Errorf("listing variables: %w", err)
+	}
+	prefix := envVarName(projectName, envName, "")
+	var vars []EnvVar
+	for _, c := range creds {
+		if key, ok := strings.CutPrefix(c.Name, prefix); ok {
+			vars = append(vars, EnvVar{Key: key, Value: c.Value})
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Key < vars[j].Key })
+	return vars, nil
+}
+
+// SetEnvVar stores a variable, replacing any earlier value.
+func SetEnvVar(store config.Store, projectName, envName string, v EnvVar) error {
+	if !envKeyRe.MatchString(v.Key) {
+		return fmt.Errorf("invalid variable name %q", v.Key)
+	}
+	return store.SetCredential(envVarService, envVarName(projectName, envName, v.Key), "value", v.Value)
+}
+
+// UnsetEnvVar removes a variable. Removing one that is not set is not an
+// error.
+func UnsetEnvVar(store config.Store, projectName, envName, key string) error {
+	return store.DeleteCredential(envVarService, envVarName(projectName, envName, key))
+}
+
+// DeleteEnvVars removes all of an environment's variables.
+func DeleteEnvVars(store config.Store, projectName, envName string) error {
+	vars, err := ListEnvVars(store, projectName, envName)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		if err := UnsetEnvVar(store, projectName, envName, v.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderEnvFile renders variables in the .env format docker compose reads.
+// Values are single-quoted so that compose takes them literally; values a
+// single-quoted string can't hold are double-quoted and escaped instead.
+func RenderEnvFile(vars []EnvVar) string {
+	var b strings.Builder
+	b.WriteString("# Managed by arnor. Change with 'arnor env set' and 'arnor env unset'.\n")
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s=%s\n", v.Key, quoteEnvValue(v.Value))
+	}
+	return b.String()
+}
+
+func quoteEnvValue(v string) string {
+	if !strings.ContainsAny(v, "'\n") {
+		return "'" + v + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// WriteEnvFile writes the environment's variables to .env in deployPath. It
+// is owned by root and readable by the deploy user's group only, since
+// docker compose reads it as the deploy user when CI deploys. The file is
+// created with that mode before anything is written to it, so the values
+// are never readable by others.
+func WriteEnvFile(client remote.Runner, deployPath, deployUser string, vars []EnvVar) error {
+	path := deployPath + "/.env"
+	if err := client.Run(fmt.Sprintf("sudo install -m 640 -o root -g %s /dev/null %s.tmp", deployUser, path)); err != nil {
+		return fmt.Errorf("creating .env: %w", err)
+	}
+	if err := client.WriteFile(path+".tmp", RenderEnvFile(vars)); err != nil {
+		return fmt.Errorf("writing .env: %w", err)
+	}
+	if err := client.Run(fmt.Sprintf("sudo mv %[1]s.tmp %[1]s", path)); err != nil {
+		return fmt.Errorf("installing .env: %w", err)
+	}
+	return nil
+}
+
+// ComposeLoadsEnvFile reports whether the compose file in deployPath has an
+// env_file entry. Compose files written before variables were supported
+// don't, and need project setup re-run.
+func ComposeLoadsEnvFile(client remote.Runner, deployPath string) bool {
+	return client.Run(fmt.Sprintf("sudo grep -q env_file %s/docker-compose.yml", deployPath)) == nil
+}
+
+// RestartStack recreates the containers in deployPath so that they pick up
+// a changed .env. The running image is kept: the compose file defaults
+// DOCKER_IMAGE to the latest tag, which may not be what CI deployed.
+func RestartStack(client remote.Runner, deployPath string) error {
+	image, _ := client.Output(fmt.Sprintf("cd %s && sudo docker compose ps --format '{{.Image}}' web 2>/dev/null", deployPath))
+	image = strings.TrimSpace(image)
+	command := fmt.Sprintf("cd %s && sudo docker compose up -d", deployPath)
+	if image != "" && !strings.Contains(image, "\n") {
+		command = fmt.Sprintf("cd %s && sudo env DOCKER_IMAGE=%s docker compose up -d", deployPath, image)
+	}
+	if err := client.Run(command); err != nil {
+		return fmt.Errorf("restarting containers: %w", err)
+	}
+	return nil
+}
+
+// SyncEnvFile writes an environment's stored variables to .env on its
+// server and, with restart, recreates its containers. It reports whether
+// the compose file loads .env at all.
+func SyncEnvFile(cfg *config.Config, store config.Store, proj *config.Project, envName string, restart bool) (bool, error) {
+	env, ok := proj.Environments[envName]
+	if !ok {
+		return false, fmt.Errorf("project %q has no %s environment", proj.Name, envName)
+	}
+	vars, err := ListEnvVars(store, proj.Name, envName)
+	if err != nil {
+		return false, err
+	}
+	server, err := LookupServer(cfg, proj.Server, store)
+	if err != nil {
+		return false, err
+	}
+	peonKey, err := store.GetPeonKey(server.IP)
+	if err != nil {
+		return false, fmt.Errorf("peon key for %s: %w", server.IP, err)
+	}
+	client, err := remote.DialPeon(server.IP, peonKey, store)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	if err := WriteEnvFile(client, env.DeployPath, env.DeployUser, vars); err != nil {
+		return false, err
+	}
+	loads := ComposeLoadsEnvFile(client, env.DeployPath)
+	if restart {
+		if err := RestartStack(client, env.DeployPath); err != nil {
+			return loads, err
+		}
+	}
+	return loads, nil
+}
@@ -0,0 +1,116 @@
+package project
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+func TestEnvVars(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, a := range []string{"DATABASE_URL=postgres://u:p@db/app?ssl=true", "API_KEY=abc", "EMPTY="} {
+		v, err := ParseEnvAssignment(a)
+		if err != nil {
+			t.Fatalf("ParseEnvAssignment(%q): %v", a, err)
+		}
+		if err := SetEnvVar(store, "myapp", "prod", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SetEnvVar(store, "myapp", "dev", EnvVar{Key: "API_KEY", Value: "dev"})
+	SetEnvVar(store, "myapp-old", "prod", EnvVar{Key: "API_KEY", Value: "other"})
+	SetEnvVar(store, "myapp", "prod", EnvVar{Key: "API_KEY", Value: "xyz"})
+	UnsetEnvVar(store, "myapp", "prod", "EMPTY")
+
+	vars, err := ListEnvVars(store, "myapp", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{{"API_KEY", "xyz"}, {"DATABASE_URL", "postgres://u:p@db/app?ssl=true"}}
+	if len(vars) != len(want) {
+		t.Fatalf("vars = %+v, want %+v", vars, want)
+	}
+	for i := range want {
+		if vars[i] != want[i] {
+			t.Errorf("var %d = %+v, want %+v", i, vars[i], want[i])
+		}
+	}
+
+	if err := DeleteEnvVars(store, "myapp", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if vars, _ := ListEnvVars(store, "myapp", "prod"); len(vars) != 0 {
+		t.Errorf("after DeleteEnvVars: %+v", vars)
+	}
+	if vars, _ := ListEnvVars(store, "myapp", "dev"); len(vars) != 1 {
+		t.Errorf("dev vars = %+v, want them kept", vars)
+	}
+
+	for _, bad := range []string{"NOVALUE", "1KEY=x", "MY-KEY=x", "=x"} {
+		if _, err := ParseEnvAssignment(bad); err == nil {
+			t.Errorf("ParseEnvAssignment(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRenderEnvFile(t *testing.T) {
+	got := RenderEnvFile([]EnvVar{
+		{"PLAIN", "hello world"},
+		{"DOLLAR", "pa$$word"},
+		{"QUOTE", `it's "fine" $HOME`},
+		{"MULTI", "line1\nline2"},
+	})
+	want := `# Managed by arnor. Change with 'arnor env set' and 'arnor env unset'.
+PLAIN='hello world'
+DOLLAR='pa$$word'
+QUOTE="it's \"fine\" \$HOME"
+MULTI="line1\nline2"
+`
+	if got != want {
+		t.Errorf("RenderEnvFile:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// recordingRunner records the commands and file writes it is given.
+type recordingRunner struct {
+	ops []string
+}
+
+func (r *recordingRunner) Run(command string) error {
+	r.ops = append(r.ops, command)
+	return nil
+}
+
+func (r *recordingRunner) Output(command string) (string, error) {
+	r.ops = append(r.ops, command)
+	return "", nil
+}
+
+func (r *recordingRunner) WriteFile(path, content string) error {
+	r.ops = append(r.ops, "write "+path)
+	return nil
+}
+
+func (r *recordingRunner) Close() error { return nil }
+
+func TestWriteEnvFile(t *testing.T) {
+	r := &recordingRunner{}
+	if err := WriteEnvFile(r, "/opt/myapp", "myapp-deploy", []EnvVar{{"API_KEY", "secret"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The file gets its final mode and owner before the values go in.
+	want := []string{
+		"sudo install -m 640 -o root -g myapp-deploy /dev/null /opt/myapp/.env.tmp",
+		"write /opt/myapp/.env.tmp",
+		"sudo mv /opt/myapp/.env.tmp /opt/myapp/.env",
+	}
+	if !reflect.DeepEqual(r.ops, want) {
+		t.Errorf("ops =\n%q\nwant\n%q", r.ops, want)
+	}
+}
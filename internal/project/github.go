@@ -3,11 +3,15 @@ package project
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
+	"sort"
 	"strings"
 
 	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/plan"
+	"github.com/dukerupert/arnor/internal/registry"
 )
 
 // GitHubRepo represents a GitHub repository from `gh repo list`.
@@ -120,6 +124,82 @@ func PushWorkflowFile(repo, path, content, branch, commitMsg string) error {
 	return nil
 }
 
+// DeleteRepoFile deletes a file from a GitHub repo via the Contents API. It
+// reports false without error if the file does not exist.
+func DeleteRepoFile(repo, path, branch, commitMsg string) (bool, error) {
+	// Get the file SHA required for deletion.
+	shaCmd := exec.Command("gh", "api",
+		fmt.Sprintf("repos/%s/contents/%s?ref=%s", repo, path, branch),
+		"--jq", ".sha")
+	shaOut, err := shaCmd.Output()
+	if err != nil {
+		if ghNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("looking up %s in %s: %w%s", path, repo, err, ghStderr(err))
+	}
+	sha := strings.TrimSpace(string(shaOut))
+
+	delCmd := exec.Command("gh", "api", "-X", "DELETE",
+		fmt.Sprintf("repos/%s/contents/%s", repo, path),
+		"-f", "message="+commitMsg,
+		"-f", "sha="+sha,
+		"-f", "branch="+branch)
+	if out, err := delCmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("deleting %s from %s: %w\n%s", path, repo, err, strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// ghNotFound reports whether a gh api command failed because the resource
+// does not exist.
+func ghNotFound(err error) bool {
+	return strings.Contains(ghStderr(err), "(HTTP 404)")
+}
+
+// ghStderr returns what a failed gh command printed to stderr, on its own
+// line, or "" if there was nothing.
+func ghStderr(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	if stderr := strings.TrimSpace(string(exitErr.Stderr)); stderr != "" {
+		return "\n" + stderr
+	}
+	return ""
+}
+
+// RepoFileExists reports whether path exists in a GitHub repo at ref.
+func RepoFileExists(repo, path, ref string) bool {
+	cmd := exec.Command("gh", "api",
+		fmt.Sprintf("repos/%s/contents/%s?ref=%s", repo, path, ref),
+		"--silent")
+	return cmd.Run() == nil
+}
+
+// FetchRepoFile returns the contents of path in a GitHub repo at ref.
+func FetchRepoFile(repo, path, ref string) (string, error) {
+	cmd := exec.Command("gh", "api",
+		fmt.Sprintf("repos/%s/contents/%s?ref=%s", repo, path, ref),
+		"-H", "Accept: application/vnd.github.raw")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("fetching %s from %s@%s: %w", path, repo, ref, err)
+	}
+	return string(out), nil
+}
+
+// DeleteGitHubSecret removes a repository secret using the gh CLI.
+func DeleteGitHubSecret(repo, name string) error {
+	cmd := exec.Command("gh", "secret", "delete", name, "--repo", repo)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("deleting secret %s: %w\n%s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // DefaultBranch returns the default branch name for a GitHub repo.
 func DefaultBranch(repo string) (string, error) {
 	cmd := exec.Command("gh", "api", fmt.Sprintf("repos/%s", repo), "--jq", ".default_branch")
@@ -154,7 +234,9 @@ func TriggerWorkflow(repo, workflowFile, ref string) error {
 // EnsureWorkflowDispatch checks that the workflow file exists on the default
 // branch with a workflow_dispatch trigger. If the file is missing it generates
 // and pushes it; if it exists without the trigger it patches the file in place.
-func EnsureWorkflowDispatch(repo, envName, projectName, dockerHubUsername string) error {
+// reg is the project's registry and composeFile the environment's repo
+// compose file, if it has one.
+func EnsureWorkflowDispatch(repo, envName, projectName string, reg registry.Registry, composeFile string) error {
 	filename := WorkflowFile(envName)
 	path := ".github/workflows/" + filename
 
@@ -163,7 +245,7 @@ func EnsureWorkflowDispatch(repo, envName, projectName, dockerHubUsername string
 		return fmt.Errorf("getting default branch: %w", err)
 	}
 
-	dockerImage := dockerHubUsername + "/" + projectName
+	dockerImage := reg.Image(projectName, repo)
 
 	// Try to fetch the existing workflow file.
 	cmd := exec.Command("gh", "api",
@@ -176,9 +258,9 @@ func EnsureWorkflowDispatch(repo, envName, projectName, dockerHubUsername string
 		var content string
 		switch envName {
 		case "dev":
-			content, err = GenerateDevWorkflow(dockerImage)
+			content, err = GenerateDevWorkflow(dockerImage, reg.Login(), composeFile)
 		case "prod":
-			content, err = GenerateProdWorkflow(dockerImage, branch)
+			content, err = GenerateProdWorkflow(dockerImage, reg.Login(), branch, composeFile)
 		default:
 			return fmt.Errorf("unknown environment: %s", envName)
 		}
@@ -197,7 +279,13 @@ func EnsureWorkflowDispatch(repo, envName, projectName, dockerHubUsername string
 	}
 
 	content := string(decoded)
-	if strings.Contains(content, "workflow_dispatch") && strings.Contains(content, "scp-action") && strings.Contains(content, "docker login") {
+	current := strings.Contains(content, "workflow_dispatch") && strings.Contains(content, "scp-action") && strings.Contains(content, "docker login")
+	// A workflow pushing elsewhere is left from before a registry change.
+	current = current && strings.Contains(content, "IMAGE_NAME: "+dockerImage+"\n")
+	if composeFile != "" {
+		current = current && strings.Contains(content, "source: "+composeFile+"\n")
+	}
+	if current {
 		return nil
 	}
 
@@ -205,9 +293,9 @@ func EnsureWorkflowDispatch(repo, envName, projectName, dockerHubUsername string
 	var updated string
 	switch envName {
 	case "dev":
-		updated, err = GenerateDevWorkflow(dockerImage)
+		updated, err = GenerateDevWorkflow(dockerImage, reg.Login(), composeFile)
 	case "prod":
-		updated, err = GenerateProdWorkflow(dockerImage, branch)
+		updated, err = GenerateProdWorkflow(dockerImage, reg.Login(), branch, composeFile)
 	default:
 		return fmt.Errorf("unknown environment: %s", envName)
 	}
@@ -234,6 +322,12 @@ var managedWorkflows = map[string]bool{
 // managed by arnor. This prevents old hand-written workflows (e.g. deploy.yml)
 // from conflicting with the generated deploy-dev.yml / deploy-prod.yml.
 func DeleteStaleWorkflows(repo, branch string) error {
+	return ghCLI{}.DeleteStaleWorkflows(repo, branch)
+}
+
+// staleWorkflows returns the workflow filenames in the repo that arnor does
+// not manage.
+func staleWorkflows(repo, branch string) []string {
 	cmd := exec.Command("gh", "api",
 		fmt.Sprintf("repos/%s/contents/.github/workflows?ref=%s", repo, branch),
 		"--jq", ".[].name")
@@ -243,31 +337,14 @@ func DeleteStaleWorkflows(repo, branch string) error {
 		return nil
 	}
 
+	var stale []string
 	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
 		if name == "" || managedWorkflows[name] {
 			continue
 		}
-		path := ".github/workflows/" + name
-		// Get the file SHA required for deletion.
-		shaCmd := exec.Command("gh", "api",
-			fmt.Sprintf("repos/%s/contents/%s?ref=%s", repo, path, branch),
-			"--jq", ".sha")
-		shaOut, err := shaCmd.Output()
-		if err != nil {
-			continue
-		}
-		sha := strings.TrimSpace(string(shaOut))
-
-		delCmd := exec.Command("gh", "api", "-X", "DELETE",
-			fmt.Sprintf("repos/%s/contents/%s", repo, path),
-			"-f", "message=Remove stale workflow "+name,
-			"-f", "sha="+sha,
-			"-f", "branch="+branch)
-		if delOut, err := delCmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("deleting stale workflow %s: %w\n%s", name, err, strings.TrimSpace(string(delOut)))
-		}
+		stale = append(stale, name)
 	}
-	return nil
+	return stale
 }
 
 // DeployRef returns the git ref to deploy for a given environment.
@@ -276,8 +353,25 @@ func DeployRef(env config.Environment) string {
 }
 
 // SetEnvironmentSecrets sets all GitHub Actions secrets for an environment.
-// prefix is "DEV" or "PROD".
-func SetEnvironmentSecrets(repo, prefix, vpsUser, deployPath, sshKey, vpsHost, dockerHubUsername, dockerHubToken string, port int) error {
+// prefix is "DEV" or "PROD"; registrySecrets are those of the project's
+// registry.
+func SetEnvironmentSecrets(repo, prefix, vpsUser, deployPath, sshKey, vpsHost string, registrySecrets map[string]string, port int) error {
+	return setEnvironmentSecrets(ghCLI{}, repo, prefix, vpsUser, deployPath, sshKey, vpsHost, registrySecrets, port)
+}
+
+// EnvironmentSecretNames returns the names of the secrets that
+// SetEnvironmentSecrets sets for one environment only.
+func EnvironmentSecretNames(prefix string) []string {
+	return []string{prefix + "_VPS_USER", prefix + "_VPS_DEPLOY_PATH", prefix + "_VPS_SSH_KEY", prefix + "_PORT"}
+}
+
+// SharedSecretNames returns the secrets SetEnvironmentSecrets sets for
+// every environment of a repo whose project pushes to registryName.
+func SharedSecretNames(registryName string) []string {
+	return append([]string{"VPS_HOST"}, registry.SecretNames(registryName)...)
+}
+
+func setEnvironmentSecrets(gh gitHub, repo, prefix, vpsUser, deployPath, sshKey, vpsHost string, registrySecrets map[string]string, port int) error {
 	secrets := map[string]string{
 		prefix + "_VPS_USER":        vpsUser,
 		prefix + "_VPS_DEPLOY_PATH": deployPath,
@@ -287,13 +381,83 @@ func SetEnvironmentSecrets(repo, prefix, vpsUser, deployPath, sshKey, vpsHost, d
 
 	// Shared secrets (same across environments)
 	secrets["VPS_HOST"] = vpsHost
-	secrets["DOCKERHUB_USERNAME"] = dockerHubUsername
-	secrets["DOCKERHUB_TOKEN"] = dockerHubToken
+	for name, value := range registrySecrets {
+		secrets[name] = value
+	}
 
-	for name, value := range secrets {
-		if err := SetGitHubSecret(repo, name, value); err != nil {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := gh.SetSecret(repo, name, secrets[name]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// gitHub is the set of GitHub operations Setup performs. ghCLI runs them
+// through the gh CLI; ghRecorder records the writes into a plan.
+type gitHub interface {
+	DefaultBranch(repo string) (string, error)
+	ReadFile(repo, path, ref string) (string, error)
+	SetSecret(repo, name, value string) error
+	PushFile(repo, path, content, branch, commitMsg string) error
+	DeleteStaleWorkflows(repo, branch string) error
+}
+
+type ghCLI struct{}
+
+func (ghCLI) DefaultBranch(repo string) (string, error) { return DefaultBranch(repo) }
+
+func (ghCLI) ReadFile(repo, path, ref string) (string, error) {
+	return FetchRepoFile(repo, path, ref)
+}
+
+func (ghCLI) SetSecret(repo, name, value string) error { return SetGitHubSecret(repo, name, value) }
+
+func (ghCLI) PushFile(repo, path, content, branch, commitMsg string) error {
+	return PushWorkflowFile(repo, path, content, branch, commitMsg)
+}
+
+func (ghCLI) DeleteStaleWorkflows(repo, branch string) error {
+	for _, name := range staleWorkflows(repo, branch) {
+		path := ".github/workflows/" + name
+		if _, err := DeleteRepoFile(repo, path, branch, "Remove stale workflow "+name); err != nil {
+			return fmt.Errorf("deleting stale workflow %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ghRecorder reads from GitHub but records secrets, pushes and deletions
+// into a plan instead of performing them.
+type ghRecorder struct {
+	plan *plan.Plan
+}
+
+func (ghRecorder) DefaultBranch(repo string) (string, error) { return DefaultBranch(repo) }
+
+func (ghRecorder) ReadFile(repo, path, ref string) (string, error) {
+	return FetchRepoFile(repo, path, ref)
+}
+
+func (g ghRecorder) SetSecret(repo, name, _ string) error {
+	g.plan.AddSecret(repo, name)
+	return nil
+}
+
+func (g ghRecorder) PushFile(repo, path, content, branch, _ string) error {
+	g.plan.AddRepoFile(plan.RepoFile{Repo: repo, Path: path, Branch: branch, Content: content})
+	return nil
+}
+
+func (g ghRecorder) DeleteStaleWorkflows(repo, branch string) error {
+	for _, name := range staleWorkflows(repo, branch) {
+		g.plan.AddExternal(fmt.Sprintf("delete %s:.github/workflows/%s (branch %s)", repo, name, branch))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/remote"
+	"golang.org/x/crypto/ssh"
+)
+
+// RotateKeyParams contains all inputs for rotating an environment's deploy
+// key.
+type RotateKeyParams struct {
+	ProjectName string
+	EnvName     string
+	PeonKey     string // PEM-encoded peon SSH key; looked up in the Store if empty
+	Store       config.Store
+	OnProgress  ProgressFunc
+}
+
+// The SSH and GitHub halves of RotateKey. Tests replace these so that it can
+// run against a stand-in for the server.
+var (
+	dialPeon = func(host, key string, store config.Store) (remote.Runner, error) {
+		client, err := remote.DialPeon(host, key, store)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	verifyDeployLogin = verifyLogin
+	setSecret         = SetGitHubSecret
+)
+
+// RotateKey replaces the deploy user's SSH keypair and the
+// <PREFIX>_VPS_SSH_KEY secret that GitHub Actions deploys with.
+//
+// The new public key is added next to the old one and a login with it is
+// verified before the secret is changed, so deploys keep working throughout.
+// Only after the secret is updated is authorized_keys cut over to the new
+// key alone. If anything fails before that, authorized_keys is put back and
+// the old key stays valid.
+func RotateKey(params RotateKeyParams) error {
+	const totalSteps = 6
+	report := func(step int, message string) {
+		if params.OnProgress != nil {
+			params.OnProgress(step, totalSteps, message)
+		}
+	}
+
+	cfg, err := params.Store.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	proj := cfg.FindProject(params.ProjectName)
+	if proj == nil {
+		return fmt.Errorf("project %q not found", params.ProjectName)
+	}
+	env, ok := proj.Environments[params.EnvName]
+	if !ok {
+		return fmt.Errorf("project %q has no %s environment", params.ProjectName, params.EnvName)
+	}
+	if proj.Repo == "" {
+		return fmt.Errorf("project %q has no GitHub repo to deploy from", params.ProjectName)
+	}
+	server, err := LookupServer(cfg, proj.Server, params.Store)
+	if err != nil {
+		return err
+	}
+
+	// Step 1: Connect as peon
+	report(1, "Connecting to "+server.IP+"...")
+	peonKey := params.PeonKey
+	if peonKey == "" {
+		peonKey, err = params.Store.GetPeonKey(server.IP)
+		if err != nil {
+			return fmt.Errorf("peon key for %s: %w", server.IP, err)
+		}
+	}
+	client, err := dialPeon(server.IP, peonKey, params.Store)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sshDir := fmt.Sprintf("/home/%s/.ssh", env.DeployUser)
+	authKeys := sshDir + "/authorized_keys"
+	newKeyPath := sshDir + "/id_ed25519.new"
+
+	// Step 2: Generate the new keypair next to the current one
+	report(2, "Generating a new deploy key...")
+	keygen := fmt.Sprintf("sudo rm -f %[1]s %[1]s.pub && sudo ssh-keygen -t ed25519 -f %[1]s -N '' -q -C %[2]s", newKeyPath, env.DeployUser)
+	if err := client.Run(keygen); err != nil {
+		return fmt.Errorf("generating key: %w", err)
+	}
+	newPrivate, err := client.Output("sudo cat " + newKeyPath)
+	if err != nil {
+		return fmt.Errorf("reading new key: %w", err)
+	}
+	newPublic, err := client.Output("sudo cat " + newKeyPath + ".pub")
+	if err != nil {
+		return fmt.Errorf("reading new key: %w", err)
+	}
+	newPrivate, newPublic = strings.TrimSpace(newPrivate), strings.TrimSpace(newPublic)
+	oldAuthorized, err := client.Output("sudo cat " + authKeys)
+	if err != nil {
+		return fmt.Errorf("reading authorized_keys: %w", err)
+	}
+
+	rollback := func(cause error) error {
+		client.Run(fmt.Sprintf("sudo rm -f %[1]s %[1]s.pub", newKeyPath))
+		if err := replaceAuthorizedKeys(client, env.DeployUser, oldAuthorized); err != nil {
+			return fmt.Errorf("%w (restoring authorized_keys also failed: %v)", cause, err)
+		}
+		return fmt.Errorf("%w; authorized_keys was restored and the old key still works", cause)
+	}
+
+	// Step 3: Authorize both keys and log in with the new one
+	report(3, "Verifying login with the new key...")
+	both := strings.TrimRight(oldAuthorized, "\n") + "\n" + newPublic + "\n"
+	if err := replaceAuthorizedKeys(client, env.DeployUser, both); err != nil {
+		return rollback(fmt.Errorf("updating authorized_keys: %w", err))
+	}
+	if err := verifyDeployLogin(server.IP, env.DeployUser, newPrivate, params.Store); err != nil {
+		return rollback(fmt.Errorf("logging in as %s with the new key: %w", env.DeployUser, err))
+	}
+
+	// Step 4: Update the GitHub secret
+	prefix := strings.ToUpper(params.EnvName)
+	report(4, "Updating "+prefix+"_VPS_SSH_KEY...")
+	if err := setSecret(proj.Repo, prefix+"_VPS_SSH_KEY", newPrivate); err != nil {
+		return rollback(err)
+	}
+
+	// Step 5: Drop the old key
+	report(5, "Removing the old key...")
+	if err := replaceAuthorizedKeys(client, env.DeployUser, newPublic+"\n"); err != nil {
+		return fmt.Errorf("removing the old key from authorized_keys (the new key is in use): %w", err)
+	}
+	promote := fmt.Sprintf("sudo mv %[1]s %[2]s/id_ed25519 && sudo mv %[1]s.pub %[2]s/id_ed25519.pub", newKeyPath, sshDir)
+	if err := client.Run(promote); err != nil {
+		return fmt.Errorf("replacing id_ed25519 (the new key is in use): %w", err)
+	}
+
+	// Step 6: Record the rotation
+	report(6, "Updating config...")
+	env.DeployKeyRotatedAt = time.Now().UTC().Truncate(time.Second)
+	proj.Environments[params.EnvName] = env
+	if err := params.Store.SaveConfig(&config.Config{Projects: []config.Project{*proj}}); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
+
+// replaceAuthorizedKeys swaps in a new authorized_keys for user in one
+// rename, so sshd never sees a partly written file.
+func replaceAuthorizedKeys(client remote.Runner, user, content string) error {
+	path := fmt.Sprintf("/home/%s/.ssh/authorized_keys", user)
+	if err := client.WriteFile(path+".tmp", content); err != nil {
+		return err
+	}
+	return client.Run(fmt.Sprintf("sudo chown %[2]s:%[2]s %[1]s.tmp && sudo chmod 600 %[1]s.tmp && sudo mv %[1]s.tmp %[1]s", path, user))
+}
+
+// verifyLogin opens a fresh connection as user with privateKey and runs a
+// no-op.
+func verifyLogin(host, user, privateKey string, store config.Store) error {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return fmt.Errorf("parsing key: %w", err)
+	}
+	client, err := remote.Dial(host, user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, store)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Run("true")
+}
+
+// KeyAge describes how long ago a deploy key was rotated, e.g. "12 days
+// old" or "rotated today". It returns "age unknown" for keys set up before
+// rotation was tracked.
+func KeyAge(rotatedAt, now time.Time) string {
+	if rotatedAt.IsZero() {
+		return "age unknown"
+	}
+	days := int(now.Sub(rotatedAt).Hours() / 24)
+	switch {
+	case days < 1:
+		return "rotated today"
+	case days == 1:
+		return "1 day old"
+	default:
+		return fmt.Sprintf("%d days old", days)
+	}
+}
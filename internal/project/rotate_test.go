@@ -0,0 +1,207 @@
+package project
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+func TestKeyAge(t *testing.T) {
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		rotatedAt time.Time
+		want      string
+	}{
+		{time.Time{}, "age unknown"},
+		{now.Add(-3 * time.Hour), "rotated today"},
+		{now.Add(-30 * time.Hour), "1 day old"},
+		{now.AddDate(0, 0, -12), "12 days old"},
+	}
+	for _, c := range cases {
+		if got := KeyAge(c.rotatedAt, now); got != c.want {
+			t.Errorf("KeyAge(%v) = %q, want %q", c.rotatedAt, got, c.want)
+		}
+	}
+}
+
+// fakeServer is a stand-in for a server's filesystem that understands the
+// commands RotateKey runs: ssh-keygen, cat, mv, rm, and chown and chmod,
+// which it ignores. Commands joined with && run in order.
+type fakeServer struct {
+	files    map[string]string
+	commands []string
+	fail     func(command string) bool
+}
+
+func (f *fakeServer) Run(command string) error {
+	f.commands = append(f.commands, command)
+	if f.fail != nil && f.fail(command) {
+		return errors.New("exit status 1")
+	}
+	for _, part := range strings.Split(command, " && ") {
+		args := strings.Fields(strings.TrimPrefix(part, "sudo "))
+		switch args[0] {
+		case "rm":
+			for _, path := range args[1:] {
+				delete(f.files, path)
+			}
+		case "mv":
+			f.files[args[2]] = f.files[args[1]]
+			delete(f.files, args[1])
+		case "ssh-keygen":
+			path := args[4]
+			f.files[path] = "PRIVATE NEW"
+			f.files[path+".pub"] = "ssh-ed25519 NEW myapp-deploy"
+		}
+	}
+	return nil
+}
+
+func (f *fakeServer) Output(command string) (string, error) {
+	f.commands = append(f.commands, command)
+	path, ok := strings.CutPrefix(command, "sudo cat ")
+	content, exists := f.files[path]
+	if !ok || !exists {
+		return "", errors.New("exit status 1")
+	}
+	return content, nil
+}
+
+func (f *fakeServer) WriteFile(path, content string) error {
+	f.files[path] = content
+	return nil
+}
+
+func (f *fakeServer) Close() error { return nil }
+
+const sshDir = "/home/myapp-deploy/.ssh"
+
+// setupRotateKey stores a prod environment whose deploy user has one key,
+// and points RotateKey's SSH and GitHub calls at fakes. It returns the
+// server, and the secrets set and keys logged in with so far.
+func setupRotateKey(t *testing.T) (*fakeServer, config.Store, map[string]string, *[]string) {
+	t.Helper()
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	store.SaveConfig(&config.Config{
+		Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}},
+		Projects: []config.Project{{Name: "myapp", Repo: "o/myapp", Server: "web1", Environments: map[string]config.Environment{
+			"prod": {Domain: "myapp.com", DeployUser: "myapp-deploy", DeployPath: "/opt/myapp"},
+		}}},
+	})
+
+	srv := &fakeServer{files: map[string]string{
+		sshDir + "/authorized_keys": "ssh-ed25519 OLD myapp-deploy\n",
+		sshDir + "/id_ed25519":      "PRIVATE OLD",
+		sshDir + "/id_ed25519.pub":  "ssh-ed25519 OLD myapp-deploy",
+	}}
+	secrets := map[string]string{}
+	var logins []string
+
+	origDial, origVerify, origSecret := dialPeon, verifyDeployLogin, setSecret
+	t.Cleanup(func() { dialPeon, verifyDeployLogin, setSecret = origDial, origVerify, origSecret })
+	dialPeon = func(host, key string, _ config.Store) (remote.Runner, error) {
+		if host != "1.2.3.4" || key != "PEON" {
+			t.Errorf("dialed %s with %q", host, key)
+		}
+		return srv, nil
+	}
+	verifyDeployLogin = func(host, user, key string, _ config.Store) error {
+		// Like sshd, only accept a key that is authorized.
+		logins = append(logins, user+" "+key)
+		if !strings.Contains(srv.files[sshDir+"/authorized_keys"], "NEW") {
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+	setSecret = func(repo, name, value string) error {
+		secrets[repo+" "+name] = value
+		return nil
+	}
+	return srv, store, secrets, &logins
+}
+
+func TestRotateKey(t *testing.T) {
+	srv, store, secrets, logins := setupRotateKey(t)
+
+	var steps int
+	err := RotateKey(RotateKeyParams{
+		ProjectName: "myapp",
+		EnvName:     "prod",
+		PeonKey:     "PEON",
+		Store:       store,
+		OnProgress:  func(step, total int, message string) { steps = step },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps != 6 {
+		t.Errorf("last step = %d, want 6", steps)
+	}
+
+	if got := srv.files[sshDir+"/authorized_keys"]; got != "ssh-ed25519 NEW myapp-deploy\n" {
+		t.Errorf("authorized_keys = %q, want the new key alone", got)
+	}
+	if srv.files[sshDir+"/id_ed25519"] != "PRIVATE NEW" || srv.files[sshDir+"/id_ed25519.pub"] != "ssh-ed25519 NEW myapp-deploy" {
+		t.Errorf("id_ed25519 not replaced: %v", srv.files)
+	}
+	if _, ok := srv.files[sshDir+"/id_ed25519.new"]; ok {
+		t.Error("id_ed25519.new left behind")
+	}
+	if len(*logins) != 1 || (*logins)[0] != "myapp-deploy PRIVATE NEW" {
+		t.Errorf("logins = %q", *logins)
+	}
+	if got := secrets["o/myapp PROD_VPS_SSH_KEY"]; got != "PRIVATE NEW" {
+		t.Errorf("PROD_VPS_SSH_KEY = %q", got)
+	}
+	cfg, _ := store.LoadConfig()
+	if rotated := cfg.FindProject("myapp").Environments["prod"].DeployKeyRotatedAt; time.Since(rotated) > time.Minute {
+		t.Errorf("rotated at %v, want now", rotated)
+	}
+}
+
+func TestRotateKeyRollsBack(t *testing.T) {
+	for name, breakIt := range map[string]func(srv *fakeServer){
+		"login fails": func(srv *fakeServer) {
+			verifyDeployLogin = func(host, user, key string, _ config.Store) error { return errors.New("permission denied") }
+		},
+		"secret fails": func(srv *fakeServer) {
+			setSecret = func(repo, name, value string) error { return errors.New("HTTP 403") }
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, store, secrets, _ := setupRotateKey(t)
+			breakIt(srv)
+
+			err := RotateKey(RotateKeyParams{ProjectName: "myapp", EnvName: "prod", PeonKey: "PEON", Store: store})
+			if err == nil || !strings.Contains(err.Error(), "authorized_keys was restored") {
+				t.Fatalf("err = %v", err)
+			}
+			if got := srv.files[sshDir+"/authorized_keys"]; got != "ssh-ed25519 OLD myapp-deploy\n" {
+				t.Errorf("authorized_keys = %q, want the old key back", got)
+			}
+			if srv.files[sshDir+"/id_ed25519"] != "PRIVATE OLD" {
+				t.Error("id_ed25519 replaced")
+			}
+			for _, path := range []string{sshDir + "/id_ed25519.new", sshDir + "/id_ed25519.new.pub"} {
+				if _, ok := srv.files[path]; ok {
+					t.Errorf("%s left behind", path)
+				}
+			}
+			if len(secrets) != 0 {
+				t.Errorf("secrets set: %v", secrets)
+			}
+			cfg, _ := store.LoadConfig()
+			if rotated := cfg.FindProject("myapp").Environments["prod"].DeployKeyRotatedAt; !rotated.IsZero() {
+				t.Errorf("rotation recorded at %v", rotated)
+			}
+		})
+	}
+}
@@ -1,17 +1,17 @@
 package project
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
 	"time"
 
-	"github.com/dukerupert/annuminas/pkg/dockerhub"
 	"github.com/dukerupert/arnor/internal/caddy"
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/arnor/internal/dns"
 	"github.com/dukerupert/arnor/internal/hetzner"
-	"golang.org/x/crypto/ssh"
+	"github.com/dukerupert/arnor/internal/plan"
+	"github.com/dukerupert/arnor/internal/registry"
+	"github.com/dukerupert/arnor/internal/remote"
 )
 
 // ProgressFunc is called to report step-by-step progress during setup.
@@ -25,11 +25,46 @@ type SetupParams struct {
 	EnvName     string // "dev" or "prod"
 	Domain      string
 	Port        int
+	Branch      string // defaults to "dev" for dev and "main" for prod
+
+	// ComposeTemplate, ContainerPort and HealthcheckPath choose the
+	// docker-compose.yml that is generated. If unset, those of an existing
+	// environment are kept, so re-running setup regenerates the same file.
+	// Without a HealthcheckPath the app gets no healthcheck.
+	ComposeTemplate string
+	ContainerPort   int
+	HealthcheckPath string
+
+	// ComposeFile is the path of a compose file in the repo, such as
+	// "compose.prod.yml", to deploy instead of a generated one. It must run
+	// the project's image and publish Port. If it and
+	// ComposeTemplate are unset, an existing environment's file is kept.
+	ComposeFile string
+
+	// Registry is where images are pushed: "dockerhub", "ghcr" or a
+	// registry host. If unset, the project's existing registry is kept,
+	// and new projects use DockerHub.
+	Registry string
+
+	DNSProvider string // detected from the domain if empty
 	PeonKey     string // PEM-encoded peon SSH key
 	Store       config.Store
 	OnProgress  ProgressFunc
+
+	// Plan, if non-nil, turns Setup into a dry run: SSH commands, DNS
+	// writes, GitHub changes and config updates are recorded into it and
+	// nothing is changed.
+	Plan *plan.Plan
 }
 
+// DNS lookups go through these variables so that tests can run against a
+// stand-in provider without resolving real nameservers.
+var (
+	newDNSProvider       = dns.NewProvider
+	dnsProviderForDomain = dns.ProviderForDomain
+	lookupRootDomain     = config.RootDomain
+)
+
 // Setup runs the full project creation orchestration for a single environment.
 func Setup(params SetupParams) error {
 	const totalSteps = 10
@@ -44,48 +79,90 @@ func Setup(params SetupParams) error {
 		return fmt.Errorf("loading config: %w", err)
 	}
 
-	// Step 1: Look up server IP
-	report(1, "Looking up server...")
-	server := cfg.FindServer(params.ServerName)
-	if server == nil {
-		mgr, err := hetzner.NewManager(cfg.HetznerProjects, params.Store)
-		if err != nil {
-			return fmt.Errorf("creating Hetzner manager: %w", err)
+	templateName, containerPort, composeFile := params.ComposeTemplate, params.ContainerPort, params.ComposeFile
+	healthcheckPath := params.HealthcheckPath
+	registryName := params.Registry
+	if p := cfg.FindProject(params.ProjectName); p != nil {
+		if registryName == "" {
+			registryName = p.Registry
 		}
-		s, err := mgr.GetServer(params.ServerName)
-		if err != nil {
-			return fmt.Errorf("server %q not found in config or Hetzner: %w", params.ServerName, err)
+		if existing, ok := p.Environments[params.EnvName]; ok {
+			if templateName == "" && composeFile == "" {
+				templateName, composeFile = existing.ComposeTemplate, existing.ComposeFile
+			}
+			if containerPort == 0 {
+				containerPort = existing.ContainerPort
+			}
+			if healthcheckPath == "" {
+				healthcheckPath = existing.HealthcheckPath
+			}
 		}
-		server = &config.Server{
-			Name:           s.Name,
-			IP:             s.PublicNet.IPv4.IP,
-			HetznerProject: s.ProjectAlias,
-			HetznerID:      s.ID,
+	}
+	tmpl, err := LookupComposeTemplate(templateName)
+	if err != nil {
+		return err
+	}
+	if err := ValidateHealthcheckPath(healthcheckPath); err != nil {
+		return err
+	}
+	branch := params.Branch
+	if branch == "" {
+		branch = "dev"
+		if params.EnvName == "prod" {
+			branch = "main"
 		}
 	}
 
+	// Step 1: Look up server IP
+	report(1, "Looking up server...")
+	server, err := LookupServer(cfg, params.ServerName, params.Store)
+	if err != nil {
+		return err
+	}
+
 	// Step 2: Detect DNS provider
 	report(2, "Detecting DNS provider...")
-	provider, err := dns.ProviderForDomain(params.Domain, cfg, params.Store)
+	var provider dns.Provider
+	if params.DNSProvider != "" {
+		provider, err = newDNSProvider(params.DNSProvider, params.Store)
+	} else {
+		provider, err = dnsProviderForDomain(params.Domain, cfg, params.Store)
+	}
 	if err != nil {
 		return fmt.Errorf("detecting DNS provider for %s: %w", params.Domain, err)
 	}
+	var gh gitHub = ghCLI{}
+	if params.Plan != nil {
+		provider = dns.NewRecorder(provider, params.Plan)
+		gh = ghRecorder{plan: params.Plan}
+	}
 
-	// Step 3: Create DockerHub repo
-	report(3, "Creating DockerHub repo...")
-	dockerHubUsername, err := params.Store.GetCredential("dockerhub", "default", "username")
+	// Step 3: Prepare the image registry
+	reg, err := registry.New(registryName, params.Store)
 	if err != nil {
-		return fmt.Errorf("dockerhub username: %w", err)
+		return err
 	}
-	dockerHubPassword, err := params.Store.GetCredential("dockerhub", "default", "password")
-	if err != nil {
-		return fmt.Errorf("dockerhub password: %w", err)
+	report(3, fmt.Sprintf("Preparing %s registry...", reg.Login().Title))
+	dockerImage := reg.Image(params.ProjectName, params.Repo)
+
+	// Check a repo compose file before anything is created for it.
+	var compose string
+	if composeFile != "" {
+		compose, err = gh.ReadFile(params.Repo, composeFile, branch)
+		if err != nil {
+			return err
+		}
+		if err := ValidateCompose(compose, dockerImage, params.Port); err != nil {
+			return fmt.Errorf("%s: %w", composeFile, err)
+		}
 	}
-	dockerHubToken, _ := params.Store.GetCredential("dockerhub", "default", "token")
-	dockerImage := dockerHubUsername + "/" + params.ProjectName
-	dhClient := dockerhub.NewClient(dockerHubUsername, dockerHubPassword)
-	if err := dhClient.EnsureRepo(dockerHubUsername, params.ProjectName); err != nil {
-		return fmt.Errorf("creating DockerHub repo: %w", err)
+
+	if creator, ok := reg.(registry.RepositoryCreator); ok {
+		if params.Plan != nil {
+			params.Plan.AddExternal(fmt.Sprintf("ensure %s repository %s", reg.Login().Title, dockerImage))
+		} else if err := creator.EnsureRepository(params.ProjectName); err != nil {
+			return err
+		}
 	}
 
 	// Step 4: SSH setup
@@ -93,38 +170,80 @@ func Setup(params SetupParams) error {
 	deployUser := deployUserName(params.ProjectName, params.EnvName)
 	deployPath := fmt.Sprintf("/opt/%s", deployDirName(params.ProjectName, params.EnvName))
 
-	peonKey := params.PeonKey
-	if peonKey == "" {
-		peonKey, err = params.Store.GetPeonKey(server.IP)
+	var client remote.Runner
+	if params.Plan != nil {
+		client = remote.NewRecorder(server.IP, params.Plan)
+	} else {
+		peonKey := params.PeonKey
+		if peonKey == "" {
+			peonKey, err = params.Store.GetPeonKey(server.IP)
+			if err != nil {
+				return fmt.Errorf("peon key for %s: %w", server.IP, err)
+			}
+		}
+		client, err = remote.DialPeon(server.IP, peonKey, params.Store)
 		if err != nil {
-			return fmt.Errorf("peon key for %s: %w", server.IP, err)
+			return err
 		}
 	}
+	defer client.Close()
 
-	sshResult, err := RunSetup(server.IP, deployUser, deployPath, peonKey)
+	sshResult, err := RunSetup(client, deployUser, deployPath)
 	if err != nil {
 		return fmt.Errorf("SSH setup: %w", err)
 	}
 
-	// Step 5: Write docker-compose.yml
-	report(5, "Writing docker-compose.yml...")
-	if err := writeComposeFile(server.IP, peonKey, deployPath, deployUser, dockerImage, params.Port); err != nil {
+	// Step 5: Write docker-compose.yml and .env
+	if composeFile != "" {
+		report(5, fmt.Sprintf("Writing docker-compose.yml (from %s) and .env...", composeFile))
+	} else {
+		report(5, fmt.Sprintf("Writing docker-compose.yml (%s) and .env...", tmpl.Name))
+		compose, err = RenderCompose(ComposeParams{
+			Template:        tmpl,
+			DockerImage:     dockerImage,
+			Port:            params.Port,
+			ContainerPort:   containerPort,
+			HealthcheckPath: healthcheckPath,
+		})
+		if err != nil {
+			return fmt.Errorf("rendering docker-compose.yml: %w", err)
+		}
+	}
+	if err := writeComposeFile(client, deployPath, deployUser, compose); err != nil {
 		return fmt.Errorf("writing docker-compose.yml: %w", err)
 	}
+	if params.Plan == nil && composeFile == "" {
+		if err := ensureTemplateEnvVars(params.Store, params.ProjectName, params.EnvName, tmpl); err != nil {
+			return err
+		}
+	}
+	envVars, err := ListEnvVars(params.Store, params.ProjectName, params.EnvName)
+	if err != nil {
+		return err
+	}
+	if params.Plan != nil {
+		// The plan shows file contents; keep the values out of it.
+		for i := range envVars {
+			envVars[i].Value = "***"
+		}
+	}
+	if err := WriteEnvFile(client, deployPath, deployUser, envVars); err != nil {
+		return err
+	}
 
 	// Step 6: Write Caddy config
 	report(6, "Writing Caddy config...")
 	caddyConfig := caddy.Generate(params.Domain, params.Port, provider.Name())
-	if err := writeCaddyConfig(server.IP, peonKey, params.Domain, caddyConfig); err != nil {
+	if err := writeCaddyConfig(client, params.Domain, caddyConfig); err != nil {
 		return fmt.Errorf("writing Caddy config: %w", err)
 	}
 
-	// Step 7: Create DNS records
-	report(7, "Creating DNS records...")
+	// Step 7: Point DNS at the server
+	report(7, "Updating DNS records...")
 
 	// Split domain into root domain and subdomain name for the DNS API.
 	// e.g. "foo.angmar.dev" -> root "angmar.dev", subName "foo"
-	rootDomain, err := config.RootDomain(params.Domain)
+	rootDomain, err := lookupRootDomain(params.Domain)
 	if err != nil {
 		return fmt.Errorf("resolving root domain for %s: %w", params.Domain, err)
 	}
@@ -133,18 +252,19 @@ func Setup(params SetupParams) error {
 		subName = strings.TrimSuffix(params.Domain, "."+rootDomain)
 	}
 
-	// Remove existing A/CNAME/ALIAS records for the domain before creating new ones.
+	// Upsert the A record so the name keeps resolving throughout. Only
+	// records that conflict with an A record (CNAME/ALIAS) are removed first.
 	if existing, err := provider.ListRecords(rootDomain); err == nil {
 		for _, r := range existing {
-			if r.Name == params.Domain && (r.Type == "A" || r.Type == "CNAME" || r.Type == "ALIAS") {
+			if r.Name == params.Domain && (r.Type == "CNAME" || r.Type == "ALIAS") {
 				provider.DeleteRecord(rootDomain, r.ID)
 			}
 		}
 	}
 
-	_, err = provider.CreateRecord(rootDomain, subName, "A", server.IP, "600")
+	_, err = provider.UpsertRecord(rootDomain, dns.DNSRecord{Name: subName, Type: "A", Content: server.IP, TTL: "600"})
 	if err != nil {
-		return fmt.Errorf("creating A record: %w", err)
+		return fmt.Errorf("upserting A record: %w", err)
 	}
 
 	// Best-effort www CNAME
@@ -152,33 +272,23 @@ func Setup(params SetupParams) error {
 	if subName != "" {
 		wwwName = "www." + subName
 	}
-	provider.CreateRecord(rootDomain, wwwName, "CNAME", params.Domain, "600")
+	provider.UpsertRecord(rootDomain, dns.DNSRecord{Name: wwwName, Type: "CNAME", Content: params.Domain, TTL: "600"})
 
 	// Step 8: Set GitHub Actions secrets
 	report(8, "Setting GitHub secrets...")
 	prefix := strings.ToUpper(params.EnvName)
-	// Prefer PAT for CI (narrower scope); fall back to password
-	dockerHubCI := dockerHubToken
-	if dockerHubCI == "" {
-		dockerHubCI = dockerHubPassword
-	}
-	if err := SetEnvironmentSecrets(params.Repo, prefix, deployUser, deployPath, sshResult.DeployPrivateKey, server.IP, dockerHubUsername, dockerHubCI, params.Port); err != nil {
+	if err := setEnvironmentSecrets(gh, params.Repo, prefix, deployUser, deployPath, sshResult.DeployPrivateKey, server.IP, reg.Secrets(), params.Port); err != nil {
 		return fmt.Errorf("setting GitHub secrets: %w", err)
 	}
 
 	// Step 9: Generate workflow files
 	report(9, "Generating workflow files...")
-	if err := generateWorkflowFile(params.Repo, params.EnvName, dockerImage); err != nil {
+	if err := generateWorkflowFile(gh, params.Repo, params.EnvName, dockerImage, reg.Login(), composeFile); err != nil {
 		return fmt.Errorf("generating workflow: %w", err)
 	}
 
 	// Step 10: Update config
 	report(10, "Updating config...")
-	branch := "dev"
-	if params.EnvName == "prod" {
-		branch = "main"
-	}
-
 	env := config.Environment{
 		Domain:      params.Domain,
 		DNSProvider: provider.Name(),
@@ -186,6 +296,18 @@ func Setup(params SetupParams) error {
 		DeployPath:  deployPath,
 		DeployUser:  deployUser,
 		Port:        params.Port,
+
+		DeployKeyRotatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if composeFile != "" {
+		env.ComposeFile = composeFile
+	} else {
+		env.ComposeTemplate, env.ContainerPort, env.HealthcheckPath = tmpl.Name, containerPort, healthcheckPath
+	}
+
+	if params.Plan != nil {
+		params.Plan.SetEnvironment(cfg, params.ProjectName, params.Repo, params.ServerName, reg.Name(), params.EnvName, env)
+		return nil
 	}
 
 	existingProject := cfg.FindProject(params.ProjectName)
@@ -194,6 +316,9 @@ func Setup(params SetupParams) error {
 			existingProject.Environments = make(map[string]config.Environment)
 		}
 		existingProject.Environments[params.EnvName] = env
+		existingProject.Repo = params.Repo
+		existingProject.Server = params.ServerName
+		existingProject.Registry = reg.Name()
 	} else {
 		cfg.Projects = append(cfg.Projects, config.Project{
 			Name:   params.ProjectName,
@@ -202,6 +327,7 @@ func Setup(params SetupParams) error {
 			Environments: map[string]config.Environment{
 				params.EnvName: env,
 			},
+			Registry: reg.Name(),
 		})
 	}
 
@@ -212,6 +338,27 @@ func Setup(params SetupParams) error {
 	return nil
 }
 
+// LookupServer finds a server in the config, falling back to the Hetzner API.
+func LookupServer(cfg *config.Config, name string, store config.Store) (*config.Server, error) {
+	if server := cfg.FindServer(name); server != nil {
+		return server, nil
+	}
+	mgr, err := hetzner.NewManager(cfg.HetznerProjects, store)
+	if err != nil {
+		return nil, fmt.Errorf("creating Hetzner manager: %w", err)
+	}
+	s, err := mgr.GetServer(name)
+	if err != nil {
+		return nil, fmt.Errorf("server %q not found in config or Hetzner: %w", name, err)
+	}
+	return &config.Server{
+		Name:           s.Name,
+		IP:             s.PublicNet.IPv4.IP,
+		HetznerProject: s.ProjectAlias,
+		HetznerID:      s.ID,
+	}, nil
+}
+
 func deployUserName(project, env string) string {
 	if env == "dev" {
 		return project + "-dev-deploy"
@@ -226,121 +373,50 @@ func deployDirName(project, env string) string {
 	return project
 }
 
-func writeCaddyConfig(serverIP, peonKeyPEM, domain, caddyConfig string) error {
-	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
-	if err != nil {
-		return fmt.Errorf("parsing peon SSH key: %w", err)
-	}
-
-	sshConfig := &ssh.ClientConfig{
-		User:            "peon",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	client, err := ssh.Dial("tcp", serverIP+":22", sshConfig)
-	if err != nil {
-		return fmt.Errorf("SSH dial to %s: %w", serverIP, err)
-	}
-	defer client.Close()
-
+func writeCaddyConfig(client remote.Runner, domain, caddyConfig string) error {
 	// Ensure sites directory exists
-	if err := runSSHCommand(client, "sudo mkdir -p /etc/caddy/conf.d"); err != nil {
+	if err := client.Run("sudo mkdir -p /etc/caddy/conf.d"); err != nil {
 		return fmt.Errorf("creating caddy sites dir: %w", err)
 	}
 
-	// Write config via stdin to avoid shell escaping issues with echo
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("creating SSH session: %w", err)
-	}
-	var stderr bytes.Buffer
-	session.Stdin = strings.NewReader(caddyConfig)
-	session.Stderr = &stderr
-	if err := session.Run(fmt.Sprintf("sudo tee /etc/caddy/conf.d/%s.caddy > /dev/null", domain)); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		session.Close()
-		if errMsg != "" {
-			return fmt.Errorf("writing caddy config: %s", errMsg)
-		}
+	if err := client.WriteFile(fmt.Sprintf("/etc/caddy/conf.d/%s.caddy", domain), caddyConfig); err != nil {
 		return fmt.Errorf("writing caddy config: %w", err)
 	}
-	session.Close()
 
 	// Validate config before reloading so we get a useful error message.
 	// Source the Caddy service environment (e.g. CF_API_TOKEN from systemd override)
 	// so the cloudflare DNS module can provision during validation.
-	validateOut, err := runSSHCommandOutput(client, `sudo bash -c 'for e in $(systemctl show caddy -p Environment --value); do export "$e"; done; caddy validate --config /etc/caddy/Caddyfile' 2>&1`)
+	validateOut, err := client.Output(`sudo bash -c 'for e in $(systemctl show caddy -p Environment --value); do export "$e"; done; caddy validate --config /etc/caddy/Caddyfile' 2>&1`)
 	if err != nil {
 		return fmt.Errorf("caddy config validation failed: %s", strings.TrimSpace(validateOut))
 	}
 
-	if err := runSSHCommand(client, "sudo systemctl reload caddy"); err != nil {
+	if err := client.Run("sudo systemctl reload caddy"); err != nil {
 		// Grab journal output for context
-		journalOut, _ := runSSHCommandOutput(client, "sudo journalctl -u caddy -n 20 --no-pager 2>&1")
+		journalOut, _ := client.Output("sudo journalctl -u caddy -n 20 --no-pager 2>&1")
 		return fmt.Errorf("reloading caddy: %w\njournal output:\n%s", err, strings.TrimSpace(journalOut))
 	}
 	return nil
 }
 
-func writeComposeFile(serverIP, peonKeyPEM, deployPath, deployUser, dockerImage string, port int) error {
-	content := fmt.Sprintf(`services:
-  web:
-    image: ${DOCKER_IMAGE:-%s}
-    ports:
-      - "${LISTEN_PORT:-%d}:80"
-    restart: unless-stopped
-`, dockerImage, port)
-
-	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
-	if err != nil {
-		return fmt.Errorf("parsing peon SSH key: %w", err)
-	}
-
-	sshConfig := &ssh.ClientConfig{
-		User:            "peon",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	client, err := ssh.Dial("tcp", serverIP+":22", sshConfig)
-	if err != nil {
-		return fmt.Errorf("SSH dial to %s: %w", serverIP, err)
-	}
-	defer client.Close()
-
+// writeComposeFile uploads a rendered docker-compose.yml, owned by the
+// deploy user who runs it.
+func writeComposeFile(client remote.Runner, deployPath, deployUser, content string) error {
 	composePath := deployPath + "/docker-compose.yml"
-
-	// Write file via sudo tee
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("creating SSH session: %w", err)
-	}
-	var stderr bytes.Buffer
-	session.Stdin = strings.NewReader(content)
-	session.Stderr = &stderr
-	if err := session.Run(fmt.Sprintf("sudo tee %s > /dev/null", composePath)); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		session.Close()
-		if errMsg != "" {
-			return fmt.Errorf("writing compose file: %s", errMsg)
-		}
+	if err := client.WriteFile(composePath, content); err != nil {
 		return fmt.Errorf("writing compose file: %w", err)
 	}
-	session.Close()
 
 	// Chown to deploy user
-	if err := runSSHCommand(client, fmt.Sprintf("sudo chown %s:%s %s", deployUser, deployUser, composePath)); err != nil {
+	if err := client.Run(fmt.Sprintf("sudo chown %s:%s %s", deployUser, deployUser, composePath)); err != nil {
 		return fmt.Errorf("chowning compose file: %w", err)
 	}
 
 	return nil
 }
 
-func generateWorkflowFile(repo, envName, dockerImage string) error {
-	branch, err := DefaultBranch(repo)
+func generateWorkflowFile(gh gitHub, repo, envName, dockerImage string, login registry.Login, composeFile string) error {
+	branch, err := gh.DefaultBranch(repo)
 	if err != nil {
 		return fmt.Errorf("detecting default branch: %w", err)
 	}
@@ -350,10 +426,10 @@ func generateWorkflowFile(repo, envName, dockerImage string) error {
 
 	switch envName {
 	case "dev":
-		content, err = GenerateDevWorkflow(dockerImage)
+		content, err = GenerateDevWorkflow(dockerImage, login, composeFile)
 		filename = "deploy-dev.yml"
 	case "prod":
-		content, err = GenerateProdWorkflow(dockerImage, branch)
+		content, err = GenerateProdWorkflow(dockerImage, login, branch, composeFile)
 		filename = "deploy-prod.yml"
 	default:
 		return fmt.Errorf("unknown environment: %s", envName)
@@ -363,12 +439,12 @@ func generateWorkflowFile(repo, envName, dockerImage string) error {
 	}
 
 	// Remove any non-arnor workflow files before pushing ours.
-	if err := DeleteStaleWorkflows(repo, branch); err != nil {
+	if err := gh.DeleteStaleWorkflows(repo, branch); err != nil {
 		return fmt.Errorf("cleaning stale workflows: %w", err)
 	}
 
 	path := ".github/workflows/" + filename
 	commitMsg := fmt.Sprintf("Add %s deploy workflow", envName)
 
-	return PushWorkflowFile(repo, path, content, branch, commitMsg)
+	return gh.PushFile(repo, path, content, branch, commitMsg)
 }
@@ -0,0 +1,123 @@
+package project
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/plan"
+)
+
+// zoneProvider is a DNS provider with a fixed zone. Dry runs only read from
+// it; the embedded nil Provider panics on writes.
+type zoneProvider struct {
+	dns.Provider
+	name    string
+	records []dns.DNSRecord
+}
+
+func (z zoneProvider) Name() string { return z.name }
+
+func (z zoneProvider) ListRecords(domain string) ([]dns.DNSRecord, error) { return z.records, nil }
+
+// remoteOps renders a plan's remote operations one per line.
+func remoteOps(ops []plan.RemoteOp) []string {
+	var lines []string
+	for _, op := range ops {
+		if op.Path != "" {
+			lines = append(lines, "write "+op.Path)
+		} else {
+			lines = append(lines, op.Command)
+		}
+	}
+	return lines
+}
+
+func TestSetupPlan(t *testing.T) {
+	fakeGH(t, "")
+	store := newDestroyStore(t)
+	origProvider, origRoot := newDNSProvider, lookupRootDomain
+	t.Cleanup(func() { newDNSProvider, lookupRootDomain = origProvider, origRoot })
+	lookupRootDomain = func(string) (string, error) { return "example.com", nil }
+	newDNSProvider = func(name string, _ config.Store) (dns.Provider, error) {
+		return zoneProvider{name: name, records: []dns.DNSRecord{
+			{ID: "7", Name: "new.example.com", Type: "CNAME", Content: "old.example.net", TTL: "600"},
+		}}, nil
+	}
+
+	p := plan.New("project create new")
+	err := Setup(SetupParams{
+		ProjectName: "new",
+		Repo:        "o/app",
+		ServerName:  "web1",
+		EnvName:     "prod",
+		Domain:      "new.example.com",
+		Port:        3001,
+		DNSProvider: "porkbun",
+		Registry:    "ghcr",
+		Store:       store,
+		Plan:        p,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRemote := []string{
+		"sudo useradd -m -s /bin/bash new-deploy 2>/dev/null || true",
+		"sudo usermod -aG docker new-deploy 2>/dev/null || true",
+		"sudo mkdir -p /opt/new",
+		"sudo chown new-deploy:new-deploy /opt/new",
+		"sudo mkdir -p /home/new-deploy/.ssh",
+		"sudo ssh-keygen -t ed25519 -f /home/new-deploy/.ssh/id_ed25519 -N '' -q <<< y 2>/dev/null || true",
+		"sudo cp /home/new-deploy/.ssh/id_ed25519.pub /home/new-deploy/.ssh/authorized_keys",
+		"sudo chown -R new-deploy:new-deploy /home/new-deploy/.ssh",
+		"sudo chmod 700 /home/new-deploy/.ssh",
+		"sudo chmod 600 /home/new-deploy/.ssh/authorized_keys",
+		"write /opt/new/docker-compose.yml",
+		"sudo chown new-deploy:new-deploy /opt/new/docker-compose.yml",
+		"sudo install -m 640 -o root -g new-deploy /dev/null /opt/new/.env.tmp",
+		"write /opt/new/.env.tmp",
+		"sudo mv /opt/new/.env.tmp /opt/new/.env",
+		"sudo mkdir -p /etc/caddy/conf.d",
+		"write /etc/caddy/conf.d/new.example.com.caddy",
+		`sudo bash -c 'for e in $(systemctl show caddy -p Environment --value); do export "$e"; done; caddy validate --config /etc/caddy/Caddyfile' 2>&1`,
+		"sudo systemctl reload caddy",
+	}
+	if got := remoteOps(p.Remote); !reflect.DeepEqual(got, wantRemote) {
+		t.Errorf("remote:\n got %q\nwant %q", got, wantRemote)
+	}
+	if got := remoteOps(p.Reads); !reflect.DeepEqual(got, []string{"sudo cat /home/new-deploy/.ssh/id_ed25519"}) {
+		t.Errorf("reads = %q", got)
+	}
+
+	var dnsChanges []string
+	for _, c := range p.DNS {
+		dnsChanges = append(dnsChanges, c.Action+" "+c.Type+" "+c.Name+" "+c.Content)
+	}
+	wantDNS := []string{
+		"delete CNAME new.example.com old.example.net",
+		"upsert A new.example.com 1.2.3.4",
+		"upsert CNAME www.new.example.com new.example.com",
+	}
+	if !reflect.DeepEqual(dnsChanges, wantDNS) {
+		t.Errorf("dns:\n got %q\nwant %q", dnsChanges, wantDNS)
+	}
+
+	wantSecrets := []string{"o/app: PROD_PORT", "o/app: PROD_VPS_DEPLOY_PATH", "o/app: PROD_VPS_SSH_KEY", "o/app: PROD_VPS_USER", "o/app: VPS_HOST"}
+	if !reflect.DeepEqual(p.Secrets, wantSecrets) {
+		t.Errorf("secrets = %q, want %q", p.Secrets, wantSecrets)
+	}
+	if len(p.RepoFiles) != 1 || p.RepoFiles[0].Path != ".github/workflows/deploy-prod.yml" || p.RepoFiles[0].Branch != "main" {
+		t.Errorf("repo files = %+v", p.RepoFiles)
+	}
+	if len(p.Config) != 2 || !strings.HasPrefix(p.Config[0], "projects: insert new") || !strings.HasPrefix(p.Config[1], "environments: insert new/prod") {
+		t.Errorf("config = %q", p.Config)
+	}
+
+	cfg, _ := store.LoadConfig()
+	if cfg.FindProject("new") != nil {
+		t.Error("dry run saved the project")
+	}
+}
@@ -2,13 +2,14 @@ package project
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/remote"
 )
 
 // SSHResult contains output from SSH setup commands.
@@ -16,29 +17,9 @@ type SSHResult struct {
 	DeployPrivateKey string
 }
 
-// RunSetup connects to the VPS as peon and creates the deploy user,
-// deploy path, docker group membership, and SSH keypair.
-func RunSetup(serverIP, deployUser, deployPath, peonKeyPEM string) (*SSHResult, error) {
-	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
-	if err != nil {
-		return nil, fmt.Errorf("parsing peon SSH key: %w", err)
-	}
-
-	config := &ssh.ClientConfig{
-		User: "peon",
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	client, err := ssh.Dial("tcp", serverIP+":22", config)
-	if err != nil {
-		return nil, fmt.Errorf("SSH dial to %s: %w", serverIP, err)
-	}
-	defer client.Close()
-
+// RunSetup creates the deploy user, deploy path, docker group membership,
+// and SSH keypair over an open peon connection.
+func RunSetup(client remote.Runner, deployUser, deployPath string) (*SSHResult, error) {
 	// Create deploy user with home dir and docker group
 	commands := []string{
 		fmt.Sprintf("sudo useradd -m -s /bin/bash %s 2>/dev/null || true", deployUser),
@@ -55,13 +36,13 @@ func RunSetup(serverIP, deployUser, deployPath, peonKeyPEM string) (*SSHResult,
 	}
 
 	for _, c := range commands {
-		if err := runSSHCommand(client, c); err != nil {
+		if err := client.Run(c); err != nil {
 			return nil, fmt.Errorf("running %q: %w", c, err)
 		}
 	}
 
 	// Read the generated private key to return for GitHub secrets
-	keyOutput, err := runSSHCommandOutput(client, fmt.Sprintf("sudo cat /home/%s/.ssh/id_ed25519", deployUser))
+	keyOutput, err := client.Output(fmt.Sprintf("sudo cat /home/%s/.ssh/id_ed25519", deployUser))
 	if err != nil {
 		return nil, fmt.Errorf("reading deploy key: %w", err)
 	}
@@ -71,25 +52,6 @@ func RunSetup(serverIP, deployUser, deployPath, peonKeyPEM string) (*SSHResult,
 	}, nil
 }
 
-func runSSHCommand(client *ssh.Client, command string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-	return session.Run(command)
-}
-
-func runSSHCommandOutput(client *ssh.Client, command string) (string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return "", err
-	}
-	defer session.Close()
-	out, err := session.Output(command)
-	return string(out), err
-}
-
 // DockerContainer holds parsed output from docker ps.
 type DockerContainer struct {
 	Name   string
@@ -99,26 +61,14 @@ type DockerContainer struct {
 }
 
 // DockerPS SSHs into the server as peon and returns running Docker containers.
-func DockerPS(serverIP, peonKeyPEM string) ([]DockerContainer, error) {
-	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
-	if err != nil {
-		return nil, fmt.Errorf("parsing peon SSH key: %w", err)
-	}
-
-	config := &ssh.ClientConfig{
-		User:            "peon",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	client, err := ssh.Dial("tcp", serverIP+":22", config)
+func DockerPS(serverIP, peonKeyPEM string, store config.Store) ([]DockerContainer, error) {
+	client, err := remote.DialPeon(serverIP, peonKeyPEM, store)
 	if err != nil {
-		return nil, fmt.Errorf("SSH dial to %s: %w", serverIP, err)
+		return nil, err
 	}
 	defer client.Close()
 
-	output, err := runSSHCommandOutput(client, `docker ps --format '{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Ports}}'`)
+	output, err := client.Output(`docker ps --format '{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Ports}}'`)
 	if err != nil {
 		return nil, fmt.Errorf("running docker ps: %w", err)
 	}
@@ -148,42 +98,45 @@ func DockerPS(serverIP, peonKeyPEM string) ([]DockerContainer, error) {
 	return containers, nil
 }
 
-// hostPortRe matches host-side port bindings in docker ps output, e.g. "0.0.0.0:3000->3000/tcp".
-var hostPortRe = regexp.MustCompile(`:(\d+)->`)
+// hostPortRe matches host-side port bindings in docker ps output, e.g.
+// "0.0.0.0:3000->3000/tcp" or "[::]:3000->3000/tcp".
+var hostPortRe = regexp.MustCompile(`(\S*):(\d+)->`)
 
-// GetUsedPorts SSHs into the server as peon and returns the host-side ports
-// currently bound by Docker containers.
-func GetUsedPorts(serverIP, peonKeyPEM string) ([]int, error) {
-	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
-	if err != nil {
-		return nil, fmt.Errorf("parsing peon SSH key: %w", err)
-	}
+// PortBinding is a host address and port that Docker publishes a container
+// port on.
+type PortBinding struct {
+	Addr string // e.g. "0.0.0.0", "127.0.0.1" or "::"
+	Port int
+}
 
-	config := &ssh.ClientConfig{
-		User:            "peon",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
+// Public reports whether the binding is reachable from outside the server.
+func (b PortBinding) Public() bool {
+	ip := net.ParseIP(b.Addr)
+	return ip == nil || !ip.IsLoopback()
+}
 
-	client, err := ssh.Dial("tcp", serverIP+":22", config)
+// GetUsedPorts SSHs into the server as peon and returns the host-side ports
+// currently bound by Docker containers.
+func GetUsedPorts(serverIP, peonKeyPEM string, store config.Store) ([]int, error) {
+	client, err := remote.DialPeon(serverIP, peonKeyPEM, store)
 	if err != nil {
-		return nil, fmt.Errorf("SSH dial to %s: %w", serverIP, err)
+		return nil, err
 	}
 	defer client.Close()
+	return UsedPorts(client)
+}
 
-	output, err := runSSHCommandOutput(client, "docker ps --format '{{.Ports}}'")
+// UsedPorts returns the host-side ports bound by Docker containers over an
+// open peon connection.
+func UsedPorts(client remote.Runner) ([]int, error) {
+	bindings, err := PortBindings(client)
 	if err != nil {
-		return nil, fmt.Errorf("running docker ps: %w", err)
+		return nil, err
 	}
 
 	seen := make(map[int]bool)
-	for _, match := range hostPortRe.FindAllStringSubmatch(output, -1) {
-		port, err := strconv.Atoi(match[1])
-		if err != nil {
-			continue
-		}
-		seen[port] = true
+	for _, b := range bindings {
+		seen[b.Port] = true
 	}
 
 	ports := make([]int, 0, len(seen))
@@ -194,6 +147,29 @@ func GetUsedPorts(serverIP, peonKeyPEM string) ([]int, error) {
 	return ports, nil
 }
 
+// PortBindings returns every host address and port bound by Docker
+// containers over an open peon connection.
+func PortBindings(client remote.Runner) ([]PortBinding, error) {
+	output, err := client.Output("docker ps --format '{{.Ports}}'")
+	if err != nil {
+		return nil, fmt.Errorf("running docker ps: %w", err)
+	}
+	return parsePortBindings(output), nil
+}
+
+func parsePortBindings(output string) []PortBinding {
+	var bindings []PortBinding
+	for _, match := range hostPortRe.FindAllStringSubmatch(output, -1) {
+		port, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		addr := strings.Trim(match[1], "[]")
+		bindings = append(bindings, PortBinding{Addr: addr, Port: port})
+	}
+	return bindings
+}
+
 // SuggestPort returns the lowest port starting from 3000 that is not in usedPorts.
 func SuggestPort(usedPorts []int) int {
 	used := make(map[int]bool, len(usedPorts))
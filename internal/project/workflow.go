@@ -2,7 +2,11 @@ package project
 
 import (
 	"bytes"
+	"path"
+	"strings"
 	"text/template"
+
+	"github.com/dukerupert/arnor/internal/registry"
 )
 
 var devWorkflowTmpl = template.Must(template.New("dev").Parse(`name: Deploy Dev
@@ -14,6 +18,12 @@ on:
 
 env:
   IMAGE_NAME: {{ .DockerImage }}
+{{- if .Login.PackagesWrite }}
+
+permissions:
+  contents: read
+  packages: write
+{{- end }}
 
 jobs:
   deploy:
@@ -21,11 +31,14 @@ jobs:
     steps:
       - uses: actions/checkout@v4
 
-      - name: Login to DockerHub
+      - name: Login to {{ .Login.Title }}
         uses: docker/login-action@v3
         with:
-          username: ${{ "{{" }} secrets.DOCKERHUB_USERNAME {{ "}}" }}
-          password: ${{ "{{" }} secrets.DOCKERHUB_TOKEN {{ "}}" }}
+{{- with .Login.Host }}
+          registry: {{ . }}
+{{- end }}
+          username: {{ .Login.Username }}
+          password: {{ .Login.Password }}
 
       - name: Build and push
         uses: docker/build-push-action@v6
@@ -33,7 +46,17 @@ jobs:
           context: .
           push: true
           tags: ${{ "{{" }} env.IMAGE_NAME {{ "}}" }}:dev-${{ "{{" }} github.sha {{ "}}" }}
-
+{{ if .ComposeFile }}
+      - name: Copy compose file
+        uses: appleboy/scp-action@v1
+        with:
+          host: ${{ "{{" }} secrets.VPS_HOST {{ "}}" }}
+          username: ${{ "{{" }} secrets.DEV_VPS_USER {{ "}}" }}
+          key: ${{ "{{" }} secrets.DEV_VPS_SSH_KEY {{ "}}" }}
+          source: {{ .ComposeFile }}
+          target: ${{ "{{" }} secrets.DEV_VPS_DEPLOY_PATH {{ "}}" }}/.compose
+          strip_components: {{ .StripComponents }}
+{{ end }}
       - name: Deploy to VPS
         uses: appleboy/ssh-action@v1
         with:
@@ -41,8 +64,11 @@ jobs:
           username: ${{ "{{" }} secrets.DEV_VPS_USER {{ "}}" }}
           key: ${{ "{{" }} secrets.DEV_VPS_SSH_KEY {{ "}}" }}
           script: |
-            echo "${{ "{{" }} secrets.DOCKERHUB_TOKEN {{ "}}" }}" | docker login -u "${{ "{{" }} secrets.DOCKERHUB_USERNAME {{ "}}" }}" --password-stdin
+            echo "{{ .Login.Password }}" | docker login{{ with .Login.Host }} {{ . }}{{ end }} -u "{{ .Login.Username }}" --password-stdin
             cd ${{ "{{" }} secrets.DEV_VPS_DEPLOY_PATH {{ "}}" }}
+{{- if .ComposeFile }}
+            mv -f .compose/{{ .ComposeBase }} docker-compose.yml && rm -rf .compose
+{{- end }}
             export DOCKER_IMAGE=${{ "{{" }} env.IMAGE_NAME {{ "}}" }}:dev-${{ "{{" }} github.sha {{ "}}" }}
             docker compose pull
             docker compose down || true
@@ -59,6 +85,12 @@ on:
 
 env:
   IMAGE_NAME: {{ .DockerImage }}
+{{- if .Login.PackagesWrite }}
+
+permissions:
+  contents: read
+  packages: write
+{{- end }}
 
 jobs:
   deploy:
@@ -75,11 +107,14 @@ jobs:
             echo "tag=build-${GITHUB_SHA::7}" >> "$GITHUB_OUTPUT"
           fi
 
-      - name: Login to DockerHub
+      - name: Login to {{ .Login.Title }}
         uses: docker/login-action@v3
         with:
-          username: ${{ "{{" }} secrets.DOCKERHUB_USERNAME {{ "}}" }}
-          password: ${{ "{{" }} secrets.DOCKERHUB_TOKEN {{ "}}" }}
+{{- with .Login.Host }}
+          registry: {{ . }}
+{{- end }}
+          username: {{ .Login.Username }}
+          password: {{ .Login.Password }}
 
       - name: Build and push
         uses: docker/build-push-action@v6
@@ -89,7 +124,17 @@ jobs:
           tags: |
             ${{ "{{" }} env.IMAGE_NAME {{ "}}" }}:${{ "{{" }} steps.version.outputs.tag {{ "}}" }}
             ${{ "{{" }} env.IMAGE_NAME {{ "}}" }}:latest
-
+{{ if .ComposeFile }}
+      - name: Copy compose file
+        uses: appleboy/scp-action@v1
+        with:
+          host: ${{ "{{" }} secrets.VPS_HOST {{ "}}" }}
+          username: ${{ "{{" }} secrets.PROD_VPS_USER {{ "}}" }}
+          key: ${{ "{{" }} secrets.PROD_VPS_SSH_KEY {{ "}}" }}
+          source: {{ .ComposeFile }}
+          target: ${{ "{{" }} secrets.PROD_VPS_DEPLOY_PATH {{ "}}" }}/.compose
+          strip_components: {{ .StripComponents }}
+{{ end }}
       - name: Deploy to VPS
         uses: appleboy/ssh-action@v1
         with:
@@ -97,8 +142,11 @@ jobs:
           username: ${{ "{{" }} secrets.PROD_VPS_USER {{ "}}" }}
           key: ${{ "{{" }} secrets.PROD_VPS_SSH_KEY {{ "}}" }}
           script: |
-            echo "${{ "{{" }} secrets.DOCKERHUB_TOKEN {{ "}}" }}" | docker login -u "${{ "{{" }} secrets.DOCKERHUB_USERNAME {{ "}}" }}" --password-stdin
+            echo "{{ .Login.Password }}" | docker login{{ with .Login.Host }} {{ . }}{{ end }} -u "{{ .Login.Username }}" --password-stdin
             cd ${{ "{{" }} secrets.PROD_VPS_DEPLOY_PATH {{ "}}" }}
+{{- if .ComposeFile }}
+            mv -f .compose/{{ .ComposeBase }} docker-compose.yml && rm -rf .compose
+{{- end }}
             export DOCKER_IMAGE=${{ "{{" }} env.IMAGE_NAME {{ "}}" }}:${{ "{{" }} steps.version.outputs.tag {{ "}}" }}
             docker compose pull
             docker compose down || true
@@ -108,12 +156,32 @@ jobs:
 type WorkflowData struct {
 	DockerImage string
 	Branch      string
+	Login       registry.Login
+
+	// ComposeFile is the repo's own compose file, copied to the server as
+	// docker-compose.yml on every deploy; "" keeps the one setup wrote.
+	ComposeFile     string
+	ComposeBase     string
+	StripComponents int
+}
+
+func newWorkflowData(dockerImage string, login registry.Login, branch, composeFile string) WorkflowData {
+	return WorkflowData{
+		DockerImage:     dockerImage,
+		Branch:          branch,
+		Login:           login,
+		ComposeFile:     composeFile,
+		ComposeBase:     path.Base(composeFile),
+		StripComponents: strings.Count(composeFile, "/"),
+	}
 }
 
-// GenerateDevWorkflow returns the dev deploy workflow YAML.
-func GenerateDevWorkflow(dockerImage string) (string, error) {
+// GenerateDevWorkflow returns the dev deploy workflow YAML. login is how
+// it logs in to the registry dockerImage is pushed to. composeFile is the
+// repo path of the compose file to deploy, or "" for the generated one.
+func GenerateDevWorkflow(dockerImage string, login registry.Login, composeFile string) (string, error) {
 	var buf bytes.Buffer
-	if err := devWorkflowTmpl.Execute(&buf, WorkflowData{DockerImage: dockerImage}); err != nil {
+	if err := devWorkflowTmpl.Execute(&buf, newWorkflowData(dockerImage, login, "", composeFile)); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
@@ -121,9 +189,9 @@ func GenerateDevWorkflow(dockerImage string) (string, error) {
 
 // GenerateProdWorkflow returns the prod deploy workflow YAML.
 // branch is the repo's default branch (e.g. "main" or "master").
-func GenerateProdWorkflow(dockerImage, branch string) (string, error) {
+func GenerateProdWorkflow(dockerImage string, login registry.Login, branch, composeFile string) (string, error) {
 	var buf bytes.Buffer
-	if err := prodWorkflowTmpl.Execute(&buf, WorkflowData{DockerImage: dockerImage, Branch: branch}); err != nil {
+	if err := prodWorkflowTmpl.Execute(&buf, newWorkflowData(dockerImage, login, branch, composeFile)); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
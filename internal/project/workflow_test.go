@@ -0,0 +1,70 @@
+package project
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/registry"
+)
+
+func TestGenerateWorkflowComposeFile(t *testing.T) {
+	login := (&registry.DockerHub{}).Login()
+	plain, err := GenerateProdWorkflow("me/myapp", login, "main", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain, "scp-action") || strings.Contains(plain, ".compose") {
+		t.Errorf("workflow without a compose file copies one:\n%s", plain)
+	}
+
+	got, err := GenerateDevWorkflow("me/myapp", login, "deploy/compose.dev.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"uses: appleboy/scp-action@v1",
+		"source: deploy/compose.dev.yml\n",
+		"target: ${{ secrets.DEV_VPS_DEPLOY_PATH }}/.compose\n",
+		"strip_components: 1\n",
+		"cd ${{ secrets.DEV_VPS_DEPLOY_PATH }}\n            mv -f .compose/compose.dev.yml docker-compose.yml && rm -rf .compose\n            export DOCKER_IMAGE=",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("dev workflow missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestGenerateWorkflowRegistry(t *testing.T) {
+	hub, err := GenerateDevWorkflow("me/myapp", (&registry.DockerHub{}).Login(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"      - name: Login to DockerHub\n        uses: docker/login-action@v3\n        with:\n          username: ${{ secrets.DOCKERHUB_USERNAME }}\n",
+		`echo "${{ secrets.DOCKERHUB_TOKEN }}" | docker login -u "${{ secrets.DOCKERHUB_USERNAME }}" --password-stdin`,
+	} {
+		if !strings.Contains(hub, want) {
+			t.Errorf("DockerHub workflow missing %q:\n%s", want, hub)
+		}
+	}
+	if strings.Contains(hub, "permissions:") || strings.Contains(hub, "registry:") {
+		t.Errorf("DockerHub workflow has GHCR settings:\n%s", hub)
+	}
+
+	ghcr, err := GenerateProdWorkflow("ghcr.io/org/myapp", registry.GHCR{}.Login(), "main", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"IMAGE_NAME: ghcr.io/org/myapp\n\npermissions:\n  contents: read\n  packages: write\n\njobs:",
+		"          registry: ghcr.io\n          username: ${{ github.actor }}\n          password: ${{ secrets.GITHUB_TOKEN }}\n",
+		`echo "${{ secrets.GITHUB_TOKEN }}" | docker login ghcr.io -u "${{ github.actor }}" --password-stdin`,
+	} {
+		if !strings.Contains(ghcr, want) {
+			t.Errorf("GHCR workflow missing %q:\n%s", want, ghcr)
+		}
+	}
+	if strings.Contains(ghcr, "DOCKERHUB") {
+		t.Errorf("GHCR workflow uses DockerHub secrets:\n%s", ghcr)
+	}
+}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"fmt"
+
+	"github.com/dukerupert/annuminas/pkg/dockerhub"
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// DockerHub pushes images to <username>/<project> on Docker Hub with the
+// dockerhub/default credentials.
+type DockerHub struct {
+	Username string
+	Password string
+	// Token is a personal access token for CI. It is narrower than the
+	// password, which is used when there is no token.
+	Token string
+}
+
+// NewDockerHub loads the dockerhub/default credentials.
+func NewDockerHub(store config.Store) (*DockerHub, error) {
+	username, err := getCredential(store, "dockerhub", "default", "username")
+	if err != nil {
+		return nil, err
+	}
+	password, err := getCredential(store, "dockerhub", "default", "password")
+	if err != nil {
+		return nil, err
+	}
+	token, _ := store.GetCredential("dockerhub", "default", "token")
+	return &DockerHub{Username: username, Password: password, Token: token}, nil
+}
+
+func (d *DockerHub) Name() string { return "dockerhub" }
+
+func (d *DockerHub) Image(project, repo string) string {
+	return d.Username + "/" + project
+}
+
+func (d *DockerHub) Secrets() map[string]string {
+	token := d.Token
+	if token == "" {
+		token = d.Password
+	}
+	return map[string]string{
+		"DOCKERHUB_USERNAME": d.Username,
+		"DOCKERHUB_TOKEN":    token,
+	}
+}
+
+func (d *DockerHub) Login() Login {
+	return Login{
+		Title:    "DockerHub",
+		Username: secret("DOCKERHUB_USERNAME"),
+		Password: secret("DOCKERHUB_TOKEN"),
+	}
+}
+
+// EnsureRepository creates the project's repository, which Docker Hub
+// needs before the first push.
+func (d *DockerHub) EnsureRepository(project string) error {
+	if err := dockerhub.NewClient(d.Username, d.Password).EnsureRepo(d.Username, project); err != nil {
+		return fmt.Errorf("creating DockerHub repo: %w", err)
+	}
+	return nil
+}
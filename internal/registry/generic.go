@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// registryHostRe matches a registry host the way docker tells one apart
+// from a Docker Hub namespace: it has a dot or a port, or is localhost.
+var registryHostRe = regexp.MustCompile(`^(localhost|[a-z0-9-]+(\.[a-z0-9-]+)+)(:[0-9]+)?$`)
+
+// Generic pushes images to <host>[/<path>]/<project> on a self-hosted
+// registry, such as the distribution registry, Harbor or Gitea. Its
+// credentials are registry/<host> username and password.
+type Generic struct {
+	Host     string // e.g. "registry.example.com" or "localhost:5000"
+	Path     string // optional namespace, e.g. "team"
+	Username string
+	Password string
+}
+
+// NewGeneric loads the credentials of the registry stored as name,
+// "<host>" or "<host>/<path>".
+func NewGeneric(name string, store config.Store) (*Generic, error) {
+	g, err := parseGeneric(name)
+	if err != nil {
+		return nil, err
+	}
+	if g.Username, err = getCredential(store, "registry", g.Host, "username"); err != nil {
+		return nil, fmt.Errorf("%s: %w", g.Host, err)
+	}
+	if g.Password, err = getCredential(store, "registry", g.Host, "password"); err != nil {
+		return nil, fmt.Errorf("%s: %w", g.Host, err)
+	}
+	return g, nil
+}
+
+func parseGeneric(name string) (*Generic, error) {
+	host, path, _ := strings.Cut(name, "/")
+	if !registryHostRe.MatchString(host) {
+		return nil, fmt.Errorf("unknown registry %q: use dockerhub, ghcr, or a registry host such as registry.example.com", name)
+	}
+	return &Generic{Host: host, Path: strings.Trim(path, "/")}, nil
+}
+
+func (g *Generic) Name() string {
+	if g.Path == "" {
+		return g.Host
+	}
+	return g.Host + "/" + g.Path
+}
+
+func (g *Generic) Image(project, repo string) string {
+	return g.Name() + "/" + project
+}
+
+func (g *Generic) Secrets() map[string]string {
+	return map[string]string{
+		"REGISTRY_USERNAME": g.Username,
+		"REGISTRY_PASSWORD": g.Password,
+	}
+}
+
+func (g *Generic) Login() Login {
+	return Login{
+		Title:    g.Host,
+		Host:     g.Host,
+		Username: secret("REGISTRY_USERNAME"),
+		Password: secret("REGISTRY_PASSWORD"),
+	}
+}
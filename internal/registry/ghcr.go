@@ -0,0 +1,29 @@
+package registry
+
+import "strings"
+
+// GHCR pushes images to ghcr.io/<owner>/<project>, logging in with the
+// workflow's own GITHUB_TOKEN, so it needs no credentials or secrets. The
+// server logs in with the same token while the workflow runs; it expires
+// when the job ends, so pulls from the server outside a deploy fail for
+// private packages.
+type GHCR struct{}
+
+func (GHCR) Name() string { return "ghcr" }
+
+// Image is lowercase because GHCR rejects uppercase owners.
+func (GHCR) Image(project, repo string) string {
+	return "ghcr.io/" + strings.ToLower(repoOwner(repo)) + "/" + project
+}
+
+func (GHCR) Secrets() map[string]string { return nil }
+
+func (GHCR) Login() Login {
+	return Login{
+		Title:         "GHCR",
+		Host:          "ghcr.io",
+		Username:      "${{ github.actor }}",
+		Password:      secret("GITHUB_TOKEN"),
+		PackagesWrite: true,
+	}
+}
@@ -0,0 +1,112 @@
+// Package registry describes the container registries that deploy
+// workflows push project images to and servers pull them from.
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+// Registry is where a project's images live. The deploy workflow logs in
+// to push builds, and the server logs in with the same credentials to pull
+// them.
+type Registry interface {
+	// Name is how the registry is stored on a project: "dockerhub",
+	// "ghcr", or a self-hosted registry's host and optional path.
+	Name() string
+	// Image is the image, without tag, that builds of project are pushed
+	// to. repo is the project's GitHub repo, e.g. "github.com/org/app".
+	Image(project, repo string) string
+	// Secrets are the GitHub Actions secrets the workflow logs in with.
+	Secrets() map[string]string
+	// Login is how the workflow and server log in.
+	Login() Login
+}
+
+// Login is how a deploy workflow logs in to a registry. Username and
+// Password are workflow expressions, e.g. "${{ secrets.X }}".
+type Login struct {
+	Title    string // shown in the workflow's step name
+	Host     string // "" for DockerHub
+	Username string
+	Password string
+	// PackagesWrite grants the workflow's GITHUB_TOKEN write access to
+	// GitHub Packages, which pushing to GHCR needs.
+	PackagesWrite bool
+}
+
+// RepositoryCreator is a Registry whose image repositories have to be
+// created before the first push.
+type RepositoryCreator interface {
+	EnsureRepository(project string) error
+}
+
+// New returns the registry stored as name, with its credentials resolved
+// from the Store. "" is DockerHub, which projects used before the
+// registry was configurable.
+func New(name string, store config.Store) (Registry, error) {
+	switch name {
+	case "", "dockerhub":
+		return NewDockerHub(store)
+	case "ghcr":
+		return GHCR{}, nil
+	}
+	return NewGeneric(name, store)
+}
+
+// Validate checks that name is a registry New understands, without
+// loading its credentials.
+func Validate(name string) error {
+	switch name {
+	case "", "dockerhub", "ghcr":
+		return nil
+	}
+	_, err := parseGeneric(name)
+	return err
+}
+
+// SecretNames returns the names of the GitHub Actions secrets the
+// registry stored as name logs in with, without loading its credentials.
+func SecretNames(name string) []string {
+	var r Registry
+	switch name {
+	case "", "dockerhub":
+		r = &DockerHub{}
+	case "ghcr":
+		r = GHCR{}
+	default:
+		r = &Generic{}
+	}
+	var names []string
+	for name := range r.Secrets() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// secret is the workflow expression for a repository secret.
+func secret(name string) string {
+	return "${{ secrets." + name + " }}"
+}
+
+// repoOwner returns the owner of repo, which may be "org/app" or
+// "github.com/org/app".
+func repoOwner(repo string) string {
+	parts := strings.Split(strings.Trim(repo, "/"), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+func getCredential(store config.Store, service, name, key string) (string, error) {
+	v, err := store.GetCredential(service, name, key)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", service, key, err)
+	}
+	return v, nil
+}
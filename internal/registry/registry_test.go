@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SetCredential("dockerhub", "default", "username", "me")
+	store.SetCredential("dockerhub", "default", "password", "pw")
+	store.SetCredential("registry", "registry.example.com", "username", "ci")
+	store.SetCredential("registry", "registry.example.com", "password", "secret")
+
+	tests := []struct {
+		name, image, stored string
+		secrets             map[string]string
+	}{
+		{"", "me/myapp", "dockerhub", map[string]string{"DOCKERHUB_USERNAME": "me", "DOCKERHUB_TOKEN": "pw"}},
+		{"ghcr", "ghcr.io/myorg/myapp", "ghcr", nil},
+		{"registry.example.com/team/", "registry.example.com/team/myapp", "registry.example.com/team",
+			map[string]string{"REGISTRY_USERNAME": "ci", "REGISTRY_PASSWORD": "secret"}},
+	}
+	for _, tt := range tests {
+		r, err := New(tt.name, store)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.name, err)
+		}
+		if got := r.Image("myapp", "github.com/MyOrg/myapp"); got != tt.image {
+			t.Errorf("New(%q).Image = %q, want %q", tt.name, got, tt.image)
+		}
+		if r.Name() != tt.stored {
+			t.Errorf("New(%q).Name = %q, want %q", tt.name, r.Name(), tt.stored)
+		}
+		if got := r.Secrets(); !reflect.DeepEqual(got, tt.secrets) {
+			t.Errorf("New(%q).Secrets = %v, want %v", tt.name, got, tt.secrets)
+		}
+		var names []string
+		for name := range tt.secrets {
+			names = append(names, name)
+		}
+		if got := SecretNames(tt.name); len(got) != len(names) {
+			t.Errorf("SecretNames(%q) = %v, want %v", tt.name, got, names)
+		}
+	}
+
+	store.SetCredential("dockerhub", "default", "token", "dckr_pat")
+	r, _ := New("dockerhub", store)
+	if got := r.Secrets()["DOCKERHUB_TOKEN"]; got != "dckr_pat" {
+		t.Errorf("DOCKERHUB_TOKEN = %q, want the token over the password", got)
+	}
+	if _, ok := r.(RepositoryCreator); !ok {
+		t.Error("DockerHub does not create repositories")
+	}
+
+	if _, err := New("other.example.com", store); err == nil {
+		t.Error("registry without credentials succeeded")
+	}
+	for _, bad := range []string{"quay", "myuser", "Registry.Example.com"} {
+		if err := Validate(bad); err == nil {
+			t.Errorf("Validate(%q) succeeded", bad)
+		}
+	}
+	for _, good := range []string{"", "dockerhub", "ghcr", "localhost:5000", "registry.example.com/team"} {
+		if err := Validate(good); err != nil {
+			t.Errorf("Validate(%q): %v", good, err)
+		}
+	}
+}
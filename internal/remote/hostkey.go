@@ -0,0 +1,80 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyMismatchError is returned when a server presents a host key that
+// differs from the one pinned in the Store.
+type HostKeyMismatchError struct {
+	Host     string
+	Pinned   string
+	Received string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key for %s has changed (pinned %s, received %s) — if the server was rebuilt, run 'arnor server trust' to re-trust it",
+		e.Host, e.Pinned, e.Received)
+}
+
+// HostKeyCallback returns an ssh.HostKeyCallback that trusts a host on first
+// use. The first key seen for a host is stored; later connections must
+// present the same key.
+func HostKeyCallback(store config.Store) ssh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		host := hostOnly(hostname)
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		pinned, err := store.GetHostKey(host)
+		if err != nil {
+			return fmt.Errorf("looking up host key for %s: %w", host, err)
+		}
+		if pinned == "" {
+			if err := store.SetHostKey(host, fingerprint); err != nil {
+				return fmt.Errorf("pinning host key for %s: %w", host, err)
+			}
+			return nil
+		}
+		if pinned != fingerprint {
+			return &HostKeyMismatchError{Host: host, Pinned: pinned, Received: fingerprint}
+		}
+		return nil
+	}
+}
+
+var errKeyScanned = errors.New("host key scanned")
+
+// ScanHostKey connects to host just long enough to read its host key and
+// returns the SHA256 fingerprint. No authentication is attempted.
+func ScanHostKey(host string) (string, error) {
+	var fingerprint string
+	sshConfig := &ssh.ClientConfig{
+		User: "arnor",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			return errKeyScanned
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	_, err := ssh.Dial("tcp", address(host), sshConfig)
+	if fingerprint != "" {
+		return fingerprint, nil
+	}
+	return "", fmt.Errorf("SSH dial to %s: %w", host, err)
+}
+
+// hostOnly strips the port from a host:port address.
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
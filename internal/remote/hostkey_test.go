@@ -0,0 +1,93 @@
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestStore(t *testing.T) *config.SQLiteStore {
+	t.Helper()
+	s, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLiteStore(:memory:): %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("wrapping key: %v", err)
+	}
+	return key
+}
+
+func TestHostKeyCallback_TrustOnFirstUse(t *testing.T) {
+	s := newTestStore(t)
+	key := newHostKey(t)
+	cb := HostKeyCallback(s)
+
+	if err := cb("1.2.3.4:22", nil, key); err != nil {
+		t.Fatalf("first contact: %v", err)
+	}
+
+	pinned, err := s.GetHostKey("1.2.3.4")
+	if err != nil {
+		t.Fatalf("GetHostKey: %v", err)
+	}
+	if pinned != ssh.FingerprintSHA256(key) {
+		t.Errorf("pinned = %q, want %q", pinned, ssh.FingerprintSHA256(key))
+	}
+
+	// Same key again is accepted.
+	if err := cb("1.2.3.4:22", nil, key); err != nil {
+		t.Errorf("second contact with same key: %v", err)
+	}
+}
+
+func TestHostKeyCallback_Mismatch(t *testing.T) {
+	s := newTestStore(t)
+	cb := HostKeyCallback(s)
+
+	if err := cb("1.2.3.4:22", nil, newHostKey(t)); err != nil {
+		t.Fatalf("first contact: %v", err)
+	}
+
+	err := cb("1.2.3.4:22", nil, newHostKey(t))
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected HostKeyMismatchError, got %v", err)
+	}
+	if mismatch.Host != "1.2.3.4" {
+		t.Errorf("host = %q, want %q", mismatch.Host, "1.2.3.4")
+	}
+}
+
+func TestHostKeyCallback_Retrust(t *testing.T) {
+	s := newTestStore(t)
+	cb := HostKeyCallback(s)
+
+	if err := cb("1.2.3.4:22", nil, newHostKey(t)); err != nil {
+		t.Fatalf("first contact: %v", err)
+	}
+
+	// Re-trusting a new key (as 'arnor server trust' does) lets it through.
+	rebuilt := newHostKey(t)
+	if err := s.SetHostKey("1.2.3.4", ssh.FingerprintSHA256(rebuilt)); err != nil {
+		t.Fatalf("SetHostKey: %v", err)
+	}
+	if err := cb("1.2.3.4:22", nil, rebuilt); err != nil {
+		t.Errorf("after re-trust: %v", err)
+	}
+}
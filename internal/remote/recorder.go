@@ -0,0 +1,80 @@
+package remote
+
+import (
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/plan"
+)
+
+// Runner is the set of operations orchestration code performs on a server.
+// *Client satisfies it; Recorder stands in for it during dry runs.
+type Runner interface {
+	Run(command string) error
+	Output(command string) (string, error)
+	WriteFile(path, content string) error
+	Close() error
+}
+
+// Recorder is a Runner that records every operation into a plan instead of
+// executing it. Commands always succeed and produce no output. Probes that
+// only read the server are recorded as reads, apart from the changes.
+type Recorder struct {
+	host string
+	plan *plan.Plan
+}
+
+// NewRecorder returns a Recorder that attributes operations to host.
+func NewRecorder(host string, p *plan.Plan) *Recorder {
+	return &Recorder{host: host, plan: p}
+}
+
+func (r *Recorder) Run(command string) error {
+	r.record(command)
+	return nil
+}
+
+func (r *Recorder) Output(command string) (string, error) {
+	r.record(command)
+	return "", nil
+}
+
+func (r *Recorder) record(command string) {
+	if readOnly(command) {
+		r.plan.AddRead(r.host, command)
+	} else {
+		r.plan.AddCommand(r.host, command)
+	}
+}
+
+// readOnly reports whether command only tests for or reads files, such as
+// "sudo test -f PATH" or "cat PATH 2>/dev/null || true".
+func readOnly(command string) bool {
+	command = strings.ReplaceAll(command, " 2>/dev/null", "")
+	for _, part := range strings.FieldsFunc(command, func(r rune) bool { return r == '|' || r == '&' }) {
+		if strings.ContainsAny(part, ";<>`$()") {
+			return false
+		}
+		fields := strings.Fields(part)
+		if len(fields) > 0 && fields[0] == "sudo" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return false
+		}
+		switch fields[0] {
+		case "test", "cat", "true":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Recorder) WriteFile(path, content string) error {
+	r.plan.AddFile(r.host, path, content)
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	return nil
+}
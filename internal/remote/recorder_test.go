@@ -0,0 +1,33 @@
+package remote
+
+import (
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/plan"
+)
+
+func TestRecorderKeepsReadsOutOfCommands(t *testing.T) {
+	p := plan.New("test")
+	r := NewRecorder("1.2.3.4", p)
+	for _, command := range []string{
+		"test -d /opt/kuma",
+		"sudo test -f /opt/kuma/docker-compose.yml",
+		"sudo cat /opt/kuma/docker-compose.yml",
+		"cat /etc/caddy/Caddyfile 2>/dev/null || true",
+		"test -f /etc/systemd/system/caddy.service || test -f /lib/systemd/system/caddy.service",
+		"sudo mkdir -p /opt/kuma",
+		"test -d /opt/kuma || sudo mkdir -p /opt/kuma",
+		"cat /tmp/key > /home/peon/.ssh/authorized_keys",
+		"sudo cat /tmp/script | sh",
+		"test -f $(which caddy)",
+	} {
+		r.Run(command)
+	}
+
+	if len(p.Reads) != 5 {
+		t.Errorf("reads = %+v, want the first 5 commands", p.Reads)
+	}
+	if len(p.Remote) != 5 || p.Remote[0].Command != "sudo mkdir -p /opt/kuma" {
+		t.Errorf("remote = %+v, want the last 5 commands", p.Remote)
+	}
+}
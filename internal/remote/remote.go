@@ -0,0 +1,131 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client is an SSH connection to a server whose host key has been verified
+// against the Store.
+type Client struct {
+	conn *ssh.Client
+}
+
+// Dial connects to host as user. The server's host key is pinned in the Store
+// on first contact; later connections are refused if the key has changed.
+// host may include a port; port 22 is assumed otherwise.
+func Dial(host, user string, auth []ssh.AuthMethod, store config.Store) (*Client, error) {
+	sshConfig := &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: HostKeyCallback(store),
+		Timeout:         10 * time.Second,
+	}
+
+	conn, err := ssh.Dial("tcp", address(host), sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("SSH dial to %s: %w", host, err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+// DialPeon connects to serverIP as the peon user using a PEM-encoded private key.
+func DialPeon(serverIP, peonKeyPEM string, store config.Store) (*Client, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("parsing peon SSH key: %w", err)
+	}
+	return Dial(serverIP, "peon", []ssh.AuthMethod{ssh.PublicKeys(signer)}, store)
+}
+
+// Close closes the underlying SSH connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// NewSession opens a raw session for callers that need to wire up their own
+// stdin/stdout handling.
+func (c *Client) NewSession() (*ssh.Session, error) {
+	return c.conn.NewSession()
+}
+
+// Run executes command and waits for it to finish.
+func (c *Client) Run(command string) error {
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Run(command)
+}
+
+// Output executes command and returns its stdout.
+func (c *Client) Output(command string) (string, error) {
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output(command)
+	return string(out), err
+}
+
+// WriteFile writes content to path via sudo tee. Content is passed on stdin
+// to avoid shell escaping issues.
+func (c *Client) WriteFile(path, content string) error {
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stdin = strings.NewReader(content)
+	session.Stderr = &stderr
+	err = session.Run(fmt.Sprintf("sudo tee %s > /dev/null", path))
+	session.Close()
+	if err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg != "" {
+			return fmt.Errorf("%s", errMsg)
+		}
+		return err
+	}
+	return nil
+}
+
+// Stream executes command with stdin and stdout connected to r and w, for
+// data too large to hold in memory. Either may be nil. If the command fails,
+// the error includes its stderr.
+func (c *Client) Stream(command string, r io.Reader, w io.Writer) error {
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	var stderr bytes.Buffer
+	session.Stdin = r
+	session.Stdout = w
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		if errMsg := strings.TrimSpace(stderr.String()); errMsg != "" {
+			return fmt.Errorf("%w: %s", err, errMsg)
+		}
+		return err
+	}
+	return nil
+}
+
+// address appends the default SSH port if host has none. host may be a
+// bare or bracketed IPv6 address.
+func address(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "22")
+}
@@ -0,0 +1,18 @@
+package remote
+
+import "testing"
+
+func TestAddress(t *testing.T) {
+	for host, want := range map[string]string{
+		"1.2.3.4":            "1.2.3.4:22",
+		"1.2.3.4:2222":       "1.2.3.4:2222",
+		"web1.example.com":   "web1.example.com:22",
+		"2001:db8::1":        "[2001:db8::1]:22",
+		"[2001:db8::1]":      "[2001:db8::1]:22",
+		"[2001:db8::1]:2222": "[2001:db8::1]:2222",
+	} {
+		if got := address(host); got != want {
+			t.Errorf("address(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
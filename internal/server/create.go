@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/caddy"
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+	"github.com/dukerupert/arnor/internal/peon"
+	"github.com/dukerupert/arnor/internal/remote"
+)
+
+// CreateParams contains all inputs for provisioning a new server.
+type CreateParams struct {
+	Cloud          *hetzner.CloudClient
+	HetznerProject string // project alias the server is recorded under
+	Name           string
+	ServerType     string // e.g. "cx22"
+	Location       string // e.g. "fsn1"
+	Image          string // e.g. "ubuntu-24.04"
+	SSHKey         string // name of a project SSH key that can log in as root
+	CFToken        string // passed to caddy.Install; empty skips DNS challenge support
+	Auth           peon.SSHAuth
+	Store          config.Store
+	OnProgress     func(step, total int, message string)
+}
+
+// The SSH half of Create. Tests replace these so that the whole path can
+// run against a stand-in for the Cloud API.
+var (
+	scanHostKey  = remote.ScanHostKey
+	runPeon      = peon.RunRemote
+	savePeonKey  = peon.SavePeonKey
+	installCaddy = caddy.Install
+)
+
+// Timeouts for the server to boot and for sshd to come up after that.
+var (
+	runningTimeout = 5 * time.Minute
+	sshTimeout     = 3 * time.Minute
+	sshRetry       = 5 * time.Second
+)
+
+// Create provisions a server and makes it ready for projects: it creates
+// the server, waits for it to boot, records it in the store, bootstraps
+// the peon user and installs Caddy. The server is recorded as soon as it
+// exists, before it has an IP, and returned with every later error, so
+// that "arnor server sync" and "arnor server init" can finish the job.
+func Create(params CreateParams) (*config.Server, error) {
+	const totalSteps = 6
+	report := func(step int, message string) {
+		if params.OnProgress != nil {
+			params.OnProgress(step, totalSteps, message)
+		}
+	}
+
+	// Step 1: Create the server
+	report(1, fmt.Sprintf("Creating %s (%s in %s)...", params.Name, params.ServerType, params.Location))
+	opts := hetzner.CreateServerOpts{
+		Name:       params.Name,
+		ServerType: params.ServerType,
+		Location:   params.Location,
+		Image:      params.Image,
+		Labels:     map[string]string{"managed-by": "arnor"},
+	}
+	if params.SSHKey != "" {
+		opts.SSHKeys = []string{params.SSHKey}
+	}
+	created, err := params.Cloud.CreateServer(opts)
+	if err != nil {
+		return nil, fmt.Errorf("creating server: %w", err)
+	}
+	srv := config.Server{Name: params.Name, HetznerProject: params.HetznerProject, HetznerID: created.ID}
+	if err := params.Store.SaveConfig(&config.Config{Servers: []config.Server{srv}}); err != nil {
+		return nil, fmt.Errorf("saving config: %w", err)
+	}
+
+	// Step 2: Wait for it to boot
+	report(2, "Waiting for the server to start...")
+	running, err := params.Cloud.WaitForStatus(created.ID, "running", runningTimeout)
+	if err != nil {
+		return &srv, err
+	}
+	ip := running.PublicNet.IPv4.IP
+	if ip == "" {
+		return &srv, fmt.Errorf("server %s has no public IPv4 address", params.Name)
+	}
+
+	// Step 3: Record its IP
+	report(3, fmt.Sprintf("Recording %s (%s) in config...", params.Name, ip))
+	srv.IP = ip
+	if err := params.Store.SaveConfig(&config.Config{Servers: []config.Server{srv}}); err != nil {
+		srv.IP = ""
+		return &srv, fmt.Errorf("saving config: %w", err)
+	}
+
+	// Step 4: Wait for sshd and pin its host key. The address may have
+	// belonged to another server before, so any earlier pin is replaced.
+	report(4, "Waiting for SSH...")
+	fingerprint, err := waitForSSH(ip)
+	if err != nil {
+		return &srv, err
+	}
+	if err := params.Store.SetHostKey(ip, fingerprint); err != nil {
+		return &srv, fmt.Errorf("pinning host key: %w", err)
+	}
+
+	// Step 5: Bootstrap peon
+	report(5, "Bootstrapping the peon user...")
+	key, err := runPeon(ip, "root", params.Auth, params.Store)
+	if err != nil {
+		return &srv, fmt.Errorf("bootstrapping peon: %w", err)
+	}
+	if _, err := savePeonKey(ip, key, params.Store); err != nil {
+		return &srv, err
+	}
+
+	// Step 6: Install Caddy
+	report(6, "Installing Caddy...")
+	if err := installCaddy(caddy.InstallParams{
+		ServerIP:   ip,
+		PeonKeyPEM: key,
+		CFToken:    params.CFToken,
+		Store:      params.Store,
+	}); err != nil {
+		return &srv, fmt.Errorf("caddy setup: %w", err)
+	}
+
+	return &srv, nil
+}
+
+// waitForSSH retries until sshd answers and returns its host key
+// fingerprint.
+func waitForSSH(ip string) (string, error) {
+	deadline := time.Now().Add(sshTimeout)
+	for {
+		fingerprint, err := scanHostKey(ip)
+		if err == nil {
+			return fingerprint, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("SSH on %s did not come up within %s: %w", ip, sshTimeout, err)
+		}
+		time.Sleep(sshRetry)
+	}
+}
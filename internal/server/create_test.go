@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/caddy"
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+	"github.com/dukerupert/arnor/internal/peon"
+)
+
+// fakeCloud is a stand-in for the Hetzner Cloud API that boots a server
+// after a couple of polls.
+func fakeCloud(t *testing.T, polls *int) *hetzner.CloudClient {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /servers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var opts hetzner.CreateServerOpts
+		json.NewDecoder(r.Body).Decode(&opts)
+		if opts.Name != "web1" || opts.ServerType != "cx22" || opts.Location != "fsn1" || opts.Image != "ubuntu-24.04" || len(opts.SSHKeys) != 1 || opts.SSHKeys[0] != "laptop" {
+			t.Errorf("create request = %+v", opts)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"server": {"id": 42, "name": "web1", "status": "initializing"}, "root_password": null}`))
+	})
+	mux.HandleFunc("GET /servers/42", func(w http.ResponseWriter, r *http.Request) {
+		*polls++
+		status := "starting"
+		if *polls >= 2 {
+			status = "running"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"server": map[string]any{
+			"id": 42, "name": "web1", "status": status,
+			"public_net": map[string]any{"ipv4": map[string]any{"ip": "1.2.3.4"}},
+		}})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	c := hetzner.NewCloudClientAt(srv.URL, "tok")
+	c.PollInterval = 0
+	return c
+}
+
+func TestCreate(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SetHostKey("1.2.3.4", "SHA256:stale")
+
+	origScan, origPeon, origSave, origCaddy := scanHostKey, runPeon, savePeonKey, installCaddy
+	t.Cleanup(func() {
+		scanHostKey, runPeon, savePeonKey, installCaddy = origScan, origPeon, origSave, origCaddy
+	})
+
+	var calls []string
+	scanHostKey = func(host string) (string, error) {
+		calls = append(calls, "scan "+host)
+		return "SHA256:new", nil
+	}
+	runPeon = func(host, user string, _ peon.SSHAuth, _ config.Store) (string, error) {
+		calls = append(calls, "peon "+user+"@"+host)
+		return "PEON KEY", nil
+	}
+	savePeonKey = func(host, key string, s config.Store) (*peon.SaveResult, error) {
+		return &peon.SaveResult{}, s.SetPeonKey(host, key, "/tmp/peon")
+	}
+	installCaddy = func(p caddy.InstallParams) error {
+		calls = append(calls, "caddy "+p.ServerIP+" "+p.PeonKeyPEM)
+		return nil
+	}
+	var polls int
+	var steps []string
+	srv, err := Create(CreateParams{
+		Cloud:          fakeCloud(t, &polls),
+		HetznerProject: "prod",
+		Name:           "web1",
+		ServerType:     "cx22",
+		Location:       "fsn1",
+		Image:          "ubuntu-24.04",
+		SSHKey:         "laptop",
+		Store:          store,
+		OnProgress:     func(step, total int, message string) { steps = append(steps, message) },
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	want := config.Server{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}
+	if *srv != want {
+		t.Errorf("server = %+v, want %+v", *srv, want)
+	}
+	if polls != 2 || len(steps) != 6 {
+		t.Errorf("polls = %d, steps = %q", polls, steps)
+	}
+	wantCalls := []string{"scan 1.2.3.4", "peon root@1.2.3.4", "caddy 1.2.3.4 PEON KEY"}
+	if len(calls) != len(wantCalls) {
+		t.Fatalf("calls = %q, want %q", calls, wantCalls)
+	}
+	for i := range calls {
+		if calls[i] != wantCalls[i] {
+			t.Errorf("call %d = %q, want %q", i, calls[i], wantCalls[i])
+		}
+	}
+
+	cfg, _ := store.LoadConfig()
+	if s := cfg.FindServer("web1"); s == nil || *s != want {
+		t.Errorf("stored server = %+v", s)
+	}
+	if fp, _ := store.GetHostKey("1.2.3.4"); fp != "SHA256:new" {
+		t.Errorf("host key = %q, want the stale pin replaced", fp)
+	}
+	if key, _ := store.GetPeonKey("1.2.3.4"); key != "PEON KEY" {
+		t.Errorf("peon key = %q", key)
+	}
+}
+
+func TestCreateRecordsServerBeforeItBoots(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	origTimeout := runningTimeout
+	t.Cleanup(func() { runningTimeout = origTimeout })
+	runningTimeout = 0
+
+	var polls int
+	srv, err := Create(CreateParams{
+		Cloud:          fakeCloud(t, &polls),
+		HetznerProject: "prod",
+		Name:           "web1",
+		ServerType:     "cx22",
+		Location:       "fsn1",
+		Image:          "ubuntu-24.04",
+		SSHKey:         "laptop",
+		Store:          store,
+	})
+	if err == nil {
+		t.Fatal("Create succeeded, want a boot timeout")
+	}
+
+	// The server exists in Hetzner, so it is returned and stored without an
+	// IP for "arnor server sync" to fill in.
+	want := config.Server{Name: "web1", HetznerProject: "prod", HetznerID: 42}
+	if srv == nil || *srv != want {
+		t.Fatalf("server = %+v, want %+v", srv, want)
+	}
+	cfg, _ := store.LoadConfig()
+	if s := cfg.FindServer("web1"); s == nil || *s != want {
+		t.Errorf("stored server = %+v", s)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+)
+
+// anywhere is the source of rules open to the whole internet.
+var anywhere = []string{"0.0.0.0/0", "::/0"}
+
+// BaseRules are the inbound rules every arnor server needs: SSH for peon
+// and deploys, and HTTP and HTTPS for Caddy. App ports are bound to
+// localhost and reached through Caddy, so they are not opened.
+func BaseRules() []hetzner.FirewallRule {
+	return []hetzner.FirewallRule{
+		{Direction: "in", Protocol: "tcp", Port: "22", SourceIPs: anywhere, Description: "ssh"},
+		{Direction: "in", Protocol: "tcp", Port: "80", SourceIPs: anywhere, Description: "http"},
+		{Direction: "in", Protocol: "tcp", Port: "443", SourceIPs: anywhere, Description: "https"},
+	}
+}
+
+// ParseRule parses an extra inbound rule written as
+// PORT[/PROTO][@CIDR,...], e.g. "51820/udp" or "5432/tcp@10.0.0.0/8".
+// PORT may be a range like "8000-8100" and PROTO defaults to tcp; "icmp"
+// alone allows ping. Without sources the rule is open to everyone.
+func ParseRule(s string) (hetzner.FirewallRule, error) {
+	rule := hetzner.FirewallRule{Direction: "in", Protocol: "tcp", SourceIPs: anywhere}
+
+	spec, sources, hasSources := strings.Cut(s, "@")
+	if hasSources {
+		rule.SourceIPs = nil
+		for _, src := range strings.Split(sources, ",") {
+			if !strings.Contains(src, "/") {
+				if ip := net.ParseIP(src); ip != nil && ip.To4() != nil {
+					src += "/32"
+				} else {
+					src += "/128"
+				}
+			}
+			if _, _, err := net.ParseCIDR(src); err != nil {
+				return rule, fmt.Errorf("rule %q: invalid source %q", s, src)
+			}
+			rule.SourceIPs = append(rule.SourceIPs, src)
+		}
+	}
+
+	if spec == "icmp" {
+		rule.Protocol = "icmp"
+		return rule, nil
+	}
+	port, proto, hasProto := strings.Cut(spec, "/")
+	if hasProto {
+		if proto != "tcp" && proto != "udp" {
+			return rule, fmt.Errorf("rule %q: protocol must be tcp or udp", s)
+		}
+		rule.Protocol = proto
+	}
+	bounds := strings.Split(port, "-")
+	if len(bounds) > 2 {
+		return rule, fmt.Errorf("rule %q: invalid port %q", s, port)
+	}
+	for _, p := range bounds {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return rule, fmt.Errorf("rule %q: invalid port %q", s, port)
+		}
+	}
+	rule.Port = port
+	return rule, nil
+}
+
+// FirewallName is the name of the firewall arnor manages for a server.
+func FirewallName(server string) string {
+	return "arnor-" + server
+}
+
+// FirewallResult describes what EnsureFirewall did.
+type FirewallResult struct {
+	Firewall *hetzner.Firewall
+	Created  bool // a new firewall was created
+	Applied  bool // the firewall was newly applied to the server
+}
+
+// EnsureFirewall creates the server's firewall, or resets the rules of the
+// existing one, to BaseRules plus extra, and applies it to the server.
+// Rules added to it by hand in the Hetzner console are replaced.
+func EnsureFirewall(cloud *hetzner.CloudClient, srv config.Server, extra []hetzner.FirewallRule) (*FirewallResult, error) {
+	rules := append(BaseRules(), extra...)
+	name := FirewallName(srv.Name)
+
+	fw, err := cloud.FirewallByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("looking up firewall %s: %w", name, err)
+	}
+
+	result := &FirewallResult{}
+	var actions []hetzner.Action
+	if fw == nil {
+		fw, actions, err = cloud.CreateFirewall(name, rules, srv.HetznerID)
+		if err != nil {
+			return nil, fmt.Errorf("creating firewall %s: %w", name, err)
+		}
+		result.Created, result.Applied = true, true
+	} else {
+		actions, err = cloud.SetFirewallRules(fw.ID, rules)
+		if err != nil {
+			return nil, fmt.Errorf("updating firewall %s: %w", name, err)
+		}
+		fw.Rules = rules
+		if !fw.AppliedToServer(srv.HetznerID) {
+			applied, err := cloud.ApplyFirewall(fw.ID, srv.HetznerID)
+			if err != nil {
+				return nil, fmt.Errorf("applying firewall %s to %s: %w", name, srv.Name, err)
+			}
+			actions = append(actions, applied...)
+			result.Applied = true
+		}
+	}
+
+	for i := range actions {
+		if err := cloud.WaitForAction(&actions[i], actionTimeout); err != nil {
+			return nil, fmt.Errorf("firewall %s: %w", name, err)
+		}
+	}
+	result.Firewall = fw
+	return result, nil
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+)
+
+func TestParseRule(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    hetzner.FirewallRule
+		wantErr bool
+	}{
+		{in: "8080", want: hetzner.FirewallRule{Direction: "in", Protocol: "tcp", Port: "8080", SourceIPs: anywhere}},
+		{in: "51820/udp", want: hetzner.FirewallRule{Direction: "in", Protocol: "udp", Port: "51820", SourceIPs: anywhere}},
+		{in: "8000-8100/tcp@10.0.0.0/8,192.168.1.5", want: hetzner.FirewallRule{Direction: "in", Protocol: "tcp", Port: "8000-8100", SourceIPs: []string{"10.0.0.0/8", "192.168.1.5/32"}}},
+		{in: "icmp", want: hetzner.FirewallRule{Direction: "in", Protocol: "icmp", SourceIPs: anywhere}},
+		{in: "/tcp", wantErr: true},
+		{in: "70000", wantErr: true},
+		{in: "1-2-3", wantErr: true},
+		{in: "53/sctp", wantErr: true},
+		{in: "22@nowhere", wantErr: true},
+	} {
+		got, err := ParseRule(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseRule(%q) = %+v, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRule(%q): %v", tc.in, err)
+			continue
+		}
+		if got.Direction != tc.want.Direction || got.Protocol != tc.want.Protocol || got.Port != tc.want.Port || !slices.Equal(got.SourceIPs, tc.want.SourceIPs) {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// firewallCloud is a stand-in for the firewall endpoints of the Cloud API.
+// existing is the firewall it already has, or nil.
+func firewallCloud(t *testing.T, existing map[string]any, calls *[]string) *hetzner.CloudClient {
+	t.Helper()
+	done := map[string]any{"actions": []any{map[string]any{"id": 1, "status": "success"}}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /firewalls", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") != "arnor-web1" {
+			t.Errorf("looked up firewall %q", r.URL.Query().Get("name"))
+		}
+		firewalls := []any{}
+		if existing != nil {
+			firewalls = append(firewalls, existing)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"firewalls": firewalls})
+	})
+	mux.HandleFunc("POST /firewalls", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name  string                 `json:"name"`
+			Rules []hetzner.FirewallRule `json:"rules"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		*calls = append(*calls, "create "+body.Name+" "+rulePorts(body.Rules))
+		json.NewEncoder(w).Encode(map[string]any{
+			"firewall": map[string]any{"id": 7, "name": body.Name},
+			"actions":  done["actions"],
+		})
+	})
+	mux.HandleFunc("POST /firewalls/7/actions/{action}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Rules []hetzner.FirewallRule `json:"rules"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		call := r.PathValue("action")
+		if body.Rules != nil {
+			call += " " + rulePorts(body.Rules)
+		}
+		*calls = append(*calls, call)
+		json.NewEncoder(w).Encode(done)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	c := hetzner.NewCloudClientAt(srv.URL, "tok")
+	c.PollInterval = 0
+	return c
+}
+
+func rulePorts(rules []hetzner.FirewallRule) string {
+	var s string
+	for i, r := range rules {
+		if i > 0 {
+			s += ","
+		}
+		s += r.Port + "/" + r.Protocol
+	}
+	return s
+}
+
+func TestEnsureFirewall(t *testing.T) {
+	web1 := config.Server{Name: "web1", IP: "1.2.3.4", HetznerID: 42}
+	extra, _ := ParseRule("51820/udp")
+
+	for _, tc := range []struct {
+		name     string
+		existing map[string]any
+		want     []string
+		created  bool
+		applied  bool
+	}{
+		{
+			name:    "new",
+			want:    []string{"create arnor-web1 22/tcp,80/tcp,443/tcp,51820/udp"},
+			created: true, applied: true,
+		},
+		{
+			name:     "existing, not applied",
+			existing: map[string]any{"id": 7, "name": "arnor-web1"},
+			want:     []string{"set_rules 22/tcp,80/tcp,443/tcp,51820/udp", "apply_to_resources"},
+			applied:  true,
+		},
+		{
+			name: "existing, applied",
+			existing: map[string]any{"id": 7, "name": "arnor-web1", "applied_to": []any{
+				map[string]any{"type": "server", "server": map[string]any{"id": 42}},
+			}},
+			want: []string{"set_rules 22/tcp,80/tcp,443/tcp,51820/udp"},
+		},
+	} {
+		var calls []string
+		result, err := EnsureFirewall(firewallCloud(t, tc.existing, &calls), web1, []hetzner.FirewallRule{extra})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !slices.Equal(calls, tc.want) {
+			t.Errorf("%s: calls = %q, want %q", tc.name, calls, tc.want)
+		}
+		if result.Created != tc.created || result.Applied != tc.applied {
+			t.Errorf("%s: result = %+v", tc.name, result)
+		}
+	}
+}
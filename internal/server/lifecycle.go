@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+)
+
+// actionTimeout bounds how long lifecycle operations wait for Hetzner.
+// Snapshots of large disks are the slowest.
+var actionTimeout = 15 * time.Minute
+
+// shutdownTimeout bounds how long Resize waits for a server to shut down
+// cleanly before cutting its power.
+var shutdownTimeout = 2 * time.Minute
+
+// ProjectsOn returns the names of the projects in cfg deployed to server.
+func ProjectsOn(cfg *config.Config, server string) []string {
+	var names []string
+	for _, p := range cfg.Projects {
+		if p.Server == server {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// Resize changes a server's type. Hetzner only resizes stopped servers, so a
+// running server is shut down first and started again afterwards. A server
+// that doesn't shut down within shutdownTimeout is powered off.
+func Resize(cloud *hetzner.CloudClient, id int, serverType string, upgradeDisk bool, onProgress func(string)) error {
+	report := func(message string) {
+		if onProgress != nil {
+			onProgress(message)
+		}
+	}
+
+	s, err := cloud.Server(id)
+	if err != nil {
+		return err
+	}
+	wasRunning := s.Status != "off"
+	if wasRunning {
+		report("Shutting down...")
+		if err := Run(cloud, cloud.Shutdown, id); err != nil {
+			return err
+		}
+		if _, err := cloud.WaitForStatus(id, "off", shutdownTimeout); err != nil {
+			report(fmt.Sprintf("%v; powering off...", err))
+			if err := Run(cloud, cloud.PowerOff, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	report(fmt.Sprintf("Changing type %s → %s...", s.ServerType.Name, serverType))
+	a, err := cloud.ChangeType(id, serverType, upgradeDisk)
+	if err == nil {
+		err = cloud.WaitForAction(a, actionTimeout)
+	}
+	if err != nil {
+		if wasRunning {
+			// Leave the server as we found it.
+			if perr := Run(cloud, cloud.PowerOn, id); perr != nil {
+				return fmt.Errorf("resizing: %w; powering back on: %w", err, perr)
+			}
+		}
+		return fmt.Errorf("resizing: %w", err)
+	}
+
+	if wasRunning {
+		report("Powering on...")
+		if err := Run(cloud, cloud.PowerOn, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild reinstalls a server from image. Its disk, the peon user and its
+// host key are all new afterwards, so the stored peon key and pinned host
+// key are dropped; run "server init" to bootstrap it again.
+func Rebuild(cloud *hetzner.CloudClient, store config.Store, srv config.Server, image string) error {
+	a, err := cloud.Rebuild(srv.HetznerID, image)
+	if err == nil {
+		err = cloud.WaitForAction(a, actionTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("rebuilding %s: %w", srv.Name, err)
+	}
+	if err := store.DeletePeonKey(srv.IP); err != nil {
+		return err
+	}
+	return store.DeleteHostKey(srv.IP)
+}
+
+// Delete deletes a server at Hetzner and then its server, peon key and host
+// key rows. Projects that referenced it are left for the caller to deal with.
+func Delete(cloud *hetzner.CloudClient, store config.Store, srv config.Server) error {
+	a, err := cloud.DeleteServer(srv.HetznerID)
+	if err == nil {
+		err = cloud.WaitForAction(a, actionTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("deleting %s: %w", srv.Name, err)
+	}
+	if err := store.DeleteServer(srv.Name); err != nil {
+		return err
+	}
+	if err := store.DeletePeonKey(srv.IP); err != nil {
+		return err
+	}
+	return store.DeleteHostKey(srv.IP)
+}
+
+// Run starts an action such as cloud.Reboot on server id and waits for it
+// to finish.
+func Run(cloud *hetzner.CloudClient, start func(id int) (*hetzner.Action, error), id int) error {
+	a, err := start(id)
+	if err != nil {
+		return err
+	}
+	return cloud.WaitForAction(a, actionTimeout)
+}
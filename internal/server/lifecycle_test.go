@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+)
+
+// cloudServer is a stand-in for the Cloud API that records the server actions
+// it is asked for and finishes each one on the first poll. Powering a server
+// off or on changes its status; so does shutting it down, unless
+// ignoresShutdown is set. Actions named in fail finish with an error.
+type cloudServer struct {
+	status          string
+	ignoresShutdown bool
+	fail            map[string]bool
+	calls           []string
+}
+
+func (f *cloudServer) client(t *testing.T) *hetzner.CloudClient {
+	t.Helper()
+	results := map[int]string{}
+	started := func(command string) map[string]any {
+		f.calls = append(f.calls, command)
+		results[len(f.calls)] = "success"
+		if f.fail[command] {
+			results[len(f.calls)] = "error"
+		}
+		return map[string]any{"action": map[string]any{"id": len(f.calls), "command": command, "status": "running"}}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /servers/42", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"server": map[string]any{
+			"id": 42, "name": "web1", "status": f.status,
+			"server_type": map[string]any{"name": "cx22"},
+		}})
+	})
+	mux.HandleFunc("POST /servers/42/actions/{action}", func(w http.ResponseWriter, r *http.Request) {
+		action := r.PathValue("action")
+		if !f.fail[action] {
+			switch {
+			case action == "poweroff", action == "shutdown" && !f.ignoresShutdown:
+				f.status = "off"
+			case action == "poweron":
+				f.status = "running"
+			}
+		}
+		json.NewEncoder(w).Encode(started(action))
+	})
+	mux.HandleFunc("DELETE /servers/42", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(started("delete_server"))
+	})
+	mux.HandleFunc("GET /actions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		json.NewEncoder(w).Encode(map[string]any{"action": map[string]any{"command": f.calls[id-1], "status": results[id]}})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	c := hetzner.NewCloudClientAt(srv.URL, "tok")
+	c.PollInterval = 0
+	return c
+}
+
+func TestResize(t *testing.T) {
+	shutdownTimeout = 0
+	t.Cleanup(func() { shutdownTimeout = 2 * time.Minute })
+
+	for name, tc := range map[string]struct {
+		cloud cloudServer
+		want  []string
+	}{
+		"running":          {cloudServer{status: "running"}, []string{"shutdown", "change_type", "poweron"}},
+		"off":              {cloudServer{status: "off"}, []string{"change_type"}},
+		"ignores shutdown": {cloudServer{status: "running", ignoresShutdown: true}, []string{"shutdown", "poweroff", "change_type", "poweron"}},
+	} {
+		cloud := tc.cloud
+		if err := Resize(cloud.client(t), 42, "cx32", false, nil); err != nil {
+			t.Fatalf("Resize (%s): %v", name, err)
+		}
+		if !slices.Equal(cloud.calls, tc.want) {
+			t.Errorf("Resize (%s) calls = %q, want %q", name, cloud.calls, tc.want)
+		}
+	}
+}
+
+func TestResizeFails(t *testing.T) {
+	cloud := &cloudServer{status: "running", fail: map[string]bool{"change_type": true}}
+	err := Resize(cloud.client(t), 42, "cx32", false, nil)
+	if err == nil || !strings.Contains(err.Error(), "change_type failed") {
+		t.Fatalf("err = %v", err)
+	}
+	if want := []string{"shutdown", "change_type", "poweron"}; !slices.Equal(cloud.calls, want) {
+		t.Errorf("calls = %q, want %q", cloud.calls, want)
+	}
+	if cloud.status != "running" {
+		t.Errorf("status = %s, want the server running again", cloud.status)
+	}
+
+	// A server left off must show in the error.
+	cloud = &cloudServer{status: "running", fail: map[string]bool{"change_type": true, "poweron": true}}
+	err = Resize(cloud.client(t), 42, "cx32", false, nil)
+	if err == nil || !strings.Contains(err.Error(), "change_type failed") || !strings.Contains(err.Error(), "powering back on: poweron failed") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	web1 := config.Server{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}
+	web2 := config.Server{Name: "web2", IP: "5.6.7.8", HetznerProject: "prod", HetznerID: 43}
+	if err := store.SaveConfig(&config.Config{
+		Servers:  []config.Server{web1, web2},
+		Projects: []config.Project{{Name: "blog", Server: "web1"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.SetPeonKey(web1.IP, "KEY1", "/tmp/k1")
+	store.SetPeonKey(web2.IP, "KEY2", "/tmp/k2")
+	store.SetHostKey(web1.IP, "SHA256:one")
+
+	cfg, _ := store.LoadConfig()
+	if got := ProjectsOn(cfg, "web1"); len(got) != 1 || got[0] != "blog" {
+		t.Errorf("ProjectsOn = %q, want [blog]", got)
+	}
+
+	cloud := &cloudServer{status: "running"}
+	if err := Delete(cloud.client(t), store, web1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if len(cloud.calls) != 1 || cloud.calls[0] != "delete_server" {
+		t.Errorf("calls = %q", cloud.calls)
+	}
+
+	cfg, _ = store.LoadConfig()
+	if cfg.FindServer("web1") != nil {
+		t.Error("web1 is still in the config")
+	}
+	if cfg.FindServer("web2") == nil {
+		t.Error("web2 was removed too")
+	}
+	if _, err := store.GetPeonKey(web1.IP); err == nil {
+		t.Error("web1's peon key is still stored")
+	}
+	if fp, _ := store.GetHostKey(web1.IP); fp != "" {
+		t.Errorf("web1's host key = %q, want it removed", fp)
+	}
+	if key, _ := store.GetPeonKey(web2.IP); key != "KEY2" {
+		t.Errorf("web2's peon key = %q", key)
+	}
+}
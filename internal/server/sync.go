@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/hetzner"
+)
+
+// SyncChange is one difference between the store and Hetzner.
+type SyncChange struct {
+	Server string // name after the sync
+	Kind   string // added, renamed, ip, id, orphaned or found
+	Old    string
+	New    string
+}
+
+// SyncPlan is what Sync changes in the store to match Hetzner.
+type SyncPlan struct {
+	Changes []SyncChange
+	Renames map[string]string // old name -> new name
+	Servers []config.Server   // servers to save, under their new names
+	Moved   map[string]string // server name -> old IP, for servers whose IP changed
+	// Recreated maps the servers matched by name only to their old IP.
+	// They are new machines, so their peon and host keys are dropped.
+	Recreated map[string]string
+}
+
+// PlanSync matches the stored servers of the Hetzner projects in aliases
+// with the live ones. A stored server is matched by project and ID, so that
+// renames are followed, or failing that by name, for a server that was
+// recreated. Servers that match neither are marked orphaned rather than
+// deleted, since projects may still point at them; live servers that match
+// nothing are added. Stored servers of other projects are left alone.
+func PlanSync(stored []config.Server, live []hetzner.ServerWithProject, aliases []string) *SyncPlan {
+	plan := &SyncPlan{Renames: make(map[string]string), Moved: make(map[string]string), Recreated: make(map[string]string)}
+	type key struct {
+		alias string
+		id    int
+	}
+	byKey := make(map[key]int)
+	byName := make(map[string]int)
+	for i, l := range live {
+		byKey[key{l.ProjectAlias, l.ID}] = i
+		byName[l.Name] = i
+	}
+	storedNames := make(map[string]bool)
+	for _, s := range stored {
+		storedNames[s.Name] = true
+	}
+
+	// IDs first, so that a rename can't take another server's match.
+	match := make([]int, len(stored))
+	matched := make(map[int]bool)
+	byID := make([]bool, len(stored))
+	for j, s := range stored {
+		match[j] = -1
+		if i, ok := byKey[key{s.HetznerProject, s.HetznerID}]; ok && slices.Contains(aliases, s.HetznerProject) {
+			match[j], matched[i], byID[j] = i, true, true
+		}
+	}
+	for j, s := range stored {
+		if i, ok := byName[s.Name]; ok && match[j] < 0 && !matched[i] && slices.Contains(aliases, s.HetznerProject) {
+			match[j], matched[i] = i, true
+		}
+	}
+
+	for j, s := range stored {
+		if !slices.Contains(aliases, s.HetznerProject) {
+			continue
+		}
+		i := match[j]
+		if i < 0 {
+			if !s.Orphaned {
+				plan.Changes = append(plan.Changes, SyncChange{Server: s.Name, Kind: "orphaned"})
+				s.Orphaned = true
+				plan.Servers = append(plan.Servers, s)
+			}
+			continue
+		}
+		l := live[i]
+
+		updated := s
+		changed := false
+		if s.Orphaned {
+			plan.Changes = append(plan.Changes, SyncChange{Server: s.Name, Kind: "found"})
+			updated.Orphaned, changed = false, true
+		}
+		if l.Name != s.Name {
+			if storedNames[l.Name] {
+				// Another stored server has the name; renaming over it
+				// would merge the two, so keep ours as it is.
+				plan.Changes = append(plan.Changes, SyncChange{Server: s.Name, Kind: "renamed", Old: s.Name, New: l.Name + " (not applied: name in use)"})
+			} else {
+				plan.Changes = append(plan.Changes, SyncChange{Server: l.Name, Kind: "renamed", Old: s.Name, New: l.Name})
+				plan.Renames[s.Name] = l.Name
+				storedNames[l.Name] = true
+				updated.Name, changed = l.Name, true
+			}
+		}
+		if l.ProjectAlias != s.HetznerProject || l.ID != s.HetznerID {
+			plan.Changes = append(plan.Changes, SyncChange{Server: updated.Name, Kind: "id",
+				Old: fmt.Sprintf("%s/%d", s.HetznerProject, s.HetznerID), New: fmt.Sprintf("%s/%d", l.ProjectAlias, l.ID)})
+			updated.HetznerProject, updated.HetznerID, changed = l.ProjectAlias, l.ID, true
+		}
+		if !byID[j] {
+			plan.Recreated[updated.Name] = s.IP
+		}
+		if ip := l.PublicNet.IPv4.IP; ip != s.IP {
+			plan.Changes = append(plan.Changes, SyncChange{Server: updated.Name, Kind: "ip", Old: s.IP, New: ip})
+			plan.Moved[updated.Name] = s.IP
+			updated.IP, changed = ip, true
+		}
+		if changed {
+			plan.Servers = append(plan.Servers, updated)
+		}
+	}
+
+	for i, l := range live {
+		if matched[i] || storedNames[l.Name] {
+			continue
+		}
+		srv := config.Server{Name: l.Name, IP: l.PublicNet.IPv4.IP, HetznerProject: l.ProjectAlias, HetznerID: l.ID}
+		plan.Changes = append(plan.Changes, SyncChange{Server: l.Name, Kind: "added", New: srv.IP})
+		plan.Servers = append(plan.Servers, srv)
+	}
+	return plan
+}
+
+// ApplySync writes a plan to the store. The peon key and pinned host key of
+// a server whose IP changed are moved to the new IP: they belong to the
+// machine, not the address. For the same reason a recreated server's keys
+// are dropped, as the new machine has neither; it has to be bootstrapped
+// again.
+func ApplySync(store config.Store, plan *SyncPlan) error {
+	for oldName, newName := range plan.Renames {
+		if err := store.RenameServer(oldName, newName); err != nil {
+			return err
+		}
+	}
+	if len(plan.Servers) > 0 {
+		if err := store.SaveConfig(&config.Config{Servers: plan.Servers}); err != nil {
+			return fmt.Errorf("saving servers: %w", err)
+		}
+	}
+
+	keys, err := store.ListPeonKeys()
+	if err != nil {
+		return fmt.Errorf("listing peon keys: %w", err)
+	}
+	for _, srv := range plan.Servers {
+		if oldIP, ok := plan.Recreated[srv.Name]; ok {
+			if err := dropKeys(store, keys, oldIP); err != nil {
+				return fmt.Errorf("dropping keys of %s: %w", srv.Name, err)
+			}
+			continue
+		}
+		oldIP, ok := plan.Moved[srv.Name]
+		if !ok || oldIP == "" {
+			continue
+		}
+		for _, k := range keys {
+			if k.ServerIP != oldIP {
+				continue
+			}
+			if err := store.SetPeonKey(srv.IP, k.PrivateKey, k.KeyPath); err != nil {
+				return fmt.Errorf("moving peon key of %s: %w", srv.Name, err)
+			}
+			if err := store.DeletePeonKey(oldIP); err != nil {
+				return err
+			}
+		}
+		if fingerprint, err := store.GetHostKey(oldIP); err == nil && fingerprint != "" {
+			if err := store.SetHostKey(srv.IP, fingerprint); err != nil {
+				return fmt.Errorf("moving host key of %s: %w", srv.Name, err)
+			}
+			if err := store.DeleteHostKey(oldIP); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dropKeys deletes the peon key and pinned host key stored for ip.
+func dropKeys(store config.Store, keys []config.PeonKey, ip string) error {
+	if ip == "" {
+		return nil
+	}
+	for _, k := range keys {
+		if k.ServerIP == ip {
+			if err := store.DeletePeonKey(ip); err != nil {
+				return err
+			}
+		}
+	}
+	return store.DeleteHostKey(ip)
+}
+
+// RepointDNS moves the A records of domain that point at oldIP to newIP.
+// Records pointing anywhere else were changed on purpose and are left
+// alone. It returns how many records were updated.
+func RepointDNS(provider dns.Provider, domain, oldIP, newIP string) (int, error) {
+	rootDomain, err := config.RootDomain(domain)
+	if err != nil {
+		return 0, fmt.Errorf("resolving root domain for %s: %w", domain, err)
+	}
+	records, err := provider.ListRecords(rootDomain)
+	if err != nil {
+		return 0, fmt.Errorf("listing DNS records for %s: %w", rootDomain, err)
+	}
+	updated := 0
+	for _, r := range records {
+		if r.Name != domain || r.Type != "A" || r.Content != oldIP {
+			continue
+		}
+		r.Content = newIP
+		if err := provider.UpdateRecord(rootDomain, r.ID, r); err != nil {
+			return updated, fmt.Errorf("updating A record %s: %w", domain, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/hetzner"
+)
+
+func liveServer(alias string, id int, name, ip string) hetzner.ServerWithProject {
+	s := hetzner.ServerWithProject{ProjectAlias: alias}
+	s.ID, s.Name = id, name
+	s.PublicNet.IPv4.IP = ip
+	return s
+}
+
+func TestPlanSync(t *testing.T) {
+	stored := []config.Server{
+		{Name: "same", IP: "1.1.1.1", HetznerProject: "prod", HetznerID: 1},
+		{Name: "web1", IP: "2.2.2.2", HetznerProject: "prod", HetznerID: 2},
+		{Name: "db", IP: "3.3.3.3", HetznerProject: "prod", HetznerID: 3},
+		{Name: "gone", IP: "4.4.4.4", HetznerProject: "prod", HetznerID: 4},
+		{Name: "back", IP: "5.5.5.5", HetznerProject: "prod", HetznerID: 5, Orphaned: true},
+		{Name: "elsewhere", IP: "6.6.6.6", HetznerProject: "other", HetznerID: 6},
+	}
+	live := []hetzner.ServerWithProject{
+		liveServer("prod", 1, "same", "1.1.1.1"),
+		liveServer("prod", 2, "edge1", "2.2.2.2"), // renamed
+		liveServer("prod", 30, "db", "3.3.3.30"),  // recreated with a new ID and IP
+		liveServer("prod", 5, "back", "5.5.5.5"),  // reappeared
+		liveServer("prod", 7, "new", "7.7.7.7"),   // created outside arnor
+		liveServer("prod", 8, "gone", "8.8.8.8"),  // not matched: "gone" has ID 4
+	}
+
+	plan := PlanSync(stored, live, []string{"prod"})
+
+	var got []string
+	for _, c := range plan.Changes {
+		got = append(got, c.Server+" "+c.Kind+" "+c.Old+" "+c.New)
+	}
+	want := []string{
+		"edge1 renamed web1 edge1",
+		"db id prod/3 prod/30",
+		"db ip 3.3.3.3 3.3.3.30",
+		"gone id prod/4 prod/8",
+		"gone ip 4.4.4.4 8.8.8.8",
+		"back found  ",
+		"new added  7.7.7.7",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("changes:\n got %q\nwant %q", got, want)
+	}
+	if plan.Renames["web1"] != "edge1" || len(plan.Renames) != 1 {
+		t.Errorf("renames = %v", plan.Renames)
+	}
+	if plan.Moved["db"] != "3.3.3.3" || plan.Moved["gone"] != "4.4.4.4" || len(plan.Moved) != 2 {
+		t.Errorf("moved = %v", plan.Moved)
+	}
+	if plan.Recreated["db"] != "3.3.3.3" || plan.Recreated["gone"] != "4.4.4.4" || len(plan.Recreated) != 2 {
+		t.Errorf("recreated = %v", plan.Recreated)
+	}
+
+	// Once gone is really gone it is orphaned, not deleted.
+	plan = PlanSync(stored, live[:5], []string{"prod"})
+	orphaned := false
+	for _, c := range plan.Changes {
+		if c.Server == "gone" {
+			orphaned = c.Kind == "orphaned"
+		}
+	}
+	if !orphaned {
+		t.Errorf("changes = %+v, want gone orphaned", plan.Changes)
+	}
+}
+
+func TestPlanSyncIDBeforeName(t *testing.T) {
+	// b was renamed to a's name and a was deleted: b must keep its
+	// identity rather than a taking over b's server.
+	stored := []config.Server{
+		{Name: "a", IP: "1.1.1.1", HetznerProject: "prod", HetznerID: 1},
+		{Name: "b", IP: "2.2.2.2", HetznerProject: "prod", HetznerID: 2},
+	}
+	live := []hetzner.ServerWithProject{liveServer("prod", 2, "a", "2.2.2.2")}
+
+	plan := PlanSync(stored, live, []string{"prod"})
+	var got []string
+	for _, c := range plan.Changes {
+		got = append(got, c.Server+" "+c.Kind)
+	}
+	want := []string{"a orphaned", "b renamed"}
+	if !slices.Equal(got, want) {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+	if len(plan.Renames) != 0 {
+		t.Errorf("renames = %v, want none (a is still stored)", plan.Renames)
+	}
+}
+
+func TestApplySync(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.SaveConfig(&config.Config{
+		Servers:  []config.Server{{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}},
+		Projects: []config.Project{{Name: "blog", Server: "web1"}},
+	})
+	store.SetPeonKey("1.2.3.4", "KEY", "/tmp/peon")
+	store.SetHostKey("1.2.3.4", "SHA256:abc")
+
+	cfg, _ := store.LoadConfig()
+	plan := PlanSync(cfg.Servers, []hetzner.ServerWithProject{liveServer("prod", 42, "edge1", "5.6.7.8")}, []string{"prod"})
+	if err := ApplySync(store, plan); err != nil {
+		t.Fatalf("ApplySync: %v", err)
+	}
+
+	cfg, _ = store.LoadConfig()
+	want := config.Server{Name: "edge1", IP: "5.6.7.8", HetznerProject: "prod", HetznerID: 42}
+	if len(cfg.Servers) != 1 || cfg.Servers[0] != want {
+		t.Errorf("servers = %+v, want [%+v]", cfg.Servers, want)
+	}
+	if p := cfg.FindProject("blog"); p == nil || p.Server != "edge1" {
+		t.Errorf("blog = %+v, want it on edge1", p)
+	}
+	if key, _ := store.GetPeonKey("5.6.7.8"); key != "KEY" {
+		t.Errorf("peon key at new IP = %q", key)
+	}
+	if _, err := store.GetPeonKey("1.2.3.4"); err == nil {
+		t.Error("peon key is still stored under the old IP")
+	}
+	if fp, _ := store.GetHostKey("5.6.7.8"); fp != "SHA256:abc" {
+		t.Errorf("host key at new IP = %q", fp)
+	}
+	if fp, _ := store.GetHostKey("1.2.3.4"); fp != "" {
+		t.Errorf("host key at old IP = %q, want it moved", fp)
+	}
+}
+
+func TestApplySyncDropsKeysOfRecreatedServer(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.SaveConfig(&config.Config{
+		Servers: []config.Server{{Name: "web1", IP: "1.2.3.4", HetznerProject: "prod", HetznerID: 42}},
+	})
+	store.SetPeonKey("1.2.3.4", "KEY", "/tmp/peon")
+	store.SetHostKey("1.2.3.4", "SHA256:abc")
+
+	// Same name, new ID: a different machine that has never seen the key.
+	cfg, _ := store.LoadConfig()
+	plan := PlanSync(cfg.Servers, []hetzner.ServerWithProject{liveServer("prod", 43, "web1", "5.6.7.8")}, []string{"prod"})
+	if err := ApplySync(store, plan); err != nil {
+		t.Fatalf("ApplySync: %v", err)
+	}
+
+	for _, ip := range []string{"1.2.3.4", "5.6.7.8"} {
+		if _, err := store.GetPeonKey(ip); err == nil {
+			t.Errorf("peon key still stored under %s", ip)
+		}
+		if fp, _ := store.GetHostKey(ip); fp != "" {
+			t.Errorf("host key at %s = %q, want none", ip, fp)
+		}
+	}
+	cfg, _ = store.LoadConfig()
+	want := config.Server{Name: "web1", IP: "5.6.7.8", HetznerProject: "prod", HetznerID: 43}
+	if len(cfg.Servers) != 1 || cfg.Servers[0] != want {
+		t.Errorf("servers = %+v, want [%+v]", cfg.Servers, want)
+	}
+}
@@ -1,17 +1,16 @@
 package service
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/dukerupert/arnor/internal/caddy"
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/arnor/internal/dns"
 	"github.com/dukerupert/arnor/internal/hetzner"
-	"golang.org/x/crypto/ssh"
+	"github.com/dukerupert/arnor/internal/plan"
+	"github.com/dukerupert/arnor/internal/remote"
 )
 
 // DeployParams contains all inputs for service deployment.
@@ -21,11 +20,24 @@ type DeployParams struct {
 	Domain      string
 	Port        int
 	ComposeFile string // local path to docker-compose.yml
+	DNSProvider string // detected from the domain if empty
 	PeonKey     string // PEM-encoded peon SSH key
 	Store       config.Store
 	OnProgress  func(step, total int, message string)
+
+	// Plan, if non-nil, turns Deploy into a dry run: SSH commands, DNS writes
+	// and config updates are recorded into it and nothing is changed.
+	Plan *plan.Plan
 }
 
+// DNS lookups go through these variables so that tests can run against a
+// stand-in provider without resolving real nameservers.
+var (
+	newDNSProvider       = dns.NewProvider
+	dnsProviderForDomain = dns.ProviderForDomain
+	lookupRootDomain     = config.RootDomain
+)
+
 // Deploy runs the full service deployment orchestration.
 func Deploy(params DeployParams) error {
 	const totalSteps = 8
@@ -62,110 +74,210 @@ func Deploy(params DeployParams) error {
 
 	// Step 2: Detect DNS provider
 	report(2, "Detecting DNS provider...")
-	provider, err := dns.ProviderForDomain(params.Domain, cfg, params.Store)
+	var provider dns.Provider
+	if params.DNSProvider != "" {
+		provider, err = newDNSProvider(params.DNSProvider, params.Store)
+	} else {
+		provider, err = dnsProviderForDomain(params.Domain, cfg, params.Store)
+	}
 	if err != nil {
 		return fmt.Errorf("detecting DNS provider for %s: %w", params.Domain, err)
 	}
 
-	// Resolve peon key
-	peonKey := params.PeonKey
-	if peonKey == "" {
-		peonKey, err = params.Store.GetPeonKey(server.IP)
+	// Open single SSH connection for steps 3-6
+	var client remote.Runner
+	if params.Plan != nil {
+		provider = dns.NewRecorder(provider, params.Plan)
+		client = remote.NewRecorder(server.IP, params.Plan)
+	} else {
+		peonKey := params.PeonKey
+		if peonKey == "" {
+			peonKey, err = params.Store.GetPeonKey(server.IP)
+			if err != nil {
+				return fmt.Errorf("peon key for %s: %w", server.IP, err)
+			}
+		}
+		client, err = remote.DialPeon(server.IP, peonKey, params.Store)
 		if err != nil {
-			return fmt.Errorf("peon key for %s: %w", server.IP, err)
+			return err
 		}
 	}
-
-	// Open single SSH connection for steps 3-6
-	client, err := dialPeon(server.IP, peonKey)
-	if err != nil {
-		return err
-	}
 	defer client.Close()
 
 	deployPath := fmt.Sprintf("/opt/%s", params.ServiceName)
+	composePath := deployPath + "/docker-compose.yml"
+	caddyPath := fmt.Sprintf("/etc/caddy/conf.d/%s.caddy", params.Domain)
+
+	// Every step that changes state registers a compensating action. If a
+	// later step fails, fail unwinds them in reverse order.
+	var undo undoLog
+	fail := func(err error) error {
+		if len(undo.actions) == 0 {
+			return err
+		}
+		return &RollbackError{Err: err, Reverted: undo.unwind()}
+	}
 
 	// Step 3: Create deploy directory
 	report(3, "Creating deploy directory...")
+	dirExisted := client.Run(fmt.Sprintf("test -d %s", deployPath)) == nil
 	cmds := []string{
 		fmt.Sprintf("sudo mkdir -p %s", deployPath),
 		fmt.Sprintf("sudo chown peon:peon %s", deployPath),
 	}
 	for _, c := range cmds {
-		if err := sshRun(client, c); err != nil {
-			return fmt.Errorf("creating deploy dir: %w", err)
+		if err := client.Run(c); err != nil {
+			return fail(fmt.Errorf("creating deploy dir: %w", err))
 		}
 	}
+	if !dirExisted {
+		undo.add("removed "+deployPath, func() error {
+			return client.Run(fmt.Sprintf("sudo rm -rf %s", deployPath))
+		})
+	}
 
 	// Step 4: Upload docker-compose.yml
 	report(4, "Uploading docker-compose.yml...")
 	composeContent, err := os.ReadFile(params.ComposeFile)
 	if err != nil {
-		return fmt.Errorf("reading compose file %s: %w", params.ComposeFile, err)
+		return fail(fmt.Errorf("reading compose file %s: %w", params.ComposeFile, err))
 	}
-	composePath := deployPath + "/docker-compose.yml"
-	if err := sshWriteFile(client, composePath, string(composeContent)); err != nil {
-		return fmt.Errorf("uploading compose file: %w", err)
+	prevCompose, composeExisted, err := readRemoteFile(client, composePath)
+	if err != nil {
+		return fail(fmt.Errorf("reading existing compose file: %w", err))
+	}
+	if err := client.WriteFile(composePath, string(composeContent)); err != nil {
+		return fail(fmt.Errorf("uploading compose file: %w", err))
+	}
+	restoreCompose := func() error {
+		if err := client.WriteFile(composePath, prevCompose); err != nil {
+			return err
+		}
+		return client.Run(fmt.Sprintf("sudo chown peon:peon %s", composePath))
+	}
+	if composeExisted {
+		undo.add("restored previous "+composePath, restoreCompose)
+	} else {
+		undo.add("removed "+composePath, func() error {
+			return client.Run(fmt.Sprintf("sudo rm -f %s", composePath))
+		})
 	}
-	if err := sshRun(client, fmt.Sprintf("sudo chown peon:peon %s", composePath)); err != nil {
-		return fmt.Errorf("chowning compose file: %w", err)
+	if err := client.Run(fmt.Sprintf("sudo chown peon:peon %s", composePath)); err != nil {
+		return fail(fmt.Errorf("chowning compose file: %w", err))
 	}
 
-	// Step 5: Run docker compose up
+	// Step 5: Run docker compose up. The undo is registered first: a failed
+	// up can still have started or replaced some of the containers.
 	report(5, "Starting containers...")
-	if err := sshRun(client, fmt.Sprintf("cd %s && docker compose up -d", deployPath)); err != nil {
-		return fmt.Errorf("running docker compose up: %w", err)
+	if composeExisted {
+		undo.add("restarted previous compose stack in "+deployPath, func() error {
+			if err := restoreCompose(); err != nil {
+				return err
+			}
+			return client.Run(fmt.Sprintf("cd %s && docker compose up -d --remove-orphans", deployPath))
+		})
+	} else {
+		undo.add("ran docker compose down in "+deployPath, func() error {
+			return client.Run(fmt.Sprintf("cd %s && docker compose down", deployPath))
+		})
+	}
+	if err := client.Run(fmt.Sprintf("cd %s && docker compose up -d", deployPath)); err != nil {
+		return fail(fmt.Errorf("running docker compose up: %w", err))
 	}
 
 	// Step 6: Generate and deploy Caddy config
 	report(6, "Writing Caddy config...")
 	caddyConfig := caddy.Generate(params.Domain, params.Port, provider.Name())
-	caddyPath := fmt.Sprintf("/etc/caddy/conf.d/%s.caddy", params.Domain)
-	if err := sshRun(client, "sudo mkdir -p /etc/caddy/conf.d"); err != nil {
-		return fmt.Errorf("creating caddy conf.d: %w", err)
+	if err := client.Run("sudo mkdir -p /etc/caddy/conf.d"); err != nil {
+		return fail(fmt.Errorf("creating caddy conf.d: %w", err))
+	}
+	prevCaddy, caddyExisted, err := readRemoteFile(client, caddyPath)
+	if err != nil {
+		return fail(fmt.Errorf("reading existing caddy config: %w", err))
 	}
-	if err := sshWriteFile(client, caddyPath, caddyConfig); err != nil {
-		return fmt.Errorf("writing caddy config: %w", err)
+	if err := client.WriteFile(caddyPath, caddyConfig); err != nil {
+		return fail(fmt.Errorf("writing caddy config: %w", err))
 	}
-	validateOut, err := sshOutput(client, `sudo bash -c 'for e in $(systemctl show caddy -p Environment --value); do export "$e"; done; caddy validate --config /etc/caddy/Caddyfile' 2>&1`)
+	if caddyExisted {
+		undo.add("restored previous "+caddyPath, func() error {
+			if err := client.WriteFile(caddyPath, prevCaddy); err != nil {
+				return err
+			}
+			return client.Run("sudo systemctl reload caddy")
+		})
+	} else {
+		undo.add("removed "+caddyPath, func() error {
+			if err := client.Run(fmt.Sprintf("sudo rm -f %s", caddyPath)); err != nil {
+				return err
+			}
+			return client.Run("sudo systemctl reload caddy")
+		})
+	}
+	validateOut, err := client.Output(`sudo bash -c 'for e in $(systemctl show caddy -p Environment --value); do export "$e"; done; caddy validate --config /etc/caddy/Caddyfile' 2>&1`)
 	if err != nil {
-		return fmt.Errorf("caddy config validation failed: %s", strings.TrimSpace(validateOut))
+		return fail(fmt.Errorf("caddy config validation failed: %s", strings.TrimSpace(validateOut)))
 	}
-	if err := sshRun(client, "sudo systemctl reload caddy"); err != nil {
-		journalOut, _ := sshOutput(client, "sudo journalctl -u caddy -n 20 --no-pager 2>&1")
-		return fmt.Errorf("reloading caddy: %w\njournal output:\n%s", err, strings.TrimSpace(journalOut))
+	if err := client.Run("sudo systemctl reload caddy"); err != nil {
+		journalOut, _ := client.Output("sudo journalctl -u caddy -n 20 --no-pager 2>&1")
+		return fail(fmt.Errorf("reloading caddy: %w\njournal output:\n%s", err, strings.TrimSpace(journalOut)))
 	}
 
-	// Step 7: Create DNS records
-	report(7, "Creating DNS records...")
-	rootDomain, err := config.RootDomain(params.Domain)
+	// Step 7: Point DNS at the server
+	report(7, "Updating DNS records...")
+	rootDomain, err := lookupRootDomain(params.Domain)
 	if err != nil {
-		return fmt.Errorf("resolving root domain for %s: %w", params.Domain, err)
+		return fail(fmt.Errorf("resolving root domain for %s: %w", params.Domain, err))
 	}
 	subName := ""
 	if rootDomain != params.Domain {
 		subName = strings.TrimSuffix(params.Domain, "."+rootDomain)
 	}
 
-	// Remove existing A/CNAME/ALIAS records before creating new ones
-	if existing, err := provider.ListRecords(rootDomain); err == nil {
-		for _, r := range existing {
-			if r.Name == params.Domain && (r.Type == "A" || r.Type == "CNAME" || r.Type == "ALIAS") {
-				provider.DeleteRecord(rootDomain, r.ID)
+	// Upsert the A record so the name keeps resolving throughout. Only
+	// records that conflict with an A record (CNAME/ALIAS) are removed first.
+	// The ListRecords snapshot is what we restore from on rollback.
+	existing, err := provider.ListRecords(rootDomain)
+	if err != nil {
+		return fail(fmt.Errorf("listing DNS records for %s: %w", rootDomain, err))
+	}
+	var previousA, previousWWW []dns.DNSRecord
+	wwwDomain := "www." + params.Domain
+	for _, r := range existing {
+		switch {
+		case r.Name == params.Domain && r.Type == "A":
+			previousA = append(previousA, r)
+		case r.Name == params.Domain && (r.Type == "CNAME" || r.Type == "ALIAS"):
+			if err := provider.DeleteRecord(rootDomain, r.ID); err != nil {
+				return fail(fmt.Errorf("deleting %s record %s: %w", r.Type, r.Name, err))
 			}
+			undo.add(fmt.Sprintf("re-created %s record %s -> %s", r.Type, r.Name, r.Content), func() error {
+				r.ID = ""
+				_, err := provider.CreateRecord(rootDomain, r)
+				return err
+			})
+		case r.Name == wwwDomain && r.Type == "CNAME":
+			previousWWW = append(previousWWW, r)
 		}
 	}
 
-	if _, err := provider.CreateRecord(rootDomain, subName, "A", server.IP, "600"); err != nil {
-		return fmt.Errorf("creating A record: %w", err)
+	aID, err := provider.UpsertRecord(rootDomain, dns.DNSRecord{Name: subName, Type: "A", Content: server.IP, TTL: "600"})
+	if err != nil {
+		return fail(fmt.Errorf("upserting A record: %w", err))
 	}
+	undo.add(restoreDescription("A", params.Domain, previousA), func() error {
+		return restoreRecords(provider, rootDomain, aID, previousA)
+	})
 
 	// Best-effort www CNAME
 	wwwName := "www"
 	if subName != "" {
 		wwwName = "www." + subName
 	}
-	provider.CreateRecord(rootDomain, wwwName, "CNAME", params.Domain, "600")
+	if cnameID, err := provider.UpsertRecord(rootDomain, dns.DNSRecord{Name: wwwName, Type: "CNAME", Content: params.Domain, TTL: "600"}); err == nil {
+		undo.add(restoreDescription("CNAME", wwwDomain, previousWWW), func() error {
+			return restoreRecords(provider, rootDomain, cnameID, previousWWW)
+		})
+	}
 
 	// Step 8: Save to config
 	report(8, "Updating config...")
@@ -177,12 +289,22 @@ func Deploy(params DeployParams) error {
 		Port:        params.Port,
 	}
 
+	if params.Plan != nil {
+		repo := ""
+		if existing := cfg.FindProject(params.ServiceName); existing != nil {
+			repo = existing.Repo
+		}
+		params.Plan.SetEnvironment(cfg, params.ServiceName, repo, params.ServerName, "", "prod", env)
+		return nil
+	}
+
 	existingProject := cfg.FindProject(params.ServiceName)
 	if existingProject != nil {
 		if existingProject.Environments == nil {
 			existingProject.Environments = make(map[string]config.Environment)
 		}
 		existingProject.Environments["prod"] = env
+		existingProject.Server = params.ServerName
 	} else {
 		cfg.Projects = append(cfg.Projects, config.Project{
 			Name:   params.ServiceName,
@@ -195,69 +317,21 @@ func Deploy(params DeployParams) error {
 	}
 
 	if err := params.Store.SaveConfig(cfg); err != nil {
-		return fmt.Errorf("saving config: %w", err)
+		return fail(fmt.Errorf("saving config: %w", err))
 	}
 
 	return nil
 }
 
-// SSH helpers — duplicated from internal/caddy/install.go per project convention.
-
-func dialPeon(serverIP, peonKeyPEM string) (*ssh.Client, error) {
-	signer, err := ssh.ParsePrivateKey([]byte(peonKeyPEM))
-	if err != nil {
-		return nil, fmt.Errorf("parsing peon SSH key: %w", err)
-	}
-
-	sshConfig := &ssh.ClientConfig{
-		User:            "peon",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+// readRemoteFile returns the contents of path on the server and whether it
+// existed, so a step can restore it later.
+func readRemoteFile(client remote.Runner, path string) (string, bool, error) {
+	if err := client.Run(fmt.Sprintf("sudo test -f %s", path)); err != nil {
+		return "", false, nil
 	}
-
-	client, err := ssh.Dial("tcp", serverIP+":22", sshConfig)
-	if err != nil {
-		return nil, fmt.Errorf("SSH dial to %s: %w", serverIP, err)
-	}
-	return client, nil
-}
-
-func sshRun(client *ssh.Client, command string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-	return session.Run(command)
-}
-
-func sshOutput(client *ssh.Client, command string) (string, error) {
-	session, err := client.NewSession()
+	content, err := client.Output(fmt.Sprintf("sudo cat %s", path))
 	if err != nil {
-		return "", err
+		return "", true, err
 	}
-	defer session.Close()
-	out, err := session.Output(command)
-	return string(out), err
-}
-
-func sshWriteFile(client *ssh.Client, path, content string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	var stderr bytes.Buffer
-	session.Stdin = strings.NewReader(content)
-	session.Stderr = &stderr
-	err = session.Run(fmt.Sprintf("sudo tee %s > /dev/null", path))
-	session.Close()
-	if err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg != "" {
-			return fmt.Errorf("%s", errMsg)
-		}
-		return err
-	}
-	return nil
+	return content, true, nil
 }
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
+	"github.com/dukerupert/arnor/internal/plan"
+)
+
+// zoneProvider is a DNS provider with a fixed zone. Dry runs only read from
+// it; the embedded nil Provider panics on writes.
+type zoneProvider struct {
+	dns.Provider
+	records []dns.DNSRecord
+}
+
+func (z zoneProvider) Name() string { return "porkbun" }
+
+func (z zoneProvider) ListRecords(domain string) ([]dns.DNSRecord, error) { return z.records, nil }
+
+func TestDeployPlan(t *testing.T) {
+	store, err := config.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SaveConfig(&config.Config{Servers: []config.Server{{Name: "web1", IP: "1.2.3.4"}}})
+
+	origProvider, origRoot := newDNSProvider, lookupRootDomain
+	t.Cleanup(func() { newDNSProvider, lookupRootDomain = origProvider, origRoot })
+	lookupRootDomain = func(string) (string, error) { return "example.com", nil }
+	newDNSProvider = func(string, config.Store) (dns.Provider, error) {
+		return zoneProvider{records: []dns.DNSRecord{
+			{ID: "7", Name: "kuma.example.com", Type: "A", Content: "5.6.7.8", TTL: "600"},
+		}}, nil
+	}
+
+	composeFile := filepath.Join(t.TempDir(), "kuma.yml")
+	os.WriteFile(composeFile, []byte("services:\n  kuma:\n    image: louislam/uptime-kuma:1\n"), 0644)
+
+	p := plan.New("service deploy kuma")
+	err = Deploy(DeployParams{
+		ServiceName: "kuma",
+		ServerName:  "web1",
+		Domain:      "kuma.example.com",
+		Port:        3100,
+		ComposeFile: composeFile,
+		DNSProvider: "porkbun",
+		Store:       store,
+		Plan:        p,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var remote []string
+	for _, op := range p.Remote {
+		if op.Path != "" {
+			remote = append(remote, "write "+op.Path)
+		} else {
+			remote = append(remote, op.Command)
+		}
+	}
+	wantRemote := []string{
+		"sudo mkdir -p /opt/kuma",
+		"sudo chown peon:peon /opt/kuma",
+		"write /opt/kuma/docker-compose.yml",
+		"sudo chown peon:peon /opt/kuma/docker-compose.yml",
+		"cd /opt/kuma && docker compose up -d",
+		"sudo mkdir -p /etc/caddy/conf.d",
+		"write /etc/caddy/conf.d/kuma.example.com.caddy",
+		`sudo bash -c 'for e in $(systemctl show caddy -p Environment --value); do export "$e"; done; caddy validate --config /etc/caddy/Caddyfile' 2>&1`,
+		"sudo systemctl reload caddy",
+	}
+	if !reflect.DeepEqual(remote, wantRemote) {
+		t.Errorf("remote:\n got %q\nwant %q", remote, wantRemote)
+	}
+
+	var reads []string
+	for _, op := range p.Reads {
+		reads = append(reads, op.Command)
+	}
+	wantReads := []string{
+		"test -d /opt/kuma",
+		"sudo test -f /opt/kuma/docker-compose.yml",
+		"sudo cat /opt/kuma/docker-compose.yml",
+		"sudo test -f /etc/caddy/conf.d/kuma.example.com.caddy",
+		"sudo cat /etc/caddy/conf.d/kuma.example.com.caddy",
+	}
+	if !reflect.DeepEqual(reads, wantReads) {
+		t.Errorf("reads:\n got %q\nwant %q", reads, wantReads)
+	}
+	if content := p.Remote[2].Content; content != "services:\n  kuma:\n    image: louislam/uptime-kuma:1\n" {
+		t.Errorf("compose content = %q", content)
+	}
+
+	var dnsChanges []string
+	for _, c := range p.DNS {
+		dnsChanges = append(dnsChanges, c.Action+" "+c.Type+" "+c.Name+" "+c.Content)
+	}
+	wantDNS := []string{
+		"upsert A kuma.example.com 1.2.3.4",
+		"upsert CNAME www.kuma.example.com kuma.example.com",
+	}
+	if !reflect.DeepEqual(dnsChanges, wantDNS) {
+		t.Errorf("dns:\n got %q\nwant %q", dnsChanges, wantDNS)
+	}
+	if len(p.Config) != 2 || p.Config[0] != "projects: insert kuma (repo=, server=web1)" {
+		t.Errorf("config = %q", p.Config)
+	}
+}
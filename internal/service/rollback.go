@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dukerupert/arnor/internal/dns"
+)
+
+// undoAction is a compensating action registered once a deploy step has
+// changed something on the server or at the DNS provider.
+type undoAction struct {
+	description string
+	undo        func() error
+}
+
+// UndoResult records the outcome of one compensating action.
+type UndoResult struct {
+	Description string
+	Err         error
+}
+
+// undoLog collects compensating actions in the order their steps ran.
+type undoLog struct {
+	actions []undoAction
+}
+
+func (l *undoLog) add(description string, undo func() error) {
+	l.actions = append(l.actions, undoAction{description: description, undo: undo})
+}
+
+// unwind runs every registered action in reverse order. Failures are recorded
+// and do not stop the remaining actions from running.
+func (l *undoLog) unwind() []UndoResult {
+	results := make([]UndoResult, 0, len(l.actions))
+	for i := len(l.actions) - 1; i >= 0; i-- {
+		a := l.actions[i]
+		results = append(results, UndoResult{Description: a.description, Err: a.undo()})
+	}
+	return results
+}
+
+// RollbackError is returned by Deploy when a step fails after earlier steps
+// have already changed state. It wraps the original failure and lists what
+// was reverted.
+type RollbackError struct {
+	Err      error
+	Reverted []UndoResult
+}
+
+func (e *RollbackError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	if len(e.Reverted) == 0 {
+		return b.String()
+	}
+	b.WriteString("\nrolled back:")
+	for _, r := range e.Reverted {
+		if r.Err != nil {
+			fmt.Fprintf(&b, "\n  ✗ %s: %v", r.Description, r.Err)
+		} else {
+			fmt.Fprintf(&b, "\n  ✓ %s", r.Description)
+		}
+	}
+	return b.String()
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// restoreDescription describes the undo of an upsert for RollbackError.
+func restoreDescription(recordType, name string, previous []dns.DNSRecord) string {
+	if len(previous) == 0 {
+		return fmt.Sprintf("deleted %s record %s", recordType, name)
+	}
+	return fmt.Sprintf("restored %s record %s -> %s", recordType, name, previous[0].Content)
+}
+
+// restoreRecords undoes an upsert: it deletes the record with the given ID
+// if there was none before, or puts back the previous records of that name
+// and type.
+func restoreRecords(provider dns.Provider, rootDomain, id string, previous []dns.DNSRecord) error {
+	if len(previous) == 0 {
+		return provider.DeleteRecord(rootDomain, id)
+	}
+	if _, err := provider.UpsertRecord(rootDomain, previous[0]); err != nil {
+		return err
+	}
+	for _, r := range previous[1:] {
+		if _, err := provider.CreateRecord(rootDomain, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dukerupert/arnor/internal/dns"
+)
+
+func TestUndoLogUnwindsInReverse(t *testing.T) {
+	var order []string
+	var l undoLog
+	l.add("first", func() error { order = append(order, "first"); return nil })
+	l.add("second", func() error { order = append(order, "second"); return errors.New("boom") })
+	l.add("third", func() error { order = append(order, "third"); return nil })
+
+	results := l.unwind()
+
+	if strings.Join(order, ",") != "third,second,first" {
+		t.Errorf("unwind order = %v, want third,second,first", order)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if results[1].Description != "second" || results[1].Err == nil {
+		t.Errorf("expected failure recorded for second action, got %+v", results[1])
+	}
+}
+
+func TestRollbackErrorMessage(t *testing.T) {
+	cause := errors.New("caddy config validation failed")
+	err := &RollbackError{
+		Err: cause,
+		Reverted: []UndoResult{
+			{Description: "removed /etc/caddy/conf.d/example.com.caddy"},
+			{Description: "ran docker compose down in /opt/app", Err: errors.New("exit 1")},
+		},
+	}
+
+	if !errors.Is(err, cause) {
+		t.Error("RollbackError should unwrap to the original error")
+	}
+
+	msg := err.Error()
+	for _, want := range []string{
+		"caddy config validation failed",
+		"rolled back:",
+		"✓ removed /etc/caddy/conf.d/example.com.caddy",
+		"✗ ran docker compose down in /opt/app: exit 1",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+// callLog is a dns.Provider that records write calls.
+type callLog struct {
+	dns.Provider
+	calls []string
+}
+
+func (c *callLog) CreateRecord(domain string, rec dns.DNSRecord) (string, error) {
+	c.calls = append(c.calls, "create "+rec.Name+" "+rec.Type+" "+rec.Value())
+	return "new", nil
+}
+
+func (c *callLog) UpsertRecord(domain string, rec dns.DNSRecord) (string, error) {
+	c.calls = append(c.calls, "upsert "+rec.Name+" "+rec.Type+" "+rec.Value())
+	return "new", nil
+}
+
+func (c *callLog) DeleteRecord(domain, id string) error {
+	c.calls = append(c.calls, "delete "+id)
+	return nil
+}
+
+func TestRestoreRecords(t *testing.T) {
+	p := &callLog{}
+	if err := restoreRecords(p, "example.com", "42", nil); err != nil {
+		t.Fatal(err)
+	}
+	previous := []dns.DNSRecord{
+		{Name: "app.example.com", Type: "A", Content: "1.1.1.1", TTL: "600"},
+		{Name: "app.example.com", Type: "A", Content: "2.2.2.2", TTL: "600"},
+	}
+	if err := restoreRecords(p, "example.com", "42", previous); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "delete 42,upsert app.example.com A 1.1.1.1,create app.example.com A 2.2.2.2"
+	if got := strings.Join(p.calls, ","); got != want {
+		t.Errorf("calls = %s, want %s", got, want)
+	}
+	if got := restoreDescription("A", "app.example.com", previous); got != "restored A record app.example.com -> 1.1.1.1" {
+		t.Errorf("description = %q", got)
+	}
+}
+
+func TestRestoreRecordsKeepsPriority(t *testing.T) {
+	p := &callLog{}
+	previous := []dns.DNSRecord{
+		{Name: "app.example.com", Type: "MX", Content: "mx1.example.net", Priority: 10, TTL: "600"},
+		{Name: "app.example.com", Type: "MX", Content: "mx2.example.net", Priority: 20, TTL: "600"},
+	}
+	if err := restoreRecords(p, "example.com", "42", previous); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "upsert app.example.com MX 10 mx1.example.net,create app.example.com MX 20 mx2.example.net"
+	if got := strings.Join(p.calls, ","); got != want {
+		t.Errorf("calls = %s, want %s", got, want)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/dukerupert/arnor/internal/config"
+	"github.com/dukerupert/arnor/internal/dns"
 	"github.com/dukerupert/arnor/tui"
 	"github.com/dukerupert/annuminas/pkg/dockerhub"
 	fhetzner "github.com/dukerupert/fornost/pkg/hetzner"
@@ -52,6 +53,18 @@ var services = []serviceSpec{
 		},
 		canValidate: true,
 	},
+	{
+		name:    "RFC 2136 (BIND)",
+		service: "rfc2136",
+		fields: []fieldSpec{
+			{key: "server", label: "Server", placeholder: "ns1.example.com:53", masked: false},
+			{key: "zones", label: "Zones", placeholder: "example.com,example.org", masked: false},
+			{key: "key_name", label: "TSIG Key Name", placeholder: "arnor", masked: false},
+			{key: "key_secret", label: "TSIG Secret", placeholder: "base64 secret", masked: true},
+			{key: "algorithm", label: "TSIG Algorithm", placeholder: "hmac-sha256", masked: false},
+		},
+		canValidate: true,
+	},
 	{
 		name:    "DockerHub",
 		service: "dockerhub",
@@ -518,6 +531,11 @@ func (m Model) validate() tea.Cmd {
 			if err := verifyCFToken(values[0], values[1]); err != nil {
 				return validateDoneMsg{err: fmt.Errorf("cloudflare validation failed: %w", err)}
 			}
+		case "rfc2136":
+			provider := dns.NewRFC2136(values[0], values[2], values[3], values[4], dns.SplitZones(values[1]))
+			if err := provider.Ping(); err != nil {
+				return validateDoneMsg{err: fmt.Errorf("rfc2136 validation failed: %w", err)}
+			}
 		case "dockerhub":
 			client := dockerhub.NewClient(values[0], values[1])
 			if err := client.Ping(); err != nil {
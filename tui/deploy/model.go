@@ -8,6 +8,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/registry"
 	"github.com/dukerupert/arnor/tui"
 )
 
@@ -194,14 +195,16 @@ func (m Model) triggerDeploy() tea.Cmd {
 	env := m.selectedProject.Environments[envName]
 	workflowFile := project.WorkflowFile(envName)
 	ref := project.DeployRef(env)
+	composeFile := env.ComposeFile
+	registryName := m.selectedProject.Registry
 	s := m.store
 
 	return func() tea.Msg {
-		dockerHubUsername, err := s.GetCredential("dockerhub", "default", "username")
+		reg, err := registry.New(registryName, s)
 		if err != nil {
-			return triggerDoneMsg{err: fmt.Errorf("dockerhub username: %w", err)}
+			return triggerDoneMsg{err: err}
 		}
-		if err := project.EnsureWorkflowDispatch(repo, envName, projectName, dockerHubUsername); err != nil {
+		if err := project.EnsureWorkflowDispatch(repo, envName, projectName, reg, composeFile); err != nil {
 			return triggerDoneMsg{err: err}
 		}
 		err = project.TriggerWorkflow(repo, workflowFile, ref)
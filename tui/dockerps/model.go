@@ -178,7 +178,7 @@ func (m Model) fetchDockerPS() tea.Cmd {
 		if err != nil {
 			return dockerPsDoneMsg{err: fmt.Errorf("peon key for %s: %w — run Server Init first", serverIP, err)}
 		}
-		containers, err := project.DockerPS(serverIP, peonKey)
+		containers, err := project.DockerPS(serverIP, peonKey, s)
 		return dockerPsDoneMsg{containers: containers, err: err}
 	}
 }
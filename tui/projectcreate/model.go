@@ -11,6 +11,7 @@ import (
 	"github.com/dukerupert/arnor/internal/config"
 	"github.com/dukerupert/arnor/internal/hetzner"
 	"github.com/dukerupert/arnor/internal/project"
+	"github.com/dukerupert/arnor/internal/registry"
 	"github.com/dukerupert/arnor/tui"
 )
 
@@ -22,6 +23,10 @@ const (
 	phaseSelectEnv
 	phaseDomain
 	phasePort
+	phaseTemplate
+	phaseContainerPort
+	phaseComposeFile
+	phaseRegistry
 	phaseConfirm
 	phaseRunning
 	phaseDone
@@ -54,6 +59,7 @@ type Model struct {
 	servers      []hetzner.ServerWithProject
 	serverCursor int
 	envCursor    int
+	tmplCursor   int
 	store        config.Store
 
 	textInput textinput.Model
@@ -68,6 +74,11 @@ type Model struct {
 	domain      string
 	port        int
 
+	composeTemplate string
+	containerPort   int
+	composeFile     string // repo path; replaces the template
+	registry        string // "" keeps the project's, or DockerHub
+
 	// Peon key resolved from server IP
 	peonKey string
 
@@ -121,6 +132,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateDomain(msg)
 	case phasePort:
 		return m.updatePort(msg)
+	case phaseTemplate:
+		return m.updateTemplate(msg)
+	case phaseContainerPort:
+		return m.updateContainerPort(msg)
+	case phaseComposeFile:
+		return m.updateComposeFile(msg)
+	case phaseRegistry:
+		return m.updateRegistry(msg)
 	case phaseConfirm:
 		return m.updateConfirm(msg)
 	case phaseRunning:
@@ -296,9 +315,112 @@ func (m Model) updatePort(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			m.port = port
+			m.phase = phaseTemplate
+			m.textInput.Blur()
+			return m, nil
+		case "esc":
+			return m.goBack()
+		}
+	}
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	return m, cmd
+}
+
+func (m Model) updateTemplate(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "up", "k":
+			if m.tmplCursor > 0 {
+				m.tmplCursor--
+			}
+		case "down", "j":
+			// The extra last entry is the repo's own compose file.
+			if m.tmplCursor < len(project.ComposeTemplates) {
+				m.tmplCursor++
+			}
+		case "enter":
+			if m.tmplCursor == len(project.ComposeTemplates) {
+				m.composeTemplate, m.containerPort = "", 0
+				m.phase = phaseComposeFile
+				m.textInput.SetValue(m.composeFile)
+				m.textInput.Placeholder = "compose.prod.yml"
+				m.textInput.Focus()
+				return m, textinput.Blink
+			}
+			m.composeTemplate = project.ComposeTemplates[m.tmplCursor].Name
+			m.composeFile = ""
+			m.phase = phaseContainerPort
+			m.textInput.SetValue("")
+			m.textInput.Placeholder = "80"
+			m.textInput.Focus()
+			return m, textinput.Blink
+		case "esc":
+			return m.goBack()
+		}
+	}
+	return m, nil
+}
+
+// updateComposeFile reads the path of the repo's own compose file.
+func (m Model) updateComposeFile(msg tea.Msg) (tea.Model, tea.Cmd) {
+	return m.updateTextInput(msg, func(val string) (tea.Model, tea.Cmd) {
+		m.composeFile = val
+		return m.toRegistry()
+	})
+}
+
+// toRegistry moves on to asking for the image registry.
+func (m Model) toRegistry() (tea.Model, tea.Cmd) {
+	m.phase = phaseRegistry
+	m.textInput.SetValue(m.registry)
+	m.textInput.Placeholder = "dockerhub"
+	m.textInput.Focus()
+	return m, textinput.Blink
+}
+
+// updateRegistry reads the registry images are pushed to. Empty keeps the
+// project's registry, or DockerHub for a new project.
+func (m Model) updateRegistry(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "enter":
+			val := strings.TrimSpace(m.textInput.Value())
+			if err := registry.Validate(val); err != nil {
+				m.err = err
+				return m, nil
+			}
+			m.registry, m.err = val, nil
 			m.phase = phaseConfirm
 			m.textInput.Blur()
 			return m, nil
+		case "esc":
+			m.err = nil
+			return m.goBack()
+		}
+	}
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	return m, cmd
+}
+
+// updateContainerPort reads the port the app listens on inside its
+// container. Empty means 80.
+func (m Model) updateContainerPort(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "enter":
+			val := strings.TrimSpace(m.textInput.Value())
+			port := 80
+			if val != "" {
+				p, err := strconv.Atoi(val)
+				if err != nil || p < 1 || p > 65535 {
+					return m, nil
+				}
+				port = p
+			}
+			m.containerPort = port
+			return m.toRegistry()
 		case "esc":
 			return m.goBack()
 		}
@@ -387,12 +509,31 @@ func (m Model) goBack() (tea.Model, tea.Cmd) {
 		}
 		m.textInput.Focus()
 		return m, textinput.Blink
-	case phaseConfirm:
+	case phaseTemplate:
 		m.phase = phasePort
 		m.textInput.SetValue(strconv.Itoa(m.port))
 		m.textInput.Placeholder = "3000"
 		m.textInput.Focus()
 		return m, textinput.Blink
+	case phaseContainerPort, phaseComposeFile:
+		m.phase = phaseTemplate
+		m.textInput.Blur()
+		return m, nil
+	case phaseConfirm:
+		return m.toRegistry()
+	case phaseRegistry:
+		if m.composeFile != "" {
+			m.phase = phaseComposeFile
+			m.textInput.SetValue(m.composeFile)
+			m.textInput.Placeholder = "compose.prod.yml"
+			m.textInput.Focus()
+			return m, textinput.Blink
+		}
+		m.phase = phaseContainerPort
+		m.textInput.SetValue(strconv.Itoa(m.containerPort))
+		m.textInput.Placeholder = "80"
+		m.textInput.Focus()
+		return m, textinput.Blink
 	}
 	return m, nil
 }
@@ -409,6 +550,11 @@ func (m Model) runSetup() tea.Cmd {
 		Port:        m.port,
 		PeonKey:     m.peonKey,
 		Store:       m.store,
+
+		ComposeTemplate: m.composeTemplate,
+		ContainerPort:   m.containerPort,
+		ComposeFile:     m.composeFile,
+		Registry:        m.registry,
 		OnProgress: func(step, total int, message string) {
 			ch <- progressMsg{step: step, total: total, message: message}
 		},
@@ -433,8 +579,9 @@ func (m Model) waitForProgress() tea.Cmd {
 func (m Model) scanPorts() tea.Cmd {
 	serverIP := m.serverIP
 	peonKey := m.peonKey
+	store := m.store
 	return func() tea.Msg {
-		ports, err := project.GetUsedPorts(serverIP, peonKey)
+		ports, err := project.GetUsedPorts(serverIP, peonKey, store)
 		return usedPortsMsg{ports: ports, err: err}
 	}
 }
@@ -502,6 +649,43 @@ func (m Model) View() string {
 		}
 		b.WriteString(tui.HelpStyle.Render("\nenter: next  esc: back"))
 
+	case phaseTemplate:
+		b.WriteString("\nCompose template:\n\n")
+		for i, t := range project.ComposeTemplates {
+			line := fmt.Sprintf("  %-20s %s", t.Name, t.Description)
+			if i == m.tmplCursor {
+				line = tui.CursorStyle.Render(fmt.Sprintf("> %-20s %s", t.Name, t.Description))
+			}
+			b.WriteString(line + "\n")
+		}
+		repoLine := fmt.Sprintf("%-20s %s", "repo file", "the repo's own compose file")
+		if m.tmplCursor == len(project.ComposeTemplates) {
+			b.WriteString(tui.CursorStyle.Render("> "+repoLine) + "\n")
+		} else {
+			b.WriteString("  " + repoLine + "\n")
+		}
+		b.WriteString(tui.HelpStyle.Render("\nj/k: navigate  enter: select  esc: back"))
+
+	case phaseComposeFile:
+		b.WriteString("\nCompose file in the repo:\n\n")
+		b.WriteString(m.textInput.View())
+		b.WriteString(tui.HelpStyle.Render("\nenter: next  esc: back"))
+
+	case phaseContainerPort:
+		b.WriteString("\nContainer port:\n\n")
+		b.WriteString(m.textInput.View())
+		b.WriteString(tui.HelpStyle.Render("\nempty = 80"))
+		b.WriteString(tui.HelpStyle.Render("\nenter: next  esc: back"))
+
+	case phaseRegistry:
+		b.WriteString("\nImage registry:\n\n")
+		b.WriteString(m.textInput.View())
+		if m.err != nil {
+			b.WriteString("\n" + tui.ErrorStyle.Render(m.err.Error()))
+		}
+		b.WriteString(tui.HelpStyle.Render("\ndockerhub, ghcr, or a host like registry.example.com; empty = keep the project's"))
+		b.WriteString(tui.HelpStyle.Render("\nenter: next  esc: back"))
+
 	case phaseConfirm:
 		b.WriteString("\n")
 		b.WriteString(tui.CursorStyle.Render("Create this project?"))
@@ -553,6 +737,18 @@ func (m Model) renderSummary() string {
 	if m.port > 0 && m.phase > phasePort {
 		render("Port", strconv.Itoa(m.port))
 	}
+	if m.composeTemplate != "" && m.phase > phaseTemplate {
+		render("Template", m.composeTemplate)
+	}
+	if m.containerPort > 0 && m.phase > phaseContainerPort {
+		render("Container port", strconv.Itoa(m.containerPort))
+	}
+	if m.composeFile != "" && m.phase > phaseComposeFile {
+		render("Compose file", m.composeFile)
+	}
+	if m.registry != "" && m.phase > phaseRegistry {
+		render("Registry", m.registry)
+	}
 
 	return b.String()
 }
@@ -322,6 +322,7 @@ func (m Model) runRemote() tea.Cmd {
 	host := m.host
 	user := m.user
 	sudoPassword := m.sudoPassword
+	store := m.store
 
 	return func() tea.Msg {
 		auth := peon.SSHAuth{
@@ -332,7 +333,7 @@ func (m Model) runRemote() tea.Cmd {
 				return resp.passphrase, resp.err
 			},
 		}
-		key, err := peon.RunRemote(host, user, auth)
+		key, err := peon.RunRemote(host, user, auth, store)
 		return runDoneMsg{key: key, err: err}
 	}
 }
@@ -372,6 +373,7 @@ func (m Model) installCaddy() tea.Cmd {
 			ServerIP:   host,
 			PeonKeyPEM: key,
 			CFToken:    cfToken,
+			Store:      s,
 		})
 		return caddyDoneMsg{err: err}
 	}
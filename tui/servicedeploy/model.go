@@ -398,8 +398,9 @@ func (m Model) waitForProgress() tea.Cmd {
 func (m Model) scanPorts() tea.Cmd {
 	serverIP := m.serverIP
 	peonKey := m.peonKey
+	store := m.store
 	return func() tea.Msg {
-		ports, err := project.GetUsedPorts(serverIP, peonKey)
+		ports, err := project.GetUsedPorts(serverIP, peonKey, store)
 		return usedPortsMsg{ports: ports, err: err}
 	}
 }